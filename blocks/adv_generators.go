@@ -2,32 +2,51 @@ package blocks
 
 import (
 	"bufio"
+	"encoding/csv"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/epfl-dcsl/schedsim/engine"
 )
 
 // PBGenerator implements a playback generator for given service times.
-// The interarrival distribution is exponential
+// The interarrival distribution is exponential. Every request is fed to the
+// queue of a CPU with one of its service times, both drawn uniformly at random
+// or, in round robin mode, cycling through the CPUs and replaying the service
+// times of each CPU in order
 type PBGenerator struct {
 	genericGenerator
 	// service times per CPU (discrete values)
 	sTimes   [][]int
 	cpuCount int
 	WaitTime randDist
+	lambda   float64
+
+	// CPUs with service times, the others are skipped
+	cpus       []int
+	roundRobin bool
+	nextCPU    int   // index in cpus of the next CPU in round robin mode
+	nextTime   []int // next service time of every CPU in round robin mode
 }
 
 // NewPBGenerator returns a PBGenerator
 // Parameters: lambda for the exponential interarrival and the filenames
-// with the service times
+// with the service times, one per line and one file per CPU. CPUs without
+// service times are skipped with a warning
 func NewPBGenerator(lambda float64, paths []string) *PBGenerator {
 	g := PBGenerator{}
 
-	for _, p := range paths {
-		inFile, _ := os.Open(p)
-		defer inFile.Close()
+	for i, p := range paths {
+		inFile, err := os.Open(p)
+		if err != nil {
+			panic(fmt.Sprintf("failed to open service times %s: %v", p, err))
+		}
 		scanner := bufio.NewScanner(inFile)
 		scanner.Split(bufio.ScanLines)
 
@@ -36,19 +55,63 @@ func NewPBGenerator(lambda float64, paths []string) *PBGenerator {
 			n, _ := strconv.Atoi(scanner.Text())
 			newTimes = append(newTimes, n)
 		}
+		inFile.Close()
+		if len(newTimes) == 0 {
+			g.logf("WARNING: no service times in %s, skipping CPU %v\n", p, i)
+		} else {
+			g.cpus = append(g.cpus, i)
+		}
 		g.sTimes = append(g.sTimes, newTimes)
 	}
+	if len(g.cpus) == 0 {
+		panic("No service times for any CPU")
+	}
 	g.cpuCount = len(paths)
-	g.WaitTime = newExponDistr(lambda)
+	g.nextTime = make([]int, g.cpuCount)
+	g.lambda = lambda
+	g.SetRand(newRand())
 	return &g
 }
 
+// SetRand makes the generator draw the CPUs, service times and interarrival
+// times from rng, so that seeding rng reproduces the same requests
+func (g *PBGenerator) SetRand(rng *rand.Rand) {
+	g.rng = rng
+	g.WaitTime = &exponDistr{g.lambda, stream{rng}}
+}
+
+// SetSimulation seeds the generator and its request creator from the
+// simulation it is registered in
+func (g *PBGenerator) SetSimulation(s *engine.Simulation) {
+	g.genericGenerator.SetSimulation(s)
+	g.SetRand(g.rng)
+}
+
+// SetRoundRobin makes the generator cycle through the CPUs in order and replay
+// the service times of every CPU in order, starting over at the end, instead
+// of drawing both at random
+func (g *PBGenerator) SetRoundRobin(roundRobin bool) {
+	g.roundRobin = roundRobin
+}
+
+// next returns the CPU and service time of the next request
+func (g *PBGenerator) next() (int, int) {
+	if g.roundRobin {
+		i := g.cpus[g.nextCPU]
+		g.nextCPU = (g.nextCPU + 1) % len(g.cpus)
+		j := g.nextTime[i]
+		g.nextTime[i] = (j + 1) % len(g.sTimes[i])
+		return i, g.sTimes[i][j]
+	}
+	i := g.cpus[g.rng.Intn(len(g.cpus))]
+	j := g.rng.Intn(len(g.sTimes[i]))
+	return i, g.sTimes[i][j]
+}
+
 func (g *PBGenerator) Run() {
 	for {
-		i := rand.Intn(g.cpuCount)
-		j := rand.Intn(len(g.sTimes[i]))
-		serviceTime := g.sTimes[i][j]
-		req := g.Creator.NewRequest(float64(serviceTime))
+		i, serviceTime := g.next()
+		req := g.newRequest(float64(serviceTime))
 		g.WriteOutQueueI(req, i)
 		g.Wait(g.WaitTime.getRand())
 	}
@@ -56,11 +119,11 @@ func (g *PBGenerator) Run() {
 
 // CDFGenerator implements a generator with CDF-based service times
 // and exponential interarrival distribution. It assumes a single CDF source.
+// If multiple queues they are fed according to the dispatch policy
 type CDFGenerator struct {
-	genericGenerator
+	randGenerator
 	// Single CDF distribution for sampling service times
-	cdf      cdfDistrib
-	WaitTime randDist
+	cdf cdfDistrib
 }
 
 // cdfDistrib holds points of a cumulative distribution function for sampling
@@ -68,11 +131,12 @@ type CDFGenerator struct {
 type cdfDistrib struct {
 	x []float64
 	p []float64
+	stream
 }
 
 // sample draws a service time by inverse-CDF interpolation
 func (c *cdfDistrib) sample() float64 {
-	u := rand.Float64()
+	u := c.rng.Float64()
 	// fmt.Printf("NewCDFGenerator::sample() u = %f \n", u)
 	// lower bound
 	if u <= c.p[0] {
@@ -98,15 +162,41 @@ func (c *cdfDistrib) sample() float64 {
 	return ret
 }
 
+func (c *cdfDistrib) getRand() float64 {
+	return c.sample()
+}
+
+// DefaultCDFScale is the divisor converting the sizes of the CDF files to
+// service times
+const DefaultCDFScale = 1000.0
+
 // NewCDFGenerator returns a CDFGenerator
 // Parameters: lambda for exponential interarrival and the path to a single CDF file.
 // CDF file: first line is mean (ignored), subsequent lines: <size> <cumProb>
 func NewCDFGenerator(lambda float64, path string) *CDFGenerator {
+	return NewCDFGeneratorScaled(lambda, path, DefaultCDFScale)
+}
+
+// NewCDFGeneratorScaled returns a CDFGenerator whose service times are the
+// sizes of the CDF file divided by scale
+func NewCDFGeneratorScaled(lambda float64, path string, scale float64) *CDFGenerator {
 	if !(path != "") {
 		panic("CDF path: '" + path + "' unknown, cannot create CDFGenerator")
 	}
-	g := CDFGenerator{}
+	g := &CDFGenerator{}
+	g.rng = newRand()
+	g.cdf = loadCDF(path, scale)
+	g.ServiceTime = &g.cdf
+	g.WaitTime = newExponDistr(lambda)
+	return g
+}
 
+// loadCDF reads a CDF file: first line is mean (ignored), subsequent lines:
+// <size> <cumProb>. Sizes are divided by scale
+func loadCDF(path string, scale float64) cdfDistrib {
+	if scale <= 0 {
+		panic(fmt.Sprintf("Non positive CDF scale: %v", scale))
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		panic(fmt.Sprintf("failed to open CDF file %s: %v", path, err))
@@ -141,27 +231,583 @@ func NewCDFGenerator(lambda float64, path string) *CDFGenerator {
 		if err != nil {
 			panic(err)
 		}
-		// xval is in bytes and should I feed it as us, the values will be very big
+		// xval is in bytes, feeding it as us makes the values very big
 		// eg w5 goes to 10M+ which is 10 seconds (and starts at 1 byte==1us)
-		// so I will divide by 10 (0.1us to 1s)
-		// Or by 1000 (0.001us to 0.001s==1ms==1000us)
-		cd.x = append(cd.x, xVal/1000.0)
+		// so it is divided by the scale, by default 1000 (0.001us to 0.001s==1ms==1000us)
+		cd.x = append(cd.x, xVal/scale)
 		cd.p = append(cd.p, pVal)
 	}
 	if len(cd.x) == 0 {
 		panic(fmt.Sprintf("no CDF data in file: %s", path))
 	}
-	g.cdf = cd
+	cd.stream = newStream()
+	return cd
+}
+
+// MixtureCDFGenerator implements a generator with exponential interarrival
+// distribution and service times drawn from a weighted mixture of CDFs.
+// Requests are tagged with the index of the CDF they were drawn from under
+// ClassTag
+type MixtureCDFGenerator struct {
+	genericGenerator
+	cdfs     []cdfDistrib
+	cumW     []float64
+	WaitTime randDist
+}
+
+// NewMixtureCDFGenerator returns a new *MixtureCDFGenerator drawing from the
+// CDF file paths[i] with probability weights[i]. Weights should sum to 1
+func NewMixtureCDFGenerator(lambda float64, paths []string, weights []float64) *MixtureCDFGenerator {
+	return NewMixtureCDFGeneratorScaled(lambda, paths, weights, DefaultCDFScale)
+}
+
+// NewMixtureCDFGeneratorScaled returns a new *MixtureCDFGenerator whose
+// service times are the sizes of the CDF files divided by scale
+func NewMixtureCDFGeneratorScaled(lambda float64, paths []string, weights []float64, scale float64) *MixtureCDFGenerator {
+	if len(paths) == 0 || len(paths) != len(weights) {
+		panic(fmt.Sprintf("Mixture needs one weight per CDF: %v paths, %v weights", len(paths), len(weights)))
+	}
+	g := &MixtureCDFGenerator{}
+	g.logf("NewMixtureCDFGenerator called with lambda: %v, paths: %v, weights: %v\n", lambda, paths, weights)
+	var sum float64
+	for i, path := range paths {
+		if weights[i] < 0 {
+			panic(fmt.Sprintf("Negative mixture weight: %v", weights[i]))
+		}
+		sum += weights[i]
+		g.cdfs = append(g.cdfs, loadCDF(path, scale))
+		g.cumW = append(g.cumW, sum)
+	}
+	if math.Abs(sum-1) > 1e-6 {
+		panic(fmt.Sprintf("Mixture weights sum to %v instead of 1", sum))
+	}
 	g.WaitTime = newExponDistr(lambda)
-	return &g
+	g.rng = newRand()
+	return g
 }
 
-// Run is the main loop of the CDFGenerator: sample a service time and wait
-func (g *CDFGenerator) Run() {
+// SetSimulation seeds the generator, its CDFs and its request creator from
+// the simulation it is registered in
+func (g *MixtureCDFGenerator) SetSimulation(s *engine.Simulation) {
+	g.genericGenerator.SetSimulation(s)
+	seedAll(s.Rand(), g.WaitTime)
+	for i := range g.cdfs {
+		g.cdfs[i].seed(s.Rand())
+	}
+}
+
+// pick returns the index of a CDF drawn according to the weights
+func (g *MixtureCDFGenerator) pick() int {
+	u := g.rng.Float64() * g.cumW[len(g.cumW)-1]
+	for i, w := range g.cumW {
+		if u < w {
+			return i
+		}
+	}
+	return len(g.cumW) - 1
+}
+
+// Run is the main loop of the MixtureCDFGenerator: pick a CDF, sample a
+// service time from it and wait
+func (g *MixtureCDFGenerator) Run() {
 	for {
-		st := g.cdf.sample()
-		req := g.Creator.NewRequest(st)
+		i := g.pick()
+		req := g.newRequest(g.cdfs[i].sample())
+		if r, ok := req.(tagSetter); ok {
+			r.SetTag(ClassTag, strconv.Itoa(i))
+		}
 		g.WriteOutQueueI(req, 0)
 		g.Wait(g.WaitTime.getRand())
 	}
 }
+
+// ZipfGenerator is a poisson interarrival generator whose requests reference
+// one of n keys drawn from a Zipf distribution, e.g. of a skewed key-value
+// workload. Every key has its own service time and requests are tagged with
+// their key, 0 being the most popular one, under KeyTag
+type ZipfGenerator struct {
+	randGenerator
+	keys *zipfDistr
+	mean float64
+}
+
+// NewZipfGenerator returns a new *ZipfGenerator over n keys of exponent s, the
+// ith most popular key being drawn with a probability proportional to 1/i^s.
+// The service time of every key is drawn once from an exponential
+// distribution, then all of them are scaled to the given mean
+func NewZipfGenerator(waitLambda float64, n int, s, mean float64) *ZipfGenerator {
+	if n < 1 {
+		panic(fmt.Sprintf("Zipf needs at least a key, got %v", n))
+	}
+	if s < 0 {
+		panic(fmt.Sprintf("Negative Zipf exponent: %v", s))
+	}
+	g := &ZipfGenerator{mean: mean}
+	g.logf("NewZipfGenerator called with waitLambda: %v, keys: %v, exponent: %v, mean: %v\n", waitLambda, n, s, mean)
+	g.rng = newRand()
+	g.keys = newZipfDistr(s, make([]float64, n))
+	g.drawKeys()
+	g.ServiceTime = g.keys
+	g.WaitTime = newExponDistr(waitLambda)
+	return g
+}
+
+// drawKeys draws the service time of every key and scales them to the mean
+func (g *ZipfGenerator) drawKeys() {
+	values := g.keys.values
+	for i := range values {
+		values[i] = g.rng.ExpFloat64()
+	}
+	scale := g.mean / g.keys.mean()
+	for i := range values {
+		values[i] *= scale
+	}
+}
+
+// SetSimulation seeds the generator from the simulation it is registered in
+// and draws the service times of the keys again from it
+func (g *ZipfGenerator) SetSimulation(s *engine.Simulation) {
+	g.genericGenerator.SetSimulation(s)
+	g.drawKeys()
+}
+
+// Run is the main loop of the ZipfGenerator: draw the key of every request of
+// the arrival, issue it with the service time of the key and wait
+func (g *ZipfGenerator) Run() {
+	for {
+		for n := g.bulkSize(); n > 0; n-- {
+			k := g.keys.pick()
+			req := g.newRequest(g.keys.values[k])
+			if r, ok := req.(tagSetter); ok {
+				r.SetTag(KeyTag, strconv.Itoa(k))
+			}
+			g.WriteOutQueueI(req, g.pickQueue())
+		}
+		g.Wait(g.WaitTime.getRand())
+	}
+}
+
+// traceEntry is a single request of a trace: its arrival time relative to the
+// beginning of the trace and its service time
+type traceEntry struct {
+	arrival     float64
+	serviceTime float64
+}
+
+// TraceGenerator replays a trace of requests at their recorded arrival times,
+// feeding its output queues according to its dispatch policy.
+// It stops when the trace is exhausted
+type TraceGenerator struct {
+	randGenerator
+	entries []traceEntry
+}
+
+// newTraceGenerator returns a new *TraceGenerator replaying entries
+func newTraceGenerator(entries []traceEntry) *TraceGenerator {
+	g := &TraceGenerator{entries: entries}
+	g.rng = newRand()
+	return g
+}
+
+// Run is the main loop of the TraceGenerator: wait till the next arrival and
+// issue the request
+func (g *TraceGenerator) Run() {
+	for _, e := range g.entries {
+		if d := e.arrival - g.Now(); d > 0 {
+			g.Wait(d)
+		}
+		req := g.newRequest(e.serviceTime)
+		g.WriteOutQueueI(req, g.pickQueue())
+	}
+	g.Done()
+}
+
+// NewTraceGenerator returns a TraceGenerator replaying a trace file with one
+// request per line as "<arrivalTime> <serviceTime>", both in us. Arrival times
+// are absolute, i.e. since the beginning of the simulation, not inter-arrival
+// times. Lines are sorted by arrival time. CSV lines as
+// "<arrivalTime>,<serviceTime>" are also accepted, along with a header line,
+// e.g. "arrival_time,service_time". Empty lines and lines starting with
+// # are ignored and malformed lines are skipped with a warning
+func NewTraceGenerator(path string) *TraceGenerator {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open trace %s: %v", path, err))
+	}
+	defer f.Close()
+
+	var entries []traceEntry
+	var warnings []string
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	header := true
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := traceFields(line)
+		if len(fields) != 2 {
+			warnings = append(warnings, fmt.Sprintf("WARNING: skipping malformed line %v in trace %s: '%s'\n", lineNo, path, line))
+			continue
+		}
+		arrival, err1 := strconv.ParseFloat(fields[0], 64)
+		serviceTime, err2 := strconv.ParseFloat(fields[1], 64)
+		if header && err1 != nil && err2 != nil {
+			// a CSV header naming the columns
+			header = false
+			continue
+		}
+		header = false
+		if err1 != nil || err2 != nil || arrival < 0 || serviceTime < 0 {
+			warnings = append(warnings, fmt.Sprintf("WARNING: skipping malformed line %v in trace %s: '%s'\n", lineNo, path, line))
+			continue
+		}
+		entries = append(entries, traceEntry{arrival: arrival, serviceTime: serviceTime})
+	}
+	if err := scanner.Err(); err != nil {
+		panic(fmt.Sprintf("failed to read trace %s: %v", path, err))
+	}
+	if len(entries) == 0 {
+		warnings = append(warnings, fmt.Sprintf("WARNING: no requests in trace %s\n", path))
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].arrival < entries[j].arrival
+	})
+	g := newTraceGenerator(entries)
+	g.info = warnings
+	return g
+}
+
+// traceFields splits a trace line on commas if it has any, or else on spaces
+func traceFields(line string) []string {
+	if !strings.Contains(line, ",") {
+		return strings.Fields(line)
+	}
+	fields := strings.Split(line, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields
+}
+
+// ScriptedEvent is a request of a scripted sequence: the time since the
+// previous request (or the beginning of the simulation) and its service time
+type ScriptedEvent struct {
+	IA  float64
+	Svc float64
+}
+
+// NewScriptedGenerator returns a TraceGenerator that emits the given requests
+// in order and then stops, without any randomness. It is mostly useful to
+// exercise processors and queues with exact timings
+func NewScriptedGenerator(events []ScriptedEvent) *TraceGenerator {
+	entries := make([]traceEntry, len(events))
+	var t float64
+	for i, e := range events {
+		t += e.IA
+		entries[i] = traceEntry{arrival: t, serviceTime: e.Svc}
+	}
+	return newTraceGenerator(entries)
+}
+
+// Column layout of the Alibaba cluster-trace-v2018 batch_task.csv file:
+// task_name,instance_num,job_name,task_type,status,start_time,end_time,plan_cpu,plan_mem
+// start_time and end_time are in seconds
+const (
+	clusterTraceColumns   = 9
+	clusterTraceStatusCol = 4
+	clusterTraceStartCol  = 5
+	clusterTraceEndCol    = 6
+	clusterTraceTimeScale = 1000000.0 // seconds to us
+)
+
+// NewClusterTraceGenerator returns a TraceGenerator replaying the tasks of a
+// cluster trace in the Alibaba cluster-trace-v2018 batch_task.csv format.
+// Every terminated task becomes a request submitted at its start time with its
+// duration (end_time - start_time) as service time. Times are converted to us
+// and arrivals are shifted so that the first task arrives at time 0.
+// Tasks that did not terminate or have missing or inconsistent times are skipped
+func NewClusterTraceGenerator(path string) *TraceGenerator {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open cluster trace %s: %v", path, err))
+	}
+	defer f.Close()
+
+	var entries []traceEntry
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(fmt.Sprintf("failed to read cluster trace %s: %v", path, err))
+		}
+		if len(record) < clusterTraceColumns || record[clusterTraceStatusCol] != "Terminated" {
+			continue
+		}
+		start, err := strconv.ParseFloat(record[clusterTraceStartCol], 64)
+		if err != nil {
+			continue
+		}
+		end, err := strconv.ParseFloat(record[clusterTraceEndCol], 64)
+		if err != nil || end < start {
+			continue
+		}
+		entries = append(entries, traceEntry{
+			arrival:     start * clusterTraceTimeScale,
+			serviceTime: (end - start) * clusterTraceTimeScale,
+		})
+	}
+	if len(entries) == 0 {
+		panic(fmt.Sprintf("no tasks in cluster trace: %s", path))
+	}
+
+	// the trace is not sorted by submission time
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].arrival < entries[j].arrival
+	})
+	first := entries[0].arrival
+	for i := range entries {
+		entries[i].arrival -= first
+	}
+	return newTraceGenerator(entries)
+}
+
+// DAGTemplate describes the tasks of a DAG job. Deps[i] holds the predecessors
+// of task i, that should all have a smaller index
+type DAGTemplate struct {
+	ServiceTimes []float64
+	Deps         [][]int
+}
+
+// DAGGenerator generates DAG jobs with exponential interarrival. It releases
+// the eligible tasks of every job to its output queue and is also the request
+// drain of the processors serving them, so that it releases the successors of
+// every completed task. Completed jobs are terminated to the job drain
+type DAGGenerator struct {
+	genericGenerator
+	statsOutput
+	template     DAGTemplate
+	criticalPath float64
+	jobDrain     RequestDrain
+	name         string
+}
+
+// NewDAGGenerator returns a new *DAGGenerator
+func NewDAGGenerator(waitLambda float64, template DAGTemplate) *DAGGenerator {
+	if len(template.ServiceTimes) == 0 || len(template.Deps) != len(template.ServiceTimes) {
+		panic(fmt.Sprintf("DAG needs dependencies for each of its %v tasks", len(template.ServiceTimes)))
+	}
+	// longest path ending at each task
+	finish := make([]float64, len(template.ServiceTimes))
+	var criticalPath float64
+	for i, deps := range template.Deps {
+		for _, d := range deps {
+			if d < 0 || d >= i {
+				panic(fmt.Sprintf("Task %v depends on task %v, tasks should be topologically sorted", i, d))
+			}
+			if finish[d] > finish[i] {
+				finish[i] = finish[d]
+			}
+		}
+		finish[i] += template.ServiceTimes[i]
+		if finish[i] > criticalPath {
+			criticalPath = finish[i]
+		}
+	}
+
+	g := &DAGGenerator{template: template, criticalPath: criticalPath}
+	g.logf("NewDAGGenerator called with waitLambda: %v, template: %v\n", waitLambda, template)
+	g.WaitTime = newExponDistr(waitLambda)
+	return g
+}
+
+// SetJobDrain sets the drain for the completed jobs
+func (g *DAGGenerator) SetJobDrain(rd RequestDrain) {
+	g.jobDrain = rd
+}
+
+func (g *DAGGenerator) newJob() *DAGReq {
+	job := &DAGReq{Request: newRequest(g.Simulation(), g.criticalPath)}
+	job.tasks = make([]*dagTaskReq, len(g.template.ServiceTimes))
+	for i, st := range g.template.ServiceTimes {
+		job.tasks[i] = &dagTaskReq{
+			Request:      Request{ID: g.Simulation().NewID(), ServiceTime: st, OriginalServiceTime: st, sim: g.Simulation()},
+			job:          job,
+			pendingPreds: len(g.template.Deps[i]),
+		}
+	}
+	for i, deps := range g.template.Deps {
+		for _, d := range deps {
+			job.tasks[d].succs = append(job.tasks[d].succs, i)
+		}
+	}
+	job.pending = len(job.tasks)
+	return job
+}
+
+func (g *DAGGenerator) release(t *dagTaskReq) {
+	t.InitTime = g.Now()
+	g.WriteOutQueue(t)
+}
+
+// Run is the main loop of the DAGGenerator: create a job, release its source
+// tasks and wait
+func (g *DAGGenerator) Run() {
+	for {
+		job := g.newJob()
+		for _, t := range job.tasks {
+			if t.pendingPreds == 0 {
+				g.release(t)
+			}
+		}
+		g.Wait(g.WaitTime.getRand())
+	}
+}
+
+// TerminateReq is called by the processors after serving a task. It releases
+// the successors that became eligible and terminates the job when all its
+// tasks have completed
+func (g *DAGGenerator) TerminateReq(req engine.ReqInterface) {
+	t := req.(*dagTaskReq)
+	for _, s := range t.succs {
+		succ := t.job.tasks[s]
+		succ.pendingPreds--
+		if succ.pendingPreds == 0 {
+			g.release(succ)
+		}
+	}
+	t.job.pending--
+	if t.job.pending == 0 {
+		g.jobDrain.TerminateReq(t.job)
+	}
+}
+
+// SetName gives a name to the particular DAGGenerator
+func (g *DAGGenerator) SetName(name string) {
+	g.name = name
+}
+
+// ClosedLoopGenerator models a fixed number of clients, each one submitting
+// its next request a think time after its previous one completed. It should be
+// the request drain of the processors, so that it sees the completions, and
+// forwards the completed requests to its own drain. Requests that are not
+// terminated to it, e.g. dropped ones, are lost along with their client
+type ClosedLoopGenerator struct {
+	genericGenerator
+	statsOutput
+	clients     int
+	inFlight    int
+	maxInFlight int
+	think       randDist
+	thinking    *delayLine
+	feedback    *Queue
+	drain       RequestDrain
+	name        string
+}
+
+// NewClosedLoopGenerator returns a new *ClosedLoopGenerator with the given
+// number of clients and exponential think times of mean thinkTime.
+// The service times should be set with SetServiceTimeOf
+func NewClosedLoopGenerator(clients int, thinkTime float64, creator ReqCreator) *ClosedLoopGenerator {
+	g := &ClosedLoopGenerator{clients: clients, thinking: newDelayLine(), feedback: NewQueue()}
+	g.logf("NewClosedLoopGenerator called with clients: %v, thinkTime: %v\n", clients, thinkTime)
+	if thinkTime > 0 {
+		g.think = newExponDistr(1 / thinkTime)
+	} else {
+		g.think = newDeterministicDistr(0)
+	}
+	g.Creator = creator
+	g.AddInQueue(g.feedback)
+	return g
+}
+
+// SetSimulation seeds the generator, its think times and its request creator
+// from the simulation it is registered in
+func (g *ClosedLoopGenerator) SetSimulation(s *engine.Simulation) {
+	g.genericGenerator.SetSimulation(s)
+	seedAll(s.Rand(), g.think)
+}
+
+// SetServiceTimeOf makes the generator draw service times from the same
+// distribution as other
+func (g *ClosedLoopGenerator) SetServiceTimeOf(other Generator) {
+	sg, ok := other.(interface{ getServiceTime() randDist })
+	if !ok || sg.getServiceTime() == nil {
+		panic(fmt.Sprintf("Cannot get the service times of %T", other))
+	}
+	g.ServiceTime = sg.getServiceTime()
+}
+
+// SetReqDrain sets the drain for the completed requests
+func (g *ClosedLoopGenerator) SetReqDrain(rd RequestDrain) {
+	g.drain = rd
+}
+
+// submit issues a new request of a client, unless the simulation is draining
+// and the client is done
+func (g *ClosedLoopGenerator) submit(_ engine.ReqInterface) {
+	if g.Simulation().Draining() {
+		return
+	}
+	g.issue()
+}
+
+func (g *ClosedLoopGenerator) issue() {
+	g.inFlight++
+	if g.inFlight > g.maxInFlight {
+		g.maxInFlight = g.inFlight
+	}
+	g.WriteOutQueue(g.newRequest(g.ServiceTime.getRand()))
+}
+
+// Run is the main loop of the ClosedLoopGenerator: every client submits a
+// request and then a new one after each completion and think time
+func (g *ClosedLoopGenerator) Run() {
+	for i := 0; i < g.clients; i++ {
+		g.issue()
+	}
+	var d float64
+	d = -1
+	for {
+		intr, done := g.WaitInterruptible(d)
+		currTime := g.Now()
+		if intr {
+			// the timer was set for the first client done thinking
+			g.thinking.releaseFirst(g.submit)
+		} else if done != nil {
+			g.thinking.add(currTime+g.think.getRand(), done)
+		}
+		g.thinking.releaseDue(currTime, g.submit)
+		d = g.thinking.nextTimeout(currTime)
+	}
+}
+
+// TerminateReq is called by the processors after serving a request. The
+// client that submitted it starts thinking
+func (g *ClosedLoopGenerator) TerminateReq(req engine.ReqInterface) {
+	g.inFlight--
+	g.drain.TerminateReq(req)
+	g.feedback.Enqueue(req)
+}
+
+// SetName gives a name to the particular ClosedLoopGenerator
+func (g *ClosedLoopGenerator) SetName(name string) {
+	g.name = name
+}
+
+// InFlight returns the number of requests submitted and not terminated yet
+func (g *ClosedLoopGenerator) InFlight() int {
+	return g.inFlight
+}
+
+// PrintStats prints the number of clients and the most requests in flight at
+// once, which should not exceed it. This is called by the model
+func (g *ClosedLoopGenerator) PrintStats() {
+	fmt.Fprintf(g.out(), "clients:%v\tmax_in_flight:%v\n", g.clients, g.maxInFlight)
+}
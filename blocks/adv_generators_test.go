@@ -0,0 +1,216 @@
+package blocks
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFile writes content to a new file named name in a temporary directory
+// and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkEntries checks that the trace of g holds the arrival and service times
+// of want, in order
+func checkEntries(t *testing.T, g *TraceGenerator, want []traceEntry) {
+	t.Helper()
+	if len(g.entries) != len(want) {
+		t.Fatalf("%v requests %v, want %v", len(g.entries), g.entries, want)
+	}
+	for i, e := range g.entries {
+		if !almostEqual(e.arrival, want[i].arrival) || !almostEqual(e.serviceTime, want[i].serviceTime) {
+			t.Errorf("request %v: arrival %v and service time %v, want %v and %v",
+				i, e.arrival, e.serviceTime, want[i].arrival, want[i].serviceTime)
+		}
+	}
+}
+
+func TestClusterTraceGenerator(t *testing.T) {
+	path := writeFile(t, "batch_task.csv", `M1,1,j_1,1,Terminated,157213,157322,100,0.3
+M2,1,j_1,1,Failed,157213,157300,100,0.3
+M1,2,j_2,1,Terminated,157210,157210.5,50,0.2
+M3,1,j_3,1,Terminated,157220,157219,100,0.3
+M4,1,j_4,1,Terminated,,157300,100,0.3
+M1,1,j_5,1,Terminated,157215,157216,100
+`)
+	// sorted by start time from the first one, in us, skipping the failed,
+	// inconsistent and incomplete tasks
+	checkEntries(t, NewClusterTraceGenerator(path), []traceEntry{
+		{0, 0.5e6},
+		{3e6, 109e6},
+	})
+}
+
+func TestScriptedGeneratorRTC(t *testing.T) {
+	// arrivals at 0, 1 and 11: the second request waits for the first one
+	// till 5, the third one finds the core idle
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 5}, {1, 2}, {10, 3}})
+	stats, _ := runProcessors(g, 1, 1e3, NewRTCProcessor(0))
+	want := []RequestData{
+		{ServiceTime: 5, Delay: 5, ArrivalTime: 0},
+		{ServiceTime: 2, Delay: 6, ArrivalTime: 1},
+		{ServiceTime: 3, Delay: 3, ArrivalTime: 11},
+	}
+	if stats.Count() != len(want) {
+		t.Fatalf("%v requests completed, want %v", stats.Count(), len(want))
+	}
+	for i, item := range stats.items {
+		if item.ServiceTime != want[i].ServiceTime || item.Delay != want[i].Delay || item.ArrivalTime != want[i].ArrivalTime {
+			t.Errorf("request %v: %+v, want %+v", i, item, want[i])
+		}
+	}
+}
+
+func TestMixtureCDFGeneratorWeights(t *testing.T) {
+	// degenerate CDFs of sizes 1000 and 5000, i.e. service times 1 and 5
+	paths := []string{
+		writeFile(t, "small.cdf", "1000\n1000 1\n"),
+		writeFile(t, "large.cdf", "5000\n5000 1\n"),
+	}
+	g := NewMixtureCDFGenerator(0.1, paths, []float64{0.3, 0.7})
+	stats, _ := runProcessors(g, 1, 1e5, NewRTCProcessor(0))
+	counts := map[string]int{}
+	for _, item := range stats.items {
+		class := item.Tags[ClassTag]
+		if class == "0" && item.ServiceTime != 1 || class == "1" && item.ServiceTime != 5 {
+			t.Fatalf("service time %v drawn from CDF %q", item.ServiceTime, class)
+		}
+		counts[class]++
+	}
+	n := float64(stats.Count())
+	if len(counts) != 2 || math.Abs(float64(counts["0"])/n-0.3) > 0.02 {
+		t.Errorf("%v requests per CDF out of %v, want 30%% from the first one", counts, n)
+	}
+}
+
+func TestCDFScale(t *testing.T) {
+	path := writeFile(t, "sizes.cdf", "200\n100 0.5\n300 1\n")
+	unscaled, scaled := loadCDF(path, 1), loadCDF(path, 100)
+	if scaled.x[0] != 1 || scaled.x[1] != 3 || scaled.p[0] != 0.5 || scaled.p[1] != 1 {
+		t.Errorf("scaled CDF sizes %v and probabilities %v, want [1 3] and [0.5 1]", scaled.x, scaled.p)
+	}
+	// the same draws give the sizes divided by the scale
+	unscaled.seed(rand.New(rand.NewSource(1)))
+	scaled.seed(rand.New(rand.NewSource(1)))
+	for i := 0; i < 1000; i++ {
+		if u, s := unscaled.sample(), scaled.sample(); !almostEqual(s, u/100) {
+			t.Fatalf("sample %v: %v scaled, %v unscaled", i, s, u)
+		}
+	}
+	if g := NewCDFGenerator(1, path); g.cdf.x[1] != 300/DefaultCDFScale {
+		t.Errorf("size 300 scaled to %v by default, want %v", g.cdf.x[1], 300/DefaultCDFScale)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic with a zero scale")
+		}
+	}()
+	loadCDF(path, 0)
+}
+
+func TestTraceGeneratorArrivals(t *testing.T) {
+	// absolute arrival times, not interarrival ones
+	g := NewTraceGenerator(writeFile(t, "three.trace", "0 2\n5 1\n5.5 3\n"))
+	checkEntries(t, g, []traceEntry{{0, 2}, {5, 1}, {5.5, 3}})
+	stats, _ := runProcessors(g, 1, 1e3, NewRTCProcessor(0))
+	// the third request waits for the second one till 6
+	want := []RequestData{
+		{ServiceTime: 2, Delay: 2, ArrivalTime: 0},
+		{ServiceTime: 1, Delay: 1, ArrivalTime: 5},
+		{ServiceTime: 3, Delay: 3.5, ArrivalTime: 5.5},
+	}
+	if stats.Count() != len(want) {
+		t.Fatalf("%v requests completed, want %v", stats.Count(), len(want))
+	}
+	for i, item := range stats.items {
+		if item.ServiceTime != want[i].ServiceTime || item.Delay != want[i].Delay || item.ArrivalTime != want[i].ArrivalTime {
+			t.Errorf("request %v: %+v, want %+v", i, item, want[i])
+		}
+	}
+}
+
+func TestClosedLoopGeneratorInFlight(t *testing.T) {
+	const clients, think, duration = 5, 1.0, 1e5
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	g := NewClosedLoopGenerator(clients, think, &SimpleReqCreator{})
+	g.SetServiceTimeOf(NewMMRandGenerator(1, 1))
+	g.SetReqDrain(stats)
+	q := NewQueue()
+	g.AddOutQueue(q)
+	for i := 0; i < 2; i++ {
+		p := NewRTCProcessor(0)
+		p.AddInQueue(q)
+		p.SetReqDrain(g)
+		sim.RegisterActor(p)
+	}
+	sim.RegisterActor(g)
+	sim.Run(duration)
+
+	if g.maxInFlight != clients || g.InFlight() > clients || g.InFlight() < 0 {
+		t.Errorf("at most %v requests in flight and %v at the end, want at most %v", g.maxInFlight, g.InFlight(), clients)
+	}
+	// the interactive response time law: throughput = clients / (delay + think)
+	want := clients / (stats.MeanDelay() + think)
+	if x := float64(stats.Count()) / duration; math.Abs(x-want) > 0.02*want {
+		t.Errorf("throughput %v, want %v", x, want)
+	}
+}
+
+func TestPBGeneratorPlayback(t *testing.T) {
+	// the second CPU has no service times and is skipped
+	paths := []string{
+		writeFile(t, "cpu0", "1\n2\n3\n"),
+		writeFile(t, "cpu1", ""),
+		writeFile(t, "cpu2", "10\n20\n"),
+	}
+	type pick struct{ cpu, serviceTime int }
+	picks := func(g *PBGenerator, n int) []pick {
+		res := make([]pick, n)
+		for i := range res {
+			res[i].cpu, res[i].serviceTime = g.next()
+		}
+		return res
+	}
+
+	// the generators of simulations with the same seed draw the same requests
+	first, second := NewPBGenerator(1, paths), NewPBGenerator(1, paths)
+	first.SetSimulation(newTestSimulation())
+	second.SetSimulation(newTestSimulation())
+	a, b := picks(first, 100), picks(second, 100)
+	cpus := map[int]bool{}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("request %v: %v and %v with the same seed", i, a[i], b[i])
+		}
+		cpus[a[i].cpu] = true
+		if a[i].cpu == 0 && (a[i].serviceTime < 1 || a[i].serviceTime > 3) ||
+			a[i].cpu == 2 && a[i].serviceTime != 10 && a[i].serviceTime != 20 {
+			t.Errorf("request %v: service time %v on CPU %v", i, a[i].serviceTime, a[i].cpu)
+		}
+	}
+	if !cpus[0] || cpus[1] || !cpus[2] {
+		t.Errorf("CPUs %v drawn, want 0 and 2", cpus)
+	}
+
+	// round robin cycles through the CPUs and through the times of each one
+	g := NewPBGenerator(1, paths)
+	g.SetRoundRobin(true)
+	want := []pick{{0, 1}, {2, 10}, {0, 2}, {2, 20}, {0, 3}, {2, 10}, {0, 1}, {2, 20}}
+	for i, p := range picks(g, len(want)) {
+		if p != want[i] {
+			t.Errorf("request %v: %v, want %v", i, p, want[i])
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package blocks
+
+import (
+	"fmt"
+)
+
+type momentsGetter interface {
+	getMoments() (arrMean, arrSCV, svcMean, svcSCV float64, ok bool)
+}
+
+type markovGetter interface {
+	isMarkovian() (arrivals, service bool)
+}
+
+// KingmanWait returns the Kingman approximation of the mean waiting time in a
+// GI/G/1 queue with utilization rho, squared coefficients of variation ca2 and
+// cs2 of the interarrival and service times and mean service time meanService
+func KingmanWait(rho, ca2, cs2, meanService float64) float64 {
+	return rho / (1 - rho) * (ca2 + cs2) / 2 * meanService
+}
+
+// KingmanKeeper compares the simulated mean waiting time of a GI/G/1 FIFO
+// queue with the Kingman approximation
+type KingmanKeeper struct {
+	statsOutput
+	g     Generator
+	stats *AllKeeper
+}
+
+// NewKingmanKeeper returns a new *KingmanKeeper for the generator feeding the
+// queue and the statistics of the served requests
+func NewKingmanKeeper(g Generator, stats *AllKeeper) *KingmanKeeper {
+	return &KingmanKeeper{g: g, stats: stats}
+}
+
+// PrintStats prints the approximated and simulated mean waiting times.
+// This is called by the model
+func (k *KingmanKeeper) PrintStats() {
+	mg, ok := k.g.(momentsGetter)
+	if !ok {
+		return
+	}
+	arrMean, ca2, svcMean, cs2, ok := mg.getMoments()
+	if !ok || k.stats.Count() == 0 {
+		return
+	}
+	rho := svcMean / arrMean
+	if rho >= 1 {
+		fmt.Fprintf(k.out(), "kingman_wait:inf\tsimulated_wait:%v\n", k.stats.MeanWait())
+		return
+	}
+	fmt.Fprintf(k.out(), "kingman_wait:%v\tsimulated_wait:%v\n", KingmanWait(rho, ca2, cs2, svcMean), k.stats.MeanWait())
+}
+
+// ErlangC returns the probability that a request waits in an M/M/k queue
+// with k servers and offered load a = lambda/mu, which should be below k
+func ErlangC(k int, a float64) float64 {
+	// Erlang B by recurrence, which does not overflow
+	b := 1.0
+	for n := 1; n <= k; n++ {
+		b = a * b / (float64(n) + a*b)
+	}
+	return float64(k) * b / (float64(k) - a*(1-b))
+}
+
+// MMKWait returns the mean waiting time and the probability of waiting in an
+// M/M/k queue with k servers, offered load a = lambda/mu below k and mean
+// service time meanService
+func MMKWait(k int, a, meanService float64) (wait, pWait float64) {
+	pWait = ErlangC(k, a)
+	return pWait * meanService / (float64(k) - a), pWait
+}
+
+// MG1Wait returns the Pollaczek-Khinchine mean waiting time and the
+// probability of waiting in an M/G/1 queue with utilization rho, squared
+// coefficient of variation cs2 of the service times and mean service time
+// meanService
+func MG1Wait(rho, cs2, meanService float64) (wait, pWait float64) {
+	return rho / (1 - rho) * (1 + cs2) / 2 * meanService, rho
+}
+
+// QueueingModelKeeper compares the simulated mean waiting time and probability
+// of waiting of a FIFO queue with the exact results of the M/M/k queue, if
+// the arrivals are Poisson and the service times exponential, or else of the
+// M/G/1 queue, if the arrivals are Poisson and there is a single core
+type QueueingModelKeeper struct {
+	statsOutput
+	g     Generator
+	stats *AllKeeper
+	cores int
+}
+
+// NewQueueingModelKeeper returns a new *QueueingModelKeeper for the generator
+// feeding the queue, the statistics of the served requests and the number of
+// cores serving the queue
+func NewQueueingModelKeeper(g Generator, stats *AllKeeper, cores int) *QueueingModelKeeper {
+	return &QueueingModelKeeper{g: g, stats: stats, cores: cores}
+}
+
+// PrintStats prints the theoretical and simulated mean waiting times and
+// probabilities of waiting, if the generator matches a known model.
+// This is called by the model
+func (k *QueueingModelKeeper) PrintStats() {
+	mg, okM := k.g.(momentsGetter)
+	mk, okK := k.g.(markovGetter)
+	if !okM || !okK || k.stats.Count() == 0 {
+		return
+	}
+	arrMean, _, svcMean, cs2, ok := mg.getMoments()
+	poisson, expService := mk.isMarkovian()
+	if !ok || !poisson || (!expService && k.cores > 1) {
+		return
+	}
+	model := "mg1"
+	if expService {
+		model = "mmk"
+	}
+	a := svcMean / arrMean
+	if a >= float64(k.cores) {
+		fmt.Fprintf(k.out(), "%v_wait:inf	%v_p_wait:1	simulated_wait:%v	simulated_p_wait:%v\n",
+			model, model, k.stats.MeanWait(), k.stats.WaitFraction())
+		return
+	}
+	var wait, pWait float64
+	if expService {
+		wait, pWait = MMKWait(k.cores, a, svcMean)
+	} else {
+		wait, pWait = MG1Wait(a, cs2, svcMean)
+	}
+	fmt.Fprintf(k.out(), "%v_wait:%v	%v_p_wait:%v	simulated_wait:%v	simulated_p_wait:%v\n",
+		model, wait, model, pWait, k.stats.MeanWait(), k.stats.WaitFraction())
+}
+
+// Autocorrelation returns the lag-k sample autocorrelation of xs
+func Autocorrelation(xs []float64, k int) float64 {
+	n := len(xs)
+	if k < 0 || k >= n {
+		return 0
+	}
+	var mean float64
+	for _, x := range xs {
+		mean += x
+	}
+	mean /= float64(n)
+	var num, den float64
+	for i, x := range xs {
+		den += (x - mean) * (x - mean)
+		if i+k < n {
+			num += (x - mean) * (xs[i+k] - mean)
+		}
+	}
+	if den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// AutocorrKeeper reports the autocorrelation of the delays of the terminated
+// requests, in termination order, for lags 1 to maxLag. Positive values mean
+// that consecutive requests see similar delays, e.g. under bursty arrivals
+type AutocorrKeeper struct {
+	statsOutput
+	stats  *AllKeeper
+	maxLag int
+}
+
+// NewAutocorrKeeper returns a new *AutocorrKeeper for the statistics of the
+// served requests
+func NewAutocorrKeeper(stats *AllKeeper, maxLag int) *AutocorrKeeper {
+	return &AutocorrKeeper{stats: stats, maxLag: maxLag}
+}
+
+// PrintStats prints the autocorrelation for every lag.
+// This is called by the model
+func (k *AutocorrKeeper) PrintStats() {
+	delays := k.stats.Delays()
+	fmt.Fprintf(k.out(), "Autocorrelation")
+	for lag := 1; lag <= k.maxLag; lag++ {
+		fmt.Fprintf(k.out(), "\tlag%v:%v", lag, Autocorrelation(delays, lag))
+	}
+	fmt.Fprintln(k.out())
+}
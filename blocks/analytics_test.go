@@ -0,0 +1,132 @@
+package blocks
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// runFIFO runs the requests of g through a FIFO queue served by cores RTC
+// cores till duration and returns the statistics
+func runFIFO(g Generator, cores int, duration float64) *AllKeeper {
+	procs := make([]Processor, cores)
+	for i := range procs {
+		procs[i] = NewRTCProcessor(0)
+	}
+	stats, _ := runProcessors(g, 1, duration, procs...)
+	return stats
+}
+
+func TestKingmanWait(t *testing.T) {
+	for _, tc := range []struct {
+		rho, ca2, cs2, s float64
+		want             float64
+	}{
+		{0.5, 1, 1, 1, 1},   // M/M/1: rho/(1-rho) * s
+		{0.5, 1, 0, 1, 0.5}, // M/D/1: half of M/M/1
+		{0.8, 1, 1, 2, 8},   // M/M/1 scaled by the service time
+		{0.8, 0.5, 2, 1, 5}, // 4 * 1.25
+		{0.9, 0, 0, 1, 0},   // D/D/1 never waits
+		{0.25, 2, 4, 3, 3},  // 1/3 * 3 * 3
+	} {
+		if got := KingmanWait(tc.rho, tc.ca2, tc.cs2, tc.s); !almostEqual(got, tc.want) {
+			t.Errorf("KingmanWait(%v, %v, %v, %v) = %v, want %v", tc.rho, tc.ca2, tc.cs2, tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestKingmanWaitMG1(t *testing.T) {
+	// with Poisson arrivals the approximation is the exact
+	// Pollaczek-Khinchine mean waiting time
+	g := NewMDRandGenerator(0.5, 1)
+	arrMean, ca2, svcMean, cs2, ok := g.getMoments()
+	if !ok || arrMean != 2 || ca2 != 1 || svcMean != 1 || cs2 != 0 {
+		t.Fatalf("moments %v %v %v %v %v, want 2 1 1 0 true", arrMean, ca2, svcMean, cs2, ok)
+	}
+	want := KingmanWait(svcMean/arrMean, ca2, cs2, svcMean)
+	if wait := runFIFO(g, 1, 2e5).MeanWait(); math.Abs(wait-want) > 0.05*want {
+		t.Errorf("mean wait %v, want about %v", wait, want)
+	}
+}
+
+func TestAutocorrelation(t *testing.T) {
+	if r := Autocorrelation([]float64{1, 2, 3, 4}, 1); !almostEqual(r, 0.25) {
+		t.Errorf("lag 1 autocorrelation of 1..4 %v, want 0.25", r)
+	}
+	if r := Autocorrelation([]float64{2, 2, 2}, 1); r != 0 {
+		t.Errorf("autocorrelation of a constant %v, want 0", r)
+	}
+
+	// independent samples, and an AR(1) process x[i] = 0.8 x[i-1] + noise
+	// whose lag k autocorrelation is 0.8^k
+	rng := rand.New(rand.NewSource(1))
+	const n = 100000
+	independent, ar := make([]float64, n), make([]float64, n)
+	for i := range independent {
+		independent[i] = rng.ExpFloat64()
+		ar[i] = rng.NormFloat64()
+		if i > 0 {
+			ar[i] += 0.8 * ar[i-1]
+		}
+	}
+	for lag := 1; lag <= 3; lag++ {
+		if r := Autocorrelation(independent, lag); math.Abs(r) > 0.02 {
+			t.Errorf("lag %v autocorrelation of independent samples %v, want about 0", lag, r)
+		}
+		if r, want := Autocorrelation(ar, lag), math.Pow(0.8, float64(lag)); math.Abs(r-want) > 0.02 {
+			t.Errorf("lag %v autocorrelation of the AR(1) process %v, want about %v", lag, r, want)
+		}
+	}
+
+	// consecutive requests of a loaded queue see similar delays
+	if r := Autocorrelation(runFIFO(NewMMRandGenerator(0.8, 1), 1, 1e5).Delays(), 1); r < 0.5 {
+		t.Errorf("lag 1 autocorrelation of M/M/1 delays %v, want strongly positive", r)
+	}
+}
+
+func TestErlangC(t *testing.T) {
+	for _, tc := range []struct {
+		k       int
+		a, want float64
+	}{
+		{1, 0.5, 0.5},        // a single server is busy a of the time
+		{2, 1, 1.0 / 3},      // 2 rho^2 / (1 + rho) for 2 servers
+		{2, 1.6, 1.28 / 1.8}, // likewise at rho = 0.8
+		{10, 0, 0},
+	} {
+		if got := ErlangC(tc.k, tc.a); !almostEqual(got, tc.want) {
+			t.Errorf("ErlangC(%v, %v) = %v, want %v", tc.k, tc.a, got, tc.want)
+		}
+	}
+	if wait, pWait := MMKWait(2, 1, 2); !almostEqual(wait, 2.0/3) || !almostEqual(pWait, 1.0/3) {
+		t.Errorf("M/M/2 wait %v and probability of waiting %v, want %v and %v", wait, pWait, 2.0/3, 1.0/3)
+	}
+	// M/D/1 waits half as long as M/M/1
+	if wait, pWait := MG1Wait(0.5, 0, 1); !almostEqual(wait, 0.5) || pWait != 0.5 {
+		t.Errorf("M/D/1 wait %v and probability of waiting %v, want 0.5 and 0.5", wait, pWait)
+	}
+}
+
+func TestQueueingModelKeeperMMK(t *testing.T) {
+	// M/M/2 at a load of 0.8
+	g := NewMMRandGenerator(1.6, 1)
+	stats := runFIFO(g, 2, 2e5)
+	wait, pWait := MMKWait(2, 1.6, 1)
+	if w := stats.MeanWait(); math.Abs(w-wait) > 0.05*wait {
+		t.Errorf("mean wait %v, want about %v", w, wait)
+	}
+	if p := stats.WaitFraction(); math.Abs(p-pWait) > 0.02 {
+		t.Errorf("probability of waiting %v, want about %v", p, pWait)
+	}
+
+	var out bytes.Buffer
+	k := NewQueueingModelKeeper(g, stats, 2)
+	k.SetOutput(&out)
+	k.PrintStats()
+	if want := fmt.Sprintf("mmk_wait:%v\tmmk_p_wait:%v\t", wait, pWait); !strings.HasPrefix(out.String(), want) {
+		t.Errorf("report %q, want it to start with %q", out.String(), want)
+	}
+}
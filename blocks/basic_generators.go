@@ -2,8 +2,10 @@ package blocks
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
-	"time"
+	"runtime"
+	"sort"
 
 	"github.com/epfl-dcsl/schedsim/engine"
 )
@@ -20,24 +22,276 @@ type genericGenerator struct {
 	Creator     ReqCreator
 	ServiceTime randDist
 	WaitTime    randDist
+	rng         *rand.Rand
+	info        []string // reported once registered
 }
 
 func (g *genericGenerator) SetCreator(rc ReqCreator) {
 	g.Creator = rc
 }
 
+// SetSimulation seeds the generator, its distributions and its request
+// creator from the simulation it is registered in, so that the seed of the
+// simulation reproduces the same requests
+func (g *genericGenerator) SetSimulation(s *engine.Simulation) {
+	src := s.Rand()
+	g.rng = seededRand(src)
+	seedAll(src, g.ServiceTime, g.WaitTime, g.Creator)
+	for _, line := range g.info {
+		fmt.Fprint(s.InfoOutput(), line)
+	}
+	g.info = nil
+}
+
+// logf records a line about the generator, e.g. its parameters or a warning,
+// printed to the information output of the simulation it is registered in
+func (g *genericGenerator) logf(format string, args ...interface{}) {
+	g.info = append(g.info, fmt.Sprintf(format, args...))
+}
+
+// newRequest returns a new request of the creator of the generator, created
+// now with the given service time
+func (g *genericGenerator) newRequest(serviceTime float64) engine.ReqInterface {
+	return g.Creator.NewRequest(g.Simulation(), serviceTime)
+}
+
+func (g *genericGenerator) getServiceTime() randDist {
+	return g.ServiceTime
+}
+
+// Wait blocks the generator for d like the Actor one. If the simulation is
+// draining by then, the generator finishes instead of issuing more requests,
+// letting the processors serve the ones in flight. An infinite d, i.e. no
+// more arrivals, finishes the generator right away
+func (g *genericGenerator) Wait(d float64) {
+	if math.IsInf(d, 1) {
+		g.Done()
+		runtime.Goexit()
+	}
+	g.Actor.Wait(d)
+	if g.Simulation().Draining() {
+		g.Done()
+		runtime.Goexit()
+	}
+}
+
+// getMoments returns the mean and squared coefficient of variation of the
+// interarrival and service times, if known
+func (g *genericGenerator) getMoments() (arrMean, arrSCV, svcMean, svcSCV float64, ok bool) {
+	wait, okW := g.WaitTime.(momentDist)
+	service, okS := g.ServiceTime.(momentDist)
+	if !okW || !okS {
+		return 0, 0, 0, 0, false
+	}
+	return wait.mean(), wait.scv(), service.mean(), service.scv(), true
+}
+
+// isMarkovian returns whether the arrivals are Poisson and whether the
+// service times are exponential
+func (g *genericGenerator) isMarkovian() (arrivals, service bool) {
+	_, arrivals = g.WaitTime.(*exponDistr)
+	_, service = g.ServiceTime.(*exponDistr)
+	return arrivals, service
+}
+
+// DispatchPolicy selects the output queue a generator feeds every request to
+type DispatchPolicy int
+
+const (
+	// DispatchRandom picks a uniformly random queue
+	DispatchRandom DispatchPolicy = iota
+	// DispatchRoundRobin cycles through the queues
+	DispatchRoundRobin
+	// DispatchJSQ joins the shortest queue
+	DispatchJSQ
+	// DispatchPowerOfTwo samples two distinct queues and joins the shorter one
+	DispatchPowerOfTwo
+)
+
+var dispatchPolicyNames = []string{"random", "rr", "jsq", "po2"}
+
+// MarshalText returns the name of the policy, random, rr, jsq or po2
+func (p DispatchPolicy) MarshalText() ([]byte, error) {
+	if p < 0 || int(p) >= len(dispatchPolicyNames) {
+		return nil, fmt.Errorf("unknown dispatch policy: %d", int(p))
+	}
+	return []byte(dispatchPolicyNames[p]), nil
+}
+
+// UnmarshalText sets the policy from its name, random, rr, jsq or po2
+func (p *DispatchPolicy) UnmarshalText(text []byte) error {
+	for i, name := range dispatchPolicyNames {
+		if name == string(text) {
+			*p = DispatchPolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown dispatch policy: %s", text)
+}
+
+// DispatchGenerator is a generator feeding multiple queues whose dispatch
+// policy can be set
+type DispatchGenerator interface {
+	Generator
+	SetDispatch(DispatchPolicy)
+}
+
+// BulkDist selects the distribution of the number of requests a generator
+// issues at once at every arrival
+type BulkDist int
+
+const (
+	// BulkFixed issues the same number of requests at every arrival
+	BulkFixed BulkDist = iota
+	// BulkGeometric issues a geometric number of requests, at least one
+	BulkGeometric
+)
+
+var bulkDistNames = []string{"fixed", "geometric"}
+
+// MarshalText returns the name of the bulk distribution, fixed or geometric
+func (b BulkDist) MarshalText() ([]byte, error) {
+	if b < 0 || int(b) >= len(bulkDistNames) {
+		return nil, fmt.Errorf("unknown bulk distribution: %d", int(b))
+	}
+	return []byte(bulkDistNames[b]), nil
+}
+
+// UnmarshalText sets the bulk distribution from its name, fixed or geometric
+func (b *BulkDist) UnmarshalText(text []byte) error {
+	for i, name := range bulkDistNames {
+		if name == string(text) {
+			*b = BulkDist(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown bulk distribution: %v", string(text))
+}
+
+// BulkGenerator is a generator whose arrivals can issue several requests at
+// once, e.g. packets coalesced by a single NIC interrupt
+type BulkGenerator interface {
+	Generator
+	SetBulk(dist BulkDist, mean float64)
+}
+
 type randGenerator struct {
 	genericGenerator
+	dispatch DispatchPolicy
+	next     int
+	queues   []engine.QueueInterface
+	bulk     BulkDist
+	bulkMean float64 // 0 or 1 for single arrivals
+}
+
+// AddOutQueue adds another output queue, recording it for the dispatch
+// policies
+func (g *randGenerator) AddOutQueue(q engine.QueueInterface) {
+	g.Actor.AddOutQueue(q)
+	g.queues = append(g.queues, q)
+}
+
+// queueLoad returns the load of the given (idx) output queue, its length
+// unless it tells otherwise, e.g. a MachineQueue
+func (g *randGenerator) queueLoad(idx int) int {
+	if l, ok := g.queues[idx].(loadGetter); ok {
+		return l.getLoad()
+	}
+	return g.GetOutQueueLen(idx)
+}
+
+// SetDispatch sets the dispatch policy, random by default
+func (g *randGenerator) SetDispatch(policy DispatchPolicy) {
+	g.dispatch = policy
+}
+
+// pickQueue returns the index of the output queue for the next request.
+// Ties between queues of the same load go to the lowest index
+func (g *randGenerator) pickQueue() int {
+	count := g.GetOutQueueCount()
+	switch g.dispatch {
+	case DispatchRoundRobin:
+		idx := g.next % count
+		g.next++
+		return idx
+	case DispatchJSQ:
+		best := 0
+		for i := 1; i < count; i++ {
+			if g.queueLoad(i) < g.queueLoad(best) {
+				best = i
+			}
+		}
+		return best
+	case DispatchPowerOfTwo:
+		if count == 1 {
+			return 0
+		}
+		i := g.rng.Intn(count)
+		j := g.rng.Intn(count - 1)
+		if j >= i {
+			j++
+		}
+		if i > j {
+			i, j = j, i
+		}
+		if g.queueLoad(j) < g.queueLoad(i) {
+			return j
+		}
+		return i
+	default:
+		return g.rng.Intn(count)
+	}
+}
+
+// SetBulk makes every arrival issue a number of requests of the given
+// distribution and mean, each with its own service time and output queue. The
+// mean of a fixed size should be a whole number
+func (g *randGenerator) SetBulk(dist BulkDist, mean float64) {
+	if mean < 1 || (dist == BulkFixed && mean != math.Trunc(mean)) {
+		panic(fmt.Sprintf("Invalid %v bulk size: %v", bulkDistNames[dist], mean))
+	}
+	g.bulk = dist
+	g.bulkMean = mean
+}
+
+// bulkSize returns the number of requests of the next arrival
+func (g *randGenerator) bulkSize() int {
+	if g.bulkMean <= 1 {
+		return 1
+	}
+	if g.bulk == BulkFixed {
+		return int(g.bulkMean)
+	}
+	// geometric on 1, 2, ... with success probability 1/bulkMean
+	return 1 + int(math.Log(1-g.rng.Float64())/math.Log(1-1/g.bulkMean))
+}
+
+// getMoments returns the moments of the generator unless its arrivals are
+// bulks, which the single arrival models do not describe
+func (g *randGenerator) getMoments() (arrMean, arrSCV, svcMean, svcSCV float64, ok bool) {
+	if g.bulkMean > 1 {
+		return 0, 0, 0, 0, false
+	}
+	return g.genericGenerator.getMoments()
+}
+
+// isMarkovian returns whether the arrivals are Poisson, but not bulks, and
+// whether the service times are exponential
+func (g *randGenerator) isMarkovian() (arrivals, service bool) {
+	arrivals, service = g.genericGenerator.isMarkovian()
+	return arrivals && g.bulkMean <= 1, service
 }
 
 func (g *randGenerator) Run() {
 	for {
-		req := g.Creator.NewRequest(g.ServiceTime.getRand())
-		qIdx := rand.Intn(g.GetOutQueueCount())
-		if monitorReq, ok := req.(*MonitorReq); ok {
-			monitorReq.initLength = g.GetAllOutQueueLens()[qIdx]
+		for n := g.bulkSize(); n > 0; n-- {
+			req := g.newRequest(g.ServiceTime.getRand())
+			qIdx := g.pickQueue()
+			if monitorReq, ok := req.(*MonitorReq); ok {
+				monitorReq.initLength = g.GetAllOutQueueLens()[qIdx]
+			}
+			g.WriteOutQueueI(req, qIdx)
 		}
-		g.WriteOutQueueI(req, qIdx)
 		g.Wait(g.WaitTime.getRand())
 	}
 }
@@ -48,7 +302,7 @@ type rRGenerator struct {
 
 func (g *rRGenerator) Run() {
 	for count := 0; ; count++ {
-		req := g.Creator.NewRequest(g.ServiceTime.getRand())
+		req := g.newRequest(g.ServiceTime.getRand())
 		g.WriteOutQueueI(req, count%g.GetOutQueueCount())
 		g.Wait(g.WaitTime.getRand())
 	}
@@ -61,8 +315,8 @@ type DDGenerator struct {
 
 // NewDDGenerator returns a DDGenerator
 func NewDDGenerator(waitTime, serviceTime float64) *DDGenerator {
-	fmt.Printf("NewDDGenerator called with waitTime: %v, serviceTime: %v\n", waitTime, serviceTime)
 	g := &DDGenerator{}
+	g.logf("NewDDGenerator called with waitTime: %v, serviceTime: %v\n", waitTime, serviceTime)
 	g.ServiceTime = newDeterministicDistr(serviceTime)
 	g.WaitTime = newDeterministicDistr(waitTime)
 	return g
@@ -76,11 +330,8 @@ type MDGenerator struct {
 
 // NewMDGenerator returns a MDGenerator
 func NewMDGenerator(waitLambda float64, serviceTime float64) *MDGenerator {
-	fmt.Printf("NewMDGenerator called with waitLambda: %v, serviceTime: %v\n", waitLambda, serviceTime)
-	// Seed with time
-	rand.Seed(time.Now().UTC().UnixNano())
-
 	g := &MDGenerator{}
+	g.logf("NewMDGenerator called with waitLambda: %v, serviceTime: %v\n", waitLambda, serviceTime)
 	g.ServiceTime = newDeterministicDistr(serviceTime)
 	g.WaitTime = newExponDistr(waitLambda)
 	return g
@@ -94,11 +345,9 @@ type MDRandGenerator struct {
 
 // NewMDRandGenerator returns a MDRandGenerator
 func NewMDRandGenerator(waitLambda float64, serviceTime float64) *MDRandGenerator {
-	fmt.Printf("NewMDRandGenerator called with waitLambda: %v, serviceTime: %v\n", waitLambda, serviceTime)
-	// Seed with time
-	rand.Seed(time.Now().UTC().UnixNano())
-
 	g := &MDRandGenerator{}
+	g.logf("NewMDRandGenerator called with waitLambda: %v, serviceTime: %v\n", waitLambda, serviceTime)
+	g.rng = newRand()
 	g.WaitTime = newExponDistr(waitLambda)
 	g.ServiceTime = newDeterministicDistr(serviceTime)
 	return g
@@ -112,11 +361,8 @@ type MMGenerator struct {
 
 // NewMMGenerator returns a MMGenerator
 func NewMMGenerator(waitLambda float64, serviceMu float64) *MMGenerator {
-	fmt.Printf("NewMMGenerator called with waitLambda: %v, serviceMu: %v\n", waitLambda, serviceMu)
-	// Seed with time
-	rand.Seed(time.Now().UTC().UnixNano())
-
 	g := &MMGenerator{}
+	g.logf("NewMMGenerator called with waitLambda: %v, serviceMu: %v\n", waitLambda, serviceMu)
 	g.ServiceTime = newExponDistr(serviceMu)
 	g.WaitTime = newExponDistr(waitLambda)
 	return g
@@ -130,11 +376,9 @@ type MMRandGenerator struct {
 
 // NewMMRandGenerator returns a MMRandGenerator
 func NewMMRandGenerator(waitLambda float64, serviceMu float64) *MMRandGenerator {
-	fmt.Printf("NewMMRandGenerator called with waitLambda: %v, serviceMu: %v\n", waitLambda, serviceMu)
-	// Seed with time
-	rand.Seed(time.Now().UTC().UnixNano())
-
 	g := &MMRandGenerator{}
+	g.logf("NewMMRandGenerator called with waitLambda: %v, serviceMu: %v\n", waitLambda, serviceMu)
+	g.rng = newRand()
 	g.ServiceTime = newExponDistr(serviceMu)
 	g.WaitTime = newExponDistr(waitLambda)
 	return g
@@ -148,11 +392,8 @@ type MLNGenerator struct {
 
 // NewMLNGenerator returns an MLNGenerator
 func NewMLNGenerator(waitLambda, mu, sigma float64) *MLNGenerator {
-	fmt.Printf("NewMLNGenerator called with waitLambda: %v, mu: %v, sigma: %v\n", waitLambda, mu, sigma)
-	// Seed with time
-	rand.Seed(time.Now().UTC().UnixNano())
-
 	g := &MLNGenerator{}
+	g.logf("NewMLNGenerator called with waitLambda: %v, mu: %v, sigma: %v\n", waitLambda, mu, sigma)
 	g.ServiceTime = newLGDistr(mu, sigma)
 	g.WaitTime = newExponDistr(waitLambda)
 	return g
@@ -167,11 +408,8 @@ type MBGenerator struct {
 
 // NewMBGenerator returns a MBGenerator
 func NewMBGenerator(waitLambda, peak1, peak2, ratio float64) *MBGenerator {
-	fmt.Printf("NewMBGenerator called with waitLambda: %v, peak1: %v, peak2: %v, ratio: %v\n", waitLambda, peak1, peak2, ratio)
-	// Seed with time
-	rand.Seed(time.Now().UTC().UnixNano())
-
 	g := &MBGenerator{}
+	g.logf("NewMBGenerator called with waitLambda: %v, peak1: %v, peak2: %v, ratio: %v\n", waitLambda, peak1, peak2, ratio)
 	g.ServiceTime = newBiDistr(peak1, peak2, ratio)
 	g.WaitTime = newExponDistr(waitLambda)
 	return g
@@ -186,12 +424,356 @@ type MBRandGenerator struct {
 
 // NewMBRandGenerator returns a new MBRandGenerator
 func NewMBRandGenerator(waitLambda, peak1, peak2, ratio float64) *MBRandGenerator {
-	fmt.Printf("NewMBRandGenerator called with waitLambda: %v, peak1: %v, peak2: %v, ratio: %v\n", waitLambda, peak1, peak2, ratio)
-	// Seed with time
-	rand.Seed(time.Now().UTC().UnixNano())
-
 	g := &MBRandGenerator{}
+	g.logf("NewMBRandGenerator called with waitLambda: %v, peak1: %v, peak2: %v, ratio: %v\n", waitLambda, peak1, peak2, ratio)
+	g.rng = newRand()
 	g.ServiceTime = newBiDistr(peak1, peak2, ratio)
 	g.WaitTime = newExponDistr(waitLambda)
 	return g
 }
+
+// BoundedParetoGenerator is a poisson interarrival generator with
+// requests with bounded Pareto distributed service times
+// If multiple queues they are fed randomly
+type BoundedParetoGenerator struct {
+	randGenerator
+}
+
+// NewBoundedParetoGenerator returns a new BoundedParetoGenerator with service
+// times of shape alpha in [low, high]
+func NewBoundedParetoGenerator(waitLambda, alpha, low, high float64) *BoundedParetoGenerator {
+	if alpha <= 0 {
+		panic(fmt.Sprintf("Non positive bounded Pareto shape: %v", alpha))
+	}
+	if low <= 0 || low >= high {
+		panic(fmt.Sprintf("Invalid bounded Pareto support: [%v, %v]", low, high))
+	}
+	g := &BoundedParetoGenerator{}
+	g.logf("NewBoundedParetoGenerator called with waitLambda: %v, alpha: %v, low: %v, high: %v\n", waitLambda, alpha, low, high)
+	g.rng = newRand()
+	g.ServiceTime = newBoundedParetoDistr(alpha, low, high)
+	g.WaitTime = newExponDistr(waitLambda)
+	return g
+}
+
+// BoundedParetoLow returns the lower bound of the bounded Pareto distribution
+// of shape alpha and high/low ratio with the given mean
+func BoundedParetoLow(alpha, ratio, mean float64) float64 {
+	// the mean scales linearly with the bounds for a fixed ratio
+	return mean / newBoundedParetoDistr(alpha, 1, ratio).mean()
+}
+
+// ParetoGenerator is a poisson interarrival generator with
+// requests with Pareto distributed service times
+// If multiple queues they are fed randomly
+type ParetoGenerator struct {
+	randGenerator
+}
+
+// NewParetoGenerator returns a new ParetoGenerator with service times of
+// shape alpha and at least low. alpha should exceed 1 for a finite mean
+func NewParetoGenerator(waitLambda, alpha, low float64) *ParetoGenerator {
+	if alpha <= 1 {
+		panic(fmt.Sprintf("Pareto shape without a finite mean: %v", alpha))
+	}
+	if low <= 0 {
+		panic(fmt.Sprintf("Non positive Pareto scale: %v", low))
+	}
+	g := &ParetoGenerator{}
+	g.logf("NewParetoGenerator called with waitLambda: %v, alpha: %v, low: %v\n", waitLambda, alpha, low)
+	g.rng = newRand()
+	g.ServiceTime = newParetoDistr(alpha, low)
+	g.WaitTime = newExponDistr(waitLambda)
+	return g
+}
+
+// ParetoLow returns the scale, i.e. the smallest value, of the Pareto
+// distribution of shape alpha with the given mean
+func ParetoLow(alpha, mean float64) float64 {
+	return mean * (alpha - 1) / alpha
+}
+
+// WeibullGenerator is a poisson interarrival generator with
+// requests with Weibull distributed service times
+// If multiple queues they are fed randomly
+type WeibullGenerator struct {
+	randGenerator
+}
+
+// NewWeibullGenerator returns a new WeibullGenerator with service times of
+// shape k and the given mean
+func NewWeibullGenerator(waitLambda, k, mean float64) *WeibullGenerator {
+	if k <= 0 {
+		panic(fmt.Sprintf("Non positive Weibull shape: %v", k))
+	}
+	g := &WeibullGenerator{}
+	g.logf("NewWeibullGenerator called with waitLambda: %v, shape: %v, mean: %v\n", waitLambda, k, mean)
+	g.rng = newRand()
+	g.ServiceTime = newWeibullDistr(k, mean/math.Gamma(1+1/k))
+	g.WaitTime = newExponDistr(waitLambda)
+	return g
+}
+
+// GammaGenerator is a poisson interarrival generator with
+// requests with gamma distributed service times, Erlang for an integer shape
+// If multiple queues they are fed randomly
+type GammaGenerator struct {
+	randGenerator
+}
+
+// NewGammaGenerator returns a new GammaGenerator with service times of shape
+// k and the given mean
+func NewGammaGenerator(waitLambda, k, mean float64) *GammaGenerator {
+	if k <= 0 {
+		panic(fmt.Sprintf("Non positive gamma shape: %v", k))
+	}
+	g := &GammaGenerator{}
+	g.logf("NewGammaGenerator called with waitLambda: %v, shape: %v, mean: %v\n", waitLambda, k, mean)
+	g.rng = newRand()
+	g.ServiceTime = newGammaDistr(k, mean/k)
+	g.WaitTime = newExponDistr(waitLambda)
+	return g
+}
+
+// HyperExpGenerator is a poisson interarrival generator with
+// requests with hyperexponential service times of any number of phases,
+// generalizing the bimodal MBRandGenerator to high variance workloads
+// If multiple queues they are fed randomly
+type HyperExpGenerator struct {
+	randGenerator
+}
+
+// NewHyperExpGenerator returns a new HyperExpGenerator whose service times are
+// exponential of mean means[i] with probability probs[i]. The probabilities
+// should sum to 1
+func NewHyperExpGenerator(waitLambda float64, probs, means []float64) *HyperExpGenerator {
+	if len(probs) == 0 || len(probs) != len(means) {
+		panic(fmt.Sprintf("Hyperexponential needs as many probabilities as means, got %v and %v", len(probs), len(means)))
+	}
+	var sum float64
+	for i, p := range probs {
+		if p < 0 || means[i] <= 0 {
+			panic(fmt.Sprintf("Invalid hyperexponential phase: %v:%v", p, means[i]))
+		}
+		sum += p
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		panic(fmt.Sprintf("Hyperexponential probabilities sum to %v", sum))
+	}
+	g := &HyperExpGenerator{}
+	g.logf("NewHyperExpGenerator called with waitLambda: %v, probs: %v, means: %v\n", waitLambda, probs, means)
+	g.rng = newRand()
+	g.ServiceTime = newHyperExpDistr(probs, means)
+	g.WaitTime = newExponDistr(waitLambda)
+	return g
+}
+
+// ScaledPhaseMeans returns the phase means scaled so that the mean of the
+// hyperexponential distribution with the given probabilities is mean
+func ScaledPhaseMeans(probs, means []float64, mean float64) []float64 {
+	scale := mean / newHyperExpDistr(probs, means).mean()
+	res := make([]float64, len(means))
+	for i, m := range means {
+		res[i] = m * scale
+	}
+	return res
+}
+
+// CoxianGenerator is a poisson interarrival generator with
+// requests with Coxian distributed service times
+// If multiple queues they are fed randomly
+type CoxianGenerator struct {
+	randGenerator
+}
+
+// NewCoxianGenerator returns a new CoxianGenerator
+// rates are the rates of the exponential phases and probs[i] the probability
+// to continue from phase i to phase i+1, so len(probs) == len(rates)-1
+func NewCoxianGenerator(waitLambda float64, rates []float64, probs []float64) *CoxianGenerator {
+	if len(rates) == 0 || len(probs) != len(rates)-1 {
+		panic(fmt.Sprintf("Coxian needs n rates and n-1 probabilities, got %v and %v", len(rates), len(probs)))
+	}
+	for _, r := range rates {
+		if r <= 0 {
+			panic(fmt.Sprintf("Non positive Coxian rate: %v", r))
+		}
+	}
+	for _, p := range probs {
+		if p < 0 || p > 1 {
+			panic(fmt.Sprintf("Invalid Coxian probability: %v", p))
+		}
+	}
+
+	g := &CoxianGenerator{}
+	g.logf("NewCoxianGenerator called with waitLambda: %v, rates: %v, probs: %v\n", waitLambda, rates, probs)
+	g.rng = newRand()
+	g.ServiceTime = newCoxianDistr(rates, probs)
+	g.WaitTime = newExponDistr(waitLambda)
+	return g
+}
+
+// MMPPGenerator is a Markov modulated Poisson process generator with
+// exponential service times. Arrivals are Poisson with the rate of the current
+// state, and the state follows a continuous time Markov chain, so high rate
+// states produce bursts. It reports the mean and coefficient of variation of
+// the interarrival times, which exceeds 1 for bursty arrivals.
+// If multiple queues they are fed randomly
+type MMPPGenerator struct {
+	randGenerator
+	statsOutput
+	count     int
+	sum       float64
+	sumSquare float64
+}
+
+// NewMMPPGenerator returns a new *MMPPGenerator. rates are the arrival rates of
+// the states and transitionRates[i][j] the rate of going from state i to
+// state j, the diagonal being ignored. The first state is the initial one
+func NewMMPPGenerator(rates []float64, transitionRates [][]float64, mu float64) *MMPPGenerator {
+	if len(rates) == 0 || len(transitionRates) != len(rates) {
+		panic(fmt.Sprintf("MMPP needs n rates and n transition rows, got %v and %v", len(rates), len(transitionRates)))
+	}
+	for i, row := range transitionRates {
+		if len(row) != len(rates) {
+			panic(fmt.Sprintf("MMPP transition row %v has %v rates instead of %v", i, len(row), len(rates)))
+		}
+		total := rates[i]
+		if total < 0 {
+			panic(fmt.Sprintf("Negative MMPP arrival rate: %v", total))
+		}
+		for j, r := range row {
+			if r < 0 {
+				panic(fmt.Sprintf("Negative MMPP transition rate: %v", r))
+			}
+			if j != i {
+				total += r
+			}
+		}
+		if total == 0 {
+			panic(fmt.Sprintf("MMPP state %v has no arrivals and no transitions", i))
+		}
+	}
+
+	g := &MMPPGenerator{}
+	g.logf("NewMMPPGenerator called with rates: %v, transitionRates: %v, serviceMu: %v\n", rates, transitionRates, mu)
+	g.rng = newRand()
+	g.ServiceTime = newExponDistr(mu)
+	g.WaitTime = newMMPPDistr(rates, transitionRates)
+	return g
+}
+
+// Run is the main generator loop, recording the interarrival times
+func (g *MMPPGenerator) Run() {
+	for {
+		req := g.newRequest(g.ServiceTime.getRand())
+		g.WriteOutQueueI(req, g.pickQueue())
+		wait := g.WaitTime.getRand()
+		g.count++
+		g.sum += wait
+		g.sumSquare += wait * wait
+		g.Wait(wait)
+	}
+}
+
+// InterarrivalCV returns the coefficient of variation of the interarrival
+// times drawn so far
+func (g *MMPPGenerator) InterarrivalCV() float64 {
+	n := float64(g.count)
+	return stddev(g.sum, g.sumSquare, n) / (g.sum / n)
+}
+
+// StationaryRate returns the long run arrival rate of the state chain, NaN if
+// it has no unique stationary distribution
+func (g *MMPPGenerator) StationaryRate() float64 {
+	return g.WaitTime.(*mmppDistr).stationaryRate()
+}
+
+// PrintStats prints the mean and coefficient of variation of the interarrival
+// times, and the long run arrival rate and utilization of a single core
+// expected from the state chain. This is called by the model
+func (g *MMPPGenerator) PrintStats() {
+	fmt.Fprintf(g.out(), "interarrival_mean:%v\tinterarrival_cv:%v\n", g.sum/float64(g.count), g.InterarrivalCV())
+	rate := g.StationaryRate()
+	fmt.Fprintf(g.out(), "stationary_rate:%v\tstationary_load:%v\n", rate, rate*g.ServiceTime.(momentDist).mean())
+}
+
+// RatePoint sets the arrival rate at Time of a piecewise linear rate profile
+type RatePoint struct {
+	Time float64
+	Rate float64
+}
+
+// TimeVaryingGenerator is a generator of Poisson arrivals whose rate follows
+// a profile over the simulated time, e.g. a load ramp or a diurnal cycle, with
+// exponential service times
+// If multiple queues they are fed randomly
+type TimeVaryingGenerator struct {
+	randGenerator
+}
+
+// NewProfileGenerator returns a new *TimeVaryingGenerator whose arrival rate
+// is interpolated linearly between the points, sorted by time, and constant
+// before the first point and after the last one. If the last rate is 0 the
+// generator stops after the last point
+func NewProfileGenerator(points []RatePoint, mu float64) *TimeVaryingGenerator {
+	if len(points) == 0 {
+		panic("Empty arrival rate profile")
+	}
+	var max float64
+	for i, p := range points {
+		if p.Rate < 0 {
+			panic(fmt.Sprintf("Negative arrival rate: %v", p.Rate))
+		}
+		if i > 0 && p.Time <= points[i-1].Time {
+			panic(fmt.Sprintf("Arrival rate profile not sorted by time: %v after %v", p.Time, points[i-1].Time))
+		}
+		max = math.Max(max, p.Rate)
+	}
+	if max == 0 {
+		panic("Arrival rate profile without arrivals")
+	}
+	g := newTimeVaryingGenerator(func(t float64) float64 { return profileRate(points, t) }, max, mu)
+	g.logf("NewProfileGenerator called with points: %v, serviceMu: %v\n", points, mu)
+	if last := points[len(points)-1]; last.Rate == 0 {
+		g.WaitTime.(*nhppDistr).end = last.Time
+	}
+	return g
+}
+
+// NewSinusoidGenerator returns a new *TimeVaryingGenerator whose arrival rate
+// is lambda * (1 + amplitude * sin(2 pi t / period)), amplitude being in [0, 1]
+func NewSinusoidGenerator(lambda, amplitude, period, mu float64) *TimeVaryingGenerator {
+	if amplitude < 0 || amplitude > 1 {
+		panic(fmt.Sprintf("Invalid arrival rate amplitude: %v", amplitude))
+	}
+	if period <= 0 {
+		panic(fmt.Sprintf("Non positive arrival rate period: %v", period))
+	}
+	rate := func(t float64) float64 {
+		return lambda * (1 + amplitude*math.Sin(2*math.Pi*t/period))
+	}
+	g := newTimeVaryingGenerator(rate, lambda*(1+amplitude), mu)
+	g.logf("NewSinusoidGenerator called with lambda: %v, amplitude: %v, period: %v, serviceMu: %v\n", lambda, amplitude, period, mu)
+	return g
+}
+
+func newTimeVaryingGenerator(rate func(t float64) float64, max, mu float64) *TimeVaryingGenerator {
+	g := &TimeVaryingGenerator{}
+	g.rng = newRand()
+	g.ServiceTime = newExponDistr(mu)
+	g.WaitTime = newNHPPDistr(rate, max)
+	return g
+}
+
+// profileRate returns the rate of the piecewise linear profile at time t
+func profileRate(points []RatePoint, t float64) float64 {
+	i := sort.Search(len(points), func(i int) bool { return points[i].Time > t })
+	if i == 0 {
+		return points[0].Rate
+	}
+	if i == len(points) {
+		return points[i-1].Rate
+	}
+	a, b := points[i-1], points[i]
+	return a.Rate + (b.Rate-a.Rate)*(t-a.Time)/(b.Time-a.Time)
+}
@@ -0,0 +1,157 @@
+package blocks
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// A profile ramping down to 0 issues the integral of its rate and then stops,
+// instead of looking for an arrival that never comes
+func TestProfileGeneratorTrailingZeroRate(t *testing.T) {
+	done := make(chan *AllKeeper)
+	go func() {
+		g := NewProfileGenerator([]RatePoint{{0, 1}, {1000, 0}}, 100)
+		stats, _ := runProcessors(g, 100, 1e9, NewRTCProcessor(0))
+		done <- stats
+	}()
+	select {
+	case stats := <-done:
+		// Poisson with a mean of 500
+		if math.Abs(float64(stats.Count())-500) > 5*math.Sqrt(500) {
+			t.Errorf("%v requests, want about 500", stats.Count())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the generator did not stop after the last point")
+	}
+}
+
+func TestProfileGeneratorRate(t *testing.T) {
+	// a ramp from 0 to 1 and back, 1000 arrivals on average
+	g := NewProfileGenerator([]RatePoint{{0, 0}, {1000, 1}, {2000, 0}}, 100)
+	stats, _ := runProcessors(g, 100, 1e9, NewRTCProcessor(0))
+	if math.Abs(float64(stats.Count())-1000) > 5*math.Sqrt(1000) {
+		t.Errorf("%v requests, want about 1000", stats.Count())
+	}
+}
+
+// The generators report their parameters to the information output of the
+// simulation they are registered in
+func TestGeneratorReportsToInfoOutput(t *testing.T) {
+	var info bytes.Buffer
+	sim := newTestSimulation()
+	sim.SetInfoOutput(&info)
+	g := NewMMRandGenerator(0.5, 1)
+	if info.Len() != 0 {
+		t.Errorf("reported before being registered: %q", info.String())
+	}
+	g.SetCreator(&SimpleReqCreator{})
+	sim.RegisterActor(g)
+	if want := "NewMMRandGenerator called with waitLambda: 0.5, serviceMu: 1\n"; info.String() != want {
+		t.Errorf("reported %q, want %q", info.String(), want)
+	}
+}
+
+// The streams of the generators are seeded from their simulation, so that the
+// same seed gives the same delays whatever the state of the global source
+func TestGeneratorsSameSeedSameDelays(t *testing.T) {
+	for name, newGen := range map[string]func() Generator{
+		"mm":          func() Generator { return NewMMRandGenerator(0.8, 1) },
+		"bimodal":     func() Generator { return NewMBRandGenerator(0.5, 1, 10, 0.9) },
+		"pareto":      func() Generator { return NewBoundedParetoGenerator(0.5, 1.5, 0.5, 50) },
+		"lognormal":   func() Generator { return NewMLNGenerator(0.5, 0, 1) },
+		"weibull":     func() Generator { return NewWeibullGenerator(0.5, 0.5, 1) },
+		"gamma":       func() Generator { return NewGammaGenerator(0.5, 2, 1) },
+		"hyperexp":    func() Generator { return NewHyperExpGenerator(0.5, []float64{0.5, 0.5}, []float64{0.5, 1.5}) },
+		"coxian":      func() Generator { return NewCoxianGenerator(0.5, []float64{2, 0.5}, []float64{0.3}) },
+		"mmpp":        func() Generator { return NewMMPPGenerator([]float64{0.2, 0.8}, [][]float64{{0, 0.01}, {0.01, 0}}, 1) },
+		"zipf":        func() Generator { return NewZipfGenerator(0.5, 100, 0.99, 1) },
+		"sinusoid":    func() Generator { return NewSinusoidGenerator(0.5, 0.5, 100, 1) },
+		"closed loop": func() Generator { return newClosedLoopOf(NewMMRandGenerator(1, 1)) },
+	} {
+		first := runSeeded(newGen())
+		rand.Int63()
+		second := runSeeded(newGen())
+		if len(first) == 0 || len(first) != len(second) {
+			t.Errorf("%v: %v and %v requests completed", name, len(first), len(second))
+			continue
+		}
+		for i := range first {
+			if first[i] != second[i] {
+				t.Errorf("%v: request %v: delays %v and %v", name, i, first[i], second[i])
+				break
+			}
+		}
+	}
+}
+
+// newClosedLoopOf returns a closed loop of 4 clients with the service times
+// of g
+func newClosedLoopOf(g Generator) *ClosedLoopGenerator {
+	cl := NewClosedLoopGenerator(4, 1, &SimpleReqCreator{})
+	cl.SetServiceTimeOf(g)
+	return cl
+}
+
+// runSeeded runs g through a FIFO queue served by 2 RTC cores in a simulation
+// seeded with 1 and returns the delays
+func runSeeded(g Generator) []float64 {
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	var drain RequestDrain = stats
+	if cl, ok := g.(*ClosedLoopGenerator); ok {
+		cl.SetReqDrain(stats)
+		drain = cl
+	} else {
+		g.SetCreator(&SimpleReqCreator{})
+	}
+	q := NewQueue()
+	g.AddOutQueue(q)
+	for i := 0; i < 2; i++ {
+		p := NewRTCProcessor(0)
+		p.AddInQueue(q)
+		p.SetReqDrain(drain)
+		sim.RegisterActor(p)
+	}
+	sim.RegisterActor(g)
+	sim.Run(1e4)
+	return stats.Delays()
+}
+
+// interarrivals returns the times between the arrivals of g till duration
+func interarrivals(g Generator, duration float64) []float64 {
+	stats, _ := runProcessors(g, 1, duration, NewInfiniteServerProcessor())
+	arrivals := make([]float64, 0, len(stats.items))
+	for _, item := range stats.items {
+		arrivals = append(arrivals, item.ArrivalTime)
+	}
+	sort.Float64s(arrivals)
+	res := make([]float64, len(arrivals)-1)
+	for i := range res {
+		res[i] = arrivals[i+1] - arrivals[i]
+	}
+	return res
+}
+
+func TestMMPPGeneratorBursty(t *testing.T) {
+	// Poisson interarrivals have a coefficient of variation of 1
+	_, scv := moments(interarrivals(NewMMRandGenerator(1, 10), 5e4))
+	if cv := math.Sqrt(scv); math.Abs(cv-1) > 0.03 {
+		t.Errorf("Poisson interarrival coefficient of variation %v, want about 1", cv)
+	}
+
+	// quiet periods at rate 0.1 and bursts at rate 2, both lasting 100 on
+	// average, for a mean rate of 1.05
+	g := NewMMPPGenerator([]float64{0.1, 2}, [][]float64{{0, 0.01}, {0.01, 0}}, 10)
+	mean, scv := moments(interarrivals(g, 2e5))
+	if math.Abs(1/mean-1.05) > 0.1 {
+		t.Errorf("arrival rate %v, want about 1.05", 1/mean)
+	}
+	if cv := math.Sqrt(scv); !(cv > 1.5) {
+		t.Errorf("MMPP interarrival coefficient of variation %v, want well above 1", cv)
+	}
+}
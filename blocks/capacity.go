@@ -0,0 +1,82 @@
+package blocks
+
+import (
+	"fmt"
+)
+
+// overheadTracker is implemented by processors that account for the time
+// spent on useful work and on context switches
+type overheadTracker interface {
+	getWorkTime() float64
+	getCtxTime() float64
+}
+
+// CapacityKeeper reports the effective service rate of a set of processors,
+// taking into account the measured context switch overhead
+type CapacityKeeper struct {
+	statsOutput
+	mu         float64
+	lambda     float64
+	cores      int
+	processors []overheadTracker
+}
+
+// NewCapacityKeeper returns a new *CapacityKeeper for the nominal per core
+// service rate mu and the arrival rate lambda
+func NewCapacityKeeper(mu, lambda float64, cores int) *CapacityKeeper {
+	return &CapacityKeeper{mu: mu, lambda: lambda, cores: cores}
+}
+
+// AddClass adds the offered load of a class of requests arriving at rate
+// lambda with the service rate mu to the one of the main arrivals
+func (k *CapacityKeeper) AddClass(lambda, mu float64) {
+	// as the rate of requests of the nominal service rate with the same load
+	k.lambda += lambda * k.mu / mu
+}
+
+// AddProcessor adds a processor to the ones monitored. Processors that do not
+// track their overhead are ignored
+func (k *CapacityKeeper) AddProcessor(p Processor) {
+	if t, ok := p.(overheadTracker); ok {
+		k.processors = append(k.processors, t)
+	}
+}
+
+// times returns the time the processors spent on useful work and on context
+// switches
+func (k *CapacityKeeper) times() (work, ctx float64) {
+	for _, p := range k.processors {
+		work += p.getWorkTime()
+		ctx += p.getCtxTime()
+	}
+	return work, ctx
+}
+
+// EffectiveMu returns the per core service rate once the context switch time
+// per unit of useful work is accounted for. It equals the nominal mu when no
+// useful work was measured
+func (k *CapacityKeeper) EffectiveMu() float64 {
+	work, ctx := k.times()
+	if work == 0 {
+		return k.mu
+	}
+	return k.mu * work / (work + ctx)
+}
+
+// PrintStats prints the nominal and effective service rates, the effective
+// capacity of all the cores and the offered load against that capacity.
+// Nothing is printed if the processors did no useful work, since the
+// overhead per unit of work is then unknown.
+// This is called by the model
+func (k *CapacityKeeper) PrintStats() {
+	if work, _ := k.times(); work == 0 {
+		return
+	}
+	effMu := k.EffectiveMu()
+	capacity := effMu * float64(k.cores)
+	fmt.Fprintf(k.out(), "nominal_service_rate:%v\teffective_service_rate:%v\teffective_capacity:%v\teffective_load:%v\n",
+		k.mu, effMu, capacity, k.lambda/capacity)
+	if k.lambda >= capacity {
+		fmt.Fprintln(k.out(), "WARNING: offered load exceeds the effective capacity, the system is unstable")
+	}
+}
@@ -0,0 +1,124 @@
+package blocks
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// capacityCase is a processor serving requests of service time 10 one at a
+// time with a context switch cost of 1, and its expected effective service
+// rate relative to the nominal one
+type capacityCase struct {
+	name  string
+	proc  func() Processor
+	wire  func(p Processor, q *Queue) []engine.ActorInterface
+	ratio float64
+}
+
+func capacityCases() []capacityCase {
+	// a quantum of 4 serves a request in 3 slices, each with a switch
+	return []capacityCase{
+		{name: "rtc", proc: func() Processor { return NewRTCProcessor(1) }, ratio: 10.0 / 11},
+		{name: "ps", proc: func() Processor { return NewPSProcessorCtx(1) }, ratio: 10.0 / 11},
+		{name: "ts", proc: func() Processor { return NewTSProcessor(4, 1) }, ratio: 10.0 / 13},
+		{name: "srpt", proc: func() Processor { return NewSrptTSProcessor(4, 1) }, ratio: 10.0 / 13},
+		{name: "las", proc: func() Processor { return NewLASProcessor(4, 1) }, ratio: 10.0 / 13},
+		{name: "speed", proc: func() Processor { return NewScheduledSpeedProcessor([]SpeedSegment{{0, 1}}, 1) }, ratio: 10.0 / 11},
+		{name: "timeout", proc: func() Processor { return NewTimeoutRTCProcessor(1000, 1) }, ratio: 10.0 / 11},
+		{name: "scalable", proc: func() Processor { return NewScalableRTCProcessor(1, 0, -1) }, ratio: 10.0 / 11},
+		{name: "rate_limited", proc: func() Processor { return NewRateLimitedRTCProcessor(NewTokenBucket(1, 1), 1) }, ratio: 10.0 / 11},
+		{name: "preemptive", proc: func() Processor { return NewPreemptiveRTCProcessor(1) }, ratio: 10.0 / 11},
+		{name: "batch", proc: func() Processor { return NewBatchProcessor(8, 0, 1) }, ratio: 10.0 / 11},
+		{name: "queue_prio", proc: func() Processor { return NewQueuePrioRTCProcessor(1) }, ratio: 10.0 / 11},
+		{name: "work_stealing", proc: func() Processor { return NewWorkStealingProcessor(1) }, ratio: 10.0 / 11},
+		{name: "gang", proc: func() Processor { return NewGangProcessor(1) }, ratio: 10.0 / 11,
+			wire: func(p Processor, q *Queue) []engine.ActorInterface {
+				s := NewGangScheduler()
+				s.AddInQueue(q)
+				s.AddProcessor(p.(*GangProcessor))
+				return []engine.ActorInterface{s}
+			}},
+	}
+}
+
+// run runs the requests of g through the processor of c till duration
+func (c capacityCase) run(g Generator, duration float64) (*AllKeeper, *CapacityKeeper) {
+	p := c.proc()
+	wire := func(q *Queue) []engine.ActorInterface {
+		if c.wire != nil {
+			return c.wire(p, q)
+		}
+		p.AddInQueue(q)
+		return nil
+	}
+	return runWired(g, 0.1, duration, wire, p)
+}
+
+func TestCapacityKeeperProcessors(t *testing.T) {
+	for _, c := range capacityCases() {
+		stats, capacity := c.run(NewDDGenerator(100, 10), 1e4)
+		if stats.Count() != 100 {
+			t.Errorf("%v: %v requests completed, want 100", c.name, stats.Count())
+		}
+		if want := 0.1 * c.ratio; !almostEqual(capacity.EffectiveMu(), want) {
+			t.Errorf("%v: effective service rate %v, want %v", c.name, capacity.EffectiveMu(), want)
+		}
+		var out bytes.Buffer
+		capacity.SetOutput(&out)
+		capacity.PrintStats()
+		if !strings.HasPrefix(out.String(), "nominal_service_rate:0.1\t") || strings.Contains(out.String(), "Inf") {
+			t.Errorf("%v: unexpected capacity report %q", c.name, out.String())
+		}
+	}
+}
+
+// Without any useful work the overhead per unit of work is unknown, so
+// nothing is reported rather than an infinite load
+func TestCapacityKeeperNoWork(t *testing.T) {
+	for _, c := range capacityCases() {
+		_, capacity := c.run(NewScriptedGenerator(nil), 1e4)
+		if capacity.EffectiveMu() != 0.1 {
+			t.Errorf("%v: effective service rate %v without work, want the nominal 0.1", c.name, capacity.EffectiveMu())
+		}
+		var out bytes.Buffer
+		capacity.SetOutput(&out)
+		capacity.PrintStats()
+		if out.Len() > 0 {
+			t.Errorf("%v: capacity reported without work: %q", c.name, out.String())
+		}
+	}
+}
+
+// Context switches without any useful work, e.g. NUMA transfers of requests
+// that were all aborted, should not be reported as an unstable system
+func TestCapacityKeeperOnlyOverhead(t *testing.T) {
+	k := NewCapacityKeeper(0.1, 0.05, 1)
+	p := NewRTCProcessor(1)
+	p.ctxTime = 5
+	k.AddProcessor(p)
+	var out bytes.Buffer
+	k.SetOutput(&out)
+	k.PrintStats()
+	if out.Len() > 0 {
+		t.Errorf("capacity reported without work: %q", out.String())
+	}
+}
+
+// Without context switch cost the effective service rate is the nominal one,
+// whatever the scheduling
+func TestCapacityKeeperNoOverhead(t *testing.T) {
+	for name, newProc := range map[string]func() Processor{
+		"rtc":  func() Processor { return NewRTCProcessor(0) },
+		"ps":   func() Processor { return NewPSProcessorCtx(0) },
+		"ts":   func() Processor { return NewTSProcessor(4, 0) },
+		"srpt": func() Processor { return NewSrptTSProcessor(4, 0) },
+	} {
+		_, capacity := runProcessors(NewMMRandGenerator(0.08, 0.1), 0.1, 1e4, newProc())
+		if capacity.EffectiveMu() != 0.1 {
+			t.Errorf("%v: effective service rate %v without context switches, want the nominal 0.1", name, capacity.EffectiveMu())
+		}
+	}
+}
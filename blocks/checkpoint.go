@@ -0,0 +1,170 @@
+package blocks
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// saveRequest returns the request to save in a checkpoint, only the basic
+// Request can be saved
+func saveRequest(req engine.ReqInterface) (*Request, error) {
+	r, ok := req.(*Request)
+	if !ok {
+		return nil, fmt.Errorf("cannot save a %T", req)
+	}
+	return r, nil
+}
+
+// restoreRequests decodes the requests saved in data for sim
+func restoreRequests(sim *engine.Simulation, data json.RawMessage) ([]*Request, error) {
+	var reqs []*Request
+	if err := json.Unmarshal(data, &reqs); err != nil {
+		return nil, err
+	}
+	for _, r := range reqs {
+		r.sim = sim
+	}
+	return reqs, nil
+}
+
+// SaveState returns the queued requests, in order
+func (q *Queue) SaveState() (interface{}, error) {
+	reqs := make([]*Request, 0, q.Len())
+	for e := q.l.Front(); e != nil; e = e.Next() {
+		r, err := saveRequest(e.Value.(engine.ReqInterface))
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, r)
+	}
+	return reqs, nil
+}
+
+// RestoreState queues the saved requests again, without tracing them
+func (q *Queue) RestoreState(sim *engine.Simulation, data json.RawMessage) error {
+	reqs, err := restoreRequests(sim, data)
+	if err != nil {
+		return err
+	}
+	for _, r := range reqs {
+		q.l.PushBack(r)
+	}
+	return nil
+}
+
+// pushFront queues req ahead of the queued requests, without tracing it
+func (q *Queue) pushFront(req engine.ReqInterface) {
+	q.l.PushFront(req)
+}
+
+// SaveState returns the request in service, if any, with the service time
+// left. Its context switch is paid again once resumed
+func (p *RTCProcessor) SaveState() (interface{}, error) {
+	if p.curr == nil {
+		return nil, nil
+	}
+	r, err := saveRequest(p.curr)
+	if err != nil {
+		return nil, err
+	}
+	left := *r
+	left.ServiceTime -= math.Min((p.Now()-p.since)*p.scale, r.ServiceTime)
+	return []*Request{&left}, nil
+}
+
+// RestoreState queues the request that was in service back at the head of
+// the first input queue, which should be a *Queue
+func (p *RTCProcessor) RestoreState(sim *engine.Simulation, data json.RawMessage) error {
+	reqs, err := restoreRequests(sim, data)
+	if err != nil {
+		return err
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+	q, ok := p.inQueues[0].(*Queue)
+	if !ok {
+		return fmt.Errorf("cannot queue the request in service back to a %T", p.inQueues[0])
+	}
+	for i := len(reqs) - 1; i >= 0; i-- {
+		q.pushFront(reqs[i])
+	}
+	return nil
+}
+
+// warmupState is the state of a warmupFilter in a checkpoint
+type warmupState struct {
+	Skipped  int     `json:"skipped"`
+	Start    float64 `json:"start"`
+	Recorded int     `json:"recorded"`
+}
+
+func (w *warmupFilter) saveState() warmupState {
+	return warmupState{Skipped: w.skipped, Start: w.start, Recorded: w.recorded}
+}
+
+func (w *warmupFilter) restoreState(s warmupState) {
+	w.skipped, w.start, w.recorded = s.Skipped, s.Start, s.Recorded
+}
+
+// allKeeperState is the state of an AllKeeper in a checkpoint
+type allKeeperState struct {
+	Warmup warmupState   `json:"warmup"`
+	Items  []RequestData `json:"items"`
+	Stolen int           `json:"stolen"`
+}
+
+// SaveState returns the requests recorded so far
+func (k *AllKeeper) SaveState() (interface{}, error) {
+	return allKeeperState{Warmup: k.warmupFilter.saveState(), Items: k.items, Stolen: k.stolenCount}, nil
+}
+
+// RestoreState restores the requests recorded before the checkpoint
+func (k *AllKeeper) RestoreState(sim *engine.Simulation, data json.RawMessage) error {
+	var s allKeeperState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	k.warmupFilter.restoreState(s.Warmup)
+	k.items, k.stolenCount = s.Items, s.Stolen
+	return nil
+}
+
+// occupancyState is the state of an OccupancyKeeper in a checkpoint
+type occupancyState struct {
+	InSystem   int     `json:"inSystem"`
+	LastChange float64 `json:"lastChange"`
+	EmptyTime  float64 `json:"emptyTime"`
+	Area       float64 `json:"area"`
+	Arrivals   int     `json:"arrivals"`
+	Departures int     `json:"departures"`
+	DelaySum   float64 `json:"delaySum"`
+}
+
+// SaveState returns the occupancy accounted so far
+func (k *OccupancyKeeper) SaveState() (interface{}, error) {
+	return occupancyState{
+		InSystem:   k.inSystem,
+		LastChange: k.lastChange,
+		EmptyTime:  k.emptyTime,
+		Area:       k.area,
+		Arrivals:   k.arrivals,
+		Departures: k.departures,
+		DelaySum:   k.delaySum,
+	}, nil
+}
+
+// RestoreState restores the occupancy accounted before the checkpoint
+func (k *OccupancyKeeper) RestoreState(sim *engine.Simulation, data json.RawMessage) error {
+	var s occupancyState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	k.sim = sim
+	k.inSystem, k.lastChange, k.emptyTime, k.area = s.InSystem, s.LastChange, s.EmptyTime, s.Area
+	k.arrivals, k.departures, k.delaySum = s.Arrivals, s.Departures, s.DelaySum
+	return nil
+}
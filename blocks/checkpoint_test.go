@@ -0,0 +1,49 @@
+package blocks
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// The queued requests are restored in order, in the resumed simulation
+func TestQueueRestoresRequests(t *testing.T) {
+	q := NewQueue()
+	for i := 0; i < 3; i++ {
+		q.l.PushBack(&Request{ID: i, ServiceTime: float64(i + 1)})
+	}
+	state, err := q.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim := engine.NewSimulation()
+	restored := NewQueue()
+	if err := restored.RestoreState(sim, data); err != nil {
+		t.Fatal(err)
+	}
+	restored.pushFront(&Request{ID: -1})
+	for want := -1; want < 3; want++ {
+		r := restored.Dequeue().(*Request)
+		if r.ID != want {
+			t.Fatalf("got request %v, want %v", r.ID, want)
+		}
+		if want >= 0 && r.sim != sim {
+			t.Errorf("request %v not in the resumed simulation", r.ID)
+		}
+	}
+}
+
+// Only the basic requests can be saved
+func TestQueueSaveStateRejects(t *testing.T) {
+	q := NewQueue()
+	q.l.PushBack(&DeadlineReq{Request{ID: 0}, 10})
+	if _, err := q.SaveState(); err == nil {
+		t.Error("saved a *DeadlineReq")
+	}
+}
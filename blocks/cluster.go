@@ -0,0 +1,91 @@
+package blocks
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// loadGetter is implemented by queues whose load for the dispatch policies is
+// not just their length
+type loadGetter interface {
+	getLoad() int
+}
+
+// MachineQueue is the input queue of a machine of a cluster, shared by the
+// cores of the machine. Its load for the dispatch policies is the number of
+// requests in the machine, queued or in service. It should be the request
+// drain of the machine processors: it keeps the statistics of the machine and
+// passes the completed requests on to its own request drain
+type MachineQueue struct {
+	engine.QueueInterface
+	stats     *AllKeeper
+	reqDrain  RequestDrain
+	inMachine map[engine.ReqInterface]bool
+}
+
+// NewMachineQueue returns a new *MachineQueue holding the requests in q, e.g.
+// a *PQueue for SRPT cores, and terminating them to rd
+func NewMachineQueue(q engine.QueueInterface, rd RequestDrain) *MachineQueue {
+	return &MachineQueue{QueueInterface: q, stats: &AllKeeper{}, reqDrain: rd, inMachine: make(map[engine.ReqInterface]bool)}
+}
+
+// Enqueue enqueues a request arriving at the machine or put back by a time
+// sharing core
+func (m *MachineQueue) Enqueue(el engine.ReqInterface) {
+	m.inMachine[el] = true
+	m.QueueInterface.Enqueue(el)
+}
+
+func (m *MachineQueue) getLoad() int {
+	return len(m.inMachine)
+}
+
+// TerminateReq is called by the machine processors for every completed
+// request
+func (m *MachineQueue) TerminateReq(req engine.ReqInterface) {
+	delete(m.inMachine, req)
+	m.stats.TerminateReq(req)
+	m.reqDrain.TerminateReq(req)
+}
+
+// SetName gives a name to the machine statistics
+func (m *MachineQueue) SetName(name string) {
+	m.stats.SetName(name)
+}
+
+// SetOutput sets the io.Writer the machine statistics are printed to
+func (m *MachineQueue) SetOutput(w io.Writer) {
+	m.stats.SetOutput(w)
+}
+
+// SetWarmup makes the machine statistics ignore the requests terminated
+// before duration
+func (m *MachineQueue) SetWarmup(duration float64) {
+	m.stats.SetWarmup(duration)
+}
+
+// SetPercentiles sets the percentiles printed in the machine statistics, nil
+// for the default ones
+func (m *MachineQueue) SetPercentiles(ps []float64) {
+	m.stats.SetPercentiles(ps)
+}
+
+// SetBatches makes the machine statistics also report batch means intervals
+// over n batches, see AllKeeper.SetBatches
+func (m *MachineQueue) SetBatches(n int) {
+	m.stats.SetBatches(n)
+}
+
+// Stats returns the statistics of the requests served by the machine
+func (m *MachineQueue) Stats() *AllKeeper {
+	return m.stats
+}
+
+// PrintStats prints the summary of the statistics of the machine.
+// This is called by the model
+func (m *MachineQueue) PrintStats() {
+	fmt.Fprintf(m.stats.out(), "Stats collector: %v\n", m.stats.name)
+	m.stats.printSummary()
+}
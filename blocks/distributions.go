@@ -3,12 +3,64 @@ package blocks
 import (
 	"math"
 	"math/rand"
+	"sort"
 )
 
 type randDist interface {
 	getRand() float64
 }
 
+// newRand returns a new source of randomness seeded from the global one, so
+// that every distribution or generator draws from its own stream. The streams
+// of the actors are seeded again from their simulation when they are
+// registered, see seedAll
+func newRand() *rand.Rand {
+	return rand.New(rand.NewSource(rand.Int63()))
+}
+
+// seededRand returns a new source of randomness seeded from src
+func seededRand(src *rand.Rand) *rand.Rand {
+	return rand.New(rand.NewSource(src.Int63()))
+}
+
+// seeder is implemented by the distributions and request creators that draw
+// from their own stream
+type seeder interface {
+	seed(src *rand.Rand)
+}
+
+// seedAll seeds the streams of the elements that have one from src, in order,
+// so that seeding the simulation reproduces all of them. The others are
+// ignored
+func seedAll(src *rand.Rand, elems ...interface{}) {
+	for _, e := range elems {
+		if s, ok := e.(seeder); ok {
+			s.seed(src)
+		}
+	}
+}
+
+// stream is embedded by the distributions and holds their own source of
+// randomness
+type stream struct {
+	rng *rand.Rand
+}
+
+func newStream() stream {
+	return stream{newRand()}
+}
+
+func (s *stream) seed(src *rand.Rand) {
+	s.rng = seededRand(src)
+}
+
+// momentDist is implemented by distributions with known mean and squared
+// coefficient of variation (variance / mean^2)
+type momentDist interface {
+	mean() float64
+	scv() float64
+}
+
 // Deterministic Distribution
 type deterministicDistr struct {
 	d float64
@@ -22,49 +74,501 @@ func (distr *deterministicDistr) getRand() float64 {
 	return distr.d
 }
 
+func (distr *deterministicDistr) mean() float64 {
+	return distr.d
+}
+
+func (distr *deterministicDistr) scv() float64 {
+	return 0
+}
+
 // Exponential Distribution
 type exponDistr struct {
 	lambda float64
+	stream
 }
 
 func newExponDistr(l float64) *exponDistr {
-	return &exponDistr{l}
+	return &exponDistr{l, newStream()}
 }
 
 func (distr *exponDistr) getRand() float64 {
-	return float64(rand.ExpFloat64() / distr.lambda)
+	return float64(distr.rng.ExpFloat64() / distr.lambda)
+}
+
+func (distr *exponDistr) mean() float64 {
+	return 1 / distr.lambda
+}
+
+func (distr *exponDistr) scv() float64 {
+	return 1
 }
 
 // LogNormal Distribution
 type lGDistr struct {
 	mu    float64
 	sigma float64
+	stream
 }
 
 func newLGDistr(mu, sigma float64) *lGDistr {
-	return &lGDistr{mu, sigma}
+	return &lGDistr{mu, sigma, newStream()}
 }
 
 func (distr *lGDistr) getRand() float64 {
-	z := rand.NormFloat64()
+	z := distr.rng.NormFloat64()
 	s := math.Exp(distr.mu + distr.sigma*z)
 	return s
 }
 
+func (distr *lGDistr) mean() float64 {
+	return math.Exp(distr.mu + distr.sigma*distr.sigma/2)
+}
+
+func (distr *lGDistr) scv() float64 {
+	return math.Exp(distr.sigma*distr.sigma) - 1
+}
+
 // Bimodel Distribution
 type biDistr struct {
 	v1    float64
 	v2    float64
 	ratio float64
+	stream
 }
 
 func newBiDistr(v1, v2, ratio float64) *biDistr {
-	return &biDistr{v1, v2, ratio}
+	return &biDistr{v1, v2, ratio, newStream()}
 }
 
 func (distr *biDistr) getRand() float64 {
-	if rand.Float64() > distr.ratio {
+	if distr.rng.Float64() > distr.ratio {
 		return distr.v2
 	}
 	return distr.v1
 }
+
+func (distr *biDistr) mean() float64 {
+	return distr.ratio*distr.v1 + (1-distr.ratio)*distr.v2
+}
+
+func (distr *biDistr) scv() float64 {
+	m := distr.mean()
+	m2 := distr.ratio*distr.v1*distr.v1 + (1-distr.ratio)*distr.v2*distr.v2
+	return (m2 - m*m) / (m * m)
+}
+
+// Coxian Distribution
+// A sequence of exponential phases; after phase i the sample continues to
+// phase i+1 with probability probs[i] or completes
+type coxianDistr struct {
+	rates []float64
+	probs []float64
+	stream
+}
+
+func newCoxianDistr(rates, probs []float64) *coxianDistr {
+	return &coxianDistr{rates, probs, newStream()}
+}
+
+func (distr *coxianDistr) getRand() float64 {
+	var s float64
+	for i, r := range distr.rates {
+		s += distr.rng.ExpFloat64() / r
+		if i == len(distr.probs) || distr.rng.Float64() >= distr.probs[i] {
+			break
+		}
+	}
+	return s
+}
+
+// moments returns the first two moments, computed backwards from the last
+// phase since S_i = X_i + B_i * S_{i+1} with B_i ~ Bernoulli(probs[i])
+func (distr *coxianDistr) moments() (float64, float64) {
+	var m1, m2 float64
+	for i := len(distr.rates) - 1; i >= 0; i-- {
+		r := distr.rates[i]
+		p := 0.0
+		if i < len(distr.probs) {
+			p = distr.probs[i]
+		}
+		m2 = 2/(r*r) + 2*p*m1/r + p*m2
+		m1 = 1/r + p*m1
+	}
+	return m1, m2
+}
+
+func (distr *coxianDistr) mean() float64 {
+	m1, _ := distr.moments()
+	return m1
+}
+
+func (distr *coxianDistr) scv() float64 {
+	m1, m2 := distr.moments()
+	return (m2 - m1*m1) / (m1 * m1)
+}
+
+// Pareto Distribution
+// Pareto with shape alpha and scale low, i.e. P(X > x) = (low/x)^alpha for
+// x >= low. The mean is finite for alpha > 1 and the variance for alpha > 2
+type paretoDistr struct {
+	alpha float64
+	low   float64
+	stream
+}
+
+func newParetoDistr(alpha, low float64) *paretoDistr {
+	return &paretoDistr{alpha, low, newStream()}
+}
+
+func (distr *paretoDistr) getRand() float64 {
+	// inverse of F(x) = 1 - (low/x)^alpha, 1-u in (0, 1]
+	return distr.low / math.Pow(1-distr.rng.Float64(), 1/distr.alpha)
+}
+
+func (distr *paretoDistr) mean() float64 {
+	if distr.alpha <= 1 {
+		return math.Inf(1)
+	}
+	return distr.alpha * distr.low / (distr.alpha - 1)
+}
+
+func (distr *paretoDistr) scv() float64 {
+	if distr.alpha <= 2 {
+		return math.Inf(1)
+	}
+	return 1 / (distr.alpha * (distr.alpha - 2))
+}
+
+// Hyperexponential Distribution
+// Exponential of mean means[i] with probability probs[i], which sum to 1
+type hyperExpDistr struct {
+	probs []float64
+	means []float64
+	stream
+}
+
+func newHyperExpDistr(probs, means []float64) *hyperExpDistr {
+	return &hyperExpDistr{probs, means, newStream()}
+}
+
+func (distr *hyperExpDistr) getRand() float64 {
+	u := distr.rng.Float64()
+	i := 0
+	for ; i < len(distr.probs)-1; i++ {
+		if u < distr.probs[i] {
+			break
+		}
+		u -= distr.probs[i]
+	}
+	return distr.rng.ExpFloat64() * distr.means[i]
+}
+
+func (distr *hyperExpDistr) mean() float64 {
+	var m float64
+	for i, p := range distr.probs {
+		m += p * distr.means[i]
+	}
+	return m
+}
+
+func (distr *hyperExpDistr) scv() float64 {
+	var m2 float64
+	for i, p := range distr.probs {
+		m2 += 2 * p * distr.means[i] * distr.means[i]
+	}
+	m := distr.mean()
+	return (m2 - m*m) / (m * m)
+}
+
+// Bounded Pareto Distribution
+// Pareto with shape alpha truncated to [low, high]
+type boundedParetoDistr struct {
+	alpha float64
+	low   float64
+	high  float64
+	stream
+}
+
+func newBoundedParetoDistr(alpha, low, high float64) *boundedParetoDistr {
+	return &boundedParetoDistr{alpha, low, high, newStream()}
+}
+
+func (distr *boundedParetoDistr) getRand() float64 {
+	// inverse of F(x) = (1 - (low/x)^alpha) / (1 - (low/high)^alpha)
+	u := distr.rng.Float64()
+	tail := 1 - math.Pow(distr.low/distr.high, distr.alpha)
+	return distr.low / math.Pow(1-u*tail, 1/distr.alpha)
+}
+
+// moment returns E[X^k]
+func (distr *boundedParetoDistr) moment(k float64) float64 {
+	a, l, h := distr.alpha, distr.low, distr.high
+	c := a * math.Pow(l, a) / (1 - math.Pow(l/h, a))
+	if k == a {
+		return c * math.Log(h/l)
+	}
+	return c * (math.Pow(h, k-a) - math.Pow(l, k-a)) / (k - a)
+}
+
+func (distr *boundedParetoDistr) mean() float64 {
+	return distr.moment(1)
+}
+
+func (distr *boundedParetoDistr) scv() float64 {
+	m := distr.mean()
+	return (distr.moment(2) - m*m) / (m * m)
+}
+
+// Weibull Distribution
+// Weibull with shape k and scale, heavier tailed than the exponential for
+// k < 1 and lighter for k > 1
+type weibullDistr struct {
+	k     float64
+	scale float64
+	stream
+}
+
+func newWeibullDistr(k, scale float64) *weibullDistr {
+	return &weibullDistr{k, scale, newStream()}
+}
+
+func (distr *weibullDistr) getRand() float64 {
+	return distr.scale * math.Pow(distr.rng.ExpFloat64(), 1/distr.k)
+}
+
+func (distr *weibullDistr) mean() float64 {
+	return distr.scale * math.Gamma(1+1/distr.k)
+}
+
+func (distr *weibullDistr) scv() float64 {
+	g1 := math.Gamma(1 + 1/distr.k)
+	return math.Gamma(1+2/distr.k)/(g1*g1) - 1
+}
+
+// Gamma Distribution
+// Gamma with shape k and scale, i.e. Erlang with k phases for an integer k
+type gammaDistr struct {
+	k     float64
+	scale float64
+	stream
+}
+
+func newGammaDistr(k, scale float64) *gammaDistr {
+	return &gammaDistr{k, scale, newStream()}
+}
+
+// getRand draws with the method of Marsaglia and Tsang, boosting shapes
+// below 1 with Gamma(k) = Gamma(k+1) * U^(1/k)
+func (distr *gammaDistr) getRand() float64 {
+	k, boost := distr.k, 1.0
+	if k < 1 {
+		boost = math.Pow(distr.rng.Float64(), 1/k)
+		k++
+	}
+	d := k - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := distr.rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := distr.rng.Float64()
+		if math.Log(u) < x*x/2+d-d*v+d*math.Log(v) {
+			return d * v * distr.scale * boost
+		}
+	}
+}
+
+func (distr *gammaDistr) mean() float64 {
+	return distr.k * distr.scale
+}
+
+func (distr *gammaDistr) scv() float64 {
+	return 1 / distr.k
+}
+
+// Zipf Distribution
+// values[i] of key i, drawn with a probability proportional to 1/(i+1)^s
+type zipfDistr struct {
+	cum    []float64 // cumulative probabilities of the keys
+	values []float64
+	stream
+}
+
+func newZipfDistr(s float64, values []float64) *zipfDistr {
+	cum := make([]float64, len(values))
+	var sum float64
+	for i := range values {
+		sum += math.Pow(float64(i+1), -s)
+		cum[i] = sum
+	}
+	for i := range cum {
+		cum[i] /= sum
+	}
+	return &zipfDistr{cum, values, newStream()}
+}
+
+// pick returns the index of a key
+func (distr *zipfDistr) pick() int {
+	return sort.SearchFloat64s(distr.cum, distr.rng.Float64())
+}
+
+// prob returns the probability of key i
+func (distr *zipfDistr) prob(i int) float64 {
+	if i == 0 {
+		return distr.cum[0]
+	}
+	return distr.cum[i] - distr.cum[i-1]
+}
+
+func (distr *zipfDistr) getRand() float64 {
+	return distr.values[distr.pick()]
+}
+
+func (distr *zipfDistr) mean() float64 {
+	var m float64
+	for i, v := range distr.values {
+		m += distr.prob(i) * v
+	}
+	return m
+}
+
+func (distr *zipfDistr) scv() float64 {
+	var m2 float64
+	for i, v := range distr.values {
+		m2 += distr.prob(i) * v * v
+	}
+	m := distr.mean()
+	return (m2 - m*m) / (m * m)
+}
+
+// Time varying Poisson process interarrival times, drawn by thinning a Poisson
+// process of rate max, which should bound rate(t), the arrival rate at time t.
+// The arrivals are drawn one after the other from time 0, so the previous one
+// is now. The rate may be 0 for good from end on
+type nhppDistr struct {
+	rate func(t float64) float64
+	max  float64
+	end  float64 // time after which there are no more arrivals
+	last float64 // time of the previous arrival
+	stream
+}
+
+func newNHPPDistr(rate func(t float64) float64, max float64) *nhppDistr {
+	return &nhppDistr{rate: rate, max: max, end: math.Inf(1), stream: newStream()}
+}
+
+// getRand returns the time from the previous arrival till the next one, +Inf
+// if there are no more arrivals
+func (distr *nhppDistr) getRand() float64 {
+	t := distr.last
+	for {
+		t += distr.rng.ExpFloat64() / distr.max
+		if t >= distr.end {
+			return math.Inf(1)
+		}
+		if distr.rng.Float64()*distr.max <= distr.rate(t) {
+			d := t - distr.last
+			distr.last = t
+			return d
+		}
+	}
+}
+
+// Markov modulated Poisson process interarrival times. The arrival rate is
+// rates[state] and the state changes to j at rate transitions[state][j]
+type mmppDistr struct {
+	rates       []float64
+	transitions [][]float64
+	state       int
+	stream
+}
+
+func newMMPPDistr(rates []float64, transitions [][]float64) *mmppDistr {
+	return &mmppDistr{rates: rates, transitions: transitions, stream: newStream()}
+}
+
+// getRand returns the time till the next arrival, going through the state
+// changes that happen first
+func (distr *mmppDistr) getRand() float64 {
+	var t float64
+	for {
+		out := distr.transitions[distr.state]
+		total := distr.rates[distr.state]
+		for j, r := range out {
+			if j != distr.state {
+				total += r
+			}
+		}
+		t += distr.rng.ExpFloat64() / total
+
+		// pick the event proportionally to its rate
+		u := distr.rng.Float64() * total
+		if u < distr.rates[distr.state] {
+			return t
+		}
+		u -= distr.rates[distr.state]
+		for j, r := range out {
+			if j == distr.state {
+				continue
+			}
+			if u < r {
+				distr.state = j
+				break
+			}
+			u -= r
+		}
+	}
+}
+
+// stationaryRate returns the long run arrival rate, i.e. the rates weighted by
+// the stationary distribution pi of the state chain, solving pi Q = 0 with the
+// probabilities summing to 1 by Gaussian elimination. It returns NaN if the
+// chain has no unique stationary distribution, e.g. a state cannot be left
+func (distr *mmppDistr) stationaryRate() float64 {
+	n := len(distr.rates)
+	// rows of the augmented system Q^T pi = 0, the last equation replaced by
+	// sum(pi) = 1
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+		for j := 0; j < n; j++ {
+			if i != j {
+				a[i][j] = distr.transitions[j][i]
+				a[i][i] -= distr.transitions[i][j]
+			}
+		}
+	}
+	for j := range a[n-1] {
+		a[n-1][j] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-15 {
+			return math.NaN()
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := 0; r < n; r++ {
+			if r == col {
+				continue
+			}
+			f := a[r][col] / a[col][col]
+			for c := col; c <= n; c++ {
+				a[r][c] -= f * a[col][c]
+			}
+		}
+	}
+	var rate float64
+	for i := 0; i < n; i++ {
+		rate += a[i][n] / a[i][i] * distr.rates[i]
+	}
+	return rate
+}
@@ -0,0 +1,72 @@
+package blocks
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// samples seeds d with seed if it has its own stream and draws n samples
+func samples(d randDist, n int) []float64 {
+	seedAll(rand.New(rand.NewSource(1)), d)
+	res := make([]float64, n)
+	for i := range res {
+		res[i] = d.getRand()
+	}
+	return res
+}
+
+// moments returns the mean and squared coefficient of variation of xs
+func moments(xs []float64) (mean, scv float64) {
+	var sum, sumSquare float64
+	for _, x := range xs {
+		sum += x
+		sumSquare += x * x
+	}
+	mean = sum / float64(len(xs))
+	return mean, (sumSquare/float64(len(xs)) - mean*mean) / (mean * mean)
+}
+
+func TestCoxianDistr(t *testing.T) {
+	// phase 1 of rate 2, followed by phase 2 of rate 0.5 with probability 0.3
+	d := newCoxianDistr([]float64{2, 0.5}, []float64{0.3})
+	want := 1/2.0 + 0.3/0.5
+	if !almostEqual(d.mean(), want) {
+		t.Errorf("analytic mean %v, want %v", d.mean(), want)
+	}
+	// E[S^2] = E[X1^2] + 0.3 * (2*E[X1]*E[X2] + E[X2^2]) = 2/4 + 0.3 * (2 + 8)
+	if wantSCV := (0.5+0.3*10)/(want*want) - 1; !almostEqual(d.scv(), wantSCV) {
+		t.Errorf("analytic scv %v, want %v", d.scv(), wantSCV)
+	}
+	mean, scv := moments(samples(d, 2e5))
+	if math.Abs(mean-want) > 0.01*want {
+		t.Errorf("sample mean %v, want %v", mean, want)
+	}
+	if math.Abs(scv-d.scv()) > 0.05*d.scv() {
+		t.Errorf("sample scv %v, want %v", scv, d.scv())
+	}
+}
+
+func TestBoundedParetoDistr(t *testing.T) {
+	d := newBoundedParetoDistr(1.5, 1, 100)
+	xs := samples(d, 2e5)
+	for _, x := range xs {
+		if x < 1 || x > 100 {
+			t.Fatalf("sample %v out of [1, 100]", x)
+		}
+	}
+	mean, scv := moments(xs)
+	if math.Abs(mean-d.mean()) > 0.02*d.mean() || math.Abs(scv-d.scv()) > 0.1*d.scv() {
+		t.Errorf("sample mean %v and scv %v, want %v and %v", mean, scv, d.mean(), d.scv())
+	}
+
+	// far from the upper bound the tail is Pareto, whose index the Hill
+	// estimator n / sum(log(x/low)) recovers
+	var sum float64
+	for _, x := range samples(newBoundedParetoDistr(1.5, 1, 1e6), 2e5) {
+		sum += math.Log(x)
+	}
+	if alpha := 2e5 / sum; math.Abs(alpha-1.5) > 0.02 {
+		t.Errorf("tail index %v, want 1.5", alpha)
+	}
+}
@@ -0,0 +1,90 @@
+package blocks
+
+import "fmt"
+
+// idleTracker is implemented by processors that account for the time spent
+// waiting for requests, asleep and waking up
+type idleTracker interface {
+	overheadTracker
+	Now() float64
+	getIdleTime() float64
+	getSleepTime() float64
+	getWakeTime() float64
+	getWakeups() int
+}
+
+// EnergyKeeper estimates the energy consumed by a set of processors from the
+// time they spend busy (serving, context switching or waking up), idle and
+// asleep, and combines it with the request latency into the energy-delay
+// product
+type EnergyKeeper struct {
+	statsOutput
+	busyPower  float64
+	idlePower  float64
+	sleepPower float64
+	stats      *AllKeeper
+	processors []idleTracker
+}
+
+// NewEnergyKeeper returns a new *EnergyKeeper for processors consuming
+// busyPower when busy, idlePower when idle and sleepPower when asleep. stats
+// are the statistics of the requests they serve
+func NewEnergyKeeper(busyPower, idlePower, sleepPower float64, stats *AllKeeper) *EnergyKeeper {
+	return &EnergyKeeper{busyPower: busyPower, idlePower: idlePower, sleepPower: sleepPower, stats: stats}
+}
+
+// AddProcessor adds a processor to the ones monitored. Processors that do not
+// track their busy time are ignored
+func (k *EnergyKeeper) AddProcessor(p Processor) {
+	if t, ok := p.(idleTracker); ok {
+		k.processors = append(k.processors, t)
+	}
+}
+
+// busyTime returns the time p spent serving or context switching
+func busyTime(p idleTracker) float64 {
+	return p.getWorkTime() + p.getCtxTime()
+}
+
+// coreEnergy returns the energy consumed by p so far. Any time it was not
+// busy, waking up or asleep counts as idle
+func (k *EnergyKeeper) coreEnergy(p idleTracker) float64 {
+	busy := busyTime(p) + p.getWakeTime()
+	sleep := p.getSleepTime()
+	idle := p.Now() - busy - sleep
+	return busy*k.busyPower + idle*k.idlePower + sleep*k.sleepPower
+}
+
+// Energy returns the total energy consumed by the processors so far
+func (k *EnergyKeeper) Energy() float64 {
+	var energy float64
+	for _, p := range k.processors {
+		energy += k.coreEnergy(p)
+	}
+	return energy
+}
+
+// EnergyPerReq returns the energy consumed per completed request
+func (k *EnergyKeeper) EnergyPerReq() float64 {
+	return k.Energy() / float64(k.stats.Count())
+}
+
+// EDP returns the energy-delay product, i.e. the energy per request times the
+// mean delay
+func (k *EnergyKeeper) EDP() float64 {
+	return k.EnergyPerReq() * k.stats.MeanDelay()
+}
+
+// PrintStats prints the energy figures at the end of the simulation, followed
+// by the time each core spent busy, idle and asleep, its utilization and its
+// energy. This is called by the model
+func (k *EnergyKeeper) PrintStats() {
+	fmt.Fprintf(k.out(), "energy:%v\tenergy_per_req:%v\tmean_delay:%v\tedp:%v\n",
+		k.Energy(), k.EnergyPerReq(), k.stats.MeanDelay(), k.EDP())
+
+	fmt.Fprintln(k.out(), "Core\tBusy\tIdle\tAsleep\tWakeups\tUtilization\tEnergy")
+	for i, p := range k.processors {
+		fmt.Fprintf(k.out(), "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", i, busyTime(p), p.getIdleTime(), p.getSleepTime(),
+			p.getWakeups(), busyTime(p)/p.Now(), k.coreEnergy(p))
+	}
+}
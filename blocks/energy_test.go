@@ -0,0 +1,63 @@
+package blocks
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEnergyKeeperEDP(t *testing.T) {
+	// the core is busy for 2 requests of 10 and idle for 20 in between, till
+	// the run ends with the last completion at 40
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 10}, {30, 10}})
+	p := NewRTCProcessor(0)
+	stats, _ := runProcessors(g, 0.1, 1e3, p)
+	k := NewEnergyKeeper(2, 0.5, 0, stats)
+	k.AddProcessor(p)
+
+	energy := 20*2 + 20*0.5
+	if k.Energy() != energy {
+		t.Errorf("energy %v, want %v", k.Energy(), energy)
+	}
+	if k.EnergyPerReq() != energy/2 {
+		t.Errorf("energy per request %v, want %v", k.EnergyPerReq(), energy/2)
+	}
+	if want := energy / 2 * 10; k.EDP() != want {
+		t.Errorf("energy-delay product %v, want %v for %v per request and a mean delay of 10", k.EDP(), want, energy/2)
+	}
+}
+
+func TestIdleTimeDominatesAtLowLoad(t *testing.T) {
+	// 5% of the time is spent serving requests
+	p := NewRTCProcessor(0)
+	runProcessors(NewMDRandGenerator(0.05, 1), 1, 1e5, p)
+	idle, work := p.getIdleTime(), p.getWorkTime()
+	if util := work / (idle + work); math.Abs(util-0.05) > 0.005 {
+		t.Errorf("utilization %v, want about 0.05", util)
+	}
+}
+
+func TestSleepAddsWakeupCost(t *testing.T) {
+	// the core falls asleep before the second request and wakes up for it,
+	// delaying the third one which arrives while it is waking up
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 1}, {20, 1}, {1, 1}})
+	p := NewRTCProcessor(0)
+	p.SetSleep(5, 2)
+	stats, _ := runProcessors(g, 1, 100, p)
+
+	want := []float64{1, 3, 3}
+	if stats.Count() != len(want) {
+		t.Fatalf("%v requests completed, want %v", stats.Count(), len(want))
+	}
+	for i, item := range stats.items {
+		if item.Delay != want[i] {
+			t.Errorf("request %v: delay %v, want %v", i, item.Delay, want[i])
+		}
+	}
+	if p.getWakeups() != 1 || p.getWakeTime() != 2 {
+		t.Errorf("%v wakeups for %v, want 1 for 2", p.getWakeups(), p.getWakeTime())
+	}
+	// asleep from 6 to 20, and the run ends with the last completion
+	if sleep := p.getSleepTime(); sleep != 14 {
+		t.Errorf("asleep for %v, want 14", sleep)
+	}
+}
@@ -0,0 +1,148 @@
+package blocks
+
+import (
+	"fmt"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// gangMember is the share of a gang request given to one of its cores. Only
+// the lead core terminates the request, the others are just held for the
+// service time
+type gangMember struct {
+	req     engine.ReqInterface
+	service float64
+	core    int
+	lead    bool
+}
+
+func (m *gangMember) GetDelay() float64 {
+	return m.req.GetDelay()
+}
+
+func (m *gangMember) GetServiceTime() float64 {
+	return m.service
+}
+
+func (m *gangMember) simulation() *engine.Simulation {
+	return reqSim(m.req)
+}
+
+// SubServiceTime does nothing, gang requests are never preempted
+func (m *gangMember) SubServiceTime(t float64) {}
+
+// GetID returns the ID of the gang request, so traces follow it to its cores
+func (m *gangMember) GetID() int {
+	if r, ok := m.req.(IDGetter); ok {
+		return r.GetID()
+	}
+	return -1
+}
+
+// GangScheduler dispatches the requests of its shared input queue in FIFO
+// order to its GangProcessors. A request needing several cores, i.e. a
+// WidthGetter, only starts once that many cores are free at the same time and
+// holds them all for its service time, while the requests behind it wait even
+// if some cores are free. Other requests need a single core
+type GangScheduler struct {
+	engine.Actor
+	statsOutput
+	released   *Queue
+	idle       []int // free cores, in release order
+	dispatched int
+	widthSum   int
+	blocked    float64 // time spent waiting for enough free cores
+}
+
+// NewGangScheduler returns a new *GangScheduler. The shared queue should be
+// added as its input queue and the processors with AddProcessor
+func NewGangScheduler() *GangScheduler {
+	s := &GangScheduler{released: NewQueue()}
+	s.AddInQueue(s.released)
+	return s
+}
+
+// AddProcessor adds a core, fed by its own queue
+func (s *GangScheduler) AddProcessor(p *GangProcessor) {
+	q := NewQueue()
+	s.AddOutQueue(q)
+	p.AddInQueue(q)
+	p.scheduler = s
+	p.core = s.GetOutQueueCount() - 1
+	s.idle = append(s.idle, p.core)
+}
+
+// release gives the core of m back to the scheduler
+func (s *GangScheduler) release(m *gangMember) {
+	s.released.Enqueue(m)
+}
+
+// Run is the main loop of the GangScheduler
+func (s *GangScheduler) Run() {
+	for {
+		req := s.ReadInQueueOnly(1)
+		width := 1
+		if r, ok := req.(WidthGetter); ok && r.GetWidth() > 1 {
+			width = r.GetWidth()
+		}
+		if width > s.GetOutQueueCount() {
+			panic(fmt.Sprintf("Gang request of width %v on %v cores", width, s.GetOutQueueCount()))
+		}
+
+		// the head of the queue blocks the requests behind it
+		start := s.Now()
+		for len(s.idle) < width {
+			m := s.ReadInQueueOnly(0).(*gangMember)
+			s.idle = append(s.idle, m.core)
+		}
+		s.blocked += s.Now() - start
+
+		for i, core := range s.idle[:width] {
+			m := &gangMember{req: req, service: req.GetServiceTime(), core: core, lead: i == 0}
+			s.WriteOutQueueI(m, core)
+		}
+		s.idle = s.idle[width:]
+		s.dispatched++
+		s.widthSum += width
+	}
+}
+
+// PrintStats prints the dispatched requests, their mean width and the time
+// the head of the queue spent waiting for enough free cores.
+// This is called by the model
+func (s *GangScheduler) PrintStats() {
+	var meanWidth float64
+	if s.dispatched > 0 {
+		meanWidth = float64(s.widthSum) / float64(s.dispatched)
+	}
+	fmt.Fprintf(s.out(), "gang_dispatched:%v\tmean_width:%v\tblocked_time:%v\n", s.dispatched, meanWidth, s.blocked)
+}
+
+// GangProcessor is a run to completion core of a GangScheduler. It serves the
+// requests it leads and is only held for the other cores of a gang request,
+// returning to the scheduler once the service time elapsed
+type GangProcessor struct {
+	genericProcessor
+	scheduler *GangScheduler
+	core      int
+}
+
+// NewGangProcessor returns a new *GangProcessor
+func NewGangProcessor(ctxCost float64) *GangProcessor {
+	return &GangProcessor{genericProcessor: genericProcessor{ctxCost: ctxCost}}
+}
+
+// Run is the main processor loop
+func (p *GangProcessor) Run() {
+	for {
+		m := p.ReadInQueue().(*gangMember)
+		if m.lead {
+			p.trace(traceStart, m.req)
+		}
+		p.serve(m.service)
+		if m.lead {
+			p.terminate(m.req)
+		}
+		p.scheduler.release(m)
+	}
+}
@@ -0,0 +1,53 @@
+package blocks
+
+import "testing"
+
+func TestGangSchedulerHeadOfLineBlocking(t *testing.T) {
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	q := NewQueue()
+	s := NewGangScheduler()
+	s.AddInQueue(q)
+	sim.RegisterActor(s)
+	for i := 0; i < 2; i++ {
+		p := NewGangProcessor(0)
+		p.SetID(i)
+		p.SetReqDrain(stats)
+		s.AddProcessor(p)
+		sim.RegisterActor(p)
+	}
+	// a single core request at 0 and 1.5 and a request needing both cores
+	// at 1, which waits for the first one to complete and blocks the last
+	// one although a core is free
+	for _, gen := range []struct {
+		wideRatio float64
+		script    []ScriptedEvent
+	}{
+		{0, []ScriptedEvent{{0, 4}, {1.5, 1}}},
+		{1, []ScriptedEvent{{1, 2}}},
+	} {
+		g := NewScriptedGenerator(gen.script)
+		g.SetCreator(NewGangReqCreator(2, gen.wideRatio))
+		g.AddOutQueue(q)
+		sim.RegisterActor(g)
+	}
+	sim.Run(1e3)
+
+	// completions at 4, 6 and 7
+	want := []struct{ service, delay float64 }{{4, 4}, {2, 5}, {1, 5.5}}
+	if stats.Count() != len(want) {
+		t.Fatalf("%v requests completed, want %v", stats.Count(), len(want))
+	}
+	for i, item := range stats.items {
+		if item.ServiceTime != want[i].service || item.Delay != want[i].delay {
+			t.Errorf("completion %v: service time %v and delay %v, want %v and %v",
+				i, item.ServiceTime, item.Delay, want[i].service, want[i].delay)
+		}
+	}
+	// the wide request waits 3 for a second core, then the last one 2 for
+	// the wide one
+	if s.blocked != 5 {
+		t.Errorf("head of the queue blocked for %v, want 5", s.blocked)
+	}
+}
@@ -0,0 +1,159 @@
+package blocks
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// HdrHistogram V2 encoding constants, the 0x10 marks the ZigZag LEB128 counts
+const (
+	hdrEncodingCookie           = 0x1c849303 | 0x10
+	hdrCompressedEncodingCookie = 0x1c849304 | 0x10
+	hdrSignificantDigits        = 3
+)
+
+// hdrLayout is the log-scale bucket layout of an HdrHistogram tracking
+// integer values from 1 to highest with hdrSignificantDigits precision
+type hdrLayout struct {
+	highest                     int64
+	subBucketHalfCountMagnitude uint
+	subBucketHalfCount          int64
+	subBucketMask               int64
+	leadingZeroCountBase        int
+	countsLen                   int
+}
+
+func newHdrLayout(highest int64) hdrLayout {
+	largestSingleUnit := 2 * int64(math.Pow10(hdrSignificantDigits))
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(float64(largestSingleUnit))))
+	subBucketCount := int64(1) << subBucketCountMagnitude
+
+	bucketCount := 1
+	for smallestUntrackable := subBucketCount; smallestUntrackable <= highest; smallestUntrackable <<= 1 {
+		bucketCount++
+	}
+
+	l := hdrLayout{highest: highest}
+	l.subBucketHalfCountMagnitude = subBucketCountMagnitude - 1
+	l.subBucketHalfCount = subBucketCount / 2
+	l.subBucketMask = subBucketCount - 1
+	l.leadingZeroCountBase = 64 - int(l.subBucketHalfCountMagnitude) - 1
+	l.countsLen = (bucketCount + 1) * int(l.subBucketHalfCount)
+	return l
+}
+
+// index returns the counts array index of value
+func (l hdrLayout) index(value int64) int {
+	bucketIndex := l.leadingZeroCountBase - bits.LeadingZeros64(uint64(value|l.subBucketMask))
+	subBucketIndex := value >> uint(bucketIndex)
+	bucketBaseIndex := (bucketIndex + 1) << l.subBucketHalfCountMagnitude
+	return bucketBaseIndex + int(subBucketIndex-l.subBucketHalfCount)
+}
+
+// value returns the lowest value counted at index
+func (l hdrLayout) value(index int) int64 {
+	bucketIndex := (index >> l.subBucketHalfCountMagnitude) - 1
+	subBucketIndex := int64(index)&(l.subBucketHalfCount-1) + l.subBucketHalfCount
+	if bucketIndex < 0 {
+		subBucketIndex -= l.subBucketHalfCount
+		bucketIndex = 0
+	}
+	return subBucketIndex << uint(bucketIndex)
+}
+
+// hdrCounts converts the linear buckets to the HdrHistogram layout. Values
+// are bucket indices, i.e. in units of the histogram granularity
+func (hdr *histogram) hdrCounts() (hdrLayout, []int64) {
+	l := newHdrLayout(int64(len(hdr.buckets)))
+	counts := make([]int64, l.countsLen)
+	for i, c := range hdr.buckets {
+		if c > 0 {
+			counts[l.index(int64(i))] += int64(c)
+		}
+	}
+	return l, counts
+}
+
+// encodeHdr returns the HdrHistogram V2 encoding of the histogram
+func (hdr *histogram) encodeHdr() []byte {
+	l, counts := hdr.hdrCounts()
+
+	last := -1
+	for i, c := range counts {
+		if c > 0 {
+			last = i
+		}
+	}
+	var payload []byte
+	buf := make([]byte, binary.MaxVarintLen64)
+	for i := 0; i <= last; {
+		c := counts[i]
+		i++
+		if c == 0 {
+			// runs of zeros are encoded as a negative length
+			zeros := int64(1)
+			for i <= last && counts[i] == 0 {
+				zeros++
+				i++
+			}
+			if zeros > 1 {
+				c = -zeros
+			}
+		}
+		n := binary.PutVarint(buf, c)
+		payload = append(payload, buf[:n]...)
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, int32(hdrEncodingCookie))
+	binary.Write(&b, binary.BigEndian, int32(len(payload)))
+	binary.Write(&b, binary.BigEndian, int32(0)) // normalizing index offset
+	binary.Write(&b, binary.BigEndian, int32(hdrSignificantDigits))
+	binary.Write(&b, binary.BigEndian, int64(1)) // lowest discernible value
+	binary.Write(&b, binary.BigEndian, l.highest)
+	binary.Write(&b, binary.BigEndian, hdr.granularity) // integer to double conversion ratio
+	b.Write(payload)
+	return b.Bytes()
+}
+
+// encodeCompressedHdr returns the compressed HdrHistogram V2 encoding of the
+// histogram, as used in histogram logs
+func (hdr *histogram) encodeCompressedHdr() ([]byte, error) {
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	if _, err := w.Write(hdr.encodeHdr()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, int32(hdrCompressedEncodingCookie))
+	binary.Write(&b, binary.BigEndian, int32(z.Len()))
+	b.Write(z.Bytes())
+	return b.Bytes(), nil
+}
+
+// writeHdrLog writes the histogram as a single interval HdrHistogram log
+// covering the interval from 0 to end
+func (hdr *histogram) writeHdrLog(w io.Writer, end float64) error {
+	enc, err := hdr.encodeCompressedHdr()
+	if err != nil {
+		return err
+	}
+	max := 0.0
+	if hdr.count > 0 {
+		max = float64(hdr.maxBucket+1) * hdr.granularity
+	}
+	_, err = fmt.Fprintf(w, "#[Histogram log format version 1.3]\n"+
+		"\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n"+
+		"%.3f,%.3f,%.3f,%v\n", 0.0, end, max, base64.StdEncoding.EncodeToString(enc))
+	return err
+}
@@ -0,0 +1,120 @@
+package blocks
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+// decodeHdrLog decodes the single interval histogram log written by
+// writeHdrLog and returns the interval fields and the encoded counts by index
+func decodeHdrLog(t *testing.T, log string) (fields []string, highest int64, ratio float64, counts []int64) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(log, "\n"), "\n")
+	if len(lines) != 3 || lines[0] != "#[Histogram log format version 1.3]" {
+		t.Fatalf("log is not a single interval histogram log:\n%s", log)
+	}
+	fields = strings.Split(lines[2], ",")
+	if len(fields) != 4 {
+		t.Fatalf("interval line %q", lines[2])
+	}
+	enc, err := base64.StdEncoding.DecodeString(fields[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cookie, length int32
+	r := bytes.NewReader(enc)
+	binary.Read(r, binary.BigEndian, &cookie)
+	binary.Read(r, binary.BigEndian, &length)
+	if cookie != hdrCompressedEncodingCookie || int(length) != r.Len() {
+		t.Fatalf("compressed cookie %x and length %v, %v bytes left", cookie, length, r.Len())
+	}
+	z, err := zlib.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payloadLen, offset, digits int32
+	var lowest int64
+	r = bytes.NewReader(raw)
+	binary.Read(r, binary.BigEndian, &cookie)
+	binary.Read(r, binary.BigEndian, &payloadLen)
+	binary.Read(r, binary.BigEndian, &offset)
+	binary.Read(r, binary.BigEndian, &digits)
+	binary.Read(r, binary.BigEndian, &lowest)
+	binary.Read(r, binary.BigEndian, &highest)
+	binary.Read(r, binary.BigEndian, &ratio)
+	if cookie != hdrEncodingCookie || int(payloadLen) != r.Len() || offset != 0 || digits != hdrSignificantDigits || lowest != 1 {
+		t.Fatalf("header cookie %x, payload %v with %v bytes left, offset %v, digits %v, lowest %v",
+			cookie, payloadLen, r.Len(), offset, digits, lowest)
+	}
+	for r.Len() > 0 {
+		c, err := binary.ReadVarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c < 0 {
+			counts = append(counts, make([]int64, -c)...)
+		} else {
+			counts = append(counts, c)
+		}
+	}
+	return fields, highest, ratio, counts
+}
+
+func TestWriteHdrLogRoundTrip(t *testing.T) {
+	hdr := newHistogram()
+	// exact values below 2048 units, and 3 significant digits beyond them
+	samples := []float64{0.005, 1.234, 1.234, 12.5, 30.01, 30.02, 500.5, 999.99}
+	for _, s := range samples {
+		hdr.addSample(s)
+	}
+	var buf bytes.Buffer
+	if err := hdr.writeHdrLog(&buf, 100); err != nil {
+		t.Fatal(err)
+	}
+	fields, highest, ratio, counts := decodeHdrLog(t, buf.String())
+	if fields[0] != "0.000" || fields[1] != "100.000" || fields[2] != "1000.000" {
+		t.Errorf("interval start %v, length %v and max %v, want 0, 100 and 1000", fields[0], fields[1], fields[2])
+	}
+	if highest != int64(len(hdr.buckets)) || ratio != hdr.granularity {
+		t.Errorf("highest value %v and ratio %v, want %v and %v", highest, ratio, len(hdr.buckets), hdr.granularity)
+	}
+
+	l := newHdrLayout(highest)
+	want := map[int64]int64{}
+	for _, s := range samples {
+		want[l.value(l.index(int64(s/hdr.granularity)))]++
+	}
+	var total int64
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		v := l.value(i)
+		if want[v] != c {
+			t.Errorf("%v samples from %v, want %v", c, float64(v)*ratio, want[v])
+		}
+		total += c
+	}
+	if total != int64(len(samples)) {
+		t.Errorf("%v samples decoded, want %v", total, len(samples))
+	}
+	// the decoded values are within the precision of the samples
+	for _, s := range samples {
+		v := float64(l.value(l.index(int64(s/hdr.granularity)))) * ratio
+		if s-v < 0 || s-v > math.Max(hdr.granularity, s*2e-3) {
+			t.Errorf("sample %v decoded as %v", s, v)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package blocks
+
+import "fmt"
+
+// TokenBucket is a rate limiter shared by several processors, e.g. to model a
+// global throughput cap. Tokens accumulate at rate per time unit up to burst.
+// Actors only run one at a time, so the bucket needs no further
+// synchronization
+type TokenBucket struct {
+	statsOutput
+	rate         float64
+	burst        float64
+	tokens       float64
+	last         float64
+	throttled    int
+	throttleTime float64
+}
+
+// NewTokenBucket returns a new full *TokenBucket
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	if rate <= 0 || burst < 1 {
+		panic(fmt.Sprintf("Invalid token bucket: rate %v, burst %v", rate, burst))
+	}
+	return &TokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+// Reserve takes a token now and returns how long the caller should wait
+// before using it. Tokens are handed out in the order they are reserved
+func (b *TokenBucket) Reserve(now float64) float64 {
+	b.tokens += (now - b.last) * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	wait := -b.tokens / b.rate
+	b.throttled++
+	b.throttleTime += wait
+	return wait
+}
+
+// PrintStats prints how many requests had to wait for a token and for how
+// long in total. This is called by the model
+func (b *TokenBucket) PrintStats() {
+	fmt.Fprintf(b.out(), "rate_limit:%v\tburst:%v\tthrottled:%v\tthrottle_time:%v\n", b.rate, b.burst, b.throttled, b.throttleTime)
+}
@@ -2,7 +2,9 @@ package blocks
 
 import (
 	"container/list"
-	//	"fmt"
+	"fmt"
+	"math"
+	"math/rand"
 
 	"github.com/epfl-dcsl/schedsim/engine"
 )
@@ -12,42 +14,603 @@ import (
 type Processor interface {
 	engine.ActorInterface
 	SetReqDrain(rd RequestDrain) // We might want to specify different drains for different processors or use the same drain for all
+	SetID(id int)
+	SetNode(node int, transferCost float64)
 }
 
 // generic processor: All processors should have it as an embedded field
 type genericProcessor struct {
 	engine.Actor
+	id       int
 	reqDrain RequestDrain
 	ctxCost  float64
+	workTime float64 // time spent doing useful work
+	ctxTime  float64 // time spent in context switches
+
+	// idle and sleep accounting
+	idleTime   float64 // time spent waiting for requests, including asleep
+	sleepTime  float64 // time spent asleep
+	wakeTime   float64 // time spent waking up
+	wakeups    int
+	sleepAfter float64 // idle time after which the core sleeps, 0 never sleeps
+	wakeupCost float64
+	waiting    bool // blocked waiting for requests since idleSince
+	idleSince  float64
+
+	// NUMA placement
+	node         int
+	transferCost float64
+	inQueues     []engine.QueueInterface
+
+	// admission control
+	slo       float64
+	shedDrain RequestDrain
 }
 
 func (p *genericProcessor) SetReqDrain(rd RequestDrain) {
 	p.reqDrain = rd
 }
 
-// RTCProcessor is a run to completion processor
+// SetID sets the processor id recorded in the requests it serves
+func (p *genericProcessor) SetID(id int) {
+	p.id = id
+}
+
+// terminate records which processor served the request and passes it to
+// the request drain
+func (p *genericProcessor) terminate(req engine.ReqInterface) {
+	p.trace(traceComplete, req)
+	if r, ok := req.(servedBySetter); ok {
+		r.setServedBy(p.id)
+	}
+	p.reqDrain.TerminateReq(req)
+}
+
+// SetNode places the processor on a NUMA node. Requests dequeued from the
+// queues of other nodes pay transferCost before their service, accounted as
+// overhead like context switches. Only the requests read with ReadInQueue,
+// ReadInQueueI and ReadInQueues pay it
+func (p *genericProcessor) SetNode(node int, transferCost float64) {
+	p.node = node
+	p.transferCost = transferCost
+}
+
+// AddInQueue adds an input queue, keeping track of its node
+func (p *genericProcessor) AddInQueue(q engine.QueueInterface) {
+	p.Actor.AddInQueue(q)
+	p.inQueues = append(p.inQueues, q)
+}
+
+// transfer waits for the transfer cost if input queue idx is on another node
+func (p *genericProcessor) transfer(idx int) {
+	if p.transferCost == 0 || queueNode(p.inQueues[idx]) == p.node {
+		return
+	}
+	p.Wait(p.transferCost)
+	p.ctxTime += p.transferCost
+}
+
+// ReadInQueueI reads input queue idx, paying the transfer cost if it is on
+// another node
+func (p *genericProcessor) ReadInQueueI(idx int) engine.ReqInterface {
+	req := p.Actor.ReadInQueueI(idx)
+	p.transfer(idx)
+	return req
+}
+
+// ReadInQueues reads the first non-empty input queue, paying the transfer
+// cost if it is on another node
+func (p *genericProcessor) ReadInQueues() (engine.ReqInterface, int) {
+	req, idx := p.Actor.ReadInQueues()
+	p.transfer(idx)
+	return req, idx
+}
+
+// SetAdmission makes the processor shed the requests that cannot meet their
+// deadline anymore, terminating them to shedDrain instead of serving them.
+// The deadline is the one of the request if it has one, its arrival plus slo
+// otherwise. Only the run to completion processors check admission
+func (p *genericProcessor) SetAdmission(slo float64, shedDrain RequestDrain) {
+	p.slo = slo
+	p.shedDrain = shedDrain
+}
+
+// admit returns whether req would still meet its deadline if served now for
+// work, shedding it otherwise
+func (p *genericProcessor) admit(req engine.ReqInterface, work float64) bool {
+	if p.shedDrain == nil {
+		return true
+	}
+	finish := p.Now() + work + p.ctxCost
+	deadline := p.Now() - req.GetDelay() + p.slo
+	if r, ok := req.(DeadlineGetter); ok {
+		deadline = r.GetDeadline()
+	}
+	if finish <= deadline {
+		return true
+	}
+	p.trace(traceDrop, req)
+	p.shedDrain.TerminateReq(req)
+	return false
+}
+
+// trace records event for req at the processor
+func (p *genericProcessor) trace(event string, req engine.ReqInterface) {
+	trace(event, req, "processor", p.id)
+}
+
+// serve blocks for work plus the context switch cost and accounts for both
+func (p *genericProcessor) serve(work float64) {
+	p.Wait(work + p.ctxCost)
+	p.workTime += work
+	p.ctxTime += p.ctxCost
+}
+
+// SetSleep makes the processor sleep once it has been idle for sleepAfter, in
+// which case the next request pays wakeupCost before its service. Only idle
+// periods spent in ReadInQueue are considered
+func (p *genericProcessor) SetSleep(sleepAfter, wakeupCost float64) {
+	p.sleepAfter = sleepAfter
+	p.wakeupCost = wakeupCost
+}
+
+// ReadInQueue blocks until a request is available in the first input queue,
+// accounting for the time spent idle and waking up if the core fell asleep
+func (p *genericProcessor) ReadInQueue() engine.ReqInterface {
+	p.waiting, p.idleSince = true, p.Now()
+	req := p.Actor.ReadInQueue()
+	p.waiting = false
+
+	idle := p.Now() - p.idleSince
+	p.idleTime += idle
+	if p.sleepAfter > 0 && idle > p.sleepAfter {
+		p.sleepTime += idle - p.sleepAfter
+		p.Wait(p.wakeupCost)
+		p.wakeTime += p.wakeupCost
+		p.wakeups++
+	}
+	p.transfer(0)
+	return req
+}
+
+// currIdle returns how long the processor has been waiting for requests if
+// it still is, e.g. at the end of the simulation
+func (p *genericProcessor) currIdle() float64 {
+	if !p.waiting {
+		return 0
+	}
+	return p.Now() - p.idleSince
+}
+
+func (p *genericProcessor) getIdleTime() float64 {
+	return p.idleTime + p.currIdle()
+}
+
+func (p *genericProcessor) getSleepTime() float64 {
+	if idle := p.currIdle(); p.sleepAfter > 0 && idle > p.sleepAfter {
+		return p.sleepTime + idle - p.sleepAfter
+	}
+	return p.sleepTime
+}
+
+func (p *genericProcessor) getWakeTime() float64 {
+	return p.wakeTime
+}
+
+func (p *genericProcessor) getWakeups() int {
+	return p.wakeups
+}
+
+func (p *genericProcessor) getWorkTime() float64 {
+	return p.workTime
+}
+
+func (p *genericProcessor) getCtxTime() float64 {
+	return p.ctxTime
+}
+
+// RTCProcessor is a run to completion processor. Its scale is its speed
+// relative to the reference core the service times are given for, e.g. a
+// scale of 2 serves requests in half their service time
 type RTCProcessor struct {
 	genericProcessor
 	scale float64
+	curr  engine.ReqInterface // in service since since, e.g. to checkpoint it
+	since float64
 }
 
-// NewRTCProcessor returns a new *RTCProcessor
+// NewRTCProcessor returns a new *RTCProcessor running at the reference speed
 func NewRTCProcessor(ctxCost float64) *RTCProcessor {
-	return &RTCProcessor{genericProcessor: genericProcessor{ctxCost: ctxCost}}
+	return NewRTCProcessorScaled(ctxCost, 1.0)
+}
+
+// NewRTCProcessorScaled returns a new *RTCProcessor running scale times
+// faster than the reference core
+func NewRTCProcessorScaled(ctxCost, scale float64) *RTCProcessor {
+	if scale <= 0 {
+		panic(fmt.Sprintf("Non positive core speed: %v", scale))
+	}
+	return &RTCProcessor{genericProcessor: genericProcessor{ctxCost: ctxCost}, scale: scale}
 }
 
 // Run is the main processor loop
 func (p *RTCProcessor) Run() {
 	for {
 		req := p.ReadInQueue()
-		p.Wait(req.GetServiceTime() + p.ctxCost)
+		if !p.admit(req, req.GetServiceTime()/p.scale) {
+			continue
+		}
+		p.trace(traceStart, req)
+		p.curr, p.since = req, p.Now()
+		p.serve(req.GetServiceTime() / p.scale)
+		p.curr = nil
 		if monitorReq, ok := req.(*MonitorReq); ok {
 			monitorReq.finalLength = p.GetInQueueLen(0)
 		}
-		p.reqDrain.TerminateReq(req)
+		p.terminate(req)
+	}
+}
+
+// RateLimitedRTCProcessor is a run to completion processor that takes a token
+// from a global rate limiter, shared with the other cores, before starting to
+// serve a request. The core idles while waiting for the token
+type RateLimitedRTCProcessor struct {
+	genericProcessor
+	limiter *TokenBucket
+}
+
+// NewRateLimitedRTCProcessor returns a new *RateLimitedRTCProcessor
+func NewRateLimitedRTCProcessor(limiter *TokenBucket, ctxCost float64) *RateLimitedRTCProcessor {
+	return &RateLimitedRTCProcessor{limiter: limiter, genericProcessor: genericProcessor{ctxCost: ctxCost}}
+}
+
+// Run is the main processor loop
+func (p *RateLimitedRTCProcessor) Run() {
+	for {
+		req := p.ReadInQueue()
+		if wait := p.limiter.Reserve(p.Now()); wait > 0 {
+			p.Wait(wait)
+		}
+		p.trace(traceStart, req)
+		p.serve(req.GetServiceTime())
+		p.terminate(req)
+	}
+}
+
+// QueuePrioRTCProcessor is a run to completion processor with multiple input
+// queues. It always serves the highest priority non-empty queue, e.g. a local
+// queue before a shared overflow one. Input queues are added in decreasing
+// priority
+type QueuePrioRTCProcessor struct {
+	genericProcessor
+}
+
+// NewQueuePrioRTCProcessor returns a new *QueuePrioRTCProcessor
+func NewQueuePrioRTCProcessor(ctxCost float64) *QueuePrioRTCProcessor {
+	return &QueuePrioRTCProcessor{genericProcessor: genericProcessor{ctxCost: ctxCost}}
+}
+
+// Run is the main processor loop
+func (p *QueuePrioRTCProcessor) Run() {
+	for {
+		req, _ := p.ReadInQueues()
+		p.trace(traceStart, req)
+		p.serve(req.GetServiceTime())
+		p.terminate(req)
 	}
 }
 
+// WorkStealingProcessor is a run to completion processor that serves its own
+// input queue first and, when it is empty, steals the oldest request of a
+// randomly chosen sibling whose queue is non-empty. Only busy siblings are
+// stolen from, since an idle one is about to serve its queue itself.
+// The local queue should be added with AddInQueue and the siblings after it
+// with AddStealQueue
+type WorkStealingProcessor struct {
+	genericProcessor
+	siblings []*WorkStealingProcessor
+	idle     bool
+	rng      *rand.Rand
+}
+
+// NewWorkStealingProcessor returns a new *WorkStealingProcessor
+func NewWorkStealingProcessor(ctxCost float64) *WorkStealingProcessor {
+	return &WorkStealingProcessor{genericProcessor: genericProcessor{ctxCost: ctxCost}, rng: newRand()}
+}
+
+// SetSimulation seeds the choice of the victims from the simulation the
+// processor is registered in
+func (p *WorkStealingProcessor) SetSimulation(s *engine.Simulation) {
+	p.rng = seededRand(s.Rand())
+}
+
+// AddStealQueue adds the local queue q of the sibling processor owner to the
+// queues to steal from
+func (p *WorkStealingProcessor) AddStealQueue(q engine.QueueInterface, owner *WorkStealingProcessor) {
+	p.AddInQueue(q)
+	p.siblings = append(p.siblings, owner)
+}
+
+// next returns the next request to serve and whether it was stolen
+func (p *WorkStealingProcessor) next() (engine.ReqInterface, bool) {
+	for {
+		if p.GetInQueueLen(0) > 0 {
+			return p.ReadInQueue(), false
+		}
+		var victims []int
+		pending := false
+		for i, s := range p.siblings {
+			if p.GetInQueueLen(i+1) == 0 {
+				continue
+			}
+			if s.idle {
+				pending = true
+			} else {
+				victims = append(victims, i+1)
+			}
+		}
+		if len(victims) > 0 {
+			return p.ReadInQueueI(victims[p.rng.Intn(len(victims))]), true
+		}
+		if pending {
+			// let the idle owner wake up and take its request
+			p.Wait(0)
+			continue
+		}
+		p.idle = true
+		p.WaitInQueues()
+		p.idle = false
+	}
+}
+
+// Run is the main processor loop
+func (p *WorkStealingProcessor) Run() {
+	for {
+		req, stolen := p.next()
+		if stealable, ok := req.(*StealableReq); ok && stolen {
+			stealable.stolen = true
+		}
+		p.trace(traceStart, req)
+		p.serve(req.GetServiceTime())
+		p.terminate(req)
+	}
+}
+
+// PreemptiveRTCProcessor is a single server that runs requests to completion
+// unless a request with a higher priority, i.e. a smaller GetCmpVal(), arrives.
+// The current request is then preempted with the work done so far subtracted
+// from its service time, and resumed once no higher priority request is
+// pending. It pulls requests from its input queues as soon as they arrive and
+// keeps the pending ones in its own priority queue, so the input queues should
+// not be shared with other processors. ctxCost is charged when a request
+// starts, is preempted and is resumed
+type PreemptiveRTCProcessor struct {
+	genericProcessor
+	statsOutput
+	pending     *PQueue
+	preemptions int
+}
+
+// NewPreemptiveRTCProcessor returns a new *PreemptiveRTCProcessor
+func NewPreemptiveRTCProcessor(ctxCost float64) *PreemptiveRTCProcessor {
+	return &PreemptiveRTCProcessor{genericProcessor: genericProcessor{ctxCost: ctxCost}, pending: NewPQueue()}
+}
+
+// switchCost blocks for a context switch
+func (p *PreemptiveRTCProcessor) switchCost() {
+	if p.ctxCost > 0 {
+		p.Wait(p.ctxCost)
+		p.ctxTime += p.ctxCost
+	}
+}
+
+// Preemptions returns how many times a request was preempted
+func (p *PreemptiveRTCProcessor) Preemptions() int {
+	return p.preemptions
+}
+
+// PrintStats prints the number of preemptions at the end of the simulation.
+// This is called by the model
+func (p *PreemptiveRTCProcessor) PrintStats() {
+	fmt.Fprintf(p.out(), "preemptions:%v\n", p.preemptions)
+}
+
+// Run is the main processor loop
+func (p *PreemptiveRTCProcessor) Run() {
+	var curr engine.ReqInterface
+	for {
+		if curr == nil {
+			for _, q := range p.GetAllInQueueLens() {
+				for ; q > 0; q-- {
+					req, _ := p.ReadInQueues()
+					p.pending.Enqueue(req)
+				}
+			}
+			if p.pending.Len() > 0 {
+				curr = p.pending.Dequeue()
+			} else {
+				curr, _ = p.ReadInQueues()
+			}
+			p.switchCost()
+			p.trace(traceStart, curr)
+		}
+
+		start := p.Now()
+		done, newReq, _ := p.WaitPreemptible(curr.GetServiceTime())
+		elapsed := p.Now() - start
+		p.workTime += elapsed
+		if done {
+			p.terminate(curr)
+			curr = nil
+			continue
+		}
+		curr.SubServiceTime(elapsed)
+		if newReq == nil {
+			continue
+		}
+
+		if newReq.(Comparable).GetCmpVal() < curr.(Comparable).GetCmpVal() {
+			p.preemptions++
+			p.trace(tracePreempt, curr)
+			p.pending.Enqueue(curr)
+			p.switchCost()
+			curr = newReq
+			p.switchCost()
+			p.trace(traceStart, curr)
+		} else {
+			p.pending.Enqueue(newReq)
+		}
+	}
+}
+
+// TimeoutRTCProcessor is a run to completion processor with a hard client
+// timeout. Requests whose delay reaches the timeout, while queued or in
+// service, are aborted and terminated to the timeout drain with the work done
+// so far subtracted from their service time
+type TimeoutRTCProcessor struct {
+	genericProcessor
+	timeout      float64
+	timeoutDrain RequestDrain
+}
+
+// NewTimeoutRTCProcessor returns a new *TimeoutRTCProcessor
+func NewTimeoutRTCProcessor(timeout, ctxCost float64) *TimeoutRTCProcessor {
+	return &TimeoutRTCProcessor{timeout: timeout, genericProcessor: genericProcessor{ctxCost: ctxCost}}
+}
+
+// SetTimeoutDrain sets the drain for the timed out requests
+func (p *TimeoutRTCProcessor) SetTimeoutDrain(rd RequestDrain) {
+	p.timeoutDrain = rd
+}
+
+// Run is the main processor loop
+func (p *TimeoutRTCProcessor) Run() {
+	for {
+		req := p.ReadInQueue()
+		age := req.GetDelay()
+		if r, ok := req.(retrier); ok {
+			// the client times out every attempt
+			age = r.getAttemptDelay()
+		}
+		left := p.timeout - age
+		if left <= 0 {
+			// timed out while queued
+			p.trace(traceAbort, req)
+			p.timeoutDrain.TerminateReq(req)
+			continue
+		}
+		if req.GetServiceTime()+p.ctxCost <= left {
+			p.trace(traceStart, req)
+			p.serve(req.GetServiceTime())
+			p.terminate(req)
+			continue
+		}
+		// abort at the timeout boundary
+		done := left - p.ctxCost
+		if done < 0 {
+			done = 0
+		}
+		p.trace(traceStart, req)
+		p.Wait(left)
+		p.workTime += done
+		p.ctxTime += left - done
+		req.SubServiceTime(done)
+		p.trace(traceAbort, req)
+		p.timeoutDrain.TerminateReq(req)
+	}
+}
+
+// ScalableRTCProcessor is a run to completion processor that is only active
+// within a time window, to model cores added or removed at a scale event.
+// A request still in service when the core is removed is requeued with its
+// remaining work, so that another core can continue it
+type ScalableRTCProcessor struct {
+	genericProcessor
+	start float64
+	stop  float64
+}
+
+// NewScalableRTCProcessor returns a new *ScalableRTCProcessor active from start
+// until stop. A negative stop means that the processor is never removed
+func NewScalableRTCProcessor(ctxCost, start, stop float64) *ScalableRTCProcessor {
+	return &ScalableRTCProcessor{start: start, stop: stop, genericProcessor: genericProcessor{ctxCost: ctxCost}}
+}
+
+// Run is the main processor loop
+func (p *ScalableRTCProcessor) Run() {
+	if p.start > 0 {
+		p.Wait(p.start)
+	}
+	for {
+		if p.stop < 0 {
+			req := p.ReadInQueue()
+			p.trace(traceStart, req)
+			p.serve(req.GetServiceTime())
+			p.terminate(req)
+			continue
+		}
+
+		now := p.Now()
+		if now >= p.stop {
+			p.Done()
+			return
+		}
+		intr, req := p.WaitInterruptible(p.stop - now)
+		if intr {
+			p.Done()
+			return
+		}
+		if req == nil {
+			continue
+		}
+
+		now = p.Now()
+		if now+req.GetServiceTime()+p.ctxCost <= p.stop {
+			p.trace(traceStart, req)
+			p.serve(req.GetServiceTime())
+			p.terminate(req)
+			continue
+		}
+		// removed while serving, requeue the remaining work
+		done := p.stop - now - p.ctxCost
+		if done < 0 {
+			done = 0
+		}
+		p.trace(traceStart, req)
+		p.Wait(p.stop - now)
+		p.workTime += done
+		p.ctxTime += p.stop - now - done
+		req.SubServiceTime(done)
+		p.trace(tracePreempt, req)
+		p.WriteInQueue(req)
+		p.Done()
+		return
+	}
+}
+
+// CoreLauncher registers processors in the running simulation at a given
+// time, to model cores added at a scale event
+type CoreLauncher struct {
+	engine.Actor
+	at         float64
+	processors []engine.ActorInterface
+}
+
+// NewCoreLauncher returns a new *CoreLauncher registering the processors at
+// time at. They should not be registered otherwise
+func NewCoreLauncher(at float64, processors ...engine.ActorInterface) *CoreLauncher {
+	return &CoreLauncher{at: at, processors: processors}
+}
+
+// Run waits for the launch time, registers the processors and finishes
+func (l *CoreLauncher) Run() {
+	l.Wait(l.at)
+	for _, p := range l.processors {
+		l.Simulation().RegisterActor(p)
+	}
+	l.Done()
+}
+
 // TSProcessor is a time sharing processor
 type TSProcessor struct {
 	genericProcessor
@@ -65,11 +628,14 @@ func (p *TSProcessor) Run() {
 		req := p.ReadInQueue()
 
 		if req.GetServiceTime() <= p.quantum {
-			p.Wait(req.GetServiceTime() + p.ctxCost)
-			p.reqDrain.TerminateReq(req)
+			p.trace(traceStart, req)
+			p.serve(req.GetServiceTime())
+			p.terminate(req)
 		} else {
-			p.Wait(p.quantum + p.ctxCost)
+			p.trace(traceStart, req)
+			p.serve(p.quantum)
 			req.SubServiceTime(p.quantum)
+			p.trace(tracePreempt, req)
 			p.WriteInQueue(req)
 		}
 	}
@@ -95,17 +661,182 @@ func (p *SrptTSProcessor) Run() {
 		req := p.ReadInQueue()
 
 		if req.GetServiceTime() <= p.quantum {
-			p.Wait(req.GetServiceTime() + p.ctxCost)
-			p.reqDrain.TerminateReq(req)
+			p.trace(traceStart, req)
+			p.serve(req.GetServiceTime())
+			p.terminate(req)
+		} else {
+			p.trace(traceStart, req)
+			p.serve(p.quantum)
+			req.SubServiceTime(p.quantum)
+			p.trace(tracePreempt, req)
+			p.WriteInQueue(req)
+		}
+	}
+}
+
+// LASProcessor is a time sharing processor that implements the Least
+// Attained Service policy. It processes a request for a quantum, and if not
+// finished, re-enqueues it. Unlike SRPT it needs no knowledge of the request
+// size, but it relies on being connected to a LAS priority queue that sorts
+// requests by the service they received so far
+type LASProcessor struct {
+	genericProcessor
+	quantum float64
+}
+
+// NewLASProcessor returns a new *LASProcessor
+func NewLASProcessor(quantum, ctxCost float64) *LASProcessor {
+	return &LASProcessor{quantum: quantum, genericProcessor: genericProcessor{ctxCost: ctxCost}}
+}
+
+// Run is the main processor loop
+func (p *LASProcessor) Run() {
+	for {
+		req := p.ReadInQueue()
+
+		if req.GetServiceTime() <= p.quantum {
+			p.trace(traceStart, req)
+			p.serve(req.GetServiceTime())
+			p.terminate(req)
 		} else {
-			p.Wait(p.quantum + p.ctxCost)
+			p.trace(traceStart, req)
+			p.serve(p.quantum)
 			req.SubServiceTime(p.quantum)
+			p.trace(tracePreempt, req)
 			p.WriteInQueue(req)
 		}
 	}
 }
 
-// PSProcessor is a processor sharing processor
+// BatchService selects how the service time of a batch is derived from the
+// service times of its requests
+type BatchService int
+
+const (
+	// BatchMax serves the batch for its longest request, e.g. parallel
+	// hardware lanes
+	BatchMax BatchService = iota
+	// BatchSum serves the batch for the sum of its requests
+	BatchSum
+)
+
+var batchServiceNames = []string{"max", "sum"}
+
+// MarshalText returns the name of the batch service, max or sum
+func (b BatchService) MarshalText() ([]byte, error) {
+	if b < 0 || int(b) >= len(batchServiceNames) {
+		return nil, fmt.Errorf("unknown batch service: %d", int(b))
+	}
+	return []byte(batchServiceNames[b]), nil
+}
+
+// UnmarshalText sets the batch service from its name, max or sum
+func (b *BatchService) UnmarshalText(text []byte) error {
+	for i, name := range batchServiceNames {
+		if name == string(text) {
+			*b = BatchService(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown batch service: %v", string(text))
+}
+
+// BatchProcessor serves requests in batches of up to maxBatch requests. Once
+// a request is available it waits up to maxWait for more to accumulate, or
+// until the batch is full, then pays a single batchOverhead for the whole
+// batch and serves it for the max or sum of the service times of its
+// requests, which all terminate together
+type BatchProcessor struct {
+	genericProcessor
+	statsOutput
+	maxBatch      int
+	maxWait       float64
+	batchOverhead float64
+	service       BatchService
+	batches       int
+	batched       int // requests served in batches
+}
+
+// NewBatchProcessor returns a new *BatchProcessor serving batches for the
+// service time of their longest request
+func NewBatchProcessor(maxBatch int, maxWait, batchOverhead float64) *BatchProcessor {
+	if maxBatch < 1 {
+		panic(fmt.Sprintf("Non positive batch size: %v", maxBatch))
+	}
+	return &BatchProcessor{maxBatch: maxBatch, maxWait: maxWait, batchOverhead: batchOverhead}
+}
+
+// SetBatchService sets how the service time of a batch is computed
+func (p *BatchProcessor) SetBatchService(service BatchService) {
+	p.service = service
+}
+
+// collect returns a batch starting with the first available request
+func (p *BatchProcessor) collect() []engine.ReqInterface {
+	batch := []engine.ReqInterface{p.ReadInQueue()}
+	deadline := p.Now() + p.maxWait
+	for len(batch) < p.maxBatch {
+		if p.GetInQueueLen(0) > 0 {
+			batch = append(batch, p.ReadInQueue())
+			continue
+		}
+		left := deadline - p.Now()
+		if left <= 0 {
+			break
+		}
+		timeout, req := p.WaitInterruptible(left)
+		if timeout {
+			break
+		}
+		if req != nil {
+			batch = append(batch, req)
+		}
+	}
+	return batch
+}
+
+// MeanBatch returns the mean number of requests per batch, 0 if no batch
+// was served
+func (p *BatchProcessor) MeanBatch() float64 {
+	if p.batches == 0 {
+		return 0
+	}
+	return float64(p.batched) / float64(p.batches)
+}
+
+// PrintStats prints the number of batches and their mean size at the end of
+// the simulation. This is called by the model
+func (p *BatchProcessor) PrintStats() {
+	fmt.Fprintf(p.out(), "batches:%v\tmean_batch:%v\n", p.batches, p.MeanBatch())
+}
+
+// Run is the main processor loop
+func (p *BatchProcessor) Run() {
+	for {
+		batch := p.collect()
+		var work float64
+		for _, req := range batch {
+			p.trace(traceStart, req)
+			if p.service == BatchSum {
+				work += req.GetServiceTime()
+			} else {
+				work = math.Max(work, req.GetServiceTime())
+			}
+		}
+		p.Wait(p.batchOverhead + work)
+		p.workTime += work
+		p.ctxTime += p.batchOverhead
+		p.batches++
+		p.batched += len(batch)
+		for _, req := range batch {
+			p.terminate(req)
+		}
+	}
+}
+
+// PSProcessor is a processor sharing processor. With a context switch cost,
+// no request progresses for ctxCost every time the processor switches to
+// another request, i.e. the one closest to completion changes
 type PSProcessor struct {
 	genericProcessor
 	workerCount int
@@ -113,11 +844,18 @@ type PSProcessor struct {
 	reqList     *list.List
 	curr        *list.Element
 	prevTime    float64
+	stallUntil  float64 // end of the current context switch
 }
 
-// NewPSProcessor returns a new *PSProcessor
+// NewPSProcessor returns a new *PSProcessor without context switch cost
 func NewPSProcessor() *PSProcessor {
-	return &PSProcessor{workerCount: 1, reqList: list.New()}
+	return NewPSProcessorCtx(0)
+}
+
+// NewPSProcessorCtx returns a new *PSProcessor with the given context switch
+// cost
+func NewPSProcessorCtx(ctxCost float64) *PSProcessor {
+	return &PSProcessor{workerCount: 1, reqList: list.New(), genericProcessor: genericProcessor{ctxCost: ctxCost}}
 }
 
 // SetWorkerCount sets the number of workers in a processor sharing processor
@@ -126,10 +864,10 @@ func (p *PSProcessor) SetWorkerCount(count int) {
 }
 
 func (p *PSProcessor) getMinService() *list.Element {
-	minS := p.reqList.Front().Value.(*Request).ServiceTime
+	minS := p.reqList.Front().Value.(engine.ReqInterface).GetServiceTime()
 	minI := p.reqList.Front()
 	for e := p.reqList.Front(); e != nil; e = e.Next() {
-		val := e.Value.(*Request).ServiceTime
+		val := e.Value.(engine.ReqInterface).GetServiceTime()
 		if val < minS {
 			minS = val
 			minI = e
@@ -146,9 +884,12 @@ func (p *PSProcessor) getFactor() float64 {
 }
 
 func (p *PSProcessor) updateServiceTimes() {
-	currTime := engine.GetTime()
-	diff := (currTime - p.prevTime) * p.getFactor()
+	currTime := p.Now()
+	// requests do not progress during a context switch
+	diff := math.Max(0, currTime-math.Max(p.prevTime, p.stallUntil)) * p.getFactor()
 	p.prevTime = currTime
+	// every request in service progressed by diff
+	p.workTime += diff * float64(p.reqList.Len())
 	for e := p.reqList.Front(); e != nil; e = e.Next() {
 		req := e.Value.(engine.ReqInterface)
 		req.SubServiceTime(diff)
@@ -165,16 +906,23 @@ func (p *PSProcessor) Run() {
 		p.updateServiceTimes()
 		if intr {
 			req := p.curr.Value.(engine.ReqInterface)
-			p.reqDrain.TerminateReq(req)
+			p.terminate(req)
 			p.reqList.Remove(p.curr)
 			p.count--
 		} else {
 			p.count++
 			p.reqList.PushBack(newReq)
+			p.trace(traceStart, newReq)
 		}
 		if p.count > 0 {
+			prev := p.curr
 			p.curr = p.getMinService()
-			d = p.curr.Value.(engine.ReqInterface).GetServiceTime() / p.getFactor()
+			currTime := p.Now()
+			if p.ctxCost > 0 && p.curr != prev {
+				p.stallUntil = math.Max(currTime, p.stallUntil) + p.ctxCost
+				p.ctxTime += p.ctxCost
+			}
+			d = math.Max(0, p.stallUntil-currTime) + p.curr.Value.(engine.ReqInterface).GetServiceTime()/p.getFactor()
 		} else {
 			d = -1
 		}
@@ -203,12 +951,14 @@ func (p *BoundedProcessor) Run() {
 				factor = 1
 			}
 		}
+		p.trace(traceStart, req)
 		p.Wait(factor * req.GetServiceTime())
 		len := p.GetOutQueueLen(0)
 		if len < p.bufSize {
+			p.trace(traceComplete, req)
 			p.WriteOutQueue(req)
 		} else {
-			p.reqDrain.TerminateReq(req)
+			p.terminate(req)
 		}
 	}
 }
@@ -230,7 +980,197 @@ func (p *BoundedProcessor2) Run() {
 				factor = 1
 			}
 		}
+		p.trace(traceStart, req)
 		p.Wait(factor * req.GetServiceTime())
+		p.terminate(req)
+	}
+}
+
+// delayedReq is a request held along with the time it should be released
+type delayedReq struct {
+	release float64
+	req     engine.ReqInterface
+}
+
+// delayLine holds requests until their release time
+type delayLine struct {
+	reqList *list.List
+}
+
+func newDelayLine() *delayLine {
+	return &delayLine{reqList: list.New()}
+}
+
+func (dl *delayLine) add(release float64, req engine.ReqInterface) {
+	dl.reqList.PushBack(delayedReq{release: release, req: req})
+}
+
+func (dl *delayLine) getFirst() *list.Element {
+	minI := dl.reqList.Front()
+	for e := dl.reqList.Front(); e != nil; e = e.Next() {
+		if e.Value.(delayedReq).release < minI.Value.(delayedReq).release {
+			minI = e
+		}
+	}
+	return minI
+}
+
+func (dl *delayLine) releaseFirst(fn func(engine.ReqInterface)) {
+	first := dl.getFirst()
+	dl.reqList.Remove(first)
+	fn(first.Value.(delayedReq).req)
+}
+
+// releaseDue releases all the requests whose release time has come
+func (dl *delayLine) releaseDue(currTime float64, fn func(engine.ReqInterface)) {
+	for dl.reqList.Len() > 0 && dl.getFirst().Value.(delayedReq).release <= currTime {
+		dl.releaseFirst(fn)
+	}
+}
+
+// nextTimeout returns the time till the next release or -1 if empty
+func (dl *delayLine) nextTimeout(currTime float64) float64 {
+	if dl.reqList.Len() == 0 {
+		return -1
+	}
+	return dl.getFirst().Value.(delayedReq).release - currTime
+}
+
+// InfiniteServerProcessor is an infinite server (M/G/inf) processor. Every
+// incoming request is served immediately, so its response time equals its
+// service time
+type InfiniteServerProcessor struct {
+	genericProcessor
+	inService *delayLine
+}
+
+// NewInfiniteServerProcessor returns a new *InfiniteServerProcessor
+func NewInfiniteServerProcessor() *InfiniteServerProcessor {
+	return &InfiniteServerProcessor{inService: newDelayLine()}
+}
+
+// Run is the main processor loop
+func (p *InfiniteServerProcessor) Run() {
+	var d float64
+	d = -1
+	for {
+		intr, newReq := p.WaitInterruptible(d)
+		currTime := p.Now()
+		if intr {
+			// the timer was set for the first completion
+			p.inService.releaseFirst(p.terminate)
+		} else {
+			p.inService.add(currTime+newReq.GetServiceTime(), newReq)
+			p.trace(traceStart, newReq)
+		}
+		p.inService.releaseDue(currTime, p.terminate)
+		d = p.inService.nextTimeout(currTime)
+	}
+}
+
+// PropagationDelay is a stage that delays every request by a propagation
+// delay, independently of the load, e.g. to model network latency.
+// Delayed requests are written to the first output queue or, if a request
+// drain is set, terminated
+type PropagationDelay struct {
+	genericProcessor
+	delay    randDist
+	inFlight *delayLine
+}
+
+// NewPropagationDelay returns a *PropagationDelay with a fixed delay
+func NewPropagationDelay(delay float64) *PropagationDelay {
+	return &PropagationDelay{delay: newDeterministicDistr(delay), inFlight: newDelayLine()}
+}
+
+// NewExpPropagationDelay returns a *PropagationDelay with exponentially
+// distributed delays of the given mean
+func NewExpPropagationDelay(mean float64) *PropagationDelay {
+	return &PropagationDelay{delay: newExponDistr(1 / mean), inFlight: newDelayLine()}
+}
+
+func (p *PropagationDelay) forward(req engine.ReqInterface) {
+	if p.reqDrain != nil {
 		p.reqDrain.TerminateReq(req)
+	} else {
+		p.WriteOutQueue(req)
+	}
+}
+
+// Run is the main loop of the stage
+func (p *PropagationDelay) Run() {
+	var d float64
+	d = -1
+	for {
+		intr, newReq := p.WaitInterruptible(d)
+		currTime := p.Now()
+		if intr {
+			// the timer was set for the first arrival
+			p.inFlight.releaseFirst(p.forward)
+		} else {
+			p.inFlight.add(currTime+p.delay.getRand(), newReq)
+		}
+		p.inFlight.releaseDue(currTime, p.forward)
+		d = p.inFlight.nextTimeout(currTime)
+	}
+}
+
+// SpeedSegment sets the processor speed from Start onwards. A speed of 0.5
+// means that requests take twice as long to be served
+type SpeedSegment struct {
+	Start float64
+	Speed float64
+}
+
+// ScheduledSpeedProcessor is a run to completion processor whose speed follows
+// an externally given schedule, e.g. maintenance windows at reduced capacity.
+// Before the first segment the speed is 1.0
+type ScheduledSpeedProcessor struct {
+	genericProcessor
+	schedule []SpeedSegment
+}
+
+// NewScheduledSpeedProcessor returns a new *ScheduledSpeedProcessor.
+// The segments should be sorted by start time and have positive speeds
+func NewScheduledSpeedProcessor(schedule []SpeedSegment, ctxCost float64) *ScheduledSpeedProcessor {
+	for i, s := range schedule {
+		if s.Speed <= 0 {
+			panic(fmt.Sprintf("Non positive speed in segment %v: %v", i, s.Speed))
+		}
+		if i > 0 && s.Start < schedule[i-1].Start {
+			panic(fmt.Sprintf("Speed schedule not sorted at segment %v", i))
+		}
+	}
+	return &ScheduledSpeedProcessor{schedule: schedule, genericProcessor: genericProcessor{ctxCost: ctxCost}}
+}
+
+// serviceDuration returns how long it takes to do work starting at start
+func (p *ScheduledSpeedProcessor) serviceDuration(start, work float64) float64 {
+	t := start
+	speed := 1.0
+	for i, s := range p.schedule {
+		if s.Start > t {
+			// the current speed lasts till this segment starts
+			if (s.Start-t)*speed >= work {
+				break
+			}
+			work -= (s.Start - t) * speed
+			t = s.Start
+		}
+		speed = p.schedule[i].Speed
+	}
+	return t + work/speed - start
+}
+
+// Run is the main processor loop
+func (p *ScheduledSpeedProcessor) Run() {
+	for {
+		req := p.ReadInQueue()
+		d := p.serviceDuration(p.Now()+p.ctxCost, req.GetServiceTime())
+		p.trace(traceStart, req)
+		p.Wait(d + p.ctxCost)
+		p.workTime += req.GetServiceTime()
+		p.ctxTime += p.ctxCost
+		p.terminate(req)
 	}
 }
@@ -0,0 +1,527 @@
+package blocks
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// runProcessors feeds the requests of g to the processors through a single
+// queue till duration and returns the main statistics and the capacity of
+// the processors for the nominal service rate mu
+func runProcessors(g Generator, mu, duration float64, procs ...Processor) (*AllKeeper, *CapacityKeeper) {
+	return runWired(g, mu, duration, func(q *Queue) []engine.ActorInterface {
+		for _, p := range procs {
+			p.AddInQueue(q)
+		}
+		return nil
+	}, procs...)
+}
+
+// newTestSimulation returns a simulation seeded with 1 that prints its
+// statistics nowhere
+func newTestSimulation() *engine.Simulation {
+	sim := engine.NewSimulation()
+	sim.SetRand(rand.New(rand.NewSource(1)))
+	sim.SetStatsOutput(io.Discard)
+	return sim
+}
+
+// runWired is runProcessors with the processors connected to the queue by
+// wire, which returns the extra actors to register, e.g. a scheduler
+func runWired(g Generator, mu, duration float64, wire func(q *Queue) []engine.ActorInterface, procs ...Processor) (*AllKeeper, *CapacityKeeper) {
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	capacity := NewCapacityKeeper(mu, 0, len(procs))
+	sim.InitStats(capacity)
+
+	q := NewQueue()
+	g.SetCreator(&SimpleReqCreator{})
+	g.AddOutQueue(q)
+	for _, a := range wire(q) {
+		sim.RegisterActor(a)
+	}
+	for i, p := range procs {
+		p.SetID(i)
+		p.SetReqDrain(stats)
+		capacity.AddProcessor(p)
+		sim.RegisterActor(p)
+	}
+	sim.RegisterActor(g)
+	sim.Run(duration)
+	return stats, capacity
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
+
+func TestPSProcessorCtxCapacity(t *testing.T) {
+	// every request arrives to an empty processor and pays a single context
+	// switch, so the effective rate is mu*s/(s+c)
+	s, c := 10.0, 1.0
+	_, capacity := runProcessors(NewDDGenerator(100, s), 1/s, 1e4, NewPSProcessorCtx(c))
+	if want := 1 / (s + c); !almostEqual(capacity.EffectiveMu(), want) {
+		t.Errorf("effective service rate %v, want %v", capacity.EffectiveMu(), want)
+	}
+
+	// two requests sharing the processor: a switch to the shorter one, then
+	// to the longer one once the shorter completed at 1+2*10
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 10}, {0, 20}})
+	p := NewPSProcessorCtx(c)
+	stats, capacity := runProcessors(g, 1/15.0, 100, p)
+	if stats.Count() != 2 {
+		t.Fatalf("%v requests completed, want 2", stats.Count())
+	}
+	if p.getWorkTime() != 30 || p.getCtxTime() != 2 {
+		t.Errorf("work %v and context switches %v, want 30 and 2", p.getWorkTime(), p.getCtxTime())
+	}
+	if want := 30 / 32.0 / 15; !almostEqual(capacity.EffectiveMu(), want) {
+		t.Errorf("effective service rate %v, want %v", capacity.EffectiveMu(), want)
+	}
+	if want := (21 + 32) / 2.0; !almostEqual(stats.MeanDelay(), want) {
+		t.Errorf("mean delay %v, want %v", stats.MeanDelay(), want)
+	}
+}
+
+func TestPSProcessorCtxConcurrency(t *testing.T) {
+	// 20 requests of 1 to 20 share the processor, which switches to the
+	// shortest one every time one completes
+	burst := make([]ScriptedEvent, 20)
+	for i := range burst {
+		burst[i] = ScriptedEvent{0, float64(i + 1)}
+	}
+	free, _ := runProcessors(NewScriptedGenerator(burst), 0.1, 1e4, NewPSProcessorCtx(0))
+	p := NewPSProcessorCtx(1)
+	costly, _ := runProcessors(NewScriptedGenerator(burst), 0.1, 1e4, p)
+	if free.Count() != 20 || costly.Count() != 20 {
+		t.Fatalf("%v and %v requests completed, want 20", free.Count(), costly.Count())
+	}
+	// the last completion is after all the work and every switch
+	if last := sortedDelays(costly)[19]; last != 210+p.getCtxTime() || p.getCtxTime() < 20 {
+		t.Errorf("last completion at %v after %v of context switches, want after 210 of work", last, p.getCtxTime())
+	}
+	if !(costly.MeanDelay() > free.MeanDelay()+10) {
+		t.Errorf("mean delay %v with context switches, %v without", costly.MeanDelay(), free.MeanDelay())
+	}
+}
+
+func TestBatchProcessorMeanBatch(t *testing.T) {
+	if m := NewBatchProcessor(4, 0, 0).MeanBatch(); m != 0 {
+		t.Errorf("mean batch %v without batches, want 0", m)
+	}
+
+	// 6 requests at once, then 1 alone: batches of 4, 2 and 1
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 1}, {0, 1}, {0, 1}, {0, 1}, {0, 1}, {0, 1}, {100, 1}})
+	p := NewBatchProcessor(4, 0, 0)
+	stats, _ := runProcessors(g, 1, 1e3, p)
+	if stats.Count() != 7 {
+		t.Fatalf("%v requests completed, want 7", stats.Count())
+	}
+	if p.batches != 3 || p.MeanBatch() != 7.0/3 {
+		t.Errorf("%v batches of %v requests on average, want 3 of %v", p.batches, p.MeanBatch(), 7.0/3)
+	}
+}
+
+func TestBatchingAmortizesOverhead(t *testing.T) {
+	// the same overhead of 1 per request one at a time, and per batch of up
+	// to 8 requests served one after the other
+	rtc := NewRTCProcessor(1)
+	batch := NewBatchProcessor(8, 0, 1)
+	batch.SetBatchService(BatchSum)
+	burst := make([]ScriptedEvent, 8)
+	for i := range burst {
+		burst[i] = ScriptedEvent{0, 1}
+	}
+	rtcStats, _ := runProcessors(NewScriptedGenerator(burst), 1, 1e3, rtc)
+	batchStats, _ := runProcessors(NewScriptedGenerator(burst), 1, 1e3, batch)
+	// completions at 2, 4, ..., 16 one at a time, and all at 9 in a batch
+	if rtc.getCtxTime() != 8 || rtcStats.MeanDelay() != 9 {
+		t.Errorf("one at a time: overhead %v and mean delay %v, want 8 and 9", rtc.getCtxTime(), rtcStats.MeanDelay())
+	}
+	if batch.getCtxTime() != 1 || batchStats.MeanDelay() != 9 {
+		t.Errorf("batched: overhead %v and mean delay %v, want 1 and 9", batch.getCtxTime(), batchStats.MeanDelay())
+	}
+
+	// at a load of 0.6 the overhead overloads the core one request at a
+	// time, while batches grow just enough to amortize it
+	rtc, batch = NewRTCProcessor(1), NewBatchProcessor(8, 0, 1)
+	batch.SetBatchService(BatchSum)
+	rtcStats, _ = runProcessors(NewMDRandGenerator(0.6, 1), 1, 1e4, rtc)
+	batchStats, _ = runProcessors(NewMDRandGenerator(0.6, 1), 1, 1e4, batch)
+	rtcOverhead := rtc.getCtxTime() / float64(rtcStats.Count())
+	batchOverhead := batch.getCtxTime() / float64(batchStats.Count())
+	if rtcOverhead != 1 || !(batchOverhead < 0.5) {
+		t.Errorf("overhead per request %v one at a time and %v batched", rtcOverhead, batchOverhead)
+	}
+	if !(batchStats.MeanDelay() < rtcStats.MeanDelay()/10) {
+		t.Errorf("mean delay %v batched and %v one at a time", batchStats.MeanDelay(), rtcStats.MeanDelay())
+	}
+}
+
+func TestInfiniteServerProcessor(t *testing.T) {
+	// at a load of 10 many requests are in service at once, but none waits
+	stats, _ := runProcessors(NewMMRandGenerator(10, 1), 1, 1e3, NewInfiniteServerProcessor())
+	if stats.Count() < 9000 {
+		t.Fatalf("only %v requests completed", stats.Count())
+	}
+	for i, item := range stats.items {
+		if math.Abs(item.Delay-item.ServiceTime) > 1e-9 {
+			t.Fatalf("request %v: delay %v, service time %v", i, item.Delay, item.ServiceTime)
+		}
+	}
+}
+
+func TestPropagationDelay(t *testing.T) {
+	// requests never wait for each other but all pay the propagation delay
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	g := NewScriptedGenerator([]ScriptedEvent{{10, 1}, {10, 2}, {10, 3}})
+	g.SetCreator(&SimpleReqCreator{})
+	in, q := NewQueue(), NewQueue()
+	g.AddOutQueue(in)
+	d := NewPropagationDelay(5)
+	d.AddInQueue(in)
+	d.AddOutQueue(q)
+	p := NewRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(stats)
+	sim.RegisterActor(p)
+	sim.RegisterActor(d)
+	sim.RegisterActor(g)
+	sim.Run(1e3)
+	if stats.Count() != 3 {
+		t.Fatalf("%v requests completed, want 3", stats.Count())
+	}
+	for i, item := range stats.items {
+		if !almostEqual(item.Delay, item.ServiceTime+5) {
+			t.Errorf("request %v: delay %v, want %v", i, item.Delay, item.ServiceTime+5)
+		}
+	}
+}
+
+func TestScheduledSpeedProcessor(t *testing.T) {
+	// half speed in [100, 200)
+	g := NewScriptedGenerator([]ScriptedEvent{{10, 10}, {140, 10}, {40, 20}})
+	p := NewScheduledSpeedProcessor([]SpeedSegment{{100, 0.5}, {200, 1}}, 0)
+	stats, _ := runProcessors(g, 1, 1e3, p)
+	// before the window, in the window, and across its end with 5 units
+	// of work done at half speed
+	want := []float64{10, 20, 10 + 15}
+	if stats.Count() != len(want) {
+		t.Fatalf("%v requests completed, want %v", stats.Count(), len(want))
+	}
+	for i, item := range stats.items {
+		if !almostEqual(item.Delay, want[i]) {
+			t.Errorf("request %v: delay %v, want %v", i, item.Delay, want[i])
+		}
+	}
+}
+
+func TestProcessorsRecordServedBy(t *testing.T) {
+	// round robin over a queue per core
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	g := NewMMRandGenerator(2, 1)
+	g.SetDispatch(DispatchRoundRobin)
+	g.SetCreator(&SimpleReqCreator{})
+	const cores = 4
+	for i := 0; i < cores; i++ {
+		q := NewQueue()
+		g.AddOutQueue(q)
+		p := NewRTCProcessor(0)
+		p.SetID(i)
+		p.AddInQueue(q)
+		p.SetReqDrain(stats)
+		sim.RegisterActor(p)
+	}
+	sim.RegisterActor(g)
+	sim.Run(1e3)
+
+	items := append([]RequestData(nil), stats.items...)
+	sort.Slice(items, func(i, j int) bool { return items[i].ArrivalTime < items[j].ArrivalTime })
+	served := make([]int, cores)
+	for i, item := range items {
+		if item.ServedBy != i%cores {
+			t.Fatalf("request %v served by %v, dispatched to %v", i, item.ServedBy, i%cores)
+		}
+		served[item.ServedBy]++
+	}
+	for i, n := range served {
+		if n == 0 {
+			t.Errorf("core %v served no request", i)
+		}
+	}
+}
+
+func TestTimeoutRTCProcessor(t *testing.T) {
+	sim := newTestSimulation()
+	stats, timedOut := &AllKeeper{}, &AllKeeper{}
+	sim.InitStats(stats)
+	sim.InitStats(timedOut)
+	// the first request completes, the second one is aborted 5 units into
+	// its service, and the third one times out while queued
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 5}, {0, 20}, {0, 3}})
+	g.SetCreator(&SimpleReqCreator{})
+	q := NewQueue()
+	g.AddOutQueue(q)
+	p := NewTimeoutRTCProcessor(10, 0)
+	p.AddInQueue(q)
+	p.SetReqDrain(stats)
+	p.SetTimeoutDrain(timedOut)
+	sim.RegisterActor(p)
+	sim.RegisterActor(g)
+	sim.Run(1e3)
+
+	if stats.Count() != 1 || stats.items[0].Delay != 5 {
+		t.Fatalf("completed %v, want a single request with a delay of 5", stats.items)
+	}
+	if timedOut.Count() != 2 {
+		t.Fatalf("%v requests timed out, want 2", timedOut.Count())
+	}
+	for i, item := range timedOut.items {
+		if item.Delay != 10 {
+			t.Errorf("timed out request %v: delay %v, want the timeout 10", i, item.Delay)
+		}
+	}
+	if p.getWorkTime() != 10 {
+		t.Errorf("work %v, want 10 with 5 units done on the aborted request", p.getWorkTime())
+	}
+}
+
+// sortedDelays returns the delays of the requests in stats in increasing order
+func sortedDelays(stats *AllKeeper) []float64 {
+	delays := stats.Delays()
+	sort.Float64s(delays)
+	return delays
+}
+
+func TestScalableRTCProcessorRemoved(t *testing.T) {
+	// the second core is removed at 10 in the middle of a request, whose
+	// remaining 10 units are served after the short request queued before
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 20}, {0, 20}, {0, 1}})
+	p0, p1 := NewRTCProcessor(0), NewScalableRTCProcessor(0, 0, 10)
+	stats, _ := runProcessors(g, 1, 1e3, p0, p1)
+	want := []float64{20, 21, 31}
+	delays := sortedDelays(stats)
+	if len(delays) != len(want) {
+		t.Fatalf("%v requests completed, want %v", len(delays), len(want))
+	}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Errorf("delays %v, want %v", delays, want)
+			break
+		}
+	}
+	if work := p0.getWorkTime() + p1.getWorkTime(); work != 41 {
+		t.Errorf("work %v, want all the 41 units of service", work)
+	}
+}
+
+func TestScalableRTCProcessorAdded(t *testing.T) {
+	// a second core added at 10 serves the request queued behind the first
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 20}, {0, 20}})
+	stats, _ := runProcessors(g, 1, 1e3, NewRTCProcessor(0), NewScalableRTCProcessor(0, 10, -1))
+	delays := sortedDelays(stats)
+	if len(delays) != 2 || delays[0] != 20 || delays[1] != 30 {
+		t.Errorf("delays %v, want [20 30]", delays)
+	}
+}
+
+func TestCoreLauncher(t *testing.T) {
+	// a second core added at 10 serves the request queued behind the first
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 20}, {0, 20}})
+	g.SetCreator(&SimpleReqCreator{})
+	q := NewQueue()
+	g.AddOutQueue(q)
+	p0, p1 := NewRTCProcessor(0), NewRTCProcessor(0)
+	for _, p := range []*RTCProcessor{p0, p1} {
+		p.AddInQueue(q)
+		p.SetReqDrain(stats)
+	}
+	sim.RegisterActor(p0)
+	sim.RegisterActor(NewCoreLauncher(10, p1))
+	sim.RegisterActor(g)
+	sim.Run(1e3)
+	delays := sortedDelays(stats)
+	if len(delays) != 2 || delays[0] != 20 || delays[1] != 30 {
+		t.Errorf("delays %v, want [20 30]", delays)
+	}
+}
+
+func TestQueuePrioRTCProcessor(t *testing.T) {
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	p := NewQueuePrioRTCProcessor(0)
+	p.SetReqDrain(stats)
+	// everything arrives at once, but the secondary requests only run once
+	// the primary queue is empty
+	for _, script := range [][]ScriptedEvent{
+		{{0, 10}, {0, 2}},
+		{{0, 3}, {0, 4}},
+	} {
+		g := NewScriptedGenerator(script)
+		g.SetCreator(&SimpleReqCreator{})
+		q := NewQueue()
+		g.AddOutQueue(q)
+		p.AddInQueue(q)
+		sim.RegisterActor(g)
+	}
+	sim.RegisterActor(p)
+	sim.Run(1e3)
+
+	want := []float64{10, 2, 3, 4}
+	if stats.Count() != len(want) {
+		t.Fatalf("%v requests completed, want %v", stats.Count(), len(want))
+	}
+	for i, item := range stats.items {
+		if item.ServiceTime != want[i] {
+			t.Errorf("completion %v: service time %v, want %v", i, item.ServiceTime, want[i])
+		}
+	}
+}
+
+func TestRateLimitedRTCProcessor(t *testing.T) {
+	// 4 cores could serve the offered load of 3, but share 1 token per time
+	// unit, with a burst of 5
+	const duration = 1e4
+	limiter := NewTokenBucket(1, 5)
+	procs := make([]Processor, 4)
+	for i := range procs {
+		procs[i] = NewRateLimitedRTCProcessor(limiter, 0)
+	}
+	stats, _ := runProcessors(NewMMRandGenerator(3, 1), 1, duration, procs...)
+	if n := float64(stats.Count()); n > duration+5 || n < 0.99*duration {
+		t.Errorf("%v requests completed, want at most %v", n, duration+5)
+	}
+	if limiter.throttled < stats.Count()/2 {
+		t.Errorf("only %v of %v requests waited for a token", limiter.throttled, stats.Count())
+	}
+}
+
+func TestRTCProcessorScaled(t *testing.T) {
+	// a core twice as fast as the reference one serves the requests in half
+	// their service time, which stays the reference one in the statistics
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 10}, {100, 4}})
+	stats, _ := runProcessors(g, 1, 1e3, NewRTCProcessorScaled(0, 2))
+	want := []float64{5, 2}
+	if stats.Count() != len(want) {
+		t.Fatalf("%v requests completed, want %v", stats.Count(), len(want))
+	}
+	for i, item := range stats.items {
+		if item.Delay != want[i] || item.ServiceTime != 2*want[i] {
+			t.Errorf("request %v: delay %v and service time %v, want %v and %v", i, item.Delay, item.ServiceTime, want[i], 2*want[i])
+		}
+	}
+	if s := stats.slowdownAvg(); s != 0.5 {
+		t.Errorf("mean slowdown %v, want 0.5 relative to the reference core", s)
+	}
+}
+
+// runOnQueue feeds the requests of g to the processors through q till
+// duration and returns the statistics
+func runOnQueue(g Generator, q engine.QueueInterface, duration float64, procs ...Processor) *AllKeeper {
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	g.SetCreator(&SimpleReqCreator{})
+	g.AddOutQueue(q)
+	for i, p := range procs {
+		p.SetID(i)
+		p.AddInQueue(q)
+		p.SetReqDrain(stats)
+		sim.RegisterActor(p)
+	}
+	sim.RegisterActor(g)
+	sim.Run(duration)
+	return stats
+}
+
+func TestLASProcessorSmallJobOvertakes(t *testing.T) {
+	// the small job arrives after the long one attained 10.5 units of
+	// service and runs at the next quantum boundary
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 100}, {10.5, 2}})
+	stats := runOnQueue(g, NewLASPQueue(), 1e3, NewLASProcessor(1, 0))
+	if stats.Count() != 2 {
+		t.Fatalf("%v requests completed, want 2", stats.Count())
+	}
+	small, long := stats.items[0], stats.items[1]
+	if small.ServiceTime != 2 || small.Delay != 2.5 {
+		t.Errorf("first completion: service time %v and delay %v, want 2 and 2.5", small.ServiceTime, small.Delay)
+	}
+	if long.ServiceTime != 100 || long.Delay != 102 {
+		t.Errorf("second completion: service time %v and delay %v, want 100 and 102", long.ServiceTime, long.Delay)
+	}
+}
+
+func TestPreemptiveRTCProcessor(t *testing.T) {
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	q := NewQueue()
+	p := NewPreemptiveRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(stats)
+	sim.RegisterActor(p)
+	// low priority requests at 0 and 1 and a high priority one at 5, which
+	// preempts the first low priority one
+	for _, gen := range []struct {
+		highRatio float64
+		script    []ScriptedEvent
+	}{
+		{0, []ScriptedEvent{{0, 10}, {1, 10}}},
+		{1, []ScriptedEvent{{5, 3}}},
+	} {
+		g := NewScriptedGenerator(gen.script)
+		g.SetCreator(NewPriorityReqCreator(gen.highRatio))
+		g.AddOutQueue(q)
+		sim.RegisterActor(g)
+	}
+	sim.Run(1e3)
+
+	want := []float64{3, 13, 22}
+	if stats.Count() != len(want) {
+		t.Fatalf("%v requests completed, want %v", stats.Count(), len(want))
+	}
+	for i, item := range stats.items {
+		if item.Delay != want[i] {
+			t.Errorf("completion %v: delay %v, want %v", i, item.Delay, want[i])
+		}
+	}
+	if p.Preemptions() != 1 {
+		t.Errorf("%v preemptions, want 1", p.Preemptions())
+	}
+}
+
+func TestRemoteCorePaysTransferCost(t *testing.T) {
+	// the queue is on node 0 along with the first core, and each core
+	// serves one of the requests
+	local, remote := NewRTCProcessor(0), NewRTCProcessor(0)
+	local.SetNode(0, 0.5)
+	remote.SetNode(1, 0.5)
+	g := NewScriptedGenerator([]ScriptedEvent{{0, 1}, {0, 1}})
+	stats, _ := runProcessors(g, 1, 100, local, remote)
+	if stats.Count() != 2 {
+		t.Fatalf("%v requests completed, want 2", stats.Count())
+	}
+	for _, item := range stats.items {
+		if want := []float64{1, 1.5}[item.ServedBy]; item.Delay != want {
+			t.Errorf("core %v: delay %v, want %v", item.ServedBy, item.Delay, want)
+		}
+	}
+	if local.getCtxTime() != 0 || remote.getCtxTime() != 0.5 {
+		t.Errorf("transfer time %v on the local core and %v on the remote one, want 0 and 0.5",
+			local.getCtxTime(), remote.getCtxTime())
+	}
+}
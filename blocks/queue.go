@@ -5,31 +5,62 @@ import (
 	"container/heap"
 	"container/list"
 	"fmt"
+	"sync/atomic"
 
 	//"sort"
 	"github.com/epfl-dcsl/schedsim/engine"
 )
 
-var count = 0
+// count is the number of queues created so far, used for their IDs. Queues
+// are created by concurrent simulations too, e.g. replications
+var count int64
+
+// nextQueueID returns a new unique queue ID
+func nextQueueID() int {
+	return int(atomic.AddInt64(&count, 1) - 1)
+}
+
+// nodeGetter is implemented by queues placed on a NUMA node
+type nodeGetter interface {
+	getNode() int
+}
+
+// queueNode returns the NUMA node of q, 0 if it is not placed
+func queueNode(q engine.QueueInterface) int {
+	if n, ok := q.(nodeGetter); ok {
+		return n.getNode()
+	}
+	return 0
+}
 
 // Queue is a imple FIFO queue
 type Queue struct {
-	l  *list.List
-	id int
+	l    *list.List
+	id   int
+	node int
 }
 
 // NewQueue returns a new *Queue
 func NewQueue() *Queue {
 	q := &Queue{}
 	q.l = list.New()
-	q.id = count
-	count++
+	q.id = nextQueueID()
 	return q
 }
 
+// SetNode places the queue on a NUMA node, 0 by default
+func (q *Queue) SetNode(node int) {
+	q.node = node
+}
+
+func (q *Queue) getNode() int {
+	return q.node
+}
+
 // Enqueue enqueues a new ReqInterface at the queue
 func (q *Queue) Enqueue(el engine.ReqInterface) {
 	//fmt.Printf("time: %v, queue: %v, len: %v\n", engine.GetTime(), q.id, q.Len())
+	trace(traceEnqueue, el, "queue", q.id)
 	q.l.PushBack(el)
 }
 
@@ -37,6 +68,7 @@ func (q *Queue) Enqueue(el engine.ReqInterface) {
 func (q *Queue) Dequeue() engine.ReqInterface {
 	el := q.l.Front()
 	q.l.Remove(el)
+	trace(traceDequeue, el.Value.(engine.ReqInterface), "queue", q.id)
 	return el.Value.(engine.ReqInterface)
 }
 
@@ -45,6 +77,151 @@ func (q *Queue) Len() int {
 	return q.l.Len()
 }
 
+// ShedPolicy selects which request a SheddingQueue drops
+type ShedPolicy int
+
+const (
+	// ShedNewest drops the request that just arrived (tail drop)
+	ShedNewest ShedPolicy = iota
+	// ShedBiggest drops the queued request with the largest service time
+	ShedBiggest
+)
+
+var shedPolicyNames = []string{"newest", "biggest"}
+
+// MarshalText returns the name of the policy, newest or biggest
+func (p ShedPolicy) MarshalText() ([]byte, error) {
+	if p < 0 || int(p) >= len(shedPolicyNames) {
+		return nil, fmt.Errorf("unknown shed policy: %d", int(p))
+	}
+	return []byte(shedPolicyNames[p]), nil
+}
+
+// UnmarshalText sets the policy from its name, newest or biggest
+func (p *ShedPolicy) UnmarshalText(text []byte) error {
+	for i, name := range shedPolicyNames {
+		if name == string(text) {
+			*p = ShedPolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown shed policy: %s", text)
+}
+
+// SheddingQueue is a FIFO queue that sheds a request whenever its length
+// exceeds a threshold. Dropped requests are terminated to the drop drain
+type SheddingQueue struct {
+	*Queue
+	statsOutput
+	threshold   int
+	policy      ShedPolicy
+	dropDrain   RequestDrain
+	dropped     int
+	droppedWork float64
+}
+
+// NewSheddingQueue returns a new *SheddingQueue
+func NewSheddingQueue(threshold int, policy ShedPolicy) *SheddingQueue {
+	return &SheddingQueue{Queue: NewQueue(), threshold: threshold, policy: policy}
+}
+
+// SetDropDrain sets the drain for the dropped requests
+func (q *SheddingQueue) SetDropDrain(rd RequestDrain) {
+	q.dropDrain = rd
+}
+
+// Enqueue enqueues a new ReqInterface and sheds a request if the queue is
+// over the threshold
+func (q *SheddingQueue) Enqueue(el engine.ReqInterface) {
+	q.Queue.Enqueue(el)
+	if q.Len() <= q.threshold {
+		return
+	}
+	victim := q.l.Back()
+	if q.policy == ShedBiggest {
+		for e := q.l.Front(); e != nil; e = e.Next() {
+			if e.Value.(engine.ReqInterface).GetServiceTime() > victim.Value.(engine.ReqInterface).GetServiceTime() {
+				victim = e
+			}
+		}
+	}
+	req := q.l.Remove(victim).(engine.ReqInterface)
+	trace(traceDrop, req, "queue", q.id)
+	q.dropped++
+	q.droppedWork += req.GetServiceTime()
+	if q.dropDrain != nil {
+		q.dropDrain.TerminateReq(req)
+	}
+}
+
+// Dropped returns how many requests were dropped
+func (q *SheddingQueue) Dropped() int {
+	return q.dropped
+}
+
+// PrintStats prints the dropped requests and work at the end of the
+// simulation. This is called by the model
+func (q *SheddingQueue) PrintStats() {
+	fmt.Fprintf(q.out(), "dropped:%v\tdropped_work:%v\n", q.dropped, q.droppedWork)
+}
+
+// BoundedFIFOQueue is a FIFO queue admitting at most capacity requests.
+// Arrivals to a full queue are dropped and terminated to the drop drain, if any
+type BoundedFIFOQueue struct {
+	*Queue
+	statsOutput
+	capacity  int
+	dropDrain RequestDrain
+	arrivals  int
+	dropped   int
+}
+
+// NewBoundedFIFOQueue returns a new *BoundedFIFOQueue
+func NewBoundedFIFOQueue(capacity int) *BoundedFIFOQueue {
+	if capacity < 1 {
+		panic(fmt.Sprintf("Non positive queue capacity: %v", capacity))
+	}
+	return &BoundedFIFOQueue{Queue: NewQueue(), capacity: capacity}
+}
+
+// SetDropDrain sets the drain for the dropped requests
+func (q *BoundedFIFOQueue) SetDropDrain(rd RequestDrain) {
+	q.dropDrain = rd
+}
+
+// Enqueue enqueues a new ReqInterface or drops it if the queue is full
+func (q *BoundedFIFOQueue) Enqueue(el engine.ReqInterface) {
+	q.arrivals++
+	if q.Len() < q.capacity {
+		q.Queue.Enqueue(el)
+		return
+	}
+	trace(traceDrop, el, "queue", q.id)
+	q.dropped++
+	if q.dropDrain != nil {
+		q.dropDrain.TerminateReq(el)
+	}
+}
+
+// Dropped returns how many requests were dropped
+func (q *BoundedFIFOQueue) Dropped() int {
+	return q.dropped
+}
+
+// LossRate returns the fraction of the arrivals that were dropped
+func (q *BoundedFIFOQueue) LossRate() float64 {
+	if q.arrivals == 0 {
+		return 0
+	}
+	return float64(q.dropped) / float64(q.arrivals)
+}
+
+// PrintStats prints the dropped requests at the end of the simulation.
+// This is called by the model
+func (q *BoundedFIFOQueue) PrintStats() {
+	fmt.Fprintf(q.out(), "queue_capacity:%v\tdropped:%v\tloss:%v%%\n", q.capacity, q.dropped, 100*q.LossRate())
+}
+
 // PriorityQueue
 type Comparable interface {
 	GetCmpVal() float64
@@ -52,57 +229,145 @@ type Comparable interface {
 	GetInitTime() float64
 }
 
-type pQueue []Comparable
+// priorityKey returns the value a request is ordered by in a PQueue
+type priorityKey func(Comparable) float64
+
+func cmpValKey(c Comparable) float64 {
+	return c.GetCmpVal()
+}
+
+type pQueue struct {
+	items []Comparable
+	key   priorityKey
+}
 
-func (pq pQueue) Len() int { return len(pq) }
+func (pq pQueue) Len() int { return len(pq.items) }
 
 func (pq pQueue) Less(i, j int) bool {
-	if pq[i].GetCmpVal() == pq[j].GetCmpVal() {
+	ki, kj := pq.key(pq.items[i]), pq.key(pq.items[j])
+	if ki == kj {
 		// Tie-break with arrival time (FIFO for same priority)
-		return pq[i].GetInitTime() < pq[j].GetInitTime()
+		return pq.items[i].GetInitTime() < pq.items[j].GetInitTime()
 	}
-	return pq[i].GetCmpVal() < pq[j].GetCmpVal()
+	return ki < kj
 }
 
 func (pq pQueue) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
+	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
 }
 
 func (pq *pQueue) Push(x interface{}) {
 	item := x.(Comparable)
-	*pq = append(*pq, item)
+	pq.items = append(pq.items, item)
 }
 
 func (pq *pQueue) Pop() interface{} {
-	old := *pq
+	old := pq.items
 	n := len(old)
 	item := old[n-1]
-	*pq = old[0 : n-1]
+	pq.items = old[0 : n-1]
 	return item
 }
 
+// PQueue is a priority queue dequeuing the request with the smallest key.
+// By default the key is the request GetCmpVal()
 type PQueue struct {
-	pq pQueue
+	pq   pQueue
+	id   int
+	node int
 }
 
+// NewPQueue returns a new *PQueue ordered by GetCmpVal()
 func NewPQueue() *PQueue {
-	q := &PQueue{}
-	q.pq = make(pQueue, 0)
+	return newPQueueWithKey(cmpValKey)
+}
+
+func newPQueueWithKey(key priorityKey) *PQueue {
+	q := &PQueue{id: nextQueueID()}
+	q.pq = pQueue{items: make([]Comparable, 0), key: key}
 	heap.Init(&q.pq)
 
 	return q
 }
 
+// NewAgingPQueue returns a new *PQueue ordered by GetCmpVal() reduced by
+// agingFactor for every time unit the request has been in the system, so that
+// waiting requests eventually overtake newer ones with a smaller GetCmpVal().
+// A request is dequeued before any request arriving after it has waited
+// GetCmpVal()/agingFactor. The reduction of all queued requests grows at the
+// same rate, so their arrival time is used to keep the order stable over time
+func NewAgingPQueue(agingFactor float64) *PQueue {
+	if agingFactor < 0 {
+		panic(fmt.Sprintf("Negative aging factor: %v", agingFactor))
+	}
+	return newPQueueWithKey(func(c Comparable) float64 {
+		return c.GetCmpVal() + agingFactor*c.GetInitTime()
+	})
+}
+
+// NewBlendedPQueue returns a new *PQueue ordered by the blended SRPT and EDF
+// priority alpha * remainingTime + (1-alpha) * timeToDeadline.
+// alpha = 1 is pure SRPT and alpha = 0 pure EDF. Requests should implement
+// DeadlineGetter. The time to deadline of all queued requests differs from
+// their absolute deadline by the same amount, so the absolute deadline is used
+// to keep the order stable over time
+func NewBlendedPQueue(alpha float64) *PQueue {
+	return newPQueueWithKey(func(c Comparable) float64 {
+		d, ok := c.(DeadlineGetter)
+		if !ok {
+			panic(fmt.Sprintf("Element in blended PQueue does not implement blocks.DeadlineGetter interface: %T", c))
+		}
+		return alpha*c.GetServiceTime() + (1-alpha)*d.GetDeadline()
+	})
+}
+
+// NewValuePQueue returns a new *PQueue dequeuing the request with the highest
+// value first. Requests should implement ValueGetter
+func NewValuePQueue() *PQueue {
+	return newPQueueWithKey(func(c Comparable) float64 {
+		v, ok := c.(ValueGetter)
+		if !ok {
+			panic(fmt.Sprintf("Element in value PQueue does not implement blocks.ValueGetter interface: %T", c))
+		}
+		return -v.GetValue()
+	})
+}
+
+// NewLASPQueue returns a new *PQueue dequeuing the request that received the
+// least service so far first, i.e. its original minus its remaining service
+// time. Requests should implement OriginalServiceTimeGetter
+func NewLASPQueue() *PQueue {
+	return newPQueueWithKey(func(c Comparable) float64 {
+		o, ok := c.(OriginalServiceTimeGetter)
+		if !ok {
+			panic(fmt.Sprintf("Element in LAS PQueue does not implement blocks.OriginalServiceTimeGetter interface: %T", c))
+		}
+		return o.GetOriginalServiceTime() - c.GetServiceTime()
+	})
+}
+
+// SetNode places the queue on a NUMA node, 0 by default
+func (pq *PQueue) SetNode(node int) {
+	pq.node = node
+}
+
+func (pq *PQueue) getNode() int {
+	return pq.node
+}
+
 func (pq *PQueue) Enqueue(el engine.ReqInterface) {
 	comp, ok := el.(Comparable)
 	if !ok {
 		panic(fmt.Sprintf("Element enqueued to PQueue does not implement blocks.Comparable interface: %T", el))
 	}
+	trace(traceEnqueue, el, "queue", pq.id)
 	heap.Push(&pq.pq, comp)
 }
 
 func (pq *PQueue) Dequeue() engine.ReqInterface {
-	return heap.Pop(&pq.pq).(engine.ReqInterface)
+	req := heap.Pop(&pq.pq).(engine.ReqInterface)
+	trace(traceDequeue, req, "queue", pq.id)
+	return req
 }
 
 func (pq *PQueue) Len() int {
@@ -110,7 +375,7 @@ func (pq *PQueue) Len() int {
 }
 
 func (pq *PQueue) PrintQueue() {
-	for _, v := range pq.pq {
+	for _, v := range pq.pq.items {
 		fmt.Printf("%v\t", v.GetServiceTime())
 	}
 }
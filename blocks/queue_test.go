@@ -0,0 +1,211 @@
+package blocks
+
+import (
+	"math"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// dequeueIDs dequeues all the requests of q and returns their IDs in order
+func dequeueIDs(q *PQueue) []int {
+	var ids []int
+	for q.Len() > 0 {
+		ids = append(ids, q.Dequeue().(IDGetter).GetID())
+	}
+	return ids
+}
+
+func sameIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBlendedPQueue(t *testing.T) {
+	// the shortest requests have the latest deadlines
+	reqs := []*DeadlineReq{
+		{Request{ID: 0, ServiceTime: 4}, 10},
+		{Request{ID: 1, ServiceTime: 1}, 40},
+		{Request{ID: 2, ServiceTime: 3}, 20},
+		{Request{ID: 3, ServiceTime: 2}, 30},
+	}
+	for _, tc := range []struct {
+		alpha float64
+		want  []int
+	}{
+		{1, []int{1, 3, 2, 0}}, // SRPT
+		{0, []int{0, 2, 3, 1}}, // EDF
+	} {
+		q := NewBlendedPQueue(tc.alpha)
+		for _, r := range reqs {
+			q.Enqueue(r)
+		}
+		if got := dequeueIDs(q); !sameIDs(got, tc.want) {
+			t.Errorf("alpha %v: order %v, want %v", tc.alpha, got, tc.want)
+		}
+	}
+
+	// alpha = 0 matches a PQueue ordered by the deadline itself
+	q := NewPQueue()
+	for _, r := range reqs {
+		q.Enqueue(r)
+	}
+	if got := dequeueIDs(q); !sameIDs(got, []int{0, 2, 3, 1}) {
+		t.Errorf("deadline PQueue order %v, want %v", got, []int{0, 2, 3, 1})
+	}
+}
+
+func TestValuePQueue(t *testing.T) {
+	// the highest value first, and the first arrival among equal values
+	q := NewValuePQueue()
+	for _, r := range []*ValueReq{
+		{Request{ID: 0, InitTime: 0}, 1},
+		{Request{ID: 1, InitTime: 1}, 5},
+		{Request{ID: 2, InitTime: 2}, 3},
+		{Request{ID: 3, InitTime: 3}, 5},
+		{Request{ID: 4, InitTime: 4}, 0.5},
+	} {
+		q.Enqueue(r)
+	}
+	if got, want := dequeueIDs(q), []int{1, 3, 2, 0, 4}; !sameIDs(got, want) {
+		t.Errorf("order %v, want %v", got, want)
+	}
+}
+
+// queuedServiceTimes returns the service times of the requests in q in order
+func queuedServiceTimes(q *Queue) []float64 {
+	var res []float64
+	for e := q.l.Front(); e != nil; e = e.Next() {
+		res = append(res, e.Value.(engine.ReqInterface).GetServiceTime())
+	}
+	return res
+}
+
+func TestSheddingQueuePolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy ShedPolicy
+		want   []float64
+	}{
+		{ShedNewest, []float64{5, 1}},
+		{ShedBiggest, []float64{1, 2}},
+	} {
+		q := NewSheddingQueue(2, tc.policy)
+		dropped := &AllKeeper{}
+		q.SetDropDrain(dropped)
+		sim := newTestSimulation()
+		dropped.SetSimulation(sim)
+		for _, st := range []float64{5, 1, 9, 2} {
+			q.Enqueue(&Request{ServiceTime: st, OriginalServiceTime: st, sim: sim})
+		}
+		got := queuedServiceTimes(q.Queue)
+		if len(got) != len(tc.want) || got[0] != tc.want[0] || got[1] != tc.want[1] {
+			t.Errorf("%v: queued %v, want %v", tc.policy, got, tc.want)
+		}
+		if q.Dropped() != 2 || dropped.Count() != 2 {
+			t.Errorf("%v: %v dropped and %v terminated, want 2", tc.policy, q.Dropped(), dropped.Count())
+		}
+	}
+}
+
+// smallJobDelay runs an overloaded bimodal workload through a shedding queue
+// and returns the mean delay of the short requests
+func smallJobDelay(policy ShedPolicy) float64 {
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	g := NewMBRandGenerator(0.6, 1, 10, 0.9)
+	g.SetCreator(&SimpleReqCreator{})
+	q := NewSheddingQueue(10, policy)
+	g.AddOutQueue(q)
+	p := NewRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(stats)
+	sim.RegisterActor(p)
+	sim.RegisterActor(g)
+	sim.Run(1e5)
+	var sum float64
+	var n int
+	for _, item := range stats.items {
+		if item.ServiceTime == 1 {
+			sum += item.Delay
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+func TestShedBiggestFavorsSmallJobs(t *testing.T) {
+	// shedding the long requests leaves less work ahead of the short ones
+	// than dropping the newest request
+	biggest, newest := smallJobDelay(ShedBiggest), smallJobDelay(ShedNewest)
+	if !(biggest < 0.9*newest) {
+		t.Errorf("short request mean delay %v shedding the biggest, %v shedding the newest", biggest, newest)
+	}
+}
+
+// bigJobDelay runs a big job behind a stream of tiny ones that alone
+// overload a shortest job first core, and returns the delay of the big job
+func bigJobDelay(q *PQueue) float64 {
+	script := []ScriptedEvent{{0, 1}, {0.1, 10}, {0.4, 1}}
+	for i := 0; i < 200; i++ {
+		script = append(script, ScriptedEvent{0.9, 1})
+	}
+	stats := runOnQueue(NewScriptedGenerator(script), q, 1e3, NewRTCProcessor(0))
+	for _, item := range stats.items {
+		if item.ServiceTime == 10 {
+			return item.Delay
+		}
+	}
+	return -1
+}
+
+func TestAgingPQueueBoundsStarvation(t *testing.T) {
+	// without aging the big job only runs once the tiny jobs stop arriving
+	if d := bigJobDelay(NewPQueue()); d < 150 {
+		t.Errorf("big job delay %v without aging, want it to wait for the stream to end", d)
+	}
+	// with a factor of 1 the tiny jobs arriving 9 units after the big one
+	// queue behind it, so it waits for at most 10 of them
+	if d := bigJobDelay(NewAgingPQueue(1)); d < 0 || d > 21 {
+		t.Errorf("big job delay %v with aging, want at most 21", d)
+	}
+}
+
+func TestBoundedFIFOQueueDrops(t *testing.T) {
+	sim := newTestSimulation()
+	stats, dropped := &AllKeeper{}, &AllKeeper{}
+	sim.InitStats(stats)
+	sim.InitStats(dropped)
+	g := NewMMRandGenerator(2, 1)
+	g.SetCreator(&SimpleReqCreator{})
+	q := NewBoundedFIFOQueue(2)
+	q.SetDropDrain(dropped)
+	g.AddOutQueue(q)
+	p := NewRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(stats)
+	sim.RegisterActor(p)
+	sim.RegisterActor(g)
+	sim.Run(1e5)
+
+	// every arrival is dropped, completed, queued or in service
+	inService := q.arrivals - q.Dropped() - stats.Count() - q.Len()
+	if inService != 0 && inService != 1 {
+		t.Errorf("%v arrivals, %v dropped, %v completed and %v queued", q.arrivals, q.Dropped(), stats.Count(), q.Len())
+	}
+	if dropped.Count() != q.Dropped() {
+		t.Errorf("%v dropped requests terminated, want %v", dropped.Count(), q.Dropped())
+	}
+	// M/M/1/K blocking probability with K = 3 requests in the system:
+	// (1-rho) rho^K / (1-rho^(K+1)) = 8/15 for rho = 2
+	if want := 8.0 / 15; math.Abs(q.LossRate()-want) > 0.01 {
+		t.Errorf("loss rate %v, want about %v", q.LossRate(), want)
+	}
+}
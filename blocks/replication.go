@@ -0,0 +1,183 @@
+package blocks
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// tQuantiles are the 0.975 quantiles of the Student t distribution for 1 to
+// 30 degrees of freedom, used for 95% confidence intervals
+var tQuantiles = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// tQuantile returns the 0.975 Student t quantile for df degrees of freedom,
+// approximated by the normal one beyond 30
+func tQuantile(df int) float64 {
+	if df <= len(tQuantiles) {
+		return tQuantiles[df-1]
+	}
+	return 1.96
+}
+
+// ReplicationAggregator collects the summary of the main statistics of
+// independent replications of a simulation, i.e. the mean delay, the reported
+// percentiles, the same statistics of the slowdown and the throughput, and
+// reports their mean and standard deviation over the replications with a 95%
+// confidence interval, along with the results and seed of every replication
+type ReplicationAggregator struct {
+	statsOutput
+	reportedPercentiles
+	labels  []string
+	keys    []string    // summary keys of the metrics
+	samples [][]float64 // samples[i] holds metric i of every replication
+	seeds   []int64     // seed of every replication
+}
+
+// NewReplicationAggregator returns a new *ReplicationAggregator for the
+// percentiles ps, nil for the default ones
+func NewReplicationAggregator(ps []float64) *ReplicationAggregator {
+	a := &ReplicationAggregator{}
+	a.SetPercentiles(ps)
+	labels, keys := []string{"AVG"}, []string{"mean"}
+	for _, p := range a.percentiles() {
+		labels = append(labels, percentileLabel(p))
+		keys = append(keys, percentileKey(p))
+	}
+	labels, keys = append(labels, "Slowdown_AVG"), append(keys, "slowdown_mean")
+	for _, p := range a.percentiles() {
+		labels = append(labels, "Slowdown_"+percentileLabel(p))
+		keys = append(keys, "slowdown_"+percentileKey(p))
+	}
+	labels = append(labels, "Reqs/time_unit")
+	keys = append(keys, "throughput")
+	a.labels, a.keys, a.samples = labels, keys, make([][]float64, len(labels))
+	return a
+}
+
+// Add records the summary of the statistics of a finished replication run
+// with the given seed. Percentiles are NaN if no request terminated
+func (a *ReplicationAggregator) Add(seed int64, stats *AllKeeper) {
+	var pct, spct map[float64]float64
+	if stats.Count() > 0 {
+		pct, spct = stats.getPercentiles(a.percentiles()), stats.slowdownPercentiles(a.percentiles())
+	}
+	metrics := []float64{stats.MeanDelay()}
+	for _, p := range a.percentiles() {
+		if stats.Count() == 0 {
+			metrics = append(metrics, math.NaN())
+		} else {
+			metrics = append(metrics, pct[p])
+		}
+	}
+	metrics = append(metrics, stats.slowdownAvg())
+	for _, p := range a.percentiles() {
+		if stats.Count() == 0 {
+			metrics = append(metrics, math.NaN())
+		} else {
+			metrics = append(metrics, spct[p])
+		}
+	}
+	metrics = append(metrics, float64(stats.Count())/stats.measured())
+	for i, m := range metrics {
+		a.samples[i] = append(a.samples[i], m)
+	}
+	a.seeds = append(a.seeds, seed)
+}
+
+// Seed returns the seed of replication r
+func (a *ReplicationAggregator) Seed(r int) int64 {
+	return a.seeds[r]
+}
+
+// Result returns metric i of replication r
+func (a *ReplicationAggregator) Result(r, i int) float64 {
+	return a.samples[i][r]
+}
+
+// Replications returns the number of replications added
+func (a *ReplicationAggregator) Replications() int {
+	return len(a.samples[0])
+}
+
+// Interval returns the mean of metric i over the replications and the half
+// width of its 95% confidence interval. There should be at least two
+// replications
+func (a *ReplicationAggregator) Interval(i int) (mean, halfWidth float64) {
+	mean, std := a.moments(i)
+	n := len(a.samples[i])
+	return mean, tQuantile(n-1) * std / math.Sqrt(float64(n))
+}
+
+// StdDev returns the sample standard deviation of metric i over the
+// replications. There should be at least two replications
+func (a *ReplicationAggregator) StdDev(i int) float64 {
+	_, std := a.moments(i)
+	return std
+}
+
+// moments returns the mean and the sample standard deviation of metric i
+func (a *ReplicationAggregator) moments(i int) (mean, std float64) {
+	xs := a.samples[i]
+	n := float64(len(xs))
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	mean = sum / n
+	var sq float64
+	for _, x := range xs {
+		sq += (x - mean) * (x - mean)
+	}
+	return mean, math.Sqrt(sq / (n - 1))
+}
+
+// PrintStats prints the mean, the standard deviation and the 95% confidence
+// interval of every metric over the replications, then every metric of every
+// replication along with its seed
+func (a *ReplicationAggregator) PrintStats() {
+	fmt.Fprintf(a.out(), "Stats collector: Replications\n")
+	fmt.Fprintf(a.out(), "replications:%v\n", a.Replications())
+	fmt.Fprintf(a.out(), "Metric\tMean\tStdDev\tCI95_low\tCI95_high\tHalfWidth\n")
+	for i, label := range a.labels {
+		mean, hw := a.Interval(i)
+		fmt.Fprintf(a.out(), "%v\t%v\t%v\t%v\t%v\t%v\n", label, mean, a.StdDev(i), mean-hw, mean+hw, hw)
+	}
+	fmt.Fprintf(a.out(), "Replication\tSeed\t%v\n", strings.Join(a.labels, "\t"))
+	for r, seed := range a.seeds {
+		fmt.Fprintf(a.out(), "%v\t%v", r, seed)
+		for i := range a.labels {
+			fmt.Fprintf(a.out(), "\t%v", a.Result(r, i))
+		}
+		fmt.Fprintln(a.out())
+	}
+}
+
+// Summary returns the number of replications and, for every metric under the
+// key of the AllKeeper summaries, prefixed by slowdown_ for the slowdowns,
+// its mean, standard deviation and 95% confidence interval. The results of
+// every replication are listed under results, with their seed
+func (a *ReplicationAggregator) Summary() map[string]interface{} {
+	results := make([]map[string]interface{}, len(a.seeds))
+	for r, seed := range a.seeds {
+		results[r] = map[string]interface{}{"seed": seed}
+		for i, key := range a.keys {
+			results[r][key] = summaryFloat(a.Result(r, i))
+		}
+	}
+	res := map[string]interface{}{"replications": a.Replications(), "results": results}
+	for i, key := range a.keys {
+		mean, hw := a.Interval(i)
+		res[key] = map[string]interface{}{
+			"mean":       summaryFloat(mean),
+			"stddev":     summaryFloat(a.StdDev(i)),
+			"ci95_low":   summaryFloat(mean - hw),
+			"ci95_high":  summaryFloat(mean + hw),
+			"half_width": summaryFloat(hw),
+		}
+	}
+	return res
+}
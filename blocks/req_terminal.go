@@ -1,9 +1,15 @@
 package blocks
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"math"
+	"math/rand"
+	"os"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/epfl-dcsl/schedsim/engine"
 )
@@ -13,43 +19,311 @@ const (
 	gRANULARITY = 0.01
 )
 
+// defaultPercentiles are the delay percentiles printed by the keepers unless
+// set otherwise
+var defaultPercentiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// reportedPercentiles is embedded by the keepers that print delay percentiles
+// and holds which ones, the default ones unless set
+type reportedPercentiles struct {
+	ps []float64
+}
+
+// SetPercentiles sets the percentiles printed by the keeper, e.g. 0.999 for
+// the 99.9th percentile. nil restores the default ones
+func (r *reportedPercentiles) SetPercentiles(ps []float64) {
+	if ps == nil {
+		r.ps = nil
+		return
+	}
+	if len(ps) == 0 {
+		panic("No percentiles given")
+	}
+	res := make([]float64, len(ps))
+	for i, p := range ps {
+		if p <= 0 || p >= 1 {
+			panic(fmt.Sprintf("Invalid percentile: %v", p))
+		}
+		res[i] = p
+	}
+	sort.Float64s(res)
+	r.ps = res
+}
+
+// percentiles returns the reported percentiles in increasing order
+func (r *reportedPercentiles) percentiles() []float64 {
+	if r.ps == nil {
+		return defaultPercentiles
+	}
+	return r.ps
+}
+
+// percentileLabel returns the column header of the p percentile, e.g. 99.9th
+func percentileLabel(p float64) string {
+	return strconv.FormatFloat(math.Round(p*1e8)/1e6, 'f', -1, 64) + "th"
+}
+
+// percentileKey returns the key of the p percentile in the summaries, e.g.
+// p99 for 0.99 or p99.9 for 0.999
+func percentileKey(p float64) string {
+	return "p" + strconv.FormatFloat(math.Round(p*1e8)/1e6, 'f', -1, 64)
+}
+
+// Summarizer is implemented by the keepers that summarize their statistics
+// for machine readable output, e.g. JSON
+type Summarizer interface {
+	Summary() map[string]interface{}
+}
+
+// summaryFloat returns v, or nil if it is not finite since JSON cannot
+// encode it, e.g. the mean of no requests
+func summaryFloat(v float64) interface{} {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return v
+}
+
+// histogramSummary returns the summary of the samples of hdr, with the
+// percentiles ps
+func histogramSummary(name string, hdr *histogram, measured float64, ps []float64) map[string]interface{} {
+	res := map[string]interface{}{
+		"name":       name,
+		"count":      hdr.count,
+		"throughput": summaryFloat(float64(hdr.count) / measured),
+		"mean":       summaryFloat(hdr.avg()),
+		"stddev":     summaryFloat(hdr.stddev()),
+		"overflow":   hdr.overflow,
+	}
+	percentiles := hdr.getPercentiles(ps)
+	for _, p := range ps {
+		res[percentileKey(p)] = nil
+		if v, ok := percentiles[p]; ok {
+			res[percentileKey(p)] = summaryFloat(v)
+		}
+	}
+	return res
+}
+
+// percentileHeader returns the tab separated headers of the percentiles ps
+func percentileHeader(ps []float64) string {
+	labels := make([]string, len(ps))
+	for i, p := range ps {
+		labels[i] = percentileLabel(p)
+	}
+	return strings.Join(labels, "\t")
+}
+
 // RequestDrain describes the behaviour of a the element that receives a request
 // after processor serving and is in charge of keeping the statistics
 type RequestDrain interface {
 	TerminateReq(r engine.ReqInterface)
 	SetName(name string)
+	SetOutput(w io.Writer)
+}
+
+// statsOutput is embedded by the elements that print statistics and holds
+// where they print them, os.Stdout by default
+type statsOutput struct {
+	w io.Writer
+}
+
+// SetOutput sets the io.Writer the statistics are printed to
+func (o *statsOutput) SetOutput(w io.Writer) {
+	o.w = w
+}
+
+func (o *statsOutput) out() io.Writer {
+	if o.w == nil {
+		return os.Stdout
+	}
+	return o.w
+}
+
+// simClock is embedded by the statistics that need the time of their
+// simulation, which they are given by InitStats or learn from the requests
+// they see
+type simClock struct {
+	sim *engine.Simulation
+}
+
+// SetSimulation sets the simulation whose time is read
+func (c *simClock) SetSimulation(s *engine.Simulation) {
+	c.sim = s
+}
+
+// see learns the simulation from req, if it tells
+func (c *simClock) see(req engine.ReqInterface) {
+	if s := reqSim(req); s != nil {
+		c.sim = s
+	}
+}
+
+// now returns the current time of the simulation, 0 if it is unknown
+func (c *simClock) now() float64 {
+	if c.sim == nil {
+		return 0
+	}
+	return c.sim.GetTime()
+}
+
+// draining returns whether the simulation is draining
+func (c *simClock) draining() bool {
+	return c.sim != nil && c.sim.Draining()
+}
+
+// warmupFilter is embedded by the keepers and drops the requests terminated
+// during the warmup, expressed in simulation time, in terminated requests or
+// both, so that the statistics exclude the transient with empty queues
+type warmupFilter struct {
+	simClock
+	warmupTime  float64
+	warmupCount int
+	skipped     int
+	start       float64
+	stopAfter   int
+	recorded    int
+}
+
+// SetWarmup makes the keeper ignore the requests terminated before duration
+func (w *warmupFilter) SetWarmup(duration float64) {
+	w.warmupTime = duration
+	w.start = duration
+}
+
+// SetWarmupCount makes the keeper ignore the first n terminated requests
+func (w *warmupFilter) SetWarmupCount(n int) {
+	w.warmupCount = n
 }
 
-// RequestData stores the service time and delay for a single request.
+// StopAfter makes the keeper stop the simulation once it recorded n requests
+// past the warmup. 0 never stops it
+func (w *warmupFilter) StopAfter(n int) {
+	w.stopAfter = n
+}
+
+// record returns whether req, terminated now, is past the warmup
+func (w *warmupFilter) record(req engine.ReqInterface) bool {
+	w.see(req)
+	now := w.now()
+	if now < w.warmupTime || w.skipped < w.warmupCount {
+		w.skipped++
+		if now > w.start {
+			w.start = now
+		}
+		return false
+	}
+	w.recorded++
+	if w.recorded == w.stopAfter {
+		w.sim.Stop()
+	}
+	return true
+}
+
+// measured returns how long the statistics were collected for
+func (w *warmupFilter) measured() float64 {
+	return w.now() - w.start
+}
+
+// RequestData stores the service time, delay, arrival time, serving
+// processor and tags for a single request, and whether it completed while the
+// simulation was draining, i.e. past its duration.
 type RequestData struct {
 	ServiceTime float64
 	Delay       float64
+	ArrivalTime float64
+	ServedBy    int
+	Tags        map[string]string
+	Drained     bool
+}
+
+// NotDrained is a predicate selecting the requests that completed before the
+// simulation started draining, e.g. to exclude the drain from the statistics
+func NotDrained(d RequestData) bool {
+	return !d.Drained
+}
+
+// TagEquals returns a predicate selecting the requests whose key tag is value
+func TagEquals(key, value string) func(RequestData) bool {
+	return func(d RequestData) bool {
+		v, ok := d.Tags[key]
+		return ok && v == value
+	}
 }
 
 // AllKeeper implements the RequestDrain interface and caclulates statistics
 // on all the given requests, without sampling
 type AllKeeper struct {
+	statsOutput
+	warmupFilter
+	reportedPercentiles
 	items       []RequestData // Changed to store RequestData
 	name        string
 	stolenCount int
+	noise       func(delay float64) float64
+	batches     int // of the batch means intervals, 0 for none
+}
+
+// GaussianDelayNoise returns a measurement noise function adding zero mean
+// gaussian noise with standard deviation sigma to a delay, without making it
+// negative. The noise is drawn from a source seeded from src, e.g. the one of
+// the simulation, so that its seed reproduces the statistics
+func GaussianDelayNoise(sigma float64, src *rand.Rand) func(float64) float64 {
+	rng := seededRand(src)
+	return func(delay float64) float64 {
+		return math.Max(0, delay+rng.NormFloat64()*sigma)
+	}
+}
+
+// SetBatches makes the keeper also report 95% confidence intervals of the mean
+// delay and slowdown computed by batch means over n batches of consecutive
+// completions. 0 disables them, the default
+func (k *AllKeeper) SetBatches(n int) {
+	if n < 0 || n == 1 {
+		panic(fmt.Sprintf("Invalid number of batches: %v", n))
+	}
+	k.batches = n
+}
+
+// SetDelayNoise makes the keeper perturb every recorded delay with noise, to
+// model measurement inaccuracy. Only the statistics are affected, not the
+// simulation itself
+func (k *AllKeeper) SetDelayNoise(noise func(delay float64) float64) {
+	k.noise = noise
 }
 
 // TerminateReq is the function called by the processor after finishing
 // request processing
 func (k *AllKeeper) TerminateReq(req engine.ReqInterface) {
+	if !k.record(req) {
+		return
+	}
 	delay := req.GetDelay()
+	arrival := k.now() - delay
+	if k.noise != nil {
+		delay = k.noise(delay)
+	}
 
-	// Default to remaining service time for backward compatibility
-	var serviceTime float64
-	// Check if the request has an original service time we can get.
-	if reqWithOriginalTime, ok := req.(OriginalServiceTimeGetter); ok {
-		serviceTime = reqWithOriginalTime.GetOriginalServiceTime()
-	} else {
-		// Fallback for older request types that don't track original time
-		serviceTime = req.GetServiceTime()
+	serviceTime := originalServiceTime(req)
+
+	var servedBy int
+	if r, ok := req.(ServedByGetter); ok {
+		servedBy = r.GetServedBy()
 	}
 
-	k.items = append(k.items, RequestData{ServiceTime: serviceTime, Delay: delay})
+	var tags map[string]string
+	if r, ok := req.(TagGetter); ok {
+		tags = r.GetTags()
+	}
+
+	k.items = append(k.items, RequestData{
+		ServiceTime: serviceTime,
+		Delay:       delay,
+		ArrivalTime: arrival,
+		ServedBy:    servedBy,
+		Tags:        tags,
+		Drained:     k.draining(),
+	})
 	if stealable, ok := req.(*StealableReq); ok {
 		if stealable.stolen {
 			k.stolenCount++
@@ -57,6 +331,16 @@ func (k *AllKeeper) TerminateReq(req engine.ReqInterface) {
 	}
 }
 
+// originalServiceTime returns the service time req was created with
+func originalServiceTime(req engine.ReqInterface) float64 {
+	// Check if the request has an original service time we can get.
+	if reqWithOriginalTime, ok := req.(OriginalServiceTimeGetter); ok {
+		return reqWithOriginalTime.GetOriginalServiceTime()
+	}
+	// Fallback for older request types that don't track original time
+	return req.GetServiceTime()
+}
+
 // SetName gives a name to the particular AllKeeper
 func (k *AllKeeper) SetName(name string) {
 	k.name = name
@@ -70,33 +354,198 @@ func (k *AllKeeper) avg() float64 {
 	return tmp / float64(len(k.items))
 }
 
+// stddev returns the standard deviation of n samples given their sum and sum
+// of squares, as sqrt(E[x^2] - E[x]^2). Rounding can make the variance
+// slightly negative, so it is clamped to 0
+func stddev(sum, sumSquare float64, n float64) float64 {
+	mean := sum / n
+	variance := sumSquare/n - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+	return math.Sqrt(variance)
+}
+
 func (k *AllKeeper) std() float64 {
-	tmp := 0.0
+	var sum, sumSquare float64
 	for _, item := range k.items {
-		tmp += item.Delay * item.Delay // Operate on Delay
+		sum += item.Delay // Operate on Delay
+		sumSquare += item.Delay * item.Delay
 	}
-	return math.Sqrt((tmp/float64(len(k.items)) - k.avg()))
+	return stddev(sum, sumSquare, float64(len(k.items)))
 }
 
-func (k *AllKeeper) getPercentiles() map[float64]float64 {
-	res := make(map[float64]float64)
-	// Create a temporary slice of delays to sort for percentiles
+// Filter returns a new *AllKeeper holding only the requests matching pred,
+// e.g. TagEquals("tenant", "A"), so that all its statistics are computed on
+// that subset
+func (k *AllKeeper) Filter(pred func(RequestData) bool) *AllKeeper {
+	res := &AllKeeper{name: k.name, statsOutput: k.statsOutput, warmupFilter: k.warmupFilter,
+		reportedPercentiles: k.reportedPercentiles, batches: k.batches}
+	for _, item := range k.items {
+		if pred(item) {
+			res.items = append(res.items, item)
+		}
+	}
+	return res
+}
+
+// DrainedCount returns the number of requests that completed while the
+// simulation was draining
+func (k *AllKeeper) DrainedCount() int {
+	n := 0
+	for _, item := range k.items {
+		if item.Drained {
+			n++
+		}
+	}
+	return n
+}
+
+// Drained returns the statistics of the requests that completed while the
+// simulation was draining only, the complement of Filter(NotDrained)
+func (k *AllKeeper) Drained() *AllKeeper {
+	return k.Filter(func(d RequestData) bool { return d.Drained })
+}
+
+// MeanDelay returns the mean delay of the terminated requests
+func (k *AllKeeper) MeanDelay() float64 {
+	return k.avg()
+}
+
+// MeanWait returns the mean time requests spent waiting, i.e. their delay
+// minus their service time
+func (k *AllKeeper) MeanWait() float64 {
+	var sum float64
+	for _, item := range k.items {
+		sum += item.Delay - item.ServiceTime
+	}
+	return sum / float64(len(k.items))
+}
+
+// WaitFraction returns the fraction of the requests that waited before their
+// service, up to the rounding of the clock at their completion
+func (k *AllKeeper) WaitFraction() float64 {
+	waited := 0
+	for _, item := range k.items {
+		if item.Delay-item.ServiceTime > 1e-12*math.Max(1, item.ArrivalTime+item.Delay) {
+			waited++
+		}
+	}
+	return float64(waited) / float64(len(k.items))
+}
+
+// ArrivalDecileMeans returns the mean delay of the requests grouped by the
+// tenth of the measured part of the run they arrived in. A rising trend
+// indicates that the system is not stationary or the warmup is not long enough.
+// The mean of a decile without requests is NaN
+func (k *AllKeeper) ArrivalDecileMeans() []float64 {
+	var sums, counts [10]float64
+	for _, item := range k.items {
+		d := int((item.ArrivalTime - k.start) / k.measured() * 10)
+		if d > 9 {
+			d = 9
+		} else if d < 0 {
+			// arrived during the warmup
+			d = 0
+		}
+		sums[d] += item.Delay
+		counts[d]++
+	}
+	res := make([]float64, 10)
+	for i := range res {
+		res[i] = sums[i] / counts[i]
+	}
+	return res
+}
+
+// BatchMeans splits the terminated requests into the given number of batches
+// of consecutive completions and returns the mean delay and slowdown with the
+// half width of their 95% confidence intervals, computed from the batch means.
+// Batches should be long enough for their means to be roughly independent.
+// The results are NaN with fewer requests than batches
+func (k *AllKeeper) BatchMeans(batches int) (delay, delayHalfWidth, slowdown, slowdownHalfWidth float64) {
 	delays := make([]float64, len(k.items))
+	slowdowns := make([]float64, len(k.items))
 	for i, item := range k.items {
 		delays[i] = item.Delay
+		slowdowns[i] = item.Delay / item.ServiceTime
+	}
+	delay, delayHalfWidth = batchInterval(delays, batches)
+	slowdown, slowdownHalfWidth = batchInterval(slowdowns, batches)
+	return
+}
+
+// batchInterval returns the mean of xs and the half width of its 95%
+// confidence interval computed from the means of the given number of batches
+// of consecutive samples
+func batchInterval(xs []float64, batches int) (mean, halfWidth float64) {
+	if len(xs) < batches {
+		return math.NaN(), math.NaN()
 	}
+	means := make([]float64, batches)
+	for b := range means {
+		batch := xs[b*len(xs)/batches : (b+1)*len(xs)/batches]
+		for _, x := range batch {
+			means[b] += x
+		}
+		means[b] /= float64(len(batch))
+		mean += means[b]
+	}
+	mean /= float64(batches)
+	var sq float64
+	for _, m := range means {
+		sq += (m - mean) * (m - mean)
+	}
+	std := math.Sqrt(sq / float64(batches-1))
+	return mean, tQuantile(batches-1) * std / math.Sqrt(float64(batches))
+}
+
+// percentile returns the p percentile of an already sorted slice
+func percentile(sorted []float64, p float64) float64 {
+	idx := int(float64(len(sorted)) * p)
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	} // Handle edge case for 99th percentile if few items
+	return sorted[idx]
+}
+
+func (k *AllKeeper) sortedDelays() []float64 {
+	// Create a temporary slice of delays to sort for percentiles
+	delays := k.Delays()
 	sort.Float64s(delays)
+	return delays
+}
 
-	for _, v := range []float64{0.5, 0.9, 0.95, 0.99} {
-		idx := int(float64(len(delays)) * v)
-		if idx >= len(delays) {
-			idx = len(delays) - 1
-		} // Handle edge case for 99th percentile if few items
-		res[v] = delays[idx]
+// getPercentiles returns the delay percentiles ps
+func (k *AllKeeper) getPercentiles(ps []float64) map[float64]float64 {
+	res := make(map[float64]float64)
+	delays := k.sortedDelays()
+	for _, v := range ps {
+		res[v] = percentile(delays, v)
 	}
 	return res
 }
 
+// Delays returns the delays of the terminated requests in termination order
+func (k *AllKeeper) Delays() []float64 {
+	delays := make([]float64, len(k.items))
+	for i, item := range k.items {
+		delays[i] = item.Delay
+	}
+	return delays
+}
+
+// Count returns the number of terminated requests
+func (k *AllKeeper) Count() int {
+	return len(k.items)
+}
+
+// Percentile returns the p (e.g. 0.99) delay percentile.
+// There should be at least one terminated request
+func (k *AllKeeper) Percentile(p float64) float64 {
+	return percentile(k.sortedDelays(), p)
+}
+
 func (k *AllKeeper) slowdownAvg() float64 {
 	var sum float64
 	for _, item := range k.items {
@@ -106,16 +555,17 @@ func (k *AllKeeper) slowdownAvg() float64 {
 }
 
 func (k *AllKeeper) slowdownStd() float64 {
-	avg := k.slowdownAvg()
-	var sumSq float64
+	var sum, sumSquare float64
 	for _, item := range k.items {
 		d := item.Delay / item.ServiceTime
-		sumSq += (d - avg) * (d - avg)
+		sum += d
+		sumSquare += d * d
 	}
-	return math.Sqrt(sumSq / float64(len(k.items)))
+	return stddev(sum, sumSquare, float64(len(k.items)))
 }
 
-func (k *AllKeeper) slowdownPercentiles() map[float64]float64 {
+// slowdownPercentiles returns the slowdown percentiles ps
+func (k *AllKeeper) slowdownPercentiles(ps []float64) map[float64]float64 {
 	// collect all slowdowns
 	slows := make([]float64, len(k.items))
 	for i, item := range k.items {
@@ -124,12 +574,51 @@ func (k *AllKeeper) slowdownPercentiles() map[float64]float64 {
 	sort.Float64s(slows)
 
 	res := make(map[float64]float64)
-	for _, p := range []float64{0.5, 0.9, 0.95, 0.99} {
-		idx := int(float64(len(slows)) * p)
-		if idx >= len(slows) {
-			idx = len(slows) - 1
+	for _, p := range ps {
+		res[p] = percentile(slows, p)
+	}
+	return res
+}
+
+// Summary returns the count, throughput, mean, standard deviation and
+// percentiles of the delays, the stolen requests and the same statistics of
+// the slowdowns. Percentiles are nil if no request terminated
+func (k *AllKeeper) Summary() map[string]interface{} {
+	res := map[string]interface{}{
+		"name":         k.name,
+		"count":        len(k.items),
+		"stolen":       k.stolenCount,
+		"drained":      k.DrainedCount(),
+		"drained_mean": summaryFloat(k.Drained().avg()),
+		"throughput":   summaryFloat(float64(len(k.items)) / k.measured()),
+		"mean":         summaryFloat(k.avg()),
+		"stddev":       summaryFloat(k.std()),
+	}
+	slowdown := map[string]interface{}{
+		"mean":   summaryFloat(k.slowdownAvg()),
+		"stddev": summaryFloat(k.slowdownStd()),
+	}
+	var pct, spct map[float64]float64
+	if len(k.items) > 0 {
+		pct, spct = k.getPercentiles(k.percentiles()), k.slowdownPercentiles(k.percentiles())
+	}
+	for _, p := range k.percentiles() {
+		key := percentileKey(p)
+		res[key], slowdown[key] = nil, nil
+		if len(k.items) > 0 {
+			res[key], slowdown[key] = summaryFloat(pct[p]), summaryFloat(spct[p])
+		}
+	}
+	res["slowdown"] = slowdown
+	if k.batches > 0 {
+		d, dhw, sd, sdhw := k.BatchMeans(k.batches)
+		res["batch_means"] = map[string]interface{}{
+			"batches":             k.batches,
+			"mean":                summaryFloat(d),
+			"half_width":          summaryFloat(dhw),
+			"slowdown_mean":       summaryFloat(sd),
+			"slowdown_half_width": summaryFloat(sdhw),
 		}
-		res[p] = slows[idx]
 	}
 	return res
 }
@@ -137,47 +626,249 @@ func (k *AllKeeper) slowdownPercentiles() map[float64]float64 {
 // PrintStats prints the collected statistics at the end of the similation.
 // This is called by the model
 func (k *AllKeeper) PrintStats() {
-	fmt.Printf("Stats collector: %v\n", k.name)
+	fmt.Fprintf(k.out(), "Stats collector: %v\n", k.name)
+	k.printSummary()
+	if n := k.DrainedCount(); n > 0 {
+		fmt.Fprintf(k.out(), "drained:%v\tdrained_mean:%v\n", n, k.Drained().avg())
+	}
+	k.PrintDetailedLatencyVsServiceTime()
+}
+
+// printSummary prints the delay, slowdown and arrival decile rows, or a
+// single line if no request terminated
+func (k *AllKeeper) printSummary() {
+	if len(k.items) == 0 {
+		fmt.Fprintln(k.out(), "no requests")
+		return
+	}
+
 	// header for delay
-	fmt.Printf("Count\tStolen\tAVG\tSTDDev\t50th\t90th\t95th\t99th\tReqs/time_unit\n")
+	fmt.Fprintf(k.out(), "Count\tStolen\tAVG\tSTDDev\t%v\tReqs/time_unit\n", percentileHeader(k.percentiles()))
 
 	// delay row
-	fmt.Printf("%d\t%d\t%v\t%v\t",
+	fmt.Fprintf(k.out(), "%d\t%d\t%v\t%v\t",
 		len(k.items), k.stolenCount, k.avg(), k.std(),
 	)
-	if len(k.items) > 0 {
-		pct := k.getPercentiles()
-		for _, p := range []float64{0.5, 0.9, 0.95, 0.99} {
-			fmt.Printf("%v\t", pct[p])
-		}
+	pct := k.getPercentiles(k.percentiles())
+	for _, p := range k.percentiles() {
+		fmt.Fprintf(k.out(), "%v\t", pct[p])
 	}
-	fmt.Printf("%v\n", float64(len(k.items))/engine.GetTime())
+	fmt.Fprintf(k.out(), "%v\n", float64(len(k.items))/k.measured())
 
 	// slowdown header & row
-	fmt.Printf("Slowdown\t\t%v\t%v\t", k.slowdownAvg(), k.slowdownStd())
-	if len(k.items) > 0 {
-		spct := k.slowdownPercentiles()
-		for _, p := range []float64{0.5, 0.9, 0.95, 0.99} {
-			fmt.Printf("%v\t", spct[p])
+	fmt.Fprintf(k.out(), "Slowdown\t\t%v\t%v\t", k.slowdownAvg(), k.slowdownStd())
+	spct := k.slowdownPercentiles(k.percentiles())
+	for _, p := range k.percentiles() {
+		fmt.Fprintf(k.out(), "%v\t", spct[p])
+	}
+	fmt.Fprintln(k.out()) // end slowdown row
+
+	// mean delay per arrival decile row, - for the deciles without requests
+	fmt.Fprintf(k.out(), "ArrivalDeciles")
+	for _, m := range k.ArrivalDecileMeans() {
+		if math.IsNaN(m) {
+			fmt.Fprintf(k.out(), "\t-")
+		} else {
+			fmt.Fprintf(k.out(), "\t%v", m)
 		}
 	}
-	fmt.Println() // end slowdown row
+	fmt.Fprintln(k.out())
 
-	k.PrintDetailedLatencyVsServiceTime()
+	if k.batches > 0 {
+		d, dhw, sd, sdhw := k.BatchMeans(k.batches)
+		fmt.Fprintf(k.out(), "batches:%v\tbatch_mean:%v\tbatch_half_width:%v\tbatch_slowdown_mean:%v\tbatch_slowdown_half_width:%v\n",
+			k.batches, d, dhw, sd, sdhw)
+	}
 }
 
-// PrintDetailedLatencyVsServiceTime prints each request's service time and delay.
+// PrintDetailedLatencyVsServiceTime prints each request's service time, delay
+// and the processor that served it.
 func (k *AllKeeper) PrintDetailedLatencyVsServiceTime() {
-	fmt.Println("---DETAILED_LATENCY_VS_SERVICE_TIME_DATA_START---")
-	fmt.Println("ServiceTime,Delay") // CSV header
+	fmt.Fprintln(k.out(), "---DETAILED_LATENCY_VS_SERVICE_TIME_DATA_START---")
+	fmt.Fprintln(k.out(), "ServiceTime,Delay,ServedBy") // CSV header
 	for _, item := range k.items {
-		fmt.Printf("%v,%v\n", item.ServiceTime, item.Delay)
+		fmt.Fprintf(k.out(), "%v,%v,%v\n", item.ServiceTime, item.Delay, item.ServedBy)
+	}
+	fmt.Fprintln(k.out(), "---DETAILED_LATENCY_VS_SERVICE_TIME_DATA_END---")
+}
+
+// ClassKeeper implements the RequestDrain interface and keeps separate
+// statistics per request class, i.e. the color of a ColoredReq, the priority
+// of a PriorityReq or the integer ClassTag tag, as well as combined ones. Other requests are in class 0
+type ClassKeeper struct {
+	statsOutput
+	classes     map[int]*AllKeeper
+	all         *AllKeeper
+	warmup      float64
+	percentiles []float64
+	batches     int
+	name        string
+}
+
+// NewClassKeeper returns a new *ClassKeeper
+func NewClassKeeper() *ClassKeeper {
+	return &ClassKeeper{classes: make(map[int]*AllKeeper), all: &AllKeeper{}}
+}
+
+// TerminateReq is the function called by the processor after finishing
+// request processing
+func (k *ClassKeeper) TerminateReq(req engine.ReqInterface) {
+	class := 0
+	if colorReq, ok := req.(*ColoredReq); ok {
+		class = colorReq.color
+	} else if prioReq, ok := req.(*PriorityReq); ok {
+		class = prioReq.Priority
+	} else if r, ok := req.(TagGetter); ok {
+		if c, err := strconv.Atoi(r.GetTags()[ClassTag]); err == nil {
+			class = c
+		}
+	}
+	if _, ok := k.classes[class]; !ok {
+		k.classes[class] = &AllKeeper{}
+		k.classes[class].SetWarmup(k.warmup)
+		k.classes[class].SetPercentiles(k.percentiles)
+		k.classes[class].SetBatches(k.batches)
+	}
+	k.classes[class].TerminateReq(req)
+	k.all.TerminateReq(req)
+}
+
+// SetName gives a name to the particular ClassKeeper
+func (k *ClassKeeper) SetName(name string) {
+	k.name = name
+	k.all.SetName(name)
+}
+
+// SetWarmup makes all the class statistics ignore the requests terminated
+// before duration
+func (k *ClassKeeper) SetWarmup(duration float64) {
+	k.warmup = duration
+	k.all.SetWarmup(duration)
+}
+
+// SetPercentiles sets the percentiles printed for every class, nil for the
+// default ones
+func (k *ClassKeeper) SetPercentiles(ps []float64) {
+	k.all.SetPercentiles(ps)
+	k.percentiles = ps
+	for _, c := range k.classes {
+		c.SetPercentiles(k.percentiles)
+	}
+}
+
+// SetBatches makes the statistics of every class also report batch means
+// intervals over n batches, see AllKeeper.SetBatches
+func (k *ClassKeeper) SetBatches(n int) {
+	k.all.SetBatches(n)
+	k.batches = n
+	for _, c := range k.classes {
+		c.SetBatches(n)
+	}
+}
+
+// Class returns the statistics of the given class, nil if no request of the
+// class terminated
+func (k *ClassKeeper) Class(class int) *AllKeeper {
+	return k.classes[class]
+}
+
+// All returns the combined statistics of all the classes
+func (k *ClassKeeper) All() *AllKeeper {
+	return k.all
+}
+
+// PrintStats prints the statistics of every class followed by the combined
+// ones. This is called by the model
+func (k *ClassKeeper) PrintStats() {
+	classes := make([]int, 0, len(k.classes))
+	for c := range k.classes {
+		classes = append(classes, c)
+	}
+	sort.Ints(classes)
+	for _, c := range classes {
+		fmt.Fprintf(k.out(), "Stats collector: %v class %v\n", k.name, c)
+		k.classes[c].SetOutput(k.out())
+		k.classes[c].printSummary()
+	}
+	k.all.SetOutput(k.out())
+	k.all.PrintStats()
+}
+
+// convergencePoint holds the percentile estimates after count samples
+type convergencePoint struct {
+	count       int
+	percentiles map[float64]float64
+}
+
+// ConvergenceKeeper records how the delay percentile estimates evolve as
+// samples accumulate, at geometrically spaced sample counts. Estimates that
+// flatten out indicate that the run was long enough
+type ConvergenceKeeper struct {
+	statsOutput
+	reportedPercentiles
+	delays []float64
+	next   int
+	factor float64
+	points []convergencePoint
+	name   string
+}
+
+// NewConvergenceKeeper returns a new *ConvergenceKeeper recording the estimates
+// after first samples and then every time the sample count grows by factor
+func NewConvergenceKeeper(first int, factor float64) *ConvergenceKeeper {
+	if first < 1 || factor <= 1 {
+		panic(fmt.Sprintf("Invalid convergence sampling: first %v, factor %v", first, factor))
+	}
+	return &ConvergenceKeeper{next: first, factor: factor}
+}
+
+func (k *ConvergenceKeeper) estimate() convergencePoint {
+	sorted := make([]float64, len(k.delays))
+	copy(sorted, k.delays)
+	sort.Float64s(sorted)
+	res := convergencePoint{count: len(sorted), percentiles: make(map[float64]float64)}
+	for _, p := range k.percentiles() {
+		res.percentiles[p] = percentile(sorted, p)
+	}
+	return res
+}
+
+// TerminateReq is the function called by the processor after finishing
+// request processing
+func (k *ConvergenceKeeper) TerminateReq(req engine.ReqInterface) {
+	k.delays = append(k.delays, req.GetDelay())
+	if len(k.delays) == k.next {
+		k.points = append(k.points, k.estimate())
+		k.next = int(math.Ceil(float64(k.next) * k.factor))
+	}
+}
+
+// SetName gives a name to the particular ConvergenceKeeper
+func (k *ConvergenceKeeper) SetName(name string) {
+	k.name = name
+}
+
+// PrintStats prints the recorded estimates and the final ones.
+// This is called by the model
+func (k *ConvergenceKeeper) PrintStats() {
+	fmt.Fprintf(k.out(), "Stats collector: %v\n", k.name)
+	fmt.Fprintf(k.out(), "Samples\t%v\n", percentileHeader(k.percentiles()))
+	points := k.points
+	if len(k.delays) > 0 && (len(points) == 0 || points[len(points)-1].count != len(k.delays)) {
+		points = append(points, k.estimate())
+	}
+	for _, pt := range points {
+		fmt.Fprintf(k.out(), "%v", pt.count)
+		for _, p := range k.percentiles() {
+			fmt.Fprintf(k.out(), "\t%v", pt.percentiles[p])
+		}
+		fmt.Fprintln(k.out())
 	}
-	fmt.Println("---DETAILED_LATENCY_VS_SERVICE_TIME_DATA_END---")
 }
 
 // MonitorKeeper keeps statistics about queue lengths
 type MonitorKeeper struct {
+	statsOutput
 	delays   []float64
 	initLen  []int
 	finalLen []int
@@ -198,9 +889,9 @@ func (k *MonitorKeeper) TerminateReq(req engine.ReqInterface) {
 // PrintStats prints the collected statistics at the end of the similation.
 // This is called by the model
 func (k *MonitorKeeper) PrintStats() {
-	fmt.Println("#Latency\tEntrace Queue\tExit Queue")
+	fmt.Fprintln(k.out(), "#Latency\tEntrace Queue\tExit Queue")
 	for idx, d := range k.delays {
-		fmt.Printf("%v\t%v\t%v\n", d, k.initLen[idx], k.finalLen[idx])
+		fmt.Fprintf(k.out(), "%v\t%v\t%v\n", d, k.initLen[idx], k.finalLen[idx])
 	}
 }
 
@@ -209,6 +900,449 @@ func (k *MonitorKeeper) SetName(name string) {
 	k.name = name
 }
 
+// littleTolerance is the relative error of Little's law attributed to
+// rounding once all requests departed
+const littleTolerance = 1e-9
+
+// OccupancyKeeper tracks the number of requests in the system, i.e. queued or
+// in service. It wraps the ReqCreator used by the generators to observe
+// arrivals and the RequestDrain used by the processors to observe departures.
+// It checks Little's law, L = lambda*W, over the whole run from the time
+// average number of requests in the system L, the arrival rate lambda and the
+// mean time W the departed requests spent in the system
+type OccupancyKeeper struct {
+	statsOutput
+	simClock
+	creator    ReqCreator
+	drain      RequestDrain
+	inSystem   int
+	lastChange float64
+	emptyTime  float64
+	area       float64 // integral of inSystem over time
+	arrivals   int
+	departures int
+	delaySum   float64 // time in the system of the departed requests
+	name       string
+}
+
+// NewOccupancyKeeper returns a new *OccupancyKeeper that creates requests
+// with creator and terminates them to drain
+func NewOccupancyKeeper(creator ReqCreator, drain RequestDrain) *OccupancyKeeper {
+	return &OccupancyKeeper{creator: creator, drain: drain}
+}
+
+func (k *OccupancyKeeper) update() {
+	now := k.now()
+	if k.inSystem == 0 {
+		k.emptyTime += now - k.lastChange
+	}
+	k.area += float64(k.inSystem) * (now - k.lastChange)
+	k.lastChange = now
+}
+
+// depart accounts for the departure of req
+func (k *OccupancyKeeper) depart(req engine.ReqInterface) {
+	k.update()
+	k.inSystem--
+	k.departures++
+	k.delaySum += req.GetDelay()
+}
+
+// NewRequest creates a new request with the wrapped creator and accounts
+// for its arrival
+func (k *OccupancyKeeper) NewRequest(sim *engine.Simulation, serviceTime float64) engine.ReqInterface {
+	k.sim = sim
+	k.update()
+	k.inSystem++
+	k.arrivals++
+	return k.creator.NewRequest(sim, serviceTime)
+}
+
+// seed seeds the wrapped creator, if it draws from its own stream
+func (k *OccupancyKeeper) seed(src *rand.Rand) {
+	seedAll(src, k.creator)
+}
+
+// TerminateReq accounts for the request departure and passes it to the
+// wrapped drain
+func (k *OccupancyKeeper) TerminateReq(req engine.ReqInterface) {
+	k.depart(req)
+	k.drain.TerminateReq(req)
+}
+
+// occupancyDrain accounts for departures to a drain other than the one
+// wrapped by the OccupancyKeeper, e.g. for dropped requests
+type occupancyDrain struct {
+	statsOutput
+	k     *OccupancyKeeper
+	drain RequestDrain
+}
+
+func (d *occupancyDrain) TerminateReq(req engine.ReqInterface) {
+	d.k.depart(req)
+	d.drain.TerminateReq(req)
+}
+
+func (d *occupancyDrain) SetName(name string) {
+	d.drain.SetName(name)
+}
+
+// DrainTo returns a RequestDrain that accounts for the request departure and
+// passes it to rd instead of the wrapped drain
+func (k *OccupancyKeeper) DrainTo(rd RequestDrain) RequestDrain {
+	return &occupancyDrain{k: k, drain: rd}
+}
+
+// SetName gives a name to the particular OccupancyKeeper
+func (k *OccupancyKeeper) SetName(name string) {
+	k.name = name
+}
+
+// EmptyFraction returns the fraction of time no request was in the system
+func (k *OccupancyKeeper) EmptyFraction() float64 {
+	k.update()
+	return k.emptyTime / k.now()
+}
+
+// InSystem returns the number of requests in the system now
+func (k *OccupancyKeeper) InSystem() int {
+	return k.inSystem
+}
+
+// MeanInSystem returns the time average number of requests in the system
+func (k *OccupancyKeeper) MeanInSystem() float64 {
+	k.update()
+	return k.area / k.now()
+}
+
+// ArrivalRate returns the measured arrival rate
+func (k *OccupancyKeeper) ArrivalRate() float64 {
+	return float64(k.arrivals) / k.now()
+}
+
+// MeanTimeInSystem returns the mean time the departed requests spent in the
+// system
+func (k *OccupancyKeeper) MeanTimeInSystem() float64 {
+	return k.delaySum / float64(k.departures)
+}
+
+// LittleError returns the relative error |L - lambda*W| / L of Little's law
+func (k *OccupancyKeeper) LittleError() float64 {
+	l := k.MeanInSystem()
+	return math.Abs(l-k.ArrivalRate()*k.MeanTimeInSystem()) / l
+}
+
+// PrintStats prints the collected statistics at the end of the similation.
+// The Little's law residual |L - lambda*W| only vanishes for long runs, as
+// the requests still in the system at the end are left out of W. Once all of
+// them departed, e.g. after draining, it should be 0 up to rounding, so a
+// larger one, which means that a block lost or double-counted requests, is
+// warned about.
+// This is called by the model
+func (k *OccupancyKeeper) PrintStats() {
+	fmt.Fprintf(k.out(), "empty_fraction:%v\n", k.EmptyFraction())
+	l, lambda, w := k.MeanInSystem(), k.ArrivalRate(), k.MeanTimeInSystem()
+	fmt.Fprintf(k.out(), "little_L:%v\tlittle_lambda:%v\tlittle_W:%v\tlittle_residual:%v\tlittle_rel_error:%v\n",
+		l, lambda, w, math.Abs(l-lambda*w), k.LittleError())
+	if k.inSystem == 0 && k.departures > 0 && k.LittleError() > littleTolerance {
+		fmt.Fprintf(k.out(), "WARNING: Little's law does not hold with all requests departed, relative error %v\n", k.LittleError())
+	}
+}
+
+// TimeoutKeeper implements the RequestDrain interface for requests aborted
+// because of a timeout and keeps track of the work wasted on them
+type TimeoutKeeper struct {
+	statsOutput
+	warmupFilter
+	count      int
+	wastedWork float64
+	name       string
+}
+
+// TerminateReq is the function called by the processor after aborting a
+// request
+func (k *TimeoutKeeper) TerminateReq(req engine.ReqInterface) {
+	if !k.record(req) {
+		return
+	}
+	k.count++
+	if r, ok := req.(OriginalServiceTimeGetter); ok {
+		k.wastedWork += r.GetOriginalServiceTime() - req.GetServiceTime()
+	}
+}
+
+// SetName gives a name to the particular TimeoutKeeper
+func (k *TimeoutKeeper) SetName(name string) {
+	k.name = name
+}
+
+// PrintStats prints the collected statistics at the end of the similation.
+// This is called by the model
+func (k *TimeoutKeeper) PrintStats() {
+	fmt.Fprintf(k.out(), "Stats collector: %v\n", k.name)
+	fmt.Fprintf(k.out(), "timed_out:%v\twasted_work:%v\n", k.count, k.wastedWork)
+}
+
+// DeadlineKeeper implements the RequestDrain interface and counts the
+// requests completed after their deadline before passing them to the next drain.
+// Requests without a deadline are passed through
+type DeadlineKeeper struct {
+	statsOutput
+	warmupFilter
+	drain  RequestDrain
+	count  int
+	missed int
+	name   string
+}
+
+// NewDeadlineKeeper returns a new *DeadlineKeeper forwarding requests to drain
+func NewDeadlineKeeper(drain RequestDrain) *DeadlineKeeper {
+	return &DeadlineKeeper{drain: drain}
+}
+
+// TerminateReq is the function called by the processor after finishing
+// request processing
+func (k *DeadlineKeeper) TerminateReq(req engine.ReqInterface) {
+	if r, ok := req.(DeadlineGetter); ok && k.record(req) {
+		k.count++
+		if k.now() > r.GetDeadline() {
+			k.missed++
+		}
+	}
+	k.drain.TerminateReq(req)
+}
+
+// SetName gives a name to the particular DeadlineKeeper
+func (k *DeadlineKeeper) SetName(name string) {
+	k.name = name
+}
+
+// MissRate returns the fraction of requests completed after their deadline
+func (k *DeadlineKeeper) MissRate() float64 {
+	if k.count == 0 {
+		return 0
+	}
+	return float64(k.missed) / float64(k.count)
+}
+
+// PrintStats prints the collected statistics at the end of the similation.
+// This is called by the model
+func (k *DeadlineKeeper) PrintStats() {
+	fmt.Fprintf(k.out(), "Stats collector: %v\n", k.name)
+	fmt.Fprintf(k.out(), "deadline_missed:%v\tdeadline_miss_rate:%v%%\n", k.missed, 100*k.MissRate())
+}
+
+// SLOKeeper implements the RequestDrain interface and counts the requests
+// completed with a delay over the SLO target before passing them to the next
+// drain. Requests shed by admission control, terminated to its ShedDrain,
+// also count as violations
+type SLOKeeper struct {
+	statsOutput
+	warmupFilter
+	drain     RequestDrain
+	slo       float64
+	completed int
+	late      int
+	shed      int
+	name      string
+}
+
+// NewSLOKeeper returns a new *SLOKeeper for the slo delay target forwarding
+// requests to drain
+func NewSLOKeeper(slo float64, drain RequestDrain) *SLOKeeper {
+	return &SLOKeeper{slo: slo, drain: drain}
+}
+
+// TerminateReq is the function called by the processor after finishing
+// request processing
+func (k *SLOKeeper) TerminateReq(req engine.ReqInterface) {
+	if k.record(req) {
+		k.completed++
+		if req.GetDelay() > k.slo {
+			k.late++
+		}
+	}
+	k.drain.TerminateReq(req)
+}
+
+// ShedDrain returns a RequestDrain that counts the shed requests as
+// violations and passes them to rd
+func (k *SLOKeeper) ShedDrain(rd RequestDrain) RequestDrain {
+	return &sloShedDrain{k: k, drain: rd}
+}
+
+// SetName gives a name to the particular SLOKeeper
+func (k *SLOKeeper) SetName(name string) {
+	k.name = name
+}
+
+// ViolationRate returns the fraction of the requests, completed or shed,
+// that did not meet the SLO
+func (k *SLOKeeper) ViolationRate() float64 {
+	if k.completed+k.shed == 0 {
+		return 0
+	}
+	return float64(k.late+k.shed) / float64(k.completed+k.shed)
+}
+
+// AdmittedViolationRate returns the fraction of the completed requests that
+// did not meet the SLO
+func (k *SLOKeeper) AdmittedViolationRate() float64 {
+	if k.completed == 0 {
+		return 0
+	}
+	return float64(k.late) / float64(k.completed)
+}
+
+// PrintStats prints the collected statistics at the end of the similation.
+// This is called by the model
+func (k *SLOKeeper) PrintStats() {
+	fmt.Fprintf(k.out(), "Stats collector: %v\n", k.name)
+	fmt.Fprintf(k.out(), "slo:%v\tcompleted:%v\tlate:%v\tshed:%v\tslo_violation_rate:%v%%\tadmitted_violation_rate:%v%%\n",
+		k.slo, k.completed, k.late, k.shed, 100*k.ViolationRate(), 100*k.AdmittedViolationRate())
+}
+
+// sloShedDrain counts the requests shed by admission control for an SLOKeeper
+type sloShedDrain struct {
+	statsOutput
+	k     *SLOKeeper
+	drain RequestDrain
+}
+
+func (d *sloShedDrain) TerminateReq(req engine.ReqInterface) {
+	if d.k.record(req) {
+		d.k.shed++
+	}
+	d.drain.TerminateReq(req)
+}
+
+func (d *sloShedDrain) SetName(name string) {
+	d.drain.SetName(name)
+}
+
+// QueueDrain implements the RequestDrain interface and forwards the terminated
+// requests to a queue, e.g. to pass them through a PropagationDelay
+type QueueDrain struct {
+	statsOutput
+	q    engine.QueueInterface
+	name string
+}
+
+// NewQueueDrain returns a new *QueueDrain writing to q, which should be an
+// input queue of an actor
+func NewQueueDrain(q engine.QueueInterface) *QueueDrain {
+	return &QueueDrain{q: q}
+}
+
+// TerminateReq is the function called by the processor after finishing
+// request processing
+func (k *QueueDrain) TerminateReq(req engine.ReqInterface) {
+	k.q.Enqueue(req)
+}
+
+// SetName gives a name to the particular QueueDrain
+func (k *QueueDrain) SetName(name string) {
+	k.name = name
+}
+
+// MuxDrain implements the RequestDrain interface and passes every terminated
+// request to all its drains, e.g. to keep several statistics of the same
+// requests
+type MuxDrain struct {
+	statsOutput
+	drains []RequestDrain
+	name   string
+}
+
+// NewMuxDrain returns a new *MuxDrain forwarding to drains, in order
+func NewMuxDrain(drains ...RequestDrain) *MuxDrain {
+	return &MuxDrain{drains: drains}
+}
+
+// TerminateReq is the function called by the processor after finishing
+// request processing
+func (k *MuxDrain) TerminateReq(req engine.ReqInterface) {
+	for _, d := range k.drains {
+		d.TerminateReq(req)
+	}
+}
+
+// SetName gives a name to the particular MuxDrain
+func (k *MuxDrain) SetName(name string) {
+	k.name = name
+}
+
+// TimeSeriesKeeper implements the RequestDrain interface and buckets the
+// completions into fixed time windows, to follow the throughput and delays
+// over time, e.g. during warmup or an overload
+type TimeSeriesKeeper struct {
+	statsOutput
+	simClock
+	window  float64
+	windows [][]float64 // delays of the requests completed in every window
+	name    string
+}
+
+// NewTimeSeriesKeeper returns a new *TimeSeriesKeeper with windows of the
+// given size
+func NewTimeSeriesKeeper(window float64) *TimeSeriesKeeper {
+	if window <= 0 {
+		panic(fmt.Sprintf("Non positive time series window: %v", window))
+	}
+	return &TimeSeriesKeeper{window: window}
+}
+
+// TerminateReq is the function called by the processor after finishing
+// request processing
+func (k *TimeSeriesKeeper) TerminateReq(req engine.ReqInterface) {
+	k.see(req)
+	idx := int(k.now() / k.window)
+	for len(k.windows) <= idx {
+		k.windows = append(k.windows, nil)
+	}
+	k.windows[idx] = append(k.windows[idx], req.GetDelay())
+}
+
+// SetName gives a name to the particular TimeSeriesKeeper
+func (k *TimeSeriesKeeper) SetName(name string) {
+	k.name = name
+}
+
+// WindowCounts returns the number of requests completed in every window
+func (k *TimeSeriesKeeper) WindowCounts() []int {
+	res := make([]int, len(k.windows))
+	for i, w := range k.windows {
+		res[i] = len(w)
+	}
+	return res
+}
+
+// PrintStats prints one row per window with its start, the completed requests,
+// the throughput and the mean and 99th percentile delays.
+// This is called by the model
+func (k *TimeSeriesKeeper) PrintStats() {
+	fmt.Fprintf(k.out(), "Stats collector: %v\n", k.name)
+	fmt.Fprintf(k.out(), "WindowStart\tCount\tReqs/time_unit\tAVG\t99th\n")
+	for i, w := range k.windows {
+		avg, p99 := 0.0, 0.0
+		if len(w) > 0 {
+			sorted := make([]float64, len(w))
+			copy(sorted, w)
+			sort.Float64s(sorted)
+			sum := 0.0
+			for _, d := range sorted {
+				sum += d
+			}
+			avg, p99 = sum/float64(len(w)), percentile(sorted, 0.99)
+		}
+		fmt.Fprintf(k.out(), "%v\t%v\t%v\t%v\t%v\n", float64(i)*k.window, len(w), float64(len(w))/k.window, avg, p99)
+	}
+}
+
+// histogram counts samples in linear buckets of granularity width. Samples
+// beyond the last bucket are counted as overflow, they are included in the
+// mean and standard deviation but the percentiles falling among them are
+// only known to be out of range
 type histogram struct {
 	granularity float64
 	buckets     []int
@@ -217,23 +1351,42 @@ type histogram struct {
 	maxBucket   int
 	sum         float64
 	sumSquare   float64
+	overflow    int64
+	overflowMax float64 // largest overflow sample
 }
 
 func newHistogram() *histogram {
+	return newHistogramWithResolution(gRANULARITY, gRANULARITY*bUCKETCOUNT)
+}
+
+// newHistogramWithResolution returns a histogram of granularity wide buckets
+// covering samples up to maxValue
+func newHistogramWithResolution(granularity, maxValue float64) *histogram {
+	if granularity <= 0 || maxValue < granularity {
+		panic(fmt.Sprintf("Invalid histogram resolution: granularity %v, max value %v", granularity, maxValue))
+	}
+	bucketCount := int(math.Ceil(maxValue / granularity))
 	return &histogram{
-		granularity: gRANULARITY,
-		buckets:     make([]int, bUCKETCOUNT),
-		minBucket:   bUCKETCOUNT - 1,
+		granularity: granularity,
+		buckets:     make([]int, bucketCount),
+		minBucket:   bucketCount - 1,
 		maxBucket:   0,
 	}
 }
 
-func (hdr *histogram) addSample(s float64) {
+// addSample counts s and returns whether it is the first sample beyond the
+// range of the histogram, for its keeper to warn about it
+func (hdr *histogram) addSample(s float64) bool {
 	index := int(s / hdr.granularity)
-	if index >= bUCKETCOUNT {
-		index = bUCKETCOUNT - 1
+	if index >= len(hdr.buckets) {
+		hdr.overflow++
+		hdr.overflowMax = math.Max(hdr.overflowMax, s)
+		hdr.count++
+		hdr.sum += s
+		hdr.sumSquare += s * s
+		return hdr.overflow == 1
 	}
-	if index < 0 || index >= bUCKETCOUNT {
+	if index < 0 {
 		panic(fmt.Sprintf("Wrong index: %v\n", index))
 	}
 	hdr.buckets[index]++
@@ -246,6 +1399,14 @@ func (hdr *histogram) addSample(s float64) {
 	hdr.count++
 	hdr.sum += s
 	hdr.sumSquare += s * s
+	return false
+}
+
+// warnOverflow warns on w that the sample s is beyond the range of the
+// histogram
+func (hdr *histogram) warnOverflow(w io.Writer, s float64) {
+	fmt.Fprintf(w, "WARNING: histogram sample %v beyond its range of %v, counted as overflow\n",
+		s, hdr.granularity*float64(len(hdr.buckets)))
 }
 
 func (hdr *histogram) avg() float64 {
@@ -253,71 +1414,77 @@ func (hdr *histogram) avg() float64 {
 }
 
 func (hdr *histogram) stddev() float64 {
-	squareAvg := hdr.sumSquare / float64(hdr.count)
-	mean := hdr.avg()
-
-	return math.Sqrt(squareAvg - mean*mean)
+	return stddev(hdr.sum, hdr.sumSquare, float64(hdr.count))
 }
 
-// FIXME: I assume that in every bucket there will be max one percentile
-func (hdr *histogram) getPercentiles() map[float64]float64 {
-	accum := make([]int, bUCKETCOUNT)
+// getPercentiles returns the percentiles, in increasing order, interpolated
+// linearly within the bucket holding their rank. Percentiles falling among
+// the overflow samples are +Inf
+func (hdr *histogram) getPercentiles(percentiles []float64) map[float64]float64 {
 	res := map[float64]float64{}
-	percentiles := []float64{0.5, 0.9, 0.95, 0.99}
 	percentileI := 0
 
-	accum[hdr.minBucket] = hdr.buckets[hdr.minBucket]
-
-	// what if percentiles in the first bucket
-	for float64(accum[hdr.minBucket]) > percentiles[percentileI]*float64(hdr.count) {
-		// linear interpolation
-		res[percentiles[percentileI]] = hdr.granularity / float64(hdr.buckets[hdr.minBucket]) * (percentiles[percentileI] * float64(hdr.count))
-		percentileI++
-	}
-	if percentileI >= len(percentiles) {
-		return res
-	}
-
-	for i := hdr.minBucket + 1; i <= hdr.maxBucket; i++ {
-		accum[i] = accum[i-1] + hdr.buckets[i]
-		for float64(accum[i]) > percentiles[percentileI]*float64(hdr.count) {
-			// linear interpolation
-			down := hdr.granularity * float64(i-1)
-
-			res[percentiles[percentileI]] = down + hdr.granularity/float64(hdr.buckets[i])*(percentiles[percentileI]*float64(hdr.count)-float64(accum[i-1]))
-			percentileI++
-			if percentileI >= len(percentiles) {
-				return res
+	// samples in the buckets before the current one
+	below := 0
+	for i := hdr.minBucket; i <= hdr.maxBucket && percentileI < len(percentiles); i++ {
+		if hdr.buckets[i] == 0 {
+			continue
+		}
+		// several percentiles can fall in the same bucket
+		for percentileI < len(percentiles) {
+			rank := percentiles[percentileI] * float64(hdr.count)
+			if float64(below+hdr.buckets[i]) < rank {
+				break
 			}
+			floor := hdr.granularity * float64(i)
+			res[percentiles[percentileI]] = floor + hdr.granularity*(rank-float64(below))/float64(hdr.buckets[i])
+			percentileI++
 		}
+		below += hdr.buckets[i]
+	}
+	// the remaining percentiles are beyond the range
+	for ; percentileI < len(percentiles) && hdr.overflow > 0; percentileI++ {
+		res[percentiles[percentileI]] = math.Inf(1)
 	}
 	return res
 }
 
-func (hdr *histogram) printPercentiles() {
-	percentiles := hdr.getPercentiles()
-	vals := []float64{0.5, 0.9, 0.95, 0.99}
+func (hdr *histogram) printPercentiles(elapsed float64, vals []float64) {
+	percentiles := hdr.getPercentiles(vals)
 	for _, v := range vals {
-		fmt.Printf("%vth: %v\t", int(v*100.0), percentiles[v])
+		fmt.Printf("%v: %v\t", percentileLabel(v), percentiles[v])
 	}
 	fmt.Println()
 
-	fmt.Printf("Req/time_unit:%v\n", float64(hdr.count)/engine.GetTime())
+	fmt.Printf("Req/time_unit:%v\n", float64(hdr.count)/elapsed)
 }
 
 // BookKeeper uses buckets to keep the information
 type BookKeeper struct {
+	statsOutput
+	warmupFilter
+	reportedPercentiles
 	hdr  *histogram
 	name string
 }
 
-// NewBookKeeper returns a new *BookKeeper
+// NewBookKeeper returns a new *BookKeeper with buckets of 0.01 covering
+// delays up to 1000
 func NewBookKeeper() *BookKeeper {
 	return &BookKeeper{
 		hdr: newHistogram(),
 	}
 }
 
+// NewBookKeeperWithResolution returns a new *BookKeeper with buckets of
+// granularity covering delays up to maxValue. Longer delays are counted
+// separately as overflow
+func NewBookKeeperWithResolution(granularity, maxValue float64) *BookKeeper {
+	return &BookKeeper{
+		hdr: newHistogramWithResolution(granularity, maxValue),
+	}
+}
+
 // SetName gives a name to the particular AllKeeper
 func (b *BookKeeper) SetName(name string) {
 	b.name = name
@@ -326,21 +1493,116 @@ func (b *BookKeeper) SetName(name string) {
 // TerminateReq is the function called by the processor after finishing
 // request processing
 func (b *BookKeeper) TerminateReq(req engine.ReqInterface) {
+	if !b.record(req) {
+		return
+	}
 	d := req.GetDelay()
-	b.hdr.addSample(d)
+	if b.hdr.addSample(d) {
+		b.hdr.warnOverflow(b.out(), d)
+	}
 }
 
 // PrintStats prints the collected statistics at the end of the similation.
 // This is called by the model
 func (b *BookKeeper) PrintStats() {
-	fmt.Printf("Stats collector: %v\n", b.name)
-	fmt.Printf("Count\tAVG\tSTDDev\t50th\t90th\t95th\t99th Reqs/time_unit\n")
-	fmt.Printf("%v\t%v\t%v\t", b.hdr.count, b.hdr.avg(), b.hdr.stddev())
+	fmt.Fprintf(b.out(), "Stats collector: %v\n", b.name)
+	fmt.Fprintf(b.out(), "Count\tAVG\tSTDDev\t%v Reqs/time_unit\n", percentileHeader(b.percentiles()))
+	fmt.Fprintf(b.out(), "%v\t%v\t%v\t", b.hdr.count, b.hdr.avg(), b.hdr.stddev())
 
-	vals := []float64{0.5, 0.9, 0.95, 0.99}
-	percentiles := b.hdr.getPercentiles()
+	vals := b.percentiles()
+	percentiles := b.hdr.getPercentiles(vals)
 	for _, v := range vals {
-		fmt.Printf("%v\t", percentiles[v])
+		fmt.Fprintf(b.out(), "%v\t", percentiles[v])
+	}
+	fmt.Fprintf(b.out(), "%v\n", float64(b.hdr.count)/b.measured())
+	if b.hdr.overflow > 0 {
+		fmt.Fprintf(b.out(), "overflow:%v\toverflow_max:%v\n", b.hdr.overflow, b.hdr.overflowMax)
+	}
+}
+
+// Summary returns the count, throughput, mean, standard deviation and
+// percentiles of the delays, and the overflow count. Percentiles are nil if
+// out of range or if no request terminated
+func (b *BookKeeper) Summary() map[string]interface{} {
+	return histogramSummary(b.name, b.hdr, b.measured(), b.percentiles())
+}
+
+// WriteHdrLog writes the collected delays as an HdrHistogram log, so that they
+// can be loaded by the HdrHistogram analysis tools. Values are recorded in
+// units of the histogram granularity, which is also the integer to double
+// conversion ratio of the encoded histogram. Overflow delays are left out
+func (b *BookKeeper) WriteHdrLog(w io.Writer) error {
+	return b.hdr.writeHdrLog(w, b.now())
+}
+
+// StreamingKeeper implements the RequestDrain interface and writes every
+// completed request as a ServiceTime,Delay,ServedBy CSV line, like the
+// detailed output of AllKeeper, as it terminates. It only keeps running
+// aggregates in a histogram, so its memory does not grow with the number of
+// requests. The output is buffered and flushed when the statistics are printed
+type StreamingKeeper struct {
+	statsOutput
+	warmupFilter
+	reportedPercentiles
+	w    *bufio.Writer
+	hdr  *histogram
+	name string
+}
+
+// NewStreamingKeeper returns a new *StreamingKeeper writing the completions
+// to w, starting with the CSV header
+func NewStreamingKeeper(w io.Writer) *StreamingKeeper {
+	k := &StreamingKeeper{w: bufio.NewWriter(w), hdr: newHistogram()}
+	fmt.Fprintln(k.w, "ServiceTime,Delay,ServedBy")
+	return k
+}
+
+// SetName gives a name to the particular StreamingKeeper
+func (k *StreamingKeeper) SetName(name string) {
+	k.name = name
+}
+
+// TerminateReq is the function called by the processor after finishing
+// request processing
+func (k *StreamingKeeper) TerminateReq(req engine.ReqInterface) {
+	if !k.record(req) {
+		return
+	}
+	var servedBy int
+	if r, ok := req.(ServedByGetter); ok {
+		servedBy = r.GetServedBy()
+	}
+	delay := req.GetDelay()
+	fmt.Fprintf(k.w, "%v,%v,%v\n", originalServiceTime(req), delay, servedBy)
+	if k.hdr.addSample(delay) {
+		k.hdr.warnOverflow(k.out(), delay)
+	}
+}
+
+// Flush writes the buffered completions
+func (k *StreamingKeeper) Flush() error {
+	return k.w.Flush()
+}
+
+// Summary returns the count, throughput, mean, standard deviation and
+// percentiles of the delays, and the overflow count. Percentiles are nil if
+// out of range or if no request terminated
+func (k *StreamingKeeper) Summary() map[string]interface{} {
+	return histogramSummary(k.name, k.hdr, k.measured(), k.percentiles())
+}
+
+// PrintStats flushes the completions and prints the aggregated statistics.
+// This is called by the model
+func (k *StreamingKeeper) PrintStats() {
+	if err := k.Flush(); err != nil {
+		fmt.Fprintf(k.out(), "WARNING: failed to write the completions of %v: %v\n", k.name, err)
+	}
+	fmt.Fprintf(k.out(), "Stats collector: %v\n", k.name)
+	fmt.Fprintf(k.out(), "Count\tAVG\tSTDDev\t%v\tReqs/time_unit\n", percentileHeader(k.percentiles()))
+	fmt.Fprintf(k.out(), "%v\t%v\t%v\t", k.hdr.count, k.hdr.avg(), k.hdr.stddev())
+	percentiles := k.hdr.getPercentiles(k.percentiles())
+	for _, p := range k.percentiles() {
+		fmt.Fprintf(k.out(), "%v\t", percentiles[p])
 	}
-	fmt.Printf("%v\n", float64(b.hdr.count)/engine.GetTime())
+	fmt.Fprintf(k.out(), "%v\n", float64(k.hdr.count)/k.measured())
 }
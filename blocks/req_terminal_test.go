@@ -0,0 +1,633 @@
+package blocks
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// printed returns what k prints as statistics
+func printed(k *AllKeeper) string {
+	var buf bytes.Buffer
+	k.SetOutput(&buf)
+	k.PrintStats()
+	return buf.String()
+}
+
+func TestAllKeeperPrintsNoRequests(t *testing.T) {
+	// the only request arrives after the end of the run
+	g := NewScriptedGenerator([]ScriptedEvent{{200, 1}})
+	stats, _ := runProcessors(g, 1, 100, NewRTCProcessor(0))
+	out := printed(stats)
+	if strings.Contains(out, "NaN") || !strings.Contains(out, "\nno requests\n") {
+		t.Errorf("statistics without requests:\n%s", out)
+	}
+}
+
+func TestAllKeeperEmptyArrivalDeciles(t *testing.T) {
+	// the run ends with the last request at 96, the first one arrives in the
+	// first tenth of the run and the second one in the last tenth
+	g := NewScriptedGenerator([]ScriptedEvent{{5, 1}, {90, 1}})
+	stats, _ := runProcessors(g, 1, 1e3, NewRTCProcessor(0))
+	for i, m := range stats.ArrivalDecileMeans() {
+		if (i == 0 || i == 9) && m != 1 || i != 0 && i != 9 && !math.IsNaN(m) {
+			t.Errorf("decile %v: mean delay %v", i, m)
+		}
+	}
+	out := printed(stats)
+	if strings.Contains(out, "NaN") || !strings.Contains(out, "ArrivalDeciles\t1\t-\t-\t-\t-\t-\t-\t-\t-\t1\n") {
+		t.Errorf("statistics with two requests:\n%s", out)
+	}
+}
+
+// runOccupancy runs an M/M/1 FIFO queue at load lambda/mu till duration and
+// returns the occupancy of the system
+func runOccupancy(lambda, mu, duration float64) *OccupancyKeeper {
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	occupancy := NewOccupancyKeeper(&SimpleReqCreator{}, stats)
+	sim.InitStats(occupancy)
+	g := NewMMRandGenerator(lambda, mu)
+	g.SetCreator(occupancy)
+	q := NewQueue()
+	g.AddOutQueue(q)
+	p := NewRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(occupancy)
+	sim.RegisterActor(p)
+	sim.RegisterActor(g)
+	sim.Run(duration)
+	return occupancy
+}
+
+func TestOccupancyKeeperEmptyFraction(t *testing.T) {
+	// P0 = 1 - rho for M/M/1
+	for _, rho := range []float64{0.2, 0.5, 0.8} {
+		if p0 := runOccupancy(rho, 1, 2e5).EmptyFraction(); math.Abs(p0-(1-rho)) > 0.03 {
+			t.Errorf("load %v: empty fraction %v, want %v", rho, p0, 1-rho)
+		}
+	}
+}
+
+func TestOccupancyKeeperLittle(t *testing.T) {
+	k := runOccupancy(0.8, 1, 2e5)
+	// L = rho/(1-rho) for M/M/1, and only the requests in the system at the
+	// end are missing from W
+	if l := k.MeanInSystem(); math.Abs(l-4) > 0.4 {
+		t.Errorf("mean number in the system %v, want about 4", l)
+	}
+	if lambda := k.ArrivalRate(); math.Abs(lambda-0.8) > 0.01 {
+		t.Errorf("arrival rate %v, want about 0.8", lambda)
+	}
+	if e := k.LittleError(); e > 1e-3 {
+		t.Errorf("Little's law relative error %v, want about 0", e)
+	}
+}
+
+func TestArrivalDecileMeansOverload(t *testing.T) {
+	// at a load of 1.5 the queue keeps growing, so every decile waits longer
+	// than the one before. The requests arriving after about 2/3 of the run
+	// are still queued at its end, which leaves the last deciles empty
+	stats, _ := runProcessors(NewMMRandGenerator(1.5, 1), 1, 1e4, NewRTCProcessor(0))
+	means := stats.ArrivalDecileMeans()
+	for i := 1; i < 6; i++ {
+		if !(means[i] > means[i-1]) {
+			t.Errorf("decile %v: mean delay %v, %v in the previous one", i, means[i], means[i-1])
+		}
+	}
+	for i := 7; i < len(means); i++ {
+		if !math.IsNaN(means[i]) {
+			t.Errorf("decile %v: mean delay %v, want no completed request", i, means[i])
+		}
+	}
+}
+
+// runDrain runs the requests of g through a FIFO queue served by a single RTC
+// core till duration, with the completed requests terminated to drain
+func runDrain(g Generator, duration float64, drain interface {
+	RequestDrain
+	engine.Stats
+}) {
+	sim := newTestSimulation()
+	sim.InitStats(drain)
+	g.SetCreator(&SimpleReqCreator{})
+	q := NewQueue()
+	g.AddOutQueue(q)
+	p := NewRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(drain)
+	sim.RegisterActor(p)
+	sim.RegisterActor(g)
+	sim.Run(duration)
+}
+
+func TestConvergenceKeeperStabilizes(t *testing.T) {
+	k := NewConvergenceKeeper(100, 2)
+	runDrain(NewMMRandGenerator(0.5, 1), 4e5, k)
+	if len(k.points) < 10 {
+		t.Fatalf("only %v estimates", len(k.points))
+	}
+	for i, pt := range k.points {
+		if want := 100 << i; pt.count != want {
+			t.Errorf("estimate %v after %v samples, want %v", i, pt.count, want)
+		}
+	}
+	// the M/M/1 sojourn time is exponential with rate mu - lambda, and the
+	// estimates after 2^i * 100 samples settle on its percentiles
+	first, prev, last := k.points[0], k.points[len(k.points)-2], k.points[len(k.points)-1]
+	for _, p := range defaultPercentiles {
+		want := -math.Log(1-p) / 0.5
+		if math.Abs(last.percentiles[p]-want) > 0.05*want {
+			t.Errorf("p%v: final estimate %v, want about %v", 100*p, last.percentiles[p], want)
+		}
+		change := math.Abs(last.percentiles[p] - prev.percentiles[p])
+		if change > 0.05*want || change > math.Abs(first.percentiles[p]-want) {
+			t.Errorf("p%v: estimate moved by %v at the end, %v off at first", 100*p, change, first.percentiles[p]-want)
+		}
+	}
+}
+
+func TestDelayNoiseOnlyAffectsStats(t *testing.T) {
+	// delays of 20 on average, which the noise seldom makes negative
+	clean, noisy := &AllKeeper{}, &AllKeeper{}
+	noisy.SetDelayNoise(GaussianDelayNoise(0.5, rand.New(rand.NewSource(1))))
+	runDrain(NewMMRandGenerator(0.05, 0.1), 2e5, clean)
+	runDrain(NewMMRandGenerator(0.05, 0.1), 2e5, noisy)
+	// the same requests complete at the same times, only their recorded
+	// delays differ by the noise
+	if clean.Count() != noisy.Count() {
+		t.Fatalf("%v requests completed with noise, %v without", noisy.Count(), clean.Count())
+	}
+	var sum, sumSq float64
+	for i, item := range noisy.items {
+		if item.ServiceTime != clean.items[i].ServiceTime {
+			t.Fatalf("request %v: service time %v with noise, %v without", i, item.ServiceTime, clean.items[i].ServiceTime)
+		}
+		diff := item.Delay - clean.items[i].Delay
+		sum += diff
+		sumSq += diff * diff
+	}
+	n := float64(noisy.Count())
+	mean := sum / n
+	std := math.Sqrt(sumSq/n - mean*mean)
+	if math.Abs(mean) > 0.02 || math.Abs(std-0.5) > 0.025 {
+		t.Errorf("noise of mean %v and standard deviation %v, want 0 and 0.5", mean, std)
+	}
+}
+
+func TestFilterByTag(t *testing.T) {
+	// two tenants sharing a core
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	q := NewQueue()
+	p := NewRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(stats)
+	sim.RegisterActor(p)
+	for _, tenant := range []struct {
+		name   string
+		lambda float64
+	}{{"A", 0.1}, {"B", 0.4}} {
+		g := NewMMRandGenerator(tenant.lambda, 1)
+		g.SetCreator(TaggingReqCreator{Creator: &SimpleReqCreator{}, Key: "tenant", Value: tenant.name})
+		g.AddOutQueue(q)
+		sim.RegisterActor(g)
+	}
+	sim.Run(1e4)
+
+	a := stats.Filter(TagEquals("tenant", "A"))
+	var subset []float64
+	for _, item := range stats.items {
+		if item.Tags["tenant"] == "A" {
+			subset = append(subset, item.Delay)
+		}
+	}
+	if a.Count() != len(subset) || a.Count() == 0 || a.Count() >= stats.Count()/2 {
+		t.Fatalf("%v requests of tenant A, want %v out of %v", a.Count(), len(subset), stats.Count())
+	}
+	var sum float64
+	for i, d := range subset {
+		if a.items[i].Delay != d {
+			t.Fatalf("request %v of tenant A: delay %v, want %v", i, a.items[i].Delay, d)
+		}
+		sum += d
+	}
+	if mean := sum / float64(len(subset)); !almostEqual(a.MeanDelay(), mean) {
+		t.Errorf("tenant A mean delay %v, want %v", a.MeanDelay(), mean)
+	}
+	if n := stats.Filter(TagEquals("tenant", "C")).Count(); n != 0 {
+		t.Errorf("%v requests of a tenant without requests", n)
+	}
+	if n := stats.Filter(TagEquals("region", "A")).Count(); n != 0 {
+		t.Errorf("%v requests with an unset tag", n)
+	}
+}
+
+func TestDelayStdDev(t *testing.T) {
+	// the population standard deviation of this set is 2
+	k := &AllKeeper{}
+	hdr := newHistogram()
+	for _, d := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		k.items = append(k.items, RequestData{Delay: d})
+		hdr.addSample(d)
+	}
+	if s := k.std(); !almostEqual(s, 2) {
+		t.Errorf("AllKeeper standard deviation %v, want 2", s)
+	}
+	if s := hdr.stddev(); !almostEqual(s, 2) {
+		t.Errorf("histogram standard deviation %v, want 2", s)
+	}
+
+	// the M/M/1 sojourn time is exponential, so its standard deviation is
+	// its mean 1/(mu - lambda)
+	stats, _ := runProcessors(NewMMRandGenerator(0.5, 1), 1, 2e5, NewRTCProcessor(0))
+	if s := stats.std(); math.Abs(s-2) > 0.1 {
+		t.Errorf("M/M/1 standard deviation %v, want about 2", s)
+	}
+}
+
+func TestSetPercentilesTail(t *testing.T) {
+	var tail reportedPercentiles
+	tail.SetPercentiles([]float64{0.999, 0.99})
+	ps := tail.percentiles()
+	if h := percentileHeader(ps); h != "99th\t99.9th" {
+		t.Errorf("percentile header %q, want the sorted percentiles", h)
+	}
+	if k := percentileKey(0.999); k != "p99.9" {
+		t.Errorf("summary key %q, want p99.9", k)
+	}
+
+	// heavy tailed service times, with most of the delay in the tail
+	g := NewBoundedParetoGenerator(0.3, 1.1, 0.1, 100)
+	stats, _ := runProcessors(g, 1, 2e5, NewRTCProcessor(0))
+	all, hist := stats.getPercentiles(ps), histogramOf(stats).getPercentiles(ps)
+	if !(all[0.999] > all[0.99]) || all[0.99] <= 0 {
+		t.Errorf("99.9th percentile %v, 99th %v", all[0.999], all[0.99])
+	}
+	// the histogram agrees within its granularity
+	for _, p := range ps {
+		if math.Abs(hist[p]-all[p]) > 2*gRANULARITY {
+			t.Errorf("%v percentile %v from the histogram, %v from the delays", percentileLabel(p), hist[p], all[p])
+		}
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	// all the samples in the bucket [5, 5.01): the percentiles are spread
+	// over the bucket from its floor
+	hdr := newHistogram()
+	for i := 0; i < 100; i++ {
+		hdr.addSample(5.003)
+	}
+	for p, v := range hdr.getPercentiles(defaultPercentiles) {
+		if want := 5 + gRANULARITY*p; !almostEqual(v, want) {
+			t.Errorf("single bucket: %v percentile %v, want %v", percentileLabel(p), v, want)
+		}
+	}
+
+	// uniform samples in [0, 10) have the percentiles 10p
+	hdr = newHistogram()
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		hdr.addSample(10 * rng.Float64())
+	}
+	for p, v := range hdr.getPercentiles(defaultPercentiles) {
+		if want := 10 * p; math.Abs(v-want) > 0.05 {
+			t.Errorf("uniform: %v percentile %v, want about %v", percentileLabel(p), v, want)
+		}
+	}
+}
+
+func TestHistogramBeyondDefaultRange(t *testing.T) {
+	// delays spread evenly up to 5000, far beyond the default range of 1000
+	wide, narrow := newHistogramWithResolution(1, 1e4), newHistogram()
+	for i := 0; i < 5000; i++ {
+		wide.addSample(float64(i) + 0.5)
+		narrow.addSample(float64(i) + 0.5)
+	}
+	if wide.overflow != 0 || narrow.overflow != 4000 {
+		t.Errorf("%v and %v samples overflowed, want 0 and 4000", wide.overflow, narrow.overflow)
+	}
+	for p, v := range wide.getPercentiles(defaultPercentiles) {
+		if want := p * 5000; math.Abs(v-want) > 1 {
+			t.Errorf("%v percentile %v, want %v", percentileLabel(p), v, want)
+		}
+	}
+	// the high percentiles are among the overflow samples, instead of the
+	// top bucket of the default range
+	for p, v := range narrow.getPercentiles(defaultPercentiles) {
+		if p > 0.2 && !math.IsInf(v, 1) {
+			t.Errorf("%v percentile %v beyond the default range, want +Inf", percentileLabel(p), v)
+		}
+	}
+	if !almostEqual(narrow.avg(), 2500) || !almostEqual(wide.avg(), 2500) {
+		t.Errorf("mean %v with overflow and %v without, want 2500", narrow.avg(), wide.avg())
+	}
+}
+
+// histogramOf returns a histogram of the delays of stats
+func histogramOf(stats *AllKeeper) *histogram {
+	hdr := newHistogram()
+	for _, d := range stats.Delays() {
+		hdr.addSample(d)
+	}
+	return hdr
+}
+
+func TestWarmupExcludesEarlyRequests(t *testing.T) {
+	// completions at 1, 11, 21 and 31
+	script := []ScriptedEvent{{0, 1}, {10, 1}, {10, 1}, {10, 1}}
+	for _, tc := range []struct {
+		name  string
+		set   func(k *AllKeeper)
+		count int
+		start float64
+	}{
+		{"none", func(k *AllKeeper) {}, 4, 0},
+		{"time", func(k *AllKeeper) { k.SetWarmup(15) }, 2, 15},
+		{"count", func(k *AllKeeper) { k.SetWarmupCount(3) }, 1, 21},
+	} {
+		k := &AllKeeper{}
+		tc.set(k)
+		runDrain(NewScriptedGenerator(script), 1e3, k)
+		if k.Count() != tc.count || k.start != tc.start {
+			t.Errorf("%v warmup: %v requests measured from %v, want %v from %v", tc.name, k.Count(), k.start, tc.count, tc.start)
+		}
+		for _, item := range k.items {
+			if item.ArrivalTime+item.Delay < tc.start {
+				t.Errorf("%v warmup: request completed at %v recorded", tc.name, item.ArrivalTime+item.Delay)
+			}
+		}
+		if want := 31 - tc.start; k.measured() != want {
+			t.Errorf("%v warmup: measured for %v, want %v", tc.name, k.measured(), want)
+		}
+	}
+}
+
+func TestClassKeeperCounts(t *testing.T) {
+	sim := newTestSimulation()
+	k := NewClassKeeper()
+	rc := NewColoredReqCreator()
+	rc.seed(rand.New(rand.NewSource(1)))
+	want := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		req := rc.NewRequest(sim, 1).(*ColoredReq)
+		want[req.color]++
+		k.TerminateReq(req)
+	}
+	// the priority and the class tag select the class too
+	k.TerminateReq(&PriorityReq{Request{sim: sim}, 3})
+	tagged := &Request{sim: sim}
+	tagged.SetTag(ClassTag, "4")
+	k.TerminateReq(tagged)
+	want[3]++
+	want[4]++
+
+	if len(k.classes) != len(want) {
+		t.Errorf("%v classes, want %v", len(k.classes), len(want))
+	}
+	total := 0
+	for class, n := range want {
+		got := 0
+		if c := k.classes[class]; c != nil {
+			got = c.Count()
+		}
+		if got != n {
+			t.Errorf("class %v: %v requests, want %v", class, got, n)
+		}
+		total += n
+	}
+	if k.all.Count() != total {
+		t.Errorf("%v requests in all, want %v", k.all.Count(), total)
+	}
+	// colors are drawn uniformly
+	if math.Abs(float64(want[0]-want[1])) > 100 {
+		t.Errorf("%v and %v requests of each color", want[0], want[1])
+	}
+}
+
+// runEDF runs Poisson arrivals of unit requests with deadlines slack after
+// their arrival through an EDF queue served by a single RTC core till
+// duration and returns the deadline statistics
+func runEDF(lambda, slack, duration float64) *DeadlineKeeper {
+	sim := newTestSimulation()
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	deadlines := NewDeadlineKeeper(stats)
+	sim.InitStats(deadlines)
+	g := NewMDRandGenerator(lambda, 1)
+	g.SetCreator(&DeadlineReqCreator{Slack: slack})
+	q := NewPQueue()
+	g.AddOutQueue(q)
+	p := NewRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(deadlines)
+	sim.RegisterActor(p)
+	sim.RegisterActor(g)
+	sim.Run(duration)
+	return deadlines
+}
+
+func TestEDFDeadlineMisses(t *testing.T) {
+	// at a load of 0.3 no request waits anywhere near 10
+	light := runEDF(0.3, 10, 1e5)
+	if light.count < 20000 || light.missed != 0 {
+		t.Errorf("light load: %v of %v requests missed their deadline", light.missed, light.count)
+	}
+	// under overload the queue keeps growing and the deadlines are missed
+	overload := runEDF(1.5, 10, 1e4)
+	if overload.MissRate() < 0.5 {
+		t.Errorf("overload: miss rate %v", overload.MissRate())
+	}
+}
+
+func TestTimeSeriesKeeperWindows(t *testing.T) {
+	// completions at 0.5, ..., 9.5, then 12.5, 15.5, 18.5, 21.5 and 40.5
+	script := []ScriptedEvent{{0, 0.5}}
+	for i := 0; i < 9; i++ {
+		script = append(script, ScriptedEvent{1, 0.5})
+	}
+	script = append(script, ScriptedEvent{3, 0.5}, ScriptedEvent{3, 0.5}, ScriptedEvent{3, 0.5},
+		ScriptedEvent{3, 0.5}, ScriptedEvent{19, 0.5})
+	k := NewTimeSeriesKeeper(10)
+	runDrain(NewScriptedGenerator(script), 1e3, k)
+
+	want := []int{10, 3, 1, 0, 1}
+	got := k.WindowCounts()
+	if len(got) != len(want) {
+		t.Fatalf("window counts %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("window counts %v, want %v", got, want)
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	k.SetOutput(&buf)
+	k.PrintStats()
+	out := buf.String()
+	for _, row := range []string{"\n0\t10\t1\t0.5\t0.5\n", "\n10\t3\t0.3\t0.5\t0.5\n", "\n30\t0\t0\t0\t0\n"} {
+		if !strings.Contains(out, row) {
+			t.Errorf("row %q missing from:\n%s", row, out)
+		}
+	}
+}
+
+// lineCounter is an io.Writer counting the lines written to it
+type lineCounter int
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	*c += lineCounter(bytes.Count(p, []byte{'\n'}))
+	return len(p), nil
+}
+
+func TestStreamingKeeperBoundedMemory(t *testing.T) {
+	const n = 1000000
+	var lines lineCounter
+	k := NewStreamingKeeper(&lines)
+	sim := newTestSimulation()
+	k.SetSimulation(sim)
+	k.SetOutput(io.Discard)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	// delays spread evenly between 0 and 10
+	for i := 0; i < n; i++ {
+		k.TerminateReq(&Request{InitTime: -float64(i%1000) / 100, ServiceTime: 1, OriginalServiceTime: 1, sim: sim})
+	}
+	k.PrintStats()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	// a header and a line per completion
+	if lines != n+1 {
+		t.Errorf("%v lines streamed, want %v", lines, n+1)
+	}
+	if k.hdr.count != n || !almostEqual(k.hdr.avg(), 4.995) {
+		t.Errorf("%v completions of mean delay %v, want %v of mean 4.995", k.hdr.count, k.hdr.avg(), n)
+	}
+	// keeping the completions would take tens of MB
+	if grown := int64(after.HeapAlloc) - int64(before.HeapAlloc); grown > 1<<20 {
+		t.Errorf("heap grew by %v bytes for %v completions", grown, n)
+	}
+}
+
+// Every keeper warns once on its own output about the delays beyond the range
+// of its histogram
+func TestHistogramOverflowWarnsOnce(t *testing.T) {
+	sim := newTestSimulation()
+	for _, k := range []RequestDrain{NewBookKeeperWithResolution(1, 10), NewStreamingKeeper(io.Discard)} {
+		var out bytes.Buffer
+		k.SetOutput(&out)
+		// delays of 5, then 2000 and 3000 beyond the range
+		for _, d := range []float64{5, 2000, 3000, 5} {
+			k.TerminateReq(&Request{InitTime: -d, ServiceTime: 1, OriginalServiceTime: 1, sim: sim})
+		}
+		if n := strings.Count(out.String(), "WARNING: histogram sample"); n != 1 {
+			t.Errorf("%T: %v warnings, want 1:\n%s", k, n, out.String())
+		}
+		if !strings.Contains(out.String(), "sample 2000 ") {
+			t.Errorf("%T: warning not about the first overflow sample:\n%s", k, out.String())
+		}
+	}
+}
+
+// runSLO runs an overloaded M/M/1 queue with an SLO of 10, with or without
+// shedding the requests that cannot meet it anymore
+func runSLO(shed bool) *SLOKeeper {
+	sim := newTestSimulation()
+	stats, dropped := &AllKeeper{}, &AllKeeper{}
+	sim.InitStats(stats)
+	sim.InitStats(dropped)
+	k := NewSLOKeeper(10, stats)
+	sim.InitStats(k)
+	g := NewMMRandGenerator(1.2, 1)
+	g.SetCreator(&SimpleReqCreator{})
+	q := NewQueue()
+	g.AddOutQueue(q)
+	p := NewRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(k)
+	if shed {
+		p.SetAdmission(10, k.ShedDrain(dropped))
+	}
+	sim.RegisterActor(p)
+	sim.RegisterActor(g)
+	sim.Run(2e4)
+	return k
+}
+
+func TestSLOSheddingAdmittedRequests(t *testing.T) {
+	// the queue keeps growing, so almost every request is late
+	all, shedding := runSLO(false), runSLO(true)
+	if r := all.AdmittedViolationRate(); r < 0.9 {
+		t.Errorf("violation rate %v without shedding, want almost all requests late", r)
+	}
+	if all.shed != 0 {
+		t.Errorf("%v requests shed without shedding", all.shed)
+	}
+	// the admitted requests are known to finish in time, and the core no
+	// longer serves requests that are already late
+	if r := shedding.AdmittedViolationRate(); r != 0 {
+		t.Errorf("violation rate of the admitted requests %v with shedding, want 0", r)
+	}
+	if shedding.shed == 0 || shedding.completed-shedding.late <= all.completed-all.late {
+		t.Errorf("%v requests in time and %v shed with shedding, %v in time without",
+			shedding.completed-shedding.late, shedding.shed, all.completed-all.late)
+	}
+}
+
+// runDraining runs an M/M/1 queue at a load of 0.2 till 1e4, along with a
+// long request arriving just before the end, with or without draining the
+// requests in flight at the end
+func runDraining(drain bool) (*AllKeeper, *OccupancyKeeper) {
+	sim := newTestSimulation()
+	sim.SetDrain(drain)
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	occupancy := NewOccupancyKeeper(&SimpleReqCreator{}, stats)
+	sim.InitStats(occupancy)
+	q := NewQueue()
+	for _, g := range []Generator{NewMMRandGenerator(0.2, 1), NewScriptedGenerator([]ScriptedEvent{{9999, 50}})} {
+		g.SetCreator(occupancy)
+		g.AddOutQueue(q)
+		sim.RegisterActor(g)
+	}
+	p := NewRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(occupancy)
+	sim.RegisterActor(p)
+	sim.Run(1e4)
+	return stats, occupancy
+}
+
+func TestDrainCompletesRequestsInFlight(t *testing.T) {
+	stats, occupancy := runDraining(false)
+	if stats.Count() >= occupancy.arrivals || stats.DrainedCount() != 0 {
+		t.Errorf("without draining: %v of %v requests completed, %v while draining",
+			stats.Count(), occupancy.arrivals, stats.DrainedCount())
+	}
+
+	// every generated request completes, the last ones while draining
+	stats, occupancy = runDraining(true)
+	if stats.Count() != occupancy.arrivals || occupancy.InSystem() != 0 {
+		t.Errorf("with draining: %v of %v requests completed, %v left", stats.Count(), occupancy.arrivals, occupancy.InSystem())
+	}
+	if n := stats.DrainedCount(); n == 0 || stats.Drained().items[n-1].ServiceTime != 50 {
+		t.Errorf("%v requests completed while draining, want the long one among them", n)
+	}
+	if e := occupancy.LittleError(); e > littleTolerance {
+		t.Errorf("Little's law relative error %v once all requests departed", e)
+	}
+}
@@ -1,6 +1,7 @@
 package blocks
 
 import (
+	"math"
 	"math/rand"
 
 	"github.com/epfl-dcsl/schedsim/engine"
@@ -11,17 +12,88 @@ type OriginalServiceTimeGetter interface {
 	GetOriginalServiceTime() float64
 }
 
+// ServedByGetter is an interface for requests that track the processor that
+// served them.
+type ServedByGetter interface {
+	GetServedBy() int
+}
+
+type servedBySetter interface {
+	setServedBy(id int)
+}
+
+// ClassTag is the tag holding the class of a request, e.g. the workload it
+// belongs to
+const ClassTag = "class"
+
+// KeyTag is the tag holding the key a request references, e.g. in a
+// key-value store
+const KeyTag = "key"
+
+type tagSetter interface {
+	SetTag(key, value string)
+}
+
+// TagGetter is an interface for requests that carry string tags, e.g. the
+// tenant that sent them.
+type TagGetter interface {
+	GetTags() map[string]string
+}
+
+// IDGetter is an interface for requests that have a unique ID.
+type IDGetter interface {
+	GetID() int
+}
+
+// simRequest is implemented by the requests that know the simulation they
+// were created in, e.g. to tell their delay
+type simRequest interface {
+	simulation() *engine.Simulation
+}
+
+// reqSim returns the simulation req was created in, nil if it does not know
+func reqSim(req engine.ReqInterface) *engine.Simulation {
+	if r, ok := req.(simRequest); ok {
+		return r.simulation()
+	}
+	return nil
+}
+
+// newRequest returns a Request created now in sim, with an ID unique in sim,
+// and traces and records its arrival
+func newRequest(sim *engine.Simulation, serviceTime float64) Request {
+	r := Request{ID: sim.NewID(), InitTime: sim.GetTime(), ServiceTime: serviceTime, OriginalServiceTime: serviceTime, sim: sim}
+	trace(traceArrival, &r, "generator", -1)
+	sim.RecordArrival(r.InitTime, serviceTime)
+	return r
+}
+
 // Request is the basic request type
 type Request struct {
+	ID                  int
 	InitTime            float64
 	ServiceTime         float64
 	OriginalServiceTime float64
+	ServedBy            int
+	Tags                map[string]string
+	Retries             int
+	AttemptTime         float64
+	sim                 *engine.Simulation
+}
+
+// GetID returns the request unique ID
+func (r *Request) GetID() int {
+	return r.ID
 }
 
 // GetDelay returns the request latency from the time it was sent till the time
 // processing was over
 func (r Request) GetDelay() float64 {
-	return engine.GetTime() - r.InitTime
+	return r.sim.GetTime() - r.InitTime
+}
+
+func (r *Request) simulation() *engine.Simulation {
+	return r.sim
 }
 
 // GetServiceTime returns the request service time
@@ -50,6 +122,55 @@ func (r *Request) GetOriginalServiceTime() float64 {
 	return r.OriginalServiceTime
 }
 
+// GetServedBy returns the id of the processor that served the request
+func (r *Request) GetServedBy() int {
+	return r.ServedBy
+}
+
+func (r *Request) setServedBy(id int) {
+	r.ServedBy = id
+}
+
+// GetTags returns the request tags
+func (r *Request) GetTags() map[string]string {
+	return r.Tags
+}
+
+// SetTag sets the key tag of the request to value
+func (r *Request) SetTag(key, value string) {
+	if r.Tags == nil {
+		r.Tags = make(map[string]string)
+	}
+	r.Tags[key] = value
+}
+
+// retrier is implemented by requests that can be resubmitted after failing
+type retrier interface {
+	getRetries() int
+	retry(at float64)
+	getAttemptDelay() float64
+}
+
+func (r *Request) getRetries() int {
+	return r.Retries
+}
+
+// retry restores the request service time for a new attempt submitted at
+func (r *Request) retry(at float64) {
+	r.ServiceTime = r.OriginalServiceTime
+	r.Retries++
+	r.AttemptTime = at
+}
+
+// getAttemptDelay returns the time since the current attempt was submitted,
+// which is the request delay if it was never retried
+func (r *Request) getAttemptDelay() float64 {
+	if r.Retries == 0 {
+		return r.GetDelay()
+	}
+	return r.sim.GetTime() - r.AttemptTime
+}
+
 // StealableReq is a request that can be stolen and is used to account for steals
 type StealableReq struct {
 	Request
@@ -71,42 +192,280 @@ func (r MonitorReq) getFinalLen() int {
 	return r.finalLength
 }
 
+// DeadlineGetter is an interface for requests that have a deadline.
+type DeadlineGetter interface {
+	GetDeadline() float64
+}
+
+// DeadlineReq is a request with an absolute deadline
+type DeadlineReq struct {
+	Request
+	Deadline float64
+}
+
+// GetDeadline returns the request absolute deadline
+func (r *DeadlineReq) GetDeadline() float64 {
+	return r.Deadline
+}
+
+// GetCmpVal returns the request absolute deadline, so that a PQueue serves
+// requests earliest deadline first
+func (r *DeadlineReq) GetCmpVal() float64 {
+	return r.Deadline
+}
+
+// DAGReq is a job made of a DAG of tasks. A task becomes eligible only when
+// all its predecessors have completed. Its service time is the length of the
+// critical path, i.e. its delay if it did not wait for any core
+type DAGReq struct {
+	Request
+	tasks   []*dagTaskReq
+	pending int // tasks not completed yet
+}
+
+// dagTaskReq is a single task of a DAGReq as it goes through the queues
+type dagTaskReq struct {
+	Request
+	job          *DAGReq
+	succs        []int
+	pendingPreds int
+}
+
+// ValueGetter is an interface for requests that carry a value, e.g. a
+// priority or weight.
+type ValueGetter interface {
+	GetValue() float64
+}
+
+// ValueReq is a request with a value
+type ValueReq struct {
+	Request
+	Value float64
+}
+
+// GetValue returns the request value
+func (r *ValueReq) GetValue() float64 {
+	return r.Value
+}
+
 type ColoredReq struct {
 	Request
 	color int
 }
 
-// ReqCreator is a used by generators to create the appropriate type of requests
+// PriorityReq is a request with a priority level, 0 being the highest
+type PriorityReq struct {
+	Request
+	Priority int
+}
+
+// GetCmpVal returns the request priority level, so that a PQueue serves the
+// highest priority requests first
+func (r *PriorityReq) GetCmpVal() float64 {
+	return float64(r.Priority)
+}
+
+// WidthGetter is an interface for requests that need several cores at once.
+type WidthGetter interface {
+	GetWidth() int
+}
+
+// GangReq is a request that needs Width cores at once for its whole service
+// time, e.g. a parallel job
+type GangReq struct {
+	Request
+	Width int
+}
+
+// GetWidth returns the number of cores the request needs
+func (r *GangReq) GetWidth() int {
+	return r.Width
+}
+
+// ReqCreator is a used by generators to create the appropriate type of
+// requests, in the simulation of the generator
 type ReqCreator interface {
-	NewRequest(serviceTime float64) engine.ReqInterface
+	NewRequest(sim *engine.Simulation, serviceTime float64) engine.ReqInterface
 }
 
 // SimpleReqCreator creates structs of type Request
 type SimpleReqCreator struct{}
 
 // NewRequest returns a new Request struct
-func (rc SimpleReqCreator) NewRequest(serviceTime float64) engine.ReqInterface {
-	return &Request{InitTime: engine.GetTime(), ServiceTime: serviceTime, OriginalServiceTime: serviceTime}
+func (rc SimpleReqCreator) NewRequest(sim *engine.Simulation, serviceTime float64) engine.ReqInterface {
+	r := newRequest(sim, serviceTime)
+	return &r
 }
 
 // StealableReqCreator creates structs of type StealableReq
 type StealableReqCreator struct{}
 
 // NewRequest returns a new StealableReq struct
-func (rc StealableReqCreator) NewRequest(serviceTime float64) engine.ReqInterface {
-	return &StealableReq{Request{InitTime: engine.GetTime(), ServiceTime: serviceTime, OriginalServiceTime: serviceTime}, false}
+func (rc StealableReqCreator) NewRequest(sim *engine.Simulation, serviceTime float64) engine.ReqInterface {
+	return &StealableReq{newRequest(sim, serviceTime), false}
 }
 
 // MonitorReqCreator creates structs of type MonitorReq
 type MonitorReqCreator struct{}
 
 // NewRequest returns a new MonitorReq struct
-func (rc MonitorReqCreator) NewRequest(serviceTime float64) engine.ReqInterface {
-	return &MonitorReq{Request{InitTime: engine.GetTime(), ServiceTime: serviceTime, OriginalServiceTime: serviceTime}, 0, 0}
+func (rc MonitorReqCreator) NewRequest(sim *engine.Simulation, serviceTime float64) engine.ReqInterface {
+	return &MonitorReq{newRequest(sim, serviceTime), 0, 0}
+}
+
+// DeadlineReqCreator creates structs of type DeadlineReq whose deadline is
+// the creation time plus Slack
+type DeadlineReqCreator struct {
+	Slack float64
+}
+
+// NewRequest returns a new DeadlineReq struct
+func (rc DeadlineReqCreator) NewRequest(sim *engine.Simulation, serviceTime float64) engine.ReqInterface {
+	r := newRequest(sim, serviceTime)
+	return &DeadlineReq{r, r.InitTime + rc.Slack}
+}
+
+// ValueReqCreator creates structs of type ValueReq. Values are drawn from an
+// exponential distribution of mean MeanValue and scaled by
+// serviceTime^Correlation, so a positive Correlation makes large requests more
+// valuable, a negative one less valuable and 0 keeps values independent.
+// Values are drawn from Rand, or the global source if it is nil. The
+// generator using the creator seeds Rand from its simulation
+type ValueReqCreator struct {
+	MeanValue   float64
+	Correlation float64
+	Rand        *rand.Rand
+}
+
+// NewValueReqCreator returns a new *ValueReqCreator with its own source of
+// randomness
+func NewValueReqCreator(meanValue, correlation float64) *ValueReqCreator {
+	return &ValueReqCreator{MeanValue: meanValue, Correlation: correlation, Rand: newRand()}
+}
+
+func (rc *ValueReqCreator) seed(src *rand.Rand) {
+	rc.Rand = seededRand(src)
+}
+
+// NewRequest returns a new ValueReq struct
+func (rc ValueReqCreator) NewRequest(sim *engine.Simulation, serviceTime float64) engine.ReqInterface {
+	exp := rand.ExpFloat64
+	if rc.Rand != nil {
+		exp = rc.Rand.ExpFloat64
+	}
+	value := exp() * rc.MeanValue * math.Pow(serviceTime, rc.Correlation)
+	return &ValueReq{newRequest(sim, serviceTime), value}
 }
 
-type ColoredReqCreator struct{}
+// ColoredReqCreator creates structs of type ColoredReq with a random color,
+// drawn from Rand or the global source if it is nil. The generator using the
+// creator seeds Rand from its simulation
+type ColoredReqCreator struct {
+	Rand *rand.Rand
+}
+
+// NewColoredReqCreator returns a new *ColoredReqCreator with its own source of
+// randomness
+func NewColoredReqCreator() *ColoredReqCreator {
+	return &ColoredReqCreator{Rand: newRand()}
+}
+
+func (rc *ColoredReqCreator) seed(src *rand.Rand) {
+	rc.Rand = seededRand(src)
+}
+
+// NewRequest returns a new ColoredReq struct
+func (rc ColoredReqCreator) NewRequest(sim *engine.Simulation, serviceTime float64) engine.ReqInterface {
+	intn := rand.Int
+	if rc.Rand != nil {
+		intn = rc.Rand.Int
+	}
+	return &ColoredReq{newRequest(sim, serviceTime), intn() % 2}
+}
+
+// PriorityReqCreator creates structs of type PriorityReq that are high
+// priority (0) with probability HighRatio and low priority (1) otherwise,
+// drawn from Rand or the global source if it is nil. The generator using the
+// creator seeds Rand from its simulation
+type PriorityReqCreator struct {
+	HighRatio float64
+	Rand      *rand.Rand
+}
+
+// NewPriorityReqCreator returns a new *PriorityReqCreator with its own source
+// of randomness
+func NewPriorityReqCreator(highRatio float64) *PriorityReqCreator {
+	return &PriorityReqCreator{HighRatio: highRatio, Rand: newRand()}
+}
+
+func (rc *PriorityReqCreator) seed(src *rand.Rand) {
+	rc.Rand = seededRand(src)
+}
+
+// NewRequest returns a new PriorityReq struct
+func (rc PriorityReqCreator) NewRequest(sim *engine.Simulation, serviceTime float64) engine.ReqInterface {
+	float := rand.Float64
+	if rc.Rand != nil {
+		float = rc.Rand.Float64
+	}
+	priority := 1
+	if float() < rc.HighRatio {
+		priority = 0
+	}
+	return &PriorityReq{newRequest(sim, serviceTime), priority}
+}
+
+// TaggingReqCreator wraps a ReqCreator and sets the Key tag of the requests it
+// creates to Value, e.g. to mark the class of the requests of a generator
+type TaggingReqCreator struct {
+	Creator ReqCreator
+	Key     string
+	Value   string
+}
+
+// seed seeds the wrapped creator, if it draws from its own stream
+func (rc TaggingReqCreator) seed(src *rand.Rand) {
+	seedAll(src, rc.Creator)
+}
+
+// NewRequest returns a new request of the wrapped creator with the tag set
+func (rc TaggingReqCreator) NewRequest(sim *engine.Simulation, serviceTime float64) engine.ReqInterface {
+	req := rc.Creator.NewRequest(sim, serviceTime)
+	if r, ok := req.(tagSetter); ok {
+		r.SetTag(rc.Key, rc.Value)
+	}
+	return req
+}
+
+// GangReqCreator creates structs of type GangReq that need Width cores with
+// probability WideRatio and a single core otherwise, drawn from Rand or the
+// global source if it is nil. The generator using the creator seeds Rand from
+// its simulation
+type GangReqCreator struct {
+	Width     int
+	WideRatio float64
+	Rand      *rand.Rand
+}
+
+// NewGangReqCreator returns a new *GangReqCreator with its own source of
+// randomness
+func NewGangReqCreator(width int, wideRatio float64) *GangReqCreator {
+	return &GangReqCreator{Width: width, WideRatio: wideRatio, Rand: newRand()}
+}
+
+func (rc *GangReqCreator) seed(src *rand.Rand) {
+	rc.Rand = seededRand(src)
+}
 
-func (rc ColoredReqCreator) NewRequest(serviceTime float64) engine.ReqInterface {
-	return &ColoredReq{Request{InitTime: engine.GetTime(), ServiceTime: serviceTime, OriginalServiceTime: serviceTime}, rand.Int() % 2}
+// NewRequest returns a new GangReq struct
+func (rc GangReqCreator) NewRequest(sim *engine.Simulation, serviceTime float64) engine.ReqInterface {
+	float := rand.Float64
+	if rc.Rand != nil {
+		float = rc.Rand.Float64
+	}
+	width := 1
+	if float() < rc.WideRatio {
+		width = rc.Width
+	}
+	return &GangReq{newRequest(sim, serviceTime), width}
 }
@@ -0,0 +1,29 @@
+package blocks
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestValueReqCreator(t *testing.T) {
+	sim := newTestSimulation()
+	for _, corr := range []float64{0, 1, -1} {
+		rc := NewValueReqCreator(2, corr)
+		rc.seed(rand.New(rand.NewSource(1)))
+		// the value divided by serviceTime^corr is exponential of mean 2
+		const n = 100000
+		var sum, sumSq float64
+		for i := 0; i < n; i++ {
+			st := float64(1 + i%4)
+			v := rc.NewRequest(sim, st).(ValueGetter).GetValue() / math.Pow(st, corr)
+			sum += v
+			sumSq += v * v
+		}
+		mean := sum / n
+		scv := (sumSq/n - mean*mean) / (mean * mean)
+		if math.Abs(mean-2) > 0.02*2 || math.Abs(scv-1) > 0.05 {
+			t.Errorf("correlation %v: scaled values of mean %v and scv %v, want 2 and 1", corr, mean, scv)
+		}
+	}
+}
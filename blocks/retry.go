@@ -0,0 +1,81 @@
+package blocks
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// RetryCoordinator models clients retrying their failed, e.g. dropped or timed
+// out, requests. It should be the drain of the failed requests and resubmits
+// them to its first output queue after an exponential backoff, up to
+// maxRetries times. Requests keep their arrival time, so their delay includes
+// all attempts. Requests failing after the last retry are terminated to the
+// request drain
+type RetryCoordinator struct {
+	genericProcessor
+	statsOutput
+	maxRetries int
+	backoff    float64
+	waiting    *delayLine
+	failed     *Queue
+	retries    int
+	gaveUp     int
+	name       string
+}
+
+// NewRetryCoordinator returns a new *RetryCoordinator waiting backoff before
+// the first retry and doubling the wait at every following one
+func NewRetryCoordinator(maxRetries int, backoff float64) *RetryCoordinator {
+	c := &RetryCoordinator{maxRetries: maxRetries, backoff: backoff, waiting: newDelayLine(), failed: NewQueue()}
+	c.AddInQueue(c.failed)
+	return c
+}
+
+// TerminateReq is called for every failed request
+func (c *RetryCoordinator) TerminateReq(req engine.ReqInterface) {
+	c.failed.Enqueue(req)
+}
+
+// SetName gives a name to the particular RetryCoordinator
+func (c *RetryCoordinator) SetName(name string) {
+	c.name = name
+}
+
+// Run is the main loop of the RetryCoordinator
+func (c *RetryCoordinator) Run() {
+	var d float64
+	d = -1
+	for {
+		intr, req := c.WaitInterruptible(d)
+		currTime := c.Now()
+		if intr {
+			// the timer was set for the first retry
+			c.waiting.releaseFirst(c.WriteOutQueue)
+		} else {
+			r, ok := req.(retrier)
+			if !ok {
+				panic(fmt.Sprintf("Request cannot be retried: %T", req))
+			}
+			if r.getRetries() >= c.maxRetries {
+				c.gaveUp++
+				c.reqDrain.TerminateReq(req)
+			} else {
+				at := currTime + c.backoff*math.Pow(2, float64(r.getRetries()))
+				c.waiting.add(at, req)
+				r.retry(at)
+				c.retries++
+			}
+		}
+		c.waiting.releaseDue(currTime, c.WriteOutQueue)
+		d = c.waiting.nextTimeout(currTime)
+	}
+}
+
+// PrintStats prints the retries and the requests that failed for good. The
+// retry rate adds to the offered load of the clients.
+// This is called by the model
+func (c *RetryCoordinator) PrintStats() {
+	fmt.Fprintf(c.out(), "retries:%v\tgave_up:%v\tretry_rate:%v\n", c.retries, c.gaveUp, float64(c.retries)/c.Now())
+}
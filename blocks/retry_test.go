@@ -0,0 +1,47 @@
+package blocks
+
+import "testing"
+
+func TestRetryCoordinatorAmplifiesLoad(t *testing.T) {
+	// an overloaded core behind a queue of 2 drops requests, which are
+	// retried up to twice
+	sim := newTestSimulation()
+	stats, failed := &AllKeeper{}, &AllKeeper{}
+	sim.InitStats(stats)
+	sim.InitStats(failed)
+	g := NewMMRandGenerator(1.2, 1)
+	g.SetCreator(&SimpleReqCreator{})
+	q := NewBoundedFIFOQueue(2)
+	g.AddOutQueue(q)
+	retry := NewRetryCoordinator(2, 1)
+	retry.SetReqDrain(failed)
+	retry.AddOutQueue(q)
+	q.SetDropDrain(retry)
+	p := NewRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(stats)
+	sim.RegisterActor(p)
+	sim.RegisterActor(retry)
+	sim.RegisterActor(g)
+	sim.Run(1e4)
+
+	// every drop is retried or given up, except the few still backing off
+	// or just dropped at the end
+	generated := q.arrivals - retry.retries
+	if inFlight := q.Dropped() - retry.retries - retry.gaveUp; inFlight < 0 || inFlight > 10 {
+		t.Errorf("%v drops, %v retries and %v given up", q.Dropped(), retry.retries, retry.gaveUp)
+	}
+	if failed.Count() != retry.gaveUp {
+		t.Errorf("%v failed requests terminated, %v given up", failed.Count(), retry.gaveUp)
+	}
+	if retry.gaveUp == 0 || retry.retries <= retry.gaveUp {
+		t.Errorf("%v retries and %v given up, want both and more retries", retry.retries, retry.gaveUp)
+	}
+	// the retries add to the offered load
+	if amplification := float64(q.arrivals) / float64(generated); amplification < 1.5 {
+		t.Errorf("%v arrivals for %v requests", q.arrivals, generated)
+	}
+	if done := stats.Count() + failed.Count(); done > generated || done < generated-10 {
+		t.Errorf("%v completed and %v failed of %v requests", stats.Count(), failed.Count(), generated)
+	}
+}
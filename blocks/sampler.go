@@ -0,0 +1,100 @@
+package blocks
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// probe is a named state variable sampled by a Sampler
+type probe struct {
+	name string
+	read func() float64
+}
+
+// Sampler samples arbitrary state, e.g. queue lengths, every interval with an
+// engine.Monitor and prints the time series of the samples along with their
+// mean. It should be registered both as an actor and as statistics
+type Sampler struct {
+	*engine.Monitor
+	statsOutput
+	probes  []probe
+	times   []float64
+	samples [][]float64 // samples[i] holds the values of every probe at times[i]
+	name    string
+}
+
+// NewSampler returns a new *Sampler sampling its probes every interval
+func NewSampler(interval float64) *Sampler {
+	s := &Sampler{}
+	s.Monitor = engine.NewMonitor(interval, s.sample)
+	return s
+}
+
+// AddProbe adds a state variable to sample, named name in the output and
+// read with read
+func (s *Sampler) AddProbe(name string, read func() float64) {
+	s.probes = append(s.probes, probe{name: name, read: read})
+}
+
+// AddQueue adds the length of q as a probe named name
+func (s *Sampler) AddQueue(name string, q engine.QueueInterface) {
+	s.AddProbe(name, func() float64 { return float64(q.Len()) })
+}
+
+func (s *Sampler) sample() {
+	values := make([]float64, len(s.probes))
+	for i, p := range s.probes {
+		values[i] = p.read()
+	}
+	s.times = append(s.times, s.Now())
+	s.samples = append(s.samples, values)
+}
+
+// SetName gives a name to the particular Sampler
+func (s *Sampler) SetName(name string) {
+	s.name = name
+}
+
+// Means returns the mean of the samples of every probe, in the order they
+// were added
+func (s *Sampler) Means() []float64 {
+	res := make([]float64, len(s.probes))
+	if len(s.samples) == 0 {
+		return res
+	}
+	for _, values := range s.samples {
+		for i, v := range values {
+			res[i] += v
+		}
+	}
+	for i := range res {
+		res[i] /= float64(len(s.samples))
+	}
+	return res
+}
+
+// PrintStats prints one row per sample with its time and the value of every
+// probe, followed by their means.
+// This is called by the model
+func (s *Sampler) PrintStats() {
+	names := make([]string, len(s.probes))
+	for i, p := range s.probes {
+		names[i] = p.name
+	}
+	fmt.Fprintf(s.out(), "Stats collector: %v\n", s.name)
+	fmt.Fprintf(s.out(), "Time\t%v\n", strings.Join(names, "\t"))
+	for i, values := range s.samples {
+		fmt.Fprintf(s.out(), "%v", s.times[i])
+		for _, v := range values {
+			fmt.Fprintf(s.out(), "\t%v", v)
+		}
+		fmt.Fprintln(s.out())
+	}
+	fmt.Fprintf(s.out(), "MEAN")
+	for _, m := range s.Means() {
+		fmt.Fprintf(s.out(), "\t%v", m)
+	}
+	fmt.Fprintln(s.out())
+}
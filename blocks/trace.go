@@ -0,0 +1,225 @@
+package blocks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// Traced events
+const (
+	traceArrival  = "arrival"  // the request was created
+	traceEnqueue  = "enqueue"  // the request entered a queue
+	traceDequeue  = "dequeue"  // the request left a queue
+	traceDrop     = "drop"     // a queue dropped the request
+	traceStart    = "start"    // a processor started or resumed serving the request
+	tracePreempt  = "preempt"  // a processor stopped serving the unfinished request
+	traceAbort    = "abort"    // a processor gave up on the unfinished request
+	traceComplete = "complete" // a processor finished serving the request
+)
+
+// TraceWriter is an engine.Tracer that writes a chronological log of the events of every request as
+// CSV lines time,req_id,event,actor, e.g. 10.5,3,start,processor0. The
+// start, preempt, abort and complete events of a processor delimit the
+// intervals it spent serving each request, e.g. for a Gantt chart. Requests
+// without an ID have -1
+type TraceWriter struct {
+	w *bufio.Writer
+}
+
+// NewTraceWriter returns a new *TraceWriter writing to w, starting with the
+// CSV header
+func NewTraceWriter(w io.Writer) *TraceWriter {
+	t := &TraceWriter{w: bufio.NewWriter(w)}
+	fmt.Fprintln(t.w, "time,req_id,event,actor")
+	return t
+}
+
+// Trace writes the event as a CSV line
+func (t *TraceWriter) Trace(time float64, reqID int, event, actor string) {
+	fmt.Fprintf(t.w, "%v,%v,%v,%v\n", time, reqID, event, actor)
+}
+
+// Flush writes the buffered events
+func (t *TraceWriter) Flush() error {
+	return t.w.Flush()
+}
+
+// trace passes event for req at actor, followed by id unless it is negative,
+// to the tracer of the simulation of req, at its current time. It does
+// nothing if tracing is disabled or req does not know its simulation
+func trace(event string, req engine.ReqInterface, actor string, id int) {
+	sim := reqSim(req)
+	if sim == nil || sim.Tracer() == nil {
+		return
+	}
+	reqID := -1
+	if r, ok := req.(IDGetter); ok {
+		reqID = r.GetID()
+	}
+	if id >= 0 {
+		actor += strconv.Itoa(id)
+	}
+	sim.Tracer().Trace(sim.GetTime(), reqID, event, actor)
+}
+
+// ArrivalRecorder writes the arrival and service time of every request of the
+// simulations it is set on with SetArrivalRecorder as "<arrivalTime>
+// <serviceTime>" lines, the format of NewTraceGenerator, so that a later run
+// can replay exactly the same requests, e.g. to compare scheduling policies
+// with common random numbers
+type ArrivalRecorder struct {
+	w *bufio.Writer
+}
+
+// NewArrivalRecorder returns a new *ArrivalRecorder writing to w
+func NewArrivalRecorder(w io.Writer) *ArrivalRecorder {
+	r := &ArrivalRecorder{w: bufio.NewWriter(w)}
+	fmt.Fprintln(r.w, "# arrivalTime serviceTime")
+	return r
+}
+
+// Flush writes the buffered arrivals
+func (r *ArrivalRecorder) Flush() error {
+	return r.w.Flush()
+}
+
+// RecordArrival writes a request created at the given time with the given
+// service time
+func (r *ArrivalRecorder) RecordArrival(time, serviceTime float64) {
+	fmt.Fprintf(r.w, "%v %v\n", time, serviceTime)
+}
+
+// MultiTracer passes the events to every one of its tracers, e.g. to write
+// several trace formats at once
+type MultiTracer []engine.Tracer
+
+// Trace passes the event to every tracer
+func (m MultiTracer) Trace(time float64, reqID int, event, actor string) {
+	for _, t := range m {
+		t.Trace(time, reqID, event, actor)
+	}
+}
+
+// chromeEvent is an event of the Chrome trace event format
+type chromeEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   float64                `json:"ts"`
+	Dur  float64                `json:"dur"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// chromeSlice is a request in service on a processor
+type chromeSlice struct {
+	actor string
+	reqID int
+}
+
+// ChromeTraceWriter is an engine.Tracer that writes the schedule of the processors in
+// the Chrome trace event format, which chrome://tracing and Perfetto display.
+// Every processor is a track and every interval it served a request, from its
+// start or resumption to its preemption, abortion or completion, is a slice
+// named after the request. Times are in us, the unit of the format. Close
+// should be called at the end of the simulation to get a valid JSON file
+type ChromeTraceWriter struct {
+	w      *bufio.Writer
+	tracks map[string]int          // track of every processor
+	open   map[chromeSlice]float64 // start of the slices in progress
+	last   float64                 // time of the last event
+	count  int                     // events written
+}
+
+// NewChromeTraceWriter returns a new *ChromeTraceWriter writing to w
+func NewChromeTraceWriter(w io.Writer) *ChromeTraceWriter {
+	t := &ChromeTraceWriter{w: bufio.NewWriter(w), tracks: make(map[string]int), open: make(map[chromeSlice]float64)}
+	fmt.Fprint(t.w, "{\"traceEvents\":[")
+	return t
+}
+
+// write writes e to the trace events
+func (t *ChromeTraceWriter) write(e chromeEvent) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	if t.count > 0 {
+		fmt.Fprint(t.w, ",")
+	}
+	fmt.Fprintf(t.w, "\n%s", b)
+	t.count++
+}
+
+// track returns the track of actor, naming it on its first use
+func (t *ChromeTraceWriter) track(actor string) int {
+	tid, ok := t.tracks[actor]
+	if !ok {
+		tid = len(t.tracks)
+		t.tracks[actor] = tid
+		t.write(chromeEvent{Name: "thread_name", Ph: "M", Tid: tid, Args: map[string]interface{}{"name": actor}})
+		t.write(chromeEvent{Name: "thread_sort_index", Ph: "M", Tid: tid, Args: map[string]interface{}{"sort_index": tid}})
+	}
+	return tid
+}
+
+// closeSlice writes the slice s ending at time because of event
+func (t *ChromeTraceWriter) closeSlice(s chromeSlice, time float64, event string) {
+	start := t.open[s]
+	delete(t.open, s)
+	t.write(chromeEvent{
+		Name: fmt.Sprintf("req %v", s.reqID), Cat: event, Ph: "X", Ts: start, Dur: time - start,
+		Tid: t.track(s.actor), Args: map[string]interface{}{"req_id": s.reqID, "end": event},
+	})
+}
+
+// Trace opens a slice when a processor starts serving a request and writes it
+// when the processor stops. The events of the queues are ignored
+func (t *ChromeTraceWriter) Trace(time float64, reqID int, event, actor string) {
+	t.last = time
+	if !strings.HasPrefix(actor, "processor") {
+		return
+	}
+	s := chromeSlice{actor: actor, reqID: reqID}
+	switch event {
+	case traceStart:
+		t.track(actor)
+		t.open[s] = time
+	case tracePreempt, traceAbort, traceComplete:
+		if _, ok := t.open[s]; ok {
+			t.closeSlice(s, time, event)
+		}
+	}
+}
+
+// Close writes the slices still in progress, ending at end, e.g. the end of
+// the simulation, and completes the JSON file. The trace should not be written
+// to afterwards
+func (t *ChromeTraceWriter) Close(end float64) error {
+	var unfinished []chromeSlice
+	for s := range t.open {
+		unfinished = append(unfinished, s)
+	}
+	// in a deterministic order
+	sort.Slice(unfinished, func(i, j int) bool {
+		if unfinished[i].actor != unfinished[j].actor {
+			return t.tracks[unfinished[i].actor] < t.tracks[unfinished[j].actor]
+		}
+		return unfinished[i].reqID < unfinished[j].reqID
+	})
+	end = math.Max(t.last, end)
+	for _, s := range unfinished {
+		t.closeSlice(s, end, "unfinished")
+	}
+	fmt.Fprint(t.w, "\n]}\n")
+	return t.w.Flush()
+}
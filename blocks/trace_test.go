@@ -0,0 +1,97 @@
+package blocks
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// runTraced runs the scripted requests on a run to completion processor,
+// tracing them to t if not nil, and returns the statistics
+func runTraced(t engine.Tracer, events []ScriptedEvent) *AllKeeper {
+	sim := newTestSimulation()
+	sim.SetTracer(t)
+	stats := &AllKeeper{}
+	sim.InitStats(stats)
+	q := NewQueue()
+	g := NewScriptedGenerator(events)
+	g.SetCreator(&SimpleReqCreator{})
+	g.AddOutQueue(q)
+	p := NewRTCProcessor(0)
+	p.AddInQueue(q)
+	p.SetReqDrain(stats)
+	sim.RegisterActor(p)
+	sim.RegisterActor(g)
+	sim.Run(100)
+	return stats
+}
+
+func TestTraceWriterArrivalsAndCompletions(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewTraceWriter(&buf)
+	events := []ScriptedEvent{{0, 2}, {1, 1}, {5, 3}}
+	stats := runTraced(w, events)
+	// the events of another simulation go to its own tracer
+	runTraced(nil, events)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || records[0][0] != "time" {
+		t.Fatalf("trace without header: %v", records)
+	}
+	arrivals, completions := map[int]float64{}, map[int]float64{}
+	last := 0.0
+	for _, rec := range records[1:] {
+		time, err := strconv.ParseFloat(rec[0], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if time < last {
+			t.Errorf("event %v before the previous one at %v", rec, last)
+		}
+		last = time
+		id, err := strconv.Atoi(rec[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch rec[2] {
+		case traceArrival:
+			if _, ok := arrivals[id]; ok {
+				t.Errorf("request %v arrived twice", id)
+			}
+			arrivals[id] = time
+		case traceComplete:
+			if _, ok := completions[id]; ok {
+				t.Errorf("request %v completed twice", id)
+			}
+			completions[id] = time
+			if rec[3] != "processor0" {
+				t.Errorf("request %v completed by %v, want processor0", id, rec[3])
+			}
+		}
+	}
+
+	// every request arrives and completes once, after its delay
+	if len(arrivals) != stats.Count() || len(completions) != stats.Count() {
+		t.Fatalf("%v arrivals and %v completions traced for %v requests", len(arrivals), len(completions), stats.Count())
+	}
+	for _, item := range stats.items {
+		var id int
+		for i, arrival := range arrivals {
+			if arrival == item.ArrivalTime {
+				id = i
+			}
+		}
+		if c, ok := completions[id]; !ok || c-arrivals[id] != item.Delay {
+			t.Errorf("request %v arrived at %v and completed at %v, want a delay of %v", id, arrivals[id], c, item.Delay)
+		}
+	}
+}
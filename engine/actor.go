@@ -1,33 +1,85 @@
 package engine
 
-import (
-	"math/rand"
-)
+import "runtime"
 
 // Actor is the basic simulation element. Every element (generator or processor)
 // should have an actor as a nested struct.
 type Actor struct {
+	sim       *Simulation
 	toModel   chan interface{}
 	wakeUpCh  chan int
 	inQueues  []QueueInterface
 	outQueues []QueueInterface
+	done      bool
 }
 
-func (a *Actor) init(ch chan interface{}) {
-	a.toModel = ch
+func (a *Actor) init(s *Simulation) {
+	a.sim = s
+	a.toModel = s.eventChan
 	a.wakeUpCh = make(chan int)
+	for _, q := range a.outQueues {
+		s.registerQueue(q)
+	}
+	for _, q := range a.inQueues {
+		s.registerQueue(q)
+	}
+}
+
+func (a *Actor) actor() *Actor {
+	return a
+}
+
+// Simulation returns the simulation the actor is registered in, nil before
+// its registration
+func (a *Actor) Simulation() *Simulation {
+	return a.sim
+}
+
+// Now returns the current time of the simulation of the actor
+func (a *Actor) Now() float64 {
+	return a.sim.getTime()
+}
+
+// send passes e to the model, or shuts the actor down if the simulation is
+// over
+func (a *Actor) send(e interface{}) {
+	select {
+	case a.toModel <- e:
+	case <-a.sim.quit:
+		runtime.Goexit()
+	}
+}
+
+// block waits till the model wakes the actor up, or shuts the actor down if
+// the simulation is over
+func (a *Actor) block() {
+	select {
+	case <-a.wakeUpCh:
+	case <-a.sim.quit:
+		runtime.Goexit()
+	}
 }
 
 // AddInQueue adds another input queue.
-// Input queues should be added in decreasing priority
+// Input queues should be added in decreasing priority. The simulation
+// monitors them like the output queues, e.g. a queue fed by a request drain
 func (a *Actor) AddInQueue(q QueueInterface) {
+	// queues added before the registration are registered along with the
+	// actor
+	if a.sim != nil {
+		a.sim.registerQueue(q)
+	}
 	a.inQueues = append(a.inQueues, q)
 }
 
 // AddOutQueue adds another output queue.
 // Output queues should be added in decreasing priority
 func (a *Actor) AddOutQueue(q QueueInterface) {
-	mdl.queues[q] = true
+	// queues added before the registration are registered along with the
+	// actor
+	if a.sim != nil {
+		a.sim.registerQueue(q)
+	}
 	a.outQueues = append(a.outQueues, q)
 }
 
@@ -71,9 +123,16 @@ func (a *Actor) GetInQueueCount() int {
 
 // Wait blocks the actor for a specific duration d
 func (a *Actor) Wait(d float64) {
-	e := timerEvent{time: d + mdl.getTime(), wakeUpCh: a.wakeUpCh}
-	a.toModel <- e
-	<-a.wakeUpCh // block
+	e := timerEvent{time: d + a.sim.getTime(), wakeUpCh: a.wakeUpCh}
+	a.send(e)
+	a.block()
+}
+
+// Done notifies the model that the actor finished. It should be the last
+// call of Run, since the actor will never be woken up again
+func (a *Actor) Done() {
+	a.done = true
+	a.send(doneEvent{})
 }
 
 // WaitInterruptible blocks the actor for a d interval, unless there is an
@@ -89,24 +148,70 @@ func (a *Actor) WaitInterruptible(d float64) (bool, ReqInterface) {
 	if d < 0 {
 		return false, a.ReadInQueue()
 	}
-	timeoutTime := d + mdl.getTime()
+	timeoutTime := d + a.sim.getTime()
 	lEvent := linkedEvent{
 		timerEvent: timerEvent{time: timeoutTime, wakeUpCh: a.wakeUpCh},
 		blockEvent: blockEvent{wakeUpCh: a.wakeUpCh, queues: a.inQueues},
 	}
-	a.toModel <- lEvent
-	<-a.wakeUpCh
+	a.send(lEvent)
+	a.block()
 
 	if a.inQueues[0].Len() > 0 {
 		return false, a.inQueues[0].Dequeue()
 	}
-	if mdl.getTime() == timeoutTime {
+	if a.sim.getTime() == timeoutTime {
 		return true, nil
 	}
 
 	return false, nil
 }
 
+// WaitPreemptible blocks the actor for a d interval, unless there is an
+// incoming request in any of its input queues, e.g. to preempt the request in
+// service. It generalizes WaitInterruptible to several input queues.
+// Returns true, nil, -1 if woken up by the timeout or false, ReqInterface and
+// the index of its queue if woken up by the incoming req, the first non-empty
+// queue being read. It returns false, nil, -1 if another actor dequeued the
+// incoming req first. If d is negative just read the input queues
+func (a *Actor) WaitPreemptible(d float64) (bool, ReqInterface, int) {
+	if req, idx := a.pollInQueues(); req != nil {
+		return false, req, idx
+	}
+
+	// Negative timeout - no timeout
+	if d < 0 {
+		req, idx := a.ReadInQueues()
+		return false, req, idx
+	}
+	timeoutTime := d + a.sim.getTime()
+	lEvent := linkedEvent{
+		timerEvent: timerEvent{time: timeoutTime, wakeUpCh: a.wakeUpCh},
+		blockEvent: blockEvent{wakeUpCh: a.wakeUpCh, queues: a.inQueues},
+	}
+	a.send(lEvent)
+	a.block()
+
+	if req, idx := a.pollInQueues(); req != nil {
+		return false, req, idx
+	}
+	if a.sim.getTime() == timeoutTime {
+		return true, nil, -1
+	}
+
+	return false, nil, -1
+}
+
+// pollInQueues dequeues from the first non-empty input queue without
+// blocking. It returns nil, -1 if all of them are empty
+func (a *Actor) pollInQueues() (ReqInterface, int) {
+	for i, q := range a.inQueues {
+		if q.Len() > 0 {
+			return q.Dequeue(), i
+		}
+	}
+	return nil, -1
+}
+
 // ReadInQueue tries to read the first input queue. If there is a ReqInterface
 // available it returns, otherwise the actor blocks
 func (a *Actor) ReadInQueue() ReqInterface {
@@ -115,8 +220,8 @@ func (a *Actor) ReadInQueue() ReqInterface {
 	}
 
 	bEvent := blockEvent{wakeUpCh: a.wakeUpCh, queues: a.inQueues}
-	a.toModel <- bEvent
-	<-a.wakeUpCh
+	a.send(bEvent)
+	a.block()
 	return a.ReadInQueue()
 }
 
@@ -126,11 +231,25 @@ func (a *Actor) ReadInQueueI(idx int) ReqInterface {
 	}
 
 	bEvent := blockEvent{wakeUpCh: a.wakeUpCh, queues: a.inQueues}
-	a.toModel <- bEvent
-	<-a.wakeUpCh
+	a.send(bEvent)
+	a.block()
 	return a.ReadInQueueI(idx)
 }
 
+// ReadInQueueOnly reads the given (idx) input queue like ReadInQueueI, but
+// blocks only on this queue, so requests in the other input queues do not
+// wake the actor up while it waits
+func (a *Actor) ReadInQueueOnly(idx int) ReqInterface {
+	if a.inQueues[idx].Len() > 0 {
+		return a.inQueues[idx].Dequeue()
+	}
+
+	bEvent := blockEvent{wakeUpCh: a.wakeUpCh, queues: a.inQueues[idx : idx+1]}
+	a.send(bEvent)
+	a.block()
+	return a.ReadInQueueOnly(idx)
+}
+
 // ReadInQueues tries to read from all the queues in descending priority
 // and blocks only if all the queues are empty. In returns the element of the
 // first queue found non-empty
@@ -143,12 +262,26 @@ func (a *Actor) ReadInQueues() (ReqInterface, int) {
 	}
 
 	bEvent := blockEvent{wakeUpCh: a.wakeUpCh, queues: a.inQueues}
-	a.toModel <- bEvent
-	<-a.wakeUpCh
+	a.send(bEvent)
+	a.block()
 
 	return a.ReadInQueues()
 }
 
+// WaitInQueues blocks the actor until at least one of its input queues is
+// non-empty, without dequeuing anything
+func (a *Actor) WaitInQueues() {
+	for _, q := range a.inQueues {
+		if q.Len() > 0 {
+			return
+		}
+	}
+
+	bEvent := blockEvent{wakeUpCh: a.wakeUpCh, queues: a.inQueues}
+	a.send(bEvent)
+	a.block()
+}
+
 type queueIdx struct {
 	idx int
 	q   QueueInterface
@@ -164,13 +297,13 @@ func (a *Actor) ReadInQueuesRand() (ReqInterface, int) {
 		}
 	}
 	if len(available) > 0 {
-		q := available[rand.Intn(len(available))]
+		q := available[a.sim.Rand().Intn(len(available))]
 		return q.q.Dequeue(), q.idx
 	}
 
 	bEvent := blockEvent{wakeUpCh: a.wakeUpCh, queues: a.inQueues}
-	a.toModel <- bEvent
-	<-a.wakeUpCh
+	a.send(bEvent)
+	a.block()
 	return a.ReadInQueues()
 }
 
@@ -188,13 +321,13 @@ func (a *Actor) ReadInQueuesRandLocalPr() (ReqInterface, int) {
 		}
 	}
 	if len(available) > 0 {
-		q := available[rand.Intn(len(available))]
+		q := available[a.sim.Rand().Intn(len(available))]
 		return q.q.Dequeue(), q.idx
 	}
 
 	bEvent := blockEvent{wakeUpCh: a.wakeUpCh, queues: a.inQueues}
-	a.toModel <- bEvent
-	<-a.wakeUpCh
+	a.send(bEvent)
+	a.block()
 	return a.ReadInQueuesRandLocalPr()
 }
 
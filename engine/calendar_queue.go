@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"sort"
+)
+
+// calendarSample is the number of the earliest events used to estimate the
+// bucket width when resizing
+const calendarSample = 25
+
+// calendarQueue is a calendar queue (R. Brown, 1988): the events are hashed
+// by time into buckets of width time units, like the days of a year of
+// len(buckets) days, and every bucket is sorted. Dequeuing scans the buckets
+// from the current day on, so both operations take constant time on average
+// as long as the width matches the event density, which is adjusted whenever
+// the number of buckets doubles or halves. Events at the same time are
+// dequeued in scheduling order
+type calendarQueue struct {
+	buckets [][]timerEventInterface
+	width   float64
+	size    int
+	last    float64 // time of the last dequeued event
+	day     int64   // day of the last dequeued event, since time 0
+}
+
+func newCalendarQueue() *calendarQueue {
+	return &calendarQueue{buckets: make([][]timerEventInterface, 2), width: 1}
+}
+
+// dayOf returns the day of time t, since time 0
+func (cq *calendarQueue) dayOf(t float64) int64 {
+	return int64(t / cq.width)
+}
+
+// bucketOf returns the bucket of time t
+func (cq *calendarQueue) bucketOf(t float64) int {
+	return int(cq.dayOf(t) % int64(len(cq.buckets)))
+}
+
+func (cq *calendarQueue) Len() int {
+	return cq.size
+}
+
+func (cq *calendarQueue) push(e timerEventInterface) {
+	cq.insert(e)
+	cq.size++
+	if cq.size > 2*len(cq.buckets) {
+		cq.resize(2 * len(cq.buckets))
+	}
+}
+
+// insert adds e after the events of its bucket to be processed before it
+func (cq *calendarQueue) insert(e timerEventInterface) {
+	b := cq.bucketOf(e.getTime())
+	bucket := cq.buckets[b]
+	i := sort.Search(len(bucket), func(i int) bool {
+		return eventBefore(e, bucket[i])
+	})
+	bucket = append(bucket, nil)
+	copy(bucket[i+1:], bucket[i:])
+	bucket[i] = e
+	cq.buckets[b] = bucket
+}
+
+func (cq *calendarQueue) pop() timerEventInterface {
+	b := cq.next()
+	e := cq.buckets[b][0]
+	cq.buckets[b] = cq.buckets[b][1:]
+	cq.last = e.getTime()
+	cq.day = cq.dayOf(cq.last)
+	cq.size--
+	if cq.size < len(cq.buckets)/2 && len(cq.buckets) > 2 {
+		cq.resize(len(cq.buckets) / 2)
+	}
+	return e
+}
+
+// next returns the bucket holding the earliest event. The queue should not
+// be empty
+func (cq *calendarQueue) next() int {
+	n := int64(len(cq.buckets))
+	// look for an event of the current year, day by day
+	for day := cq.day; day < cq.day+n; day++ {
+		b := int(day % n)
+		if len(cq.buckets[b]) > 0 && cq.dayOf(cq.buckets[b][0].getTime()) == day {
+			return b
+		}
+	}
+	// the events are sparse, pick the earliest first event of the buckets
+	best := -1
+	for b, bucket := range cq.buckets {
+		if len(bucket) > 0 && (best < 0 || eventBefore(bucket[0], cq.buckets[best][0])) {
+			best = b
+		}
+	}
+	return best
+}
+
+func (cq *calendarQueue) remove(e timerEventInterface) {
+	b := cq.bucketOf(e.getTime())
+	for i, other := range cq.buckets[b] {
+		if other == e {
+			cq.buckets[b] = append(cq.buckets[b][:i], cq.buckets[b][i+1:]...)
+			cq.size--
+			return
+		}
+	}
+	panic("Removing an event that is not in the calendar queue")
+}
+
+// resize rehashes the events into the given number of buckets, with a width
+// of three times the average separation of the earliest events, leaving out
+// the outliers
+func (cq *calendarQueue) resize(buckets int) {
+	events := make([]timerEventInterface, 0, cq.size)
+	for _, bucket := range cq.buckets {
+		events = append(events, bucket...)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return eventBefore(events[i], events[j])
+	})
+
+	if w := separation(events); w > 0 {
+		cq.width = 3 * w
+	}
+	cq.buckets = make([][]timerEventInterface, buckets)
+	for _, e := range events {
+		b := cq.bucketOf(e.getTime())
+		cq.buckets[b] = append(cq.buckets[b], e)
+	}
+	cq.day = cq.dayOf(cq.last)
+}
+
+// separation returns the average separation of the earliest sorted events,
+// ignoring the separations over twice the average, or 0 if they are all at
+// the same time
+func separation(events []timerEventInterface) float64 {
+	n := len(events)
+	if n > calendarSample {
+		n = calendarSample
+	}
+	if n < 2 {
+		return 0
+	}
+	avg := (events[n-1].getTime() - events[0].getTime()) / float64(n-1)
+	var sum float64
+	var count int
+	for i := 1; i < n; i++ {
+		if d := events[i].getTime() - events[i-1].getTime(); d <= 2*avg {
+			sum += d
+			count++
+		}
+	}
+	if count == 0 {
+		return avg
+	}
+	return sum / float64(count)
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Checkpointer is implemented by the queues, statistics and actors whose
+// state can be saved in a Checkpoint, e.g. the requests of a queue
+type Checkpointer interface {
+	// SaveState returns the state to save, encoded as JSON
+	SaveState() (interface{}, error)
+	// RestoreState restores the state saved in data, once registered in the
+	// resumed simulation sim
+	RestoreState(sim *Simulation, data json.RawMessage) error
+}
+
+// Checkpoint is the state of a simulation whose run is over, to resume it
+// later in a new simulation of the same topology, e.g. to go on with a long
+// run or to branch several runs off a common prefix. The actors themselves
+// are not saved: the topology rebuilds them and they restart their Run at the
+// time they were to wake up at, e.g. a generator issues its next request then,
+// or right away if they were waiting for a request. The actors holding
+// requests, e.g. a processor serving one, should be Checkpointers, since their
+// requests are lost otherwise, and so should the queues holding requests
+type Checkpoint struct {
+	Time   float64           `json:"time"`
+	IDs    int               `json:"ids"`   // identifiers handed out
+	Seed   int64             `json:"seed"`  // of the source of randomness
+	Draws  uint64            `json:"draws"` // values drawn from the source
+	Queues []json.RawMessage `json:"queues"`
+	Stats  []json.RawMessage `json:"stats"`
+	Actors []ActorState      `json:"actors"`
+}
+
+// ActorState is the state of an actor in a Checkpoint: its own one if it is
+// a Checkpointer, whether it was done or the time it was to wake up at
+type ActorState struct {
+	State  json.RawMessage `json:"state,omitempty"`
+	Done   bool            `json:"done,omitempty"`
+	Wakeup *float64        `json:"wakeup,omitempty"`
+}
+
+// savedSource is a source of randomness whose state is its seed and the
+// number of values drawn since
+type savedSource struct {
+	src   rand.Source64
+	seed  int64
+	draws uint64
+}
+
+// newSavedSource returns a new *savedSource seeded with seed, after draws
+// values
+func newSavedSource(seed int64, draws uint64) *savedSource {
+	s := &savedSource{src: rand.NewSource(seed).(rand.Source64), seed: seed}
+	for s.draws < draws {
+		s.Uint64()
+	}
+	return s
+}
+
+func (s *savedSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *savedSource) Uint64() uint64 {
+	s.draws++
+	return s.src.Uint64()
+}
+
+func (s *savedSource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.seed, s.draws = seed, 0
+}
+
+// SetSeed seeds the source of randomness of the engine like SetRand with
+// rand.NewSource, but its state can be saved in a Checkpoint
+func (m *Simulation) SetSeed(seed int64) {
+	m.src = newSavedSource(seed, 0)
+	m.rng = rand.New(m.src)
+}
+
+// saveState returns the state of c encoded as JSON
+func saveState(c Checkpointer) (json.RawMessage, error) {
+	state, err := c.SaveState()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(state)
+}
+
+// Checkpoint returns the state of the simulation once its run is over, see
+// Checkpoint. Its source of randomness should be set with SetSeed, and its
+// queues holding requests should be Checkpointers. The statistics that are
+// not Checkpointers start over when resumed
+func (m *Simulation) Checkpoint() (*Checkpoint, error) {
+	if m.src == nil {
+		return nil, fmt.Errorf("the source of randomness cannot be saved, it should be set with SetSeed")
+	}
+	c := &Checkpoint{Time: m.time, IDs: m.ids, Seed: m.src.seed, Draws: m.src.draws}
+	for i, q := range m.queueList {
+		var state json.RawMessage
+		if s, ok := q.(Checkpointer); ok {
+			var err error
+			if state, err = saveState(s); err != nil {
+				return nil, fmt.Errorf("cannot save queue %v: %v", i, err)
+			}
+		} else if q.Len() > 0 {
+			return nil, fmt.Errorf("cannot save the requests of queue %v, a %T", i, q)
+		}
+		c.Queues = append(c.Queues, state)
+	}
+	for i, s := range m.bookkeeping {
+		var state json.RawMessage
+		if cs, ok := s.(Checkpointer); ok {
+			var err error
+			if state, err = saveState(cs); err != nil {
+				return nil, fmt.Errorf("cannot save statistics %v: %v", i, err)
+			}
+		}
+		c.Stats = append(c.Stats, state)
+	}
+
+	// the pending timers of the actors, put back in place
+	wakeups := make(map[chan int]float64)
+	var pending []timerEventInterface
+	for m.pq.Len() > 0 {
+		e := m.pq.pop()
+		wakeups[e.getChannel()] = e.getTime()
+		pending = append(pending, e)
+	}
+	for _, e := range pending {
+		m.pq.push(e)
+	}
+	for i, a := range m.registered {
+		var state ActorState
+		if cs, ok := a.(Checkpointer); ok {
+			var err error
+			if state.State, err = saveState(cs); err != nil {
+				return nil, fmt.Errorf("cannot save actor %v: %v", i, err)
+			}
+		} else if a.actor().done {
+			state.Done = true
+		} else if t, ok := wakeups[a.actor().wakeUpCh]; ok {
+			state.Wakeup = &t
+		}
+		c.Actors = append(c.Actors, state)
+	}
+	return c, nil
+}
+
+// Resume makes the simulation go on from the checkpoint c: it starts at its
+// time with its source of randomness, which SetSeed can replace to branch
+// off, and restores the queues, statistics and actors of its topology once
+// rebuilt, at the start of the run
+func (m *Simulation) Resume(c *Checkpoint) {
+	m.time, m.ids = c.Time, c.IDs
+	m.src = newSavedSource(c.Seed, c.Draws)
+	m.rng = rand.New(m.src)
+	m.resumed = c
+}
+
+// restoreState restores the state saved in data into the element i of a
+// checkpoint, if any was saved
+func (m *Simulation) restoreState(kind string, i int, x interface{}, data json.RawMessage) {
+	if len(data) == 0 || string(data) == "null" {
+		return
+	}
+	c, ok := x.(Checkpointer)
+	if !ok {
+		panic(fmt.Sprintf("Cannot restore %v %v, a %T", kind, i, x))
+	}
+	if err := c.RestoreState(m, data); err != nil {
+		panic(fmt.Sprintf("Cannot restore %v %v: %v", kind, i, err))
+	}
+}
+
+// restore restores the topology rebuilt since Resume and returns the time
+// every actor should start at, NaN for the ones that are done
+func (m *Simulation) restore() []float64 {
+	c := m.resumed
+	if len(c.Queues) != len(m.queueList) || len(c.Stats) != len(m.bookkeeping) || len(c.Actors) != len(m.actors) {
+		panic(fmt.Sprintf("Checkpoint of %v queues, %v statistics and %v actors resumed with %v, %v and %v",
+			len(c.Queues), len(c.Stats), len(c.Actors), len(m.queueList), len(m.bookkeeping), len(m.actors)))
+	}
+	for i, q := range m.queueList {
+		m.restoreState("queue", i, q, c.Queues[i])
+	}
+	for i, s := range m.bookkeeping {
+		m.restoreState("statistics", i, s, c.Stats[i])
+	}
+	starts := make([]float64, len(m.actors))
+	for i, a := range m.actors {
+		state := c.Actors[i]
+		starts[i] = m.time
+		if state.State != nil {
+			m.restoreState("actor", i, a, state.State)
+		} else if state.Done {
+			starts[i] = math.NaN()
+		} else if state.Wakeup != nil {
+			starts[i] = *state.Wakeup
+		}
+	}
+	return starts
+}
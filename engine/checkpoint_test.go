@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+)
+
+func TestSavedSourceResumesDraws(t *testing.T) {
+	want := rand.New(rand.NewSource(7))
+	src := newSavedSource(7, 0)
+	got := rand.New(src)
+	for i := 0; i < 10; i++ {
+		if w, g := want.Int63(), got.Int63(); w != g {
+			t.Fatalf("draw %v: got %v, want %v", i, g, w)
+		}
+	}
+
+	// a source resumed after the draws goes on with the same values
+	resumed := rand.New(newSavedSource(7, src.draws))
+	for i := 0; i < 10; i++ {
+		if w, g := want.Float64(), resumed.Float64(); w != g {
+			t.Fatalf("resumed draw %v: got %v, want %v", i, g, w)
+		}
+	}
+}
+
+// testTicker records the times it ticks at, every period
+type testTicker struct {
+	Actor
+	period float64
+	ticks  []float64
+}
+
+func (a *testTicker) Run() {
+	for {
+		a.ticks = append(a.ticks, a.Now())
+		a.Wait(a.period)
+	}
+}
+
+// testOnce runs once and finishes
+type testOnce struct {
+	Actor
+	runs int
+}
+
+func (a *testOnce) Run() {
+	a.runs++
+	a.Done()
+}
+
+// roundTrip returns c once encoded and decoded, like in a checkpoint file
+func roundTrip(t *testing.T, c *Checkpoint) *Checkpoint {
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Checkpoint
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	return &got
+}
+
+func TestCheckpointResumesActors(t *testing.T) {
+	whole := NewSimulation()
+	all := &testTicker{period: 10}
+	whole.RegisterActor(all)
+	whole.Run(45)
+
+	sim := NewSimulation()
+	sim.SetSeed(1)
+	first := &testTicker{period: 10}
+	sim.RegisterActor(first)
+	sim.RegisterActor(&testOnce{})
+	sim.NewID()
+	sim.Run(25)
+	c, err := sim.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = roundTrip(t, c)
+	if c.IDs != 1 {
+		t.Fatalf("got %v ids, want 1", c.IDs)
+	}
+
+	resumed := NewSimulation()
+	resumed.Resume(c)
+	ticker, once := &testTicker{period: 10}, &testOnce{}
+	resumed.RegisterActor(ticker)
+	resumed.RegisterActor(once)
+	resumed.Run(45)
+
+	// the ticker goes on at its next tick and the finished actor stays so
+	ticks := append(first.ticks, ticker.ticks...)
+	if len(ticks) != len(all.ticks) {
+		t.Fatalf("got ticks %v, want %v", ticks, all.ticks)
+	}
+	for i := range ticks {
+		if ticks[i] != all.ticks[i] {
+			t.Fatalf("got ticks %v, want %v", ticks, all.ticks)
+		}
+	}
+	if once.runs != 0 {
+		t.Errorf("finished actor ran %v times again", once.runs)
+	}
+	if id := resumed.NewID(); id != 2 {
+		t.Errorf("got id %v after the checkpoint, want 2", id)
+	}
+}
+
+func TestCheckpointErrors(t *testing.T) {
+	sim := NewSimulation()
+	sim.SetRand(rand.New(rand.NewSource(1)))
+	sim.Run(10)
+	if _, err := sim.Checkpoint(); err == nil {
+		t.Error("checkpointed a simulation without a saved source")
+	}
+
+	// the requests of a testQueue cannot be saved
+	sim = NewSimulation()
+	sim.SetSeed(1)
+	q := &testQueue{}
+	src := &testSource{lambda: 1, mu: 0.001}
+	src.AddOutQueue(q)
+	srv := &testServer{}
+	srv.AddInQueue(q)
+	sim.RegisterActor(srv)
+	sim.RegisterActor(src)
+	sim.Run(100)
+	if q.Len() == 0 {
+		t.Fatal("no queued requests")
+	}
+	if _, err := sim.Checkpoint(); err == nil {
+		t.Error("checkpointed the requests of a testQueue")
+	}
+}
@@ -1,12 +1,13 @@
 package engine
 
 import (
-	"container/heap"
 	"container/list"
+	"io"
+	"math"
+	"math/rand"
+	"os"
 )
 
-var mdl *model
-
 // ActorInterface is the main interface to be used in main package.
 // Every element of the topology should implement this interface.
 // Init, AddInQueuem AddOutQueue are provided by the Actor nested struct and
@@ -15,7 +16,8 @@ type ActorInterface interface {
 	Run()
 	AddInQueue(q QueueInterface)
 	AddOutQueue(q QueueInterface)
-	init(ch chan interface{})
+	init(s *Simulation)
+	actor() *Actor
 }
 
 // ReqInterface describes what a basic request should look like
@@ -38,9 +40,39 @@ type Stats interface {
 	PrintStats()
 }
 
+// OutputSetter is implemented by statistics that can be printed to any
+// io.Writer
+type OutputSetter interface {
+	SetOutput(w io.Writer)
+}
+
+// SimulationSetter is implemented by the actors and statistics that need the
+// simulation they are part of before it runs, e.g. to seed their sources of
+// randomness from it. The simulation sets itself as they are registered
+type SimulationSetter interface {
+	SetSimulation(s *Simulation)
+}
+
+// ArrivalRecorder records the arrival and service time of the requests
+// created in a simulation, e.g. to replay them in another one
+type ArrivalRecorder interface {
+	RecordArrival(time, serviceTime float64)
+}
+
+// Tracer receives the events of every request of a simulation as they
+// happen, e.g. to log them or to draw the schedule. reqID is -1 for requests
+// without an ID and actor is the name of the queue or processor, followed by
+// its ID if any, e.g. processor0
+type Tracer interface {
+	Trace(time float64, reqID int, event, actor string)
+}
+
 type timerEventInterface interface {
 	getTime() float64
 	setIdx(idx int)
+	getIdx() int
+	setSeq(seq uint64)
+	getSeq() uint64
 	getChannel() chan int
 }
 
@@ -48,6 +80,7 @@ type timerEvent struct {
 	time     float64
 	wakeUpCh chan int
 	idx      int
+	seq      uint64 // scheduling order, breaking the ties of time
 }
 
 func (te *timerEvent) getTime() float64 {
@@ -58,6 +91,18 @@ func (te *timerEvent) setIdx(idx int) {
 	te.idx = idx
 }
 
+func (te *timerEvent) getIdx() int {
+	return te.idx
+}
+
+func (te *timerEvent) setSeq(seq uint64) {
+	te.seq = seq
+}
+
+func (te *timerEvent) getSeq() uint64 {
+	return te.seq
+}
+
 func (te *timerEvent) getChannel() chan int {
 	return te.wakeUpCh
 }
@@ -107,34 +152,78 @@ func (le *linkedEvent) getChannel() chan int {
 	return le.blockEvent.wakeUpCh
 }
 
-type model struct {
+// doneEvent is sent by an actor that finished and will not schedule any
+// more events
+type doneEvent struct{}
+
+// startEvent is sent by an actor registering another actor during the run.
+// The model starts it and waits for its first event before replying on
+// started, so that only one actor runs at a time
+type startEvent struct {
+	actor   ActorInterface
+	started chan int
+}
+
+// Simulation holds the state of a simulation: its clock, the event loop and
+// the registered actors, queues and statistics. Independent simulations can
+// run concurrently, e.g. in a parameter sweep, as long as their actors and
+// statistics only use their own Simulation, see Actor
+type Simulation struct {
 	time            float64
-	actorCount      int
-	pq              priorityQueue
+	actors          []ActorInterface // registered before the run, not started yet
+	registered      []ActorInterface // all the actors, in registration order
+	pq              eventList
+	seq             uint64 // timer events scheduled so far
 	eventChan       chan interface{}
 	blockedInQueues map[QueueInterface]*list.List
 	queues          map[QueueInterface]bool
+	queueList       []QueueInterface // queues in registration order
 	bookkeeping     []Stats
+	stopped         bool
+	running         bool    // actors register through the event loop
+	cutoff          float64 // threshold time of the run
+	statsOutput     io.Writer
+	infoOutput      io.Writer
+	arrivals        ArrivalRecorder
+	tracer          Tracer
+	drain           bool
+	eventList       EventList
+	rng             *rand.Rand
+	src             *savedSource  // source of rng if set with SetSeed
+	resumed         *Checkpoint   // restored at the start of the run
+	ids             int           // identifiers handed out so far
+	quit            chan struct{} // closed at the end of the run
+	progress        progress
 }
 
-func newModel() *model {
-	m := &model{}
+// NewSimulation returns a new *Simulation at time 0 with a heap event list,
+// without progress reports nor wall clock limit
+func NewSimulation() *Simulation {
+	m := &Simulation{progress: newProgress()}
 	m.eventChan = make(chan interface{})
-	m.pq = make(priorityQueue, 0)
+	m.quit = make(chan struct{})
+	m.pq = newEventList(HeapEventList)
 	m.queues = make(map[QueueInterface]bool)
 	m.blockedInQueues = make(map[QueueInterface]*list.List)
-	heap.Init(&m.pq)
 	return m
 }
 
-func (m *model) registerActor(a ActorInterface) {
-	a.init(m.eventChan)
-	m.actorCount++
-
+// registerActor binds a to the simulation. Actors registered before the run
+// are started by it, the others right away
+func (m *Simulation) registerActor(a ActorInterface) {
+	a.init(m)
+	if s, ok := a.(SimulationSetter); ok {
+		s.SetSimulation(m)
+	}
+	m.registered = append(m.registered, a)
+	if !m.running {
+		m.actors = append(m.actors, a)
+		return
+	}
 	go a.Run()
 }
 
-func (m *model) registerBlockEvent(e blockEventInterface) {
+func (m *Simulation) registerBlockEvent(e blockEventInterface) {
 	for _, q := range e.getQueues() {
 		if _, ok := m.blockedInQueues[q]; !ok {
 			m.blockedInQueues[q] = list.New()
@@ -144,14 +233,31 @@ func (m *model) registerBlockEvent(e blockEventInterface) {
 	}
 }
 
-func (m *model) getTime() float64 {
+func (m *Simulation) registerQueue(q QueueInterface) {
+	if m.queues[q] {
+		return
+	}
+	m.queues[q] = true
+	m.queueList = append(m.queueList, q)
+}
+
+func (m *Simulation) getTime() float64 {
 	return m.time
 }
 
-func (m *model) waitActor() {
+// schedule adds e to the event list. Events at the same time are processed in
+// the order they were scheduled, whatever the event list, so that runs are
+// reproducible
+func (m *Simulation) schedule(e timerEventInterface) {
+	m.seq++
+	e.setSeq(m.seq)
+	m.pq.push(e)
+}
+
+func (m *Simulation) waitActor() {
 	newEvent := <-m.eventChan
 	if timerE, ok := newEvent.(timerEvent); ok {
-		heap.Push(&m.pq, &timerE)
+		m.schedule(&timerE)
 		return
 	}
 	if blockE, ok := newEvent.(blockEvent); ok {
@@ -159,51 +265,59 @@ func (m *model) waitActor() {
 		return
 	}
 	if linkedE, ok := newEvent.(linkedEvent); ok {
-		heap.Push(&m.pq, &linkedE)
+		m.schedule(&linkedE)
 		m.registerBlockEvent(&linkedE)
 		return
 	}
+	if _, ok := newEvent.(doneEvent); ok {
+		return
+	}
+	if startE, ok := newEvent.(startEvent); ok {
+		m.registerActor(startE.actor)
+		m.waitActor()
+		startE.started <- 1
+		// wait till the registering actor adds event or blocks in queue
+		m.waitActor()
+		return
+	}
 }
 
-func (m *model) run(threshold float64) {
-	////wait for all actors to start and add an event or block on a queue
-	for i := 0; i < m.actorCount; i++ {
+func (m *Simulation) run(threshold float64) {
+	m.cutoff = threshold
+	m.progress.begin()
+	m.running = true
+	var starts []float64
+	if m.resumed != nil {
+		starts = m.restore()
+	}
+	// start the actors one at a time, in registration order, and wait for
+	// each one to add an event or block on a queue, so that only one runs at a
+	// time and the run is reproducible
+	for i := 0; i < len(m.actors); i++ {
+		if starts == nil {
+			go m.actors[i].Run()
+		} else if !m.startAt(m.actors[i], starts[i]) {
+			continue
+		}
 		m.waitActor()
 	}
+	m.actors = nil
 
 	//all actors started
-	for m.time < threshold {
-
-		for q := range m.queues {
-			if q.Len() == 0 {
-				continue
-			}
+	for (m.time < threshold || m.drain) && !m.stopped {
 
-			// Check if none is waiting for this active queue
-			if val, ok := m.blockedInQueues[q]; ok {
-				if val.Len() == 0 {
-					continue
-				}
-			} else {
-				continue
-			}
+		// the actors woken up may fill queues scanned already, e.g. a
+		// dispatcher feeding the queue of a core, so scan till none is
+		for m.wakeBlocked() && !m.stopped {
+		}
 
-			for e := m.blockedInQueues[q].Front(); e != nil && q.Len() > 0; e = e.Next() {
-				be := e.Value.(blockEventInterface)
-				// Remove the blockEvents for the rest of the queues if any
-				be.deactivateReplicas()
-
-				if linkedE, ok := e.Value.(*linkedEvent); ok {
-					heap.Remove(&m.pq, linkedE.timerEvent.idx)
-				}
-				be.getChannel() <- 1 // try to unblock
-				m.waitActor()
-				//m.blockedInQueues[q].Remove(e)
-			}
+		// no more events, every actor is either done or blocked
+		if m.pq.Len() == 0 || m.stopped {
+			break
 		}
 
 		// pick event and wake up process
-		e := heap.Pop(&m.pq).(timerEventInterface)
+		e := m.pq.pop()
 		m.time = e.getTime()
 
 		// if it's linked deactivate the blocked requests
@@ -214,36 +328,236 @@ func (m *model) run(threshold float64) {
 
 		// wait till process adds event or blocks in queue
 		m.waitActor()
+		m.progress.event(m)
 	}
+	m.running = false
+	// the actors are all blocked by now and never woken up again
+	close(m.quit)
 	for _, s := range m.bookkeeping {
 		s.PrintStats()
 	}
 }
 
-// InitSim initialises the simulation
-func InitSim() {
-	mdl = newModel()
+// startAt starts a resumed actor at the given time, NaN if it is done, and
+// returns whether it started
+func (m *Simulation) startAt(a ActorInterface, at float64) bool {
+	if math.IsNaN(at) {
+		return false
+	}
+	if d := at - m.time; d > 0 {
+		go func() {
+			a.actor().Wait(d)
+			a.Run()
+		}()
+		return true
+	}
+	go a.Run()
+	return true
 }
 
-// GetTime returns the current simulation time
-func GetTime() float64 {
-	return mdl.getTime()
+// wakeBlocked wakes the actors blocked on the non-empty queues up, one at a
+// time, and returns whether it woke any
+func (m *Simulation) wakeBlocked() bool {
+	woken := false
+	for _, q := range m.queueList {
+		if q.Len() == 0 {
+			continue
+		}
+
+		// Check if none is waiting for this active queue
+		if val, ok := m.blockedInQueues[q]; ok {
+			if val.Len() == 0 {
+				continue
+			}
+		} else {
+			continue
+		}
+
+		for e := m.blockedInQueues[q].Front(); e != nil && q.Len() > 0 && !m.stopped; e = e.Next() {
+			be := e.Value.(blockEventInterface)
+			// Remove the blockEvents for the rest of the queues if any
+			be.deactivateReplicas()
+
+			if linkedE, ok := e.Value.(*linkedEvent); ok {
+				m.pq.remove(linkedE)
+			}
+			be.getChannel() <- 1 // try to unblock
+			m.waitActor()
+			m.progress.event(m)
+			woken = true
+			//m.blockedInQueues[q].Remove(e)
+		}
+	}
+	return woken
 }
 
 // RegisterActor registers a specific simulation element.
-// All actors should be registered
-func RegisterActor(a ActorInterface) {
-	mdl.registerActor(a)
+// All actors should be registered. Actors can also be registered while the
+// simulation runs, e.g. to add cores, from the Run of another actor: they
+// start at the current time before it goes on. An actor deregisters by
+// calling Done and returning from its Run
+func (m *Simulation) RegisterActor(a ActorInterface) {
+	if m.running {
+		started := make(chan int)
+		m.eventChan <- startEvent{actor: a, started: started}
+		<-started
+		return
+	}
+	m.registerActor(a)
+}
+
+// RegisterQueue makes the simulation monitor a queue that is not the output
+// queue of any actor, e.g. a queue fed by a request drain
+func (m *Simulation) RegisterQueue(q QueueInterface) {
+	m.registerQueue(q)
+}
+
+// Run runs the simulation till the given threshold time and prints the
+// statistics. The actors still blocked at the end are shut down, so a
+// simulation runs only once
+func (m *Simulation) Run(threshold float64) {
+	m.run(threshold)
+}
+
+// GetTime returns the current simulation time
+func (m *Simulation) GetTime() float64 {
+	return m.time
+}
+
+// Stop ends the simulation as soon as the running actor blocks, as if the
+// threshold time had been reached, e.g. once enough requests completed.
+// It should be called by an actor
+func (m *Simulation) Stop() {
+	m.stopped = true
+}
+
+// Aborted returns whether the simulation was stopped because it exceeded its
+// wall clock limit, see SetWallLimit
+func (m *Simulation) Aborted() bool {
+	return m.progress.aborted
+}
+
+// SetEventList sets the future event set implementation of the simulation,
+// the heap by default. It should be called before registering any actor
+func (m *Simulation) SetEventList(kind EventList) {
+	m.eventList = kind
+	m.pq = newEventList(kind)
+}
+
+// Sibling returns a new simulation with the settings of m: its event list,
+// drain, statistics and information outputs, arrival recorder, tracer,
+// progress reports and wall clock limit, and a source of randomness seeded from the one
+// of m. It lets a
+// topology made of several runs, e.g. to compare two of them, run each on its
+// own simulation
+func (m *Simulation) Sibling() *Simulation {
+	s := NewSimulation()
+	s.SetEventList(m.eventList)
+	s.drain = m.drain
+	s.statsOutput = m.statsOutput
+	s.infoOutput = m.infoOutput
+	s.arrivals = m.arrivals
+	s.tracer = m.tracer
+	s.progress = newProgressOf(m.progress)
+	s.rng = rand.New(rand.NewSource(m.Rand().Int63()))
+	return s
+}
+
+// SetDrain makes the simulation drain the requests in flight at its threshold
+// time: the generators should stop once Draining, but the simulation goes on
+// until the processors served everything queued, i.e. until there are no more
+// events
+func (m *Simulation) SetDrain(d bool) {
+	m.drain = d
 }
 
-// Run runs the simulation for till the given threshold time
-func Run(threshold float64) {
-	mdl.run(threshold)
+// Draining returns whether the simulation is past its threshold and only
+// drains the requests in flight. It should be called by an actor
+func (m *Simulation) Draining() bool {
+	return m.drain && m.time >= m.cutoff
+}
+
+// Rand returns the source of randomness of the engine itself, e.g. to read a
+// random input queue. Unless set with SetRand it is seeded from the global
+// source the first time it is needed
+func (m *Simulation) Rand() *rand.Rand {
+	if m.rng == nil {
+		m.rng = rand.New(rand.NewSource(rand.Int63()))
+	}
+	return m.rng
+}
+
+// SetRand sets the source of randomness of the engine, e.g. a seeded one to
+// reproduce a simulation. The actors registered afterwards seed their own
+// sources from it
+func (m *Simulation) SetRand(rng *rand.Rand) {
+	m.rng, m.src = rng, nil
+}
+
+// NewID returns a new identifier, unique in the simulation, e.g. for a
+// request
+func (m *Simulation) NewID() int {
+	m.ids++
+	return m.ids
 }
 
 // InitStats sets the interface in charge of collecting statistics.
 // This is interface is called at the end of the simulation to print the
-// collected statistics
-func InitStats(s Stats) {
-	mdl.bookkeeping = append(mdl.bookkeeping, s)
+// collected statistics. If it implements OutputSetter it prints them to the
+// writer set with SetStatsOutput, and if it implements SimulationSetter it is
+// given the simulation
+func (m *Simulation) InitStats(s Stats) {
+	if o, ok := s.(OutputSetter); ok && m.statsOutput != nil {
+		o.SetOutput(m.statsOutput)
+	}
+	if ss, ok := s.(SimulationSetter); ok {
+		ss.SetSimulation(m)
+	}
+	m.bookkeeping = append(m.bookkeeping, s)
+}
+
+// SetStatsOutput sets the writer the statistics initialised afterwards are
+// printed to
+func (m *Simulation) SetStatsOutput(w io.Writer) {
+	m.statsOutput = w
+}
+
+// SetInfoOutput sets the writer the elements of the simulation print what they
+// report along the way to, e.g. their parameters and warnings, as opposed to
+// the statistics printed at the end
+func (m *Simulation) SetInfoOutput(w io.Writer) {
+	m.infoOutput = w
+}
+
+// InfoOutput returns the writer set with SetInfoOutput, os.Stdout by default
+func (m *Simulation) InfoOutput() io.Writer {
+	if m.infoOutput == nil {
+		return os.Stdout
+	}
+	return m.infoOutput
+}
+
+// SetArrivalRecorder makes the generators of the simulation record the
+// requests they create to r. nil disables recording, the default
+func (m *Simulation) SetArrivalRecorder(r ArrivalRecorder) {
+	m.arrivals = r
+}
+
+// RecordArrival records a request created at the given time with the given
+// service time to the arrival recorder, if any
+func (m *Simulation) RecordArrival(time, serviceTime float64) {
+	if m.arrivals != nil {
+		m.arrivals.RecordArrival(time, serviceTime)
+	}
+}
+
+// SetTracer makes the queues and processors of the simulation pass the
+// events of its requests to t. nil disables tracing, the default
+func (m *Simulation) SetTracer(t Tracer) {
+	m.tracer = t
+}
+
+// Tracer returns the tracer set with SetTracer, nil if tracing is disabled
+func (m *Simulation) Tracer() Tracer {
+	return m.tracer
 }
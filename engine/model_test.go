@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testReq struct {
+	arrival, service float64
+}
+
+func (r *testReq) GetDelay() float64        { return 0 }
+func (r *testReq) GetServiceTime() float64  { return r.service }
+func (r *testReq) SubServiceTime(t float64) { r.service -= t }
+
+type testQueue struct {
+	reqs []ReqInterface
+}
+
+func (q *testQueue) Enqueue(r ReqInterface) { q.reqs = append(q.reqs, r) }
+func (q *testQueue) Len() int               { return len(q.reqs) }
+func (q *testQueue) Dequeue() ReqInterface {
+	r := q.reqs[0]
+	q.reqs = q.reqs[1:]
+	return r
+}
+
+// testSource issues requests with exponential interarrival and service times
+// drawn from the source of randomness of its simulation
+type testSource struct {
+	Actor
+	lambda, mu float64
+}
+
+func (s *testSource) Run() {
+	rng := s.Simulation().Rand()
+	for {
+		s.Wait(rng.ExpFloat64() / s.lambda)
+		s.WriteOutQueue(&testReq{arrival: s.Now(), service: rng.ExpFloat64() / s.mu})
+	}
+}
+
+// testServer serves the requests in FIFO order and sums their delays
+type testServer struct {
+	Actor
+	count int
+	total float64
+}
+
+func (s *testServer) Run() {
+	for {
+		r := s.ReadInQueue().(*testReq)
+		s.Wait(r.service)
+		s.count++
+		s.total += s.Now() - r.arrival
+	}
+}
+
+// runMM1 runs an M/M/1 queue till duration and returns the number of
+// completed requests and their mean delay
+func runMM1(seed int64, lambda, mu, duration float64, kind EventList) (int, float64) {
+	sim := NewSimulation()
+	sim.SetRand(rand.New(rand.NewSource(seed)))
+	sim.SetEventList(kind)
+	q := &testQueue{}
+	src := &testSource{lambda: lambda, mu: mu}
+	src.AddOutQueue(q)
+	srv := &testServer{}
+	srv.AddInQueue(q)
+	sim.RegisterActor(srv)
+	sim.RegisterActor(src)
+	sim.Run(duration)
+	return srv.count, srv.total / float64(srv.count)
+}
+
+func TestSimulationMM1(t *testing.T) {
+	lambda, mu := 0.5, 1.0
+	count, mean := runMM1(1, lambda, mu, 2e5, HeapEventList)
+	if count < 9e4 {
+		t.Fatalf("only %v requests completed", count)
+	}
+	// the mean sojourn time of M/M/1 is 1/(mu-lambda)
+	if want := 1 / (mu - lambda); math.Abs(mean-want) > 0.05*want {
+		t.Errorf("mean delay %v, want %v", mean, want)
+	}
+}
+
+func TestSimulationReproducible(t *testing.T) {
+	for _, kind := range []EventList{HeapEventList, CalendarEventList} {
+		c1, m1 := runMM1(42, 0.8, 1, 1e4, kind)
+		c2, m2 := runMM1(42, 0.8, 1, 1e4, kind)
+		if c1 != c2 || m1 != m2 {
+			t.Errorf("event list %v: runs with the same seed differ: %v/%v and %v/%v", kind, c1, m1, c2, m2)
+		}
+	}
+}
+
+// Simulations running concurrently should give the results they give alone,
+// and should not race, see go test -race
+func TestSimulationsConcurrent(t *testing.T) {
+	const runs = 4
+	want := make([]float64, runs)
+	for i := range want {
+		_, want[i] = runMM1(int64(i+1), 0.8, 1, 1e4, HeapEventList)
+	}
+	got := make([]float64, runs)
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, got[i] = runMM1(int64(i+1), 0.8, 1, 1e4, HeapEventList)
+		}(i)
+	}
+	wg.Wait()
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("run %v: concurrent mean delay %v, alone %v", i, got[i], want[i])
+		}
+	}
+}
+
+// The actors still blocked at the end of a run should be shut down
+func TestSimulationShutsActorsDown(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		runMM1(int64(i), 0.8, 1, 1e3, HeapEventList)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%v goroutines left running, %v before the runs", n, before)
+	}
+}
+
+// testRelay forwards the requests of its input queue to its output queue
+type testRelay struct {
+	Actor
+}
+
+func (r *testRelay) Run() {
+	for {
+		r.WriteOutQueue(r.ReadInQueue())
+	}
+}
+
+// A request relayed to a queue scanned before the one it came from should be
+// served right away, not at the next event
+func TestSimulationRelayedRequest(t *testing.T) {
+	sim := NewSimulation()
+	sim.SetRand(rand.New(rand.NewSource(1)))
+	in, out := &testQueue{}, &testQueue{}
+	srv := &testServer{}
+	srv.AddInQueue(out)
+	relay := &testRelay{}
+	relay.AddInQueue(in)
+	relay.AddOutQueue(out)
+	src := &testSource{lambda: 0.01, mu: 1}
+	src.AddOutQueue(in)
+	sim.RegisterActor(srv)
+	sim.RegisterActor(relay)
+	sim.RegisterActor(src)
+	sim.Run(1e4)
+	// without any queueing the mean delay is the mean service time
+	if srv.count == 0 || srv.total/float64(srv.count) > 2 {
+		t.Errorf("mean delay %v of %v requests, want about 1", srv.total/float64(srv.count), srv.count)
+	}
+}
@@ -0,0 +1,34 @@
+package engine
+
+import "fmt"
+
+// Monitor is an actor that the simulation wakes every interval, starting at
+// time 0, to call sample, e.g. to record queue lengths as a time series
+// without piggybacking on requests. It stops when the simulation starts
+// draining, so that it does not keep it running
+type Monitor struct {
+	Actor
+	interval float64
+	sample   func()
+}
+
+// NewMonitor returns a new *Monitor calling sample every interval. It should
+// be registered like any actor
+func NewMonitor(interval float64, sample func()) *Monitor {
+	if interval <= 0 {
+		panic(fmt.Sprintf("Non positive monitor interval: %v", interval))
+	}
+	return &Monitor{interval: interval, sample: sample}
+}
+
+// Run is the main monitor loop
+func (m *Monitor) Run() {
+	for {
+		m.sample()
+		m.Wait(m.interval)
+		if m.sim.Draining() {
+			m.Done()
+			return
+		}
+	}
+}
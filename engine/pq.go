@@ -1,12 +1,78 @@
 package engine
 
+import (
+	"container/heap"
+	"fmt"
+)
+
+// eventList is the future event set of the simulation, ordered by time
+type eventList interface {
+	push(e timerEventInterface)
+	pop() timerEventInterface // removes and returns the earliest event
+	remove(e timerEventInterface)
+	Len() int
+}
+
+// EventList selects the implementation of the future event set. They are
+// equivalent: both process the events at the same time in the order they were
+// scheduled, so they only differ in speed
+type EventList int
+
+const (
+	// HeapEventList is a binary heap, the default
+	HeapEventList EventList = iota
+	// CalendarEventList is a calendar queue, whose operations take constant
+	// time on average, faster with many pending events, e.g. many cores
+	CalendarEventList
+)
+
+var eventListNames = []string{"heap", "calendar"}
+
+// MarshalText returns the name of the event list, heap or calendar
+func (l EventList) MarshalText() ([]byte, error) {
+	if l < 0 || int(l) >= len(eventListNames) {
+		return nil, fmt.Errorf("unknown event list: %d", int(l))
+	}
+	return []byte(eventListNames[l]), nil
+}
+
+// UnmarshalText sets the event list from its name, heap or calendar
+func (l *EventList) UnmarshalText(text []byte) error {
+	for i, name := range eventListNames {
+		if name == string(text) {
+			*l = EventList(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown event list: %s", text)
+}
+
+// eventBefore returns whether a should be processed before b, i.e. a is
+// earlier or scheduled first at the same time
+func eventBefore(a, b timerEventInterface) bool {
+	if a.getTime() != b.getTime() {
+		return a.getTime() < b.getTime()
+	}
+	return a.getSeq() < b.getSeq()
+}
+
+// newEventList returns an empty event list of the given kind
+func newEventList(kind EventList) eventList {
+	if kind == CalendarEventList {
+		return newCalendarQueue()
+	}
+	pq := make(priorityQueue, 0)
+	heap.Init(&pq)
+	return &pq
+}
+
 // timerEvent pointer because we change the index
 type priorityQueue []timerEventInterface
 
 func (pq priorityQueue) Len() int { return len(pq) }
 
 func (pq priorityQueue) Less(i, j int) bool {
-	return pq[i].getTime() < pq[j].getTime() // greater time - less priority
+	return eventBefore(pq[i], pq[j]) // greater time - less priority
 }
 
 func (pq priorityQueue) Swap(i, j int) {
@@ -29,3 +95,15 @@ func (pq *priorityQueue) Pop() interface{} {
 	*pq = old[0 : n-1]
 	return event
 }
+
+func (pq *priorityQueue) push(e timerEventInterface) {
+	heap.Push(pq, e)
+}
+
+func (pq *priorityQueue) pop() timerEventInterface {
+	return heap.Pop(pq).(timerEventInterface)
+}
+
+func (pq *priorityQueue) remove(e timerEventInterface) {
+	heap.Remove(pq, e.getIdx())
+}
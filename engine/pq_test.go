@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// newTestEvent returns a timer event at time t scheduled as the seq-th one
+func newTestEvent(t float64, seq uint64) *timerEvent {
+	e := &timerEvent{time: t}
+	e.setSeq(seq)
+	return e
+}
+
+// Both event lists should process the events in the same order, by time and
+// then by scheduling order, whatever the pushes, pops and removals
+func TestEventListsSameOrder(t *testing.T) {
+	heapList, calendar := newEventList(HeapEventList), newEventList(CalendarEventList)
+	rng := rand.New(rand.NewSource(1))
+	var pending [2][]timerEventInterface
+	var seq uint64
+	now := 0.0
+	for i := 0; i < 3e4; i++ {
+		switch op := rng.Intn(10); {
+		case op < 6 || heapList.Len() == 0:
+			// round the times to get ties
+			at := now + math.Round(rng.ExpFloat64()*4)/4
+			seq++
+			for j, l := range []eventList{heapList, calendar} {
+				e := newTestEvent(at, seq)
+				l.push(e)
+				pending[j] = append(pending[j], e)
+			}
+		case op < 9:
+			h, c := heapList.pop(), calendar.pop()
+			if h.getTime() != c.getTime() || h.getSeq() != c.getSeq() {
+				t.Fatalf("pop %v: (%v, %v) from the heap, (%v, %v) from the calendar",
+					i, h.getTime(), h.getSeq(), c.getTime(), c.getSeq())
+			}
+			if h.getTime() < now {
+				t.Fatalf("pop %v: time %v before %v", i, h.getTime(), now)
+			}
+			now = h.getTime()
+			for j, e := range []timerEventInterface{h, c} {
+				pending[j] = removeEvent(pending[j], e)
+			}
+		default:
+			k := rng.Intn(len(pending[0]))
+			heapList.remove(pending[0][k])
+			calendar.remove(pending[1][k])
+			for j := range pending {
+				pending[j] = append(pending[j][:k], pending[j][k+1:]...)
+			}
+		}
+		if heapList.Len() != calendar.Len() {
+			t.Fatalf("operation %v: %v events in the heap, %v in the calendar", i, heapList.Len(), calendar.Len())
+		}
+	}
+}
+
+// removeEvent removes e from events, keeping the order of the others
+func removeEvent(events []timerEventInterface, e timerEventInterface) []timerEventInterface {
+	for i := range events {
+		if events[i] == e {
+			return append(events[:i], events[i+1:]...)
+		}
+	}
+	return events
+}
+
+// benchmarkEventList holds a constant number of pending events, popping the
+// earliest one and scheduling a new one after it at every step, as a
+// simulation with that many cores would
+func benchmarkEventList(b *testing.B, kind EventList, pending int) {
+	l := newEventList(kind)
+	rng := rand.New(rand.NewSource(1))
+	var seq uint64
+	for ; seq < uint64(pending); seq++ {
+		l.push(newTestEvent(rng.ExpFloat64(), seq))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e := l.pop().(*timerEvent)
+		seq++
+		e.time += rng.ExpFloat64()
+		e.setSeq(seq)
+		l.push(e)
+	}
+}
+
+func BenchmarkEventListHeap(b *testing.B) {
+	for _, pending := range []int{16, 1024, 65536} {
+		b.Run(strconv.Itoa(pending), func(b *testing.B) {
+			benchmarkEventList(b, HeapEventList, pending)
+		})
+	}
+}
+
+func BenchmarkEventListCalendar(b *testing.B) {
+	for _, pending := range []int{16, 1024, 65536} {
+		b.Run(strconv.Itoa(pending), func(b *testing.B) {
+			benchmarkEventList(b, CalendarEventList, pending)
+		})
+	}
+}
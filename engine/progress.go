@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// progressCheck is the number of events between two looks at the wall clock
+const progressCheck = 1024
+
+// progress reports periodically how far a simulation is, to tell a slow
+// simulation from a stuck one, and aborts it past its wall clock limit
+type progress struct {
+	interval time.Duration
+	limit    time.Duration
+	aborted  bool
+	w        io.Writer
+	start    time.Time
+	last     time.Time // time of the last report
+	events   int       // events processed since the start
+	reported int       // events processed at the last report
+}
+
+// SetProgress makes the simulation print a progress line to stderr every
+// interval of wall clock time. 0 disables it, the default. It should be called
+// before the run
+func (m *Simulation) SetProgress(interval time.Duration) {
+	m.progress.interval = interval
+}
+
+// SetWallLimit makes the simulation stop as if its threshold time had been
+// reached once it ran for limit of wall clock time, e.g. an unstable
+// configuration whose queues keep growing. Its statistics are printed as
+// usual, but only cover the time simulated so far. 0 disables the limit, the
+// default. It should be called before the run
+func (m *Simulation) SetWallLimit(limit time.Duration) {
+	m.progress.limit = limit
+}
+
+// begin starts measuring the wall clock time
+func (p *progress) begin() {
+	p.start = time.Now()
+	p.last = p.start
+}
+
+// event counts an event and reports the progress of m if it is time to
+func (p *progress) event(m *Simulation) {
+	p.events++
+	if (p.interval <= 0 && p.limit <= 0) || p.events%progressCheck != 0 {
+		return
+	}
+	now := time.Now()
+	if p.limit > 0 && now.Sub(p.start) > p.limit {
+		fmt.Fprintf(p.w, "WARNING: wall clock limit of %v exceeded at time %v, the statistics are partial\n", p.limit, m.time)
+		p.aborted = true
+		m.Stop()
+		return
+	}
+	if p.interval <= 0 || now.Sub(p.last) < p.interval {
+		return
+	}
+	rate := float64(p.events-p.reported) / now.Sub(p.last).Seconds()
+	queued, longest := 0, 0
+	for _, q := range m.queueList {
+		queued += q.Len()
+		if q.Len() > longest {
+			longest = q.Len()
+		}
+	}
+	fmt.Fprintf(p.w, "progress: sim_time:%v\twall_time:%v\tevents:%v\tevents_per_sec:%.0f\tqueued:%v\tlongest_queue:%v\n",
+		m.time, now.Sub(p.start).Round(time.Millisecond), p.events, rate, queued, longest)
+	p.last, p.reported = now, p.events
+}
+
+func newProgress() progress {
+	return progress{w: os.Stderr}
+}
+
+// newProgressOf returns a new progress with the settings of p
+func newProgressOf(p progress) progress {
+	return progress{interval: p.interval, limit: p.limit, w: p.w}
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStats records whether the statistics were printed and the time then
+type testStats struct {
+	sim     *Simulation
+	printed bool
+	at      float64
+}
+
+func (s *testStats) SetSimulation(sim *Simulation) { s.sim = sim }
+func (s *testStats) PrintStats() {
+	s.printed, s.at = true, s.sim.GetTime()
+}
+
+// A tiny wall clock limit aborts the simulation long before its threshold,
+// warns on the writer of the progress and still prints the statistics
+func TestWallLimitAborts(t *testing.T) {
+	sim := NewSimulation()
+	sim.SetRand(rand.New(rand.NewSource(1)))
+	sim.SetWallLimit(time.Nanosecond)
+	var w bytes.Buffer
+	sim.progress.w = &w
+	stats := &testStats{}
+	sim.InitStats(stats)
+	q := &testQueue{}
+	src := &testSource{lambda: 0.5, mu: 1}
+	src.AddOutQueue(q)
+	srv := &testServer{}
+	srv.AddInQueue(q)
+	sim.RegisterActor(srv)
+	sim.RegisterActor(src)
+	sim.Run(1e12)
+
+	if !sim.Aborted() {
+		t.Fatal("not aborted")
+	}
+	if !stats.printed || stats.at >= 1e12 {
+		t.Errorf("statistics printed %v at %v, want the partial ones", stats.printed, stats.at)
+	}
+	if srv.count == 0 {
+		t.Error("no request completed before the abort")
+	}
+	if !strings.HasPrefix(w.String(), "WARNING: wall clock limit of 1ns exceeded") {
+		t.Errorf("unexpected warning %q", w.String())
+	}
+}
+
+func TestWallLimitUnset(t *testing.T) {
+	sim := NewSimulation()
+	var w bytes.Buffer
+	sim.progress.w = &w
+	q := &testQueue{}
+	src := &testSource{lambda: 0.5, mu: 1}
+	src.AddOutQueue(q)
+	srv := &testServer{}
+	srv.AddInQueue(q)
+	sim.RegisterActor(srv)
+	sim.RegisterActor(src)
+	sim.Run(1e4)
+	if sim.Aborted() || w.Len() > 0 {
+		t.Errorf("aborted %v without a limit, printed %q", sim.Aborted(), w.String())
+	}
+}
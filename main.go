@@ -1,14 +1,23 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/epfl-dcsl/schedsim/blocks"
+	"github.com/epfl-dcsl/schedsim/engine"
 	"github.com/epfl-dcsl/schedsim/topologies"
 )
 
-func GetWorkloadPath(wl string) string {
-	fmt.Printf("GetWorkloadPath(): Workload: %v\n", wl)
+func GetWorkloadPath(info io.Writer, wl string) string {
+	fmt.Fprintf(info, "GetWorkloadPath(): Workload: %v\n", wl)
 	switch wl {
 	case "":
 		return ""
@@ -27,6 +36,362 @@ func GetWorkloadPath(wl string) string {
 	}
 }
 
+// ParseSpeedSchedule parses a comma separated list of startTime:speed segments
+func ParseSpeedSchedule(schedule string) []blocks.SpeedSegment {
+	var res []blocks.SpeedSegment
+	if schedule == "" {
+		return res
+	}
+	for _, seg := range strings.Split(schedule, ",") {
+		fields := strings.Split(seg, ":")
+		if len(fields) != 2 {
+			panic("Invalid speed segment: " + seg)
+		}
+		start, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			panic("Invalid speed segment start: " + seg)
+		}
+		speed, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			panic("Invalid speed segment speed: " + seg)
+		}
+		res = append(res, blocks.SpeedSegment{Start: start, Speed: speed})
+	}
+	return res
+}
+
+// ParseCoreSpeeds parses a comma separated list of core speeds relative to the
+// reference core, e.g. 2,1,1
+func ParseCoreSpeeds(speeds string) []float64 {
+	var res []float64
+	if speeds == "" {
+		return res
+	}
+	for _, s := range strings.Split(speeds, ",") {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			panic("Invalid core speed: " + s)
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+// ParseMMPPTransitions parses the MMPP transition rates given as rows
+// separated by / of comma separated rates, e.g. 0,0.001/0.01,0
+func ParseMMPPTransitions(transitions string) [][]float64 {
+	var res [][]float64
+	if transitions == "" {
+		return res
+	}
+	for _, row := range strings.Split(transitions, "/") {
+		var rates []float64
+		for _, r := range strings.Split(row, ",") {
+			v, err := strconv.ParseFloat(r, 64)
+			if err != nil {
+				panic("Invalid MMPP transition rate: " + r)
+			}
+			rates = append(rates, v)
+		}
+		res = append(res, rates)
+	}
+	return res
+}
+
+// CheckSLO returns whether the 99th percentile delay is within slo99, telling
+// info about the violation otherwise
+func CheckSLO(info io.Writer, stats *blocks.AllKeeper, slo99 float64) bool {
+	if stats.Count() == 0 {
+		fmt.Fprintf(info, "SLO violation: no completed requests\n")
+		return false
+	}
+	if p99 := stats.Percentile(0.99); p99 > slo99 {
+		fmt.Fprintf(info, "SLO violation: 99th percentile %v > %v\n", p99, slo99)
+		return false
+	}
+	return true
+}
+
+// ParseDAG parses a DAG template given as semicolon separated tasks, each one
+// as serviceTime or serviceTime:pred1,pred2,...
+func ParseDAG(dag string) blocks.DAGTemplate {
+	var res blocks.DAGTemplate
+	for _, task := range strings.Split(dag, ";") {
+		fields := strings.Split(task, ":")
+		if len(fields) > 2 {
+			panic("Invalid DAG task: " + task)
+		}
+		st, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			panic("Invalid DAG task service time: " + task)
+		}
+		deps := []int{}
+		if len(fields) == 2 {
+			for _, d := range strings.Split(fields[1], ",") {
+				idx, err := strconv.Atoi(d)
+				if err != nil {
+					panic("Invalid DAG task dependency: " + task)
+				}
+				deps = append(deps, idx)
+			}
+		}
+		res.ServiceTimes = append(res.ServiceTimes, st)
+		res.Deps = append(res.Deps, deps)
+	}
+	return res
+}
+
+// ParsePercentiles parses a comma separated list of percentiles, e.g. 0.99
+func ParsePercentiles(percentiles string) []float64 {
+	var res []float64
+	for _, p := range strings.Split(percentiles, ",") {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			panic("Invalid percentile: " + p)
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+// ParseCDFMix parses a comma separated list of workload:weight pairs into the
+// CDF paths and weights of a mixture, telling info about the workloads
+func ParseCDFMix(info io.Writer, mix string) ([]string, []float64) {
+	var paths []string
+	var weights []float64
+	if mix == "" {
+		return paths, weights
+	}
+	for _, entry := range strings.Split(mix, ",") {
+		fields := strings.Split(entry, ":")
+		if len(fields) != 2 {
+			panic("Invalid CDF mixture entry: " + entry)
+		}
+		w, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			panic("Invalid CDF mixture weight: " + entry)
+		}
+		paths = append(paths, GetWorkloadPath(info, fields[0]))
+		weights = append(weights, w)
+	}
+	return paths, weights
+}
+
+// ParseRateProfile parses a comma separated list of time:rate points of a
+// piecewise linear arrival rate profile
+func ParseRateProfile(profile string) []blocks.RatePoint {
+	var res []blocks.RatePoint
+	if profile == "" {
+		return res
+	}
+	for _, point := range strings.Split(profile, ",") {
+		fields := strings.Split(point, ":")
+		if len(fields) != 2 {
+			panic("Invalid rate point: " + point)
+		}
+		t, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			panic("Invalid rate point time: " + point)
+		}
+		rate, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			panic("Invalid rate point rate: " + point)
+		}
+		res = append(res, blocks.RatePoint{Time: t, Rate: rate})
+	}
+	return res
+}
+
+// ParsePhases parses a comma separated list of probability:mean phases of a
+// hyperexponential distribution, e.g. 0.9:1,0.1:100
+func ParsePhases(phases string) ([]float64, []float64) {
+	var probs, means []float64
+	if phases == "" {
+		return probs, means
+	}
+	for _, phase := range strings.Split(phases, ",") {
+		fields := strings.Split(phase, ":")
+		if len(fields) != 2 {
+			panic("Invalid phase: " + phase)
+		}
+		p, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			panic("Invalid phase probability: " + phase)
+		}
+		m, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			panic("Invalid phase mean: " + phase)
+		}
+		probs = append(probs, p)
+		means = append(means, m)
+	}
+	return probs, means
+}
+
+// classFlags collects the extra request classes given as repeated
+// lambda:genType:mu flags
+type classFlags []topologies.ClassSpec
+
+func (c *classFlags) String() string {
+	return fmt.Sprint(*c)
+}
+
+// Set parses and adds a lambda:genType:mu class
+func (c *classFlags) Set(value string) error {
+	fields := strings.Split(value, ":")
+	if len(fields) != 3 {
+		return fmt.Errorf("class should be lambda:genType:mu, got %v", value)
+	}
+	lambda, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return fmt.Errorf("invalid class lambda: %v", value)
+	}
+	genType, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("invalid class genType: %v", value)
+	}
+	mu, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return fmt.Errorf("invalid class mu: %v", value)
+	}
+	*c = append(*c, topologies.ClassSpec{Lambda: lambda, GenType: genType, Mu: mu})
+	return nil
+}
+
+// GetEventList returns the engine event list with the given name
+func GetEventList(name string) engine.EventList {
+	var res engine.EventList
+	if err := res.UnmarshalText([]byte(name)); err != nil {
+		panic(err.Error())
+	}
+	return res
+}
+
+// GetShedPolicy returns the shedding policy with the given name
+func GetShedPolicy(policy string) blocks.ShedPolicy {
+	var res blocks.ShedPolicy
+	if err := res.UnmarshalText([]byte(policy)); err != nil {
+		panic(err.Error())
+	}
+	return res
+}
+
+// GetBatchService returns the batch service time with the given name
+func GetBatchService(service string) blocks.BatchService {
+	var res blocks.BatchService
+	if err := res.UnmarshalText([]byte(service)); err != nil {
+		panic(err.Error())
+	}
+	return res
+}
+
+// GetBulkDist returns the bulk size distribution with the given name
+func GetBulkDist(dist string) blocks.BulkDist {
+	var res blocks.BulkDist
+	if err := res.UnmarshalText([]byte(dist)); err != nil {
+		panic(err.Error())
+	}
+	return res
+}
+
+// GetDispatchPolicy returns the dispatch policy of the multi queue generator
+func GetDispatchPolicy(policy string) blocks.DispatchPolicy {
+	var res blocks.DispatchPolicy
+	if err := res.UnmarshalText([]byte(policy)); err != nil {
+		panic(err.Error())
+	}
+	return res
+}
+
+// OpenOutput opens the file the statistics are written to. An empty path
+// keeps them on stdout
+func OpenOutput(path string) (*os.File, error) {
+	if path == "" {
+		return os.Stdout, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create output file: %v", err)
+	}
+	return f, nil
+}
+
+// OpenTrace creates the file at path and returns a tracer writing the request
+// events to it as CSV, and the function flushing and closing the trace
+func OpenTrace(path string) (engine.Tracer, func() error, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create trace file: %v", err)
+	}
+	t := blocks.NewTraceWriter(f)
+	return t, func() error {
+		if err := t.Flush(); err != nil {
+			return err
+		}
+		return f.Close()
+	}, nil
+}
+
+// OpenChromeTrace creates the file at path and returns a tracer writing the
+// schedule of the processors to it in the Chrome trace event format, and the
+// function completing the trace at the given end time and closing it
+func OpenChromeTrace(path string) (engine.Tracer, func(end float64) error, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create chrome trace file: %v", err)
+	}
+	t := blocks.NewChromeTraceWriter(f)
+	return t, func(end float64) error {
+		if err := t.Close(end); err != nil {
+			return err
+		}
+		return f.Close()
+	}, nil
+}
+
+// OpenArrivalRecord creates the file at path and returns a recorder writing
+// every request created to it, in the format of the arrival traces, and the
+// function flushing and closing it
+func OpenArrivalRecord(path string) (*blocks.ArrivalRecorder, func() error, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create arrival record: %v", err)
+	}
+	r := blocks.NewArrivalRecorder(f)
+	return r, func() error {
+		if err := r.Flush(); err != nil {
+			return err
+		}
+		return f.Close()
+	}, nil
+}
+
+// ReplicationPath returns the path of the file of replication i, path with i
+// before its extension, e.g. arrivals.3.txt for arrivals.txt
+func ReplicationPath(path string, i int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%v.%v%v", strings.TrimSuffix(path, ext), i, ext)
+}
+
+// exitIfAborted exits with status 1 if a simulation exceeded the wall clock
+// limit, so that sweep scripts can tell partial statistics apart
+func exitIfAborted(aborted bool) {
+	if aborted {
+		fmt.Fprintln(os.Stderr, "simulation aborted: wall clock limit exceeded")
+		os.Exit(1)
+	}
+}
+
+// WriteSummary writes the summary of the statistics of s to w as indented
+// JSON, along with the seed of the run
+func WriteSummary(w io.Writer, s blocks.Summarizer, seed int64) error {
+	summary := s.Summary()
+	summary["seed"] = seed
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(summary)
+}
+
 func main() {
 	var topo = flag.Int("topo", 0, "topology selector")
 	var mu = flag.Float64("mu", 0.02, "mu service rate [reqs/us]") // default 50usec
@@ -34,26 +399,331 @@ func main() {
 	var genType = flag.Int("genType", 0, "type of generator")
 	var procType = flag.Int("procType", 0, "type of processor")
 	var duration = flag.Float64("duration", 10000000, "experiment duration [us]")
+	var classStats = flag.Bool("classStats", false, "break the main statistics down per request color (topo 2)")
+	var timeSeries = flag.Float64("timeSeries", 0.0, "window of the completions time series, 0 disables it (topo 0) [us]")
+	var samplePeriod = flag.Float64("samplePeriod", 0.0, "time between samples of the queue length, 0 disables them (topo 0) [us]")
+	var maxReqs = flag.Int("maxReqs", 0, "stop once that many requests completed after the warmup, 0 only stops at the duration")
+	var drain = flag.Bool("drain", false, "stop the arrivals at the duration but keep serving the requests in flight")
+	var eventList = flag.String("eventList", "heap", "future event set of the engine: heap, or calendar for a calendar queue, faster with many cores")
+	var warmup = flag.Float64("warmup", 0.0, "initial period whose terminated requests are left out of the statistics [us]")
 	var bufferSize = flag.Int("buffersize", 1, "size of the bounded buffer")
+	var queueCap = flag.Int("queueCap", 0, "capacity of the input queue dropping arrivals when full, 0 is unbounded (topo 2)")
 	var quantum = flag.Float64("quantum", 10.0, "time sharing processor quantum [us]")
 	var cores = flag.Int("cores", 1, "number of processor cores")
+	var mmppRates = flag.String("mmppRates", "", "comma separated arrival rates of the MMPP states (genType 10) [reqs/us]")
+	var mmppTransitions = flag.String("mmppTransitions", "", "MMPP transition rates as / separated rows of comma separated rates [1/us]")
+	var maxBatch = flag.Int("maxBatch", 8, "maximum number of requests per batch (procType 14)")
+	var maxWait = flag.Float64("maxWait", 0.0, "time a batch waits for more requests once it has one [us]")
+	var batchOverhead = flag.Float64("batchOverhead", 0.0, "fixed cost paid once per batch [us]")
+	var batchService = flag.String("batchService", "max", "service time of a batch, the max or sum of its requests")
+	var bulkSize = flag.Float64("bulkSize", 1.0, "mean number of requests issued at once at every arrival (topo 0)")
+	var bulkDist = flag.String("bulkDist", "fixed", "distribution of the number of requests of every arrival, fixed or geometric")
+	var gangWidth = flag.Int("gangWidth", 2, "number of cores a gang request needs at once (procType 15)")
+	var gangRatio = flag.Float64("gangRatio", 0.1, "fraction of gang requests, the others need a single core (procType 15)")
+	var numaNodes = flag.Int("numaNodes", 1, "number of NUMA nodes the cores are split over (topo 0, 1)")
+	var transferCost = flag.Float64("transferCost", 0.0, "cost of dequeuing a request from a queue of another NUMA node [us]")
 	var ctxCost = flag.Float64("ctxCost", 0.0, "absolute context switch cost [us]")
+	var coreSpeeds = flag.String("coreSpeeds", "", "comma separated speeds of the first cores relative to the reference one (procType 0)")
 	var cdfWorkload = flag.String("cdfWorkload", "", "path to CDF workload file to draw processing times")
+	var paretoAlpha = flag.Float64("paretoAlpha", 1.1, "shape of the bounded (genType 8) and unbounded (genType 11) Pareto service times")
+	var weibullShape = flag.Float64("weibullShape", 0.5, "shape of the Weibull service times (genType 12)")
+	var gammaShape = flag.Float64("gammaShape", 2, "shape of the gamma service times, Erlang for an integer (genType 13)")
+	var paretoRange = flag.Float64("paretoRange", 1e5, "ratio of the largest to the smallest bounded Pareto service time (genType 8)")
+	var cdfScale = flag.Float64("cdfScale", blocks.DefaultCDFScale, "divisor converting CDF workload sizes to service times (genType 5, 7)")
+	var phases = flag.String("phases", "", "hyperexponential phases as probability:mean,..., the means being scaled to 1/mu (genType 14)")
+	var zipfKeys = flag.Int("zipfKeys", 1000, "number of keys the requests reference (genType 16)")
+	var zipfExponent = flag.Float64("zipfExponent", 0.99, "exponent of the Zipf distribution of the keys (genType 16)")
+	var lambdaProfile = flag.String("lambdaProfile", "", "piecewise linear arrival rate profile as time:rate,... (genType 15) [us:reqs/us]")
+	var lambdaPeriod = flag.Float64("lambdaPeriod", 0.0, "period of the sinusoidal arrival rate around lambda without a lambdaProfile (genType 15) [us]")
+	var lambdaAmplitude = flag.Float64("lambdaAmplitude", 0.5, "relative amplitude of the sinusoidal arrival rate, in [0, 1] (genType 15)")
+	var cdfMix = flag.String("cdfMix", "", "weighted mixture of CDF workloads as workload:weight,... (genType 7)")
+	var arrivalTrace = flag.String("arrivalTrace", "", "path to a trace of arrivalTime serviceTime (or CSV arrivalTime,serviceTime) lines to replay (genType 9)")
+	var clusterTrace = flag.String("clusterTrace", "", "path to an Alibaba batch_task.csv cluster trace to replay (genType 6)")
+
+	var propDelay = flag.Float64("propDelay", 0.0, "propagation delay between the generator and the queue [us]")
+	var returnDelay = flag.Float64("returnDelay", 0.0, "propagation delay between completion and termination [us]")
+	var speedSchedule = flag.String("speedSchedule", "", "processor speed schedule as startTime:speed,... (procType 5)")
+	var alpha = flag.Float64("alpha", 1.0, "blended priority weight, 1 is SRPT and 0 is EDF (procType 6)")
+	var deadlineSlack = flag.Float64("deadlineSlack", 100.0, "request deadline relative to its arrival (procType 6, 11) [us]")
+	var timeout = flag.Float64("timeout", 1000.0, "client timeout aborting requests even in service (procType 7) [us]")
+	var scaleTime = flag.Float64("scaleTime", 0.0, "time of the scale event (procType 8) [us]")
+	var scaleCores = flag.Int("scaleCores", 0, "number of cores added (positive) or removed (negative) at the scale event (procType 8)")
+	var dag = flag.String("dag", "10;10:0;10:0;10:1,2", "DAG job template as serviceTime:pred1,pred2;... (topo 3)")
+	var sharedQueue = flag.Bool("sharedQueue", false, "add a shared overflow queue served after the per core queues (topo 1, procType 0)")
+	var dispatch = flag.String("dispatch", "random", "queue every request is dispatched to: random, rr, jsq or po2 (topo 1, 5)")
+	var machines = flag.Int("machines", 2, "number of machines of the cluster, each with cores cores (topo 5)")
+	var steal = flag.Bool("steal", false, "idle cores steal from a random non-empty sibling queue (topo 1, procType 0)")
+	var aging = flag.Float64("aging", 0.0, "priority gained per us waited by the queued requests, 0 disables aging (procType 3, 11)")
+	var highPrio = flag.Float64("highPrio", 0.1, "fraction of high priority requests (procType 13)")
+	var valueCorr = flag.Float64("valueCorr", 0.0, "exponent correlating request values with service times (procType 9)")
+	var shedThreshold = flag.Int("shedThreshold", 0, "queue length over which requests are shed, 0 disables shedding (topo 0)")
+	var shedPolicy = flag.String("shedPolicy", "newest", "request shed when over the threshold: newest or biggest")
+	var busyPower = flag.Float64("busyPower", 0.0, "core power when busy, enables the energy report (topo 0)")
+	var idlePower = flag.Float64("idlePower", 0.0, "core power when idle")
+	var sleepPower = flag.Float64("sleepPower", 0.0, "core power when asleep")
+	var sleepAfter = flag.Float64("sleepAfter", 0.0, "idle time after which a core sleeps, 0 never sleeps (topo 0)")
+	var wakeupCost = flag.Float64("wakeupCost", 0.0, "time a sleeping core takes to wake up before serving a request")
+	var maxRetries = flag.Int("maxRetries", 0, "times a timed out or shed request is retried, 0 disables retries (topo 0)")
+	var retryBackoff = flag.Float64("retryBackoff", 100.0, "wait before the first retry, doubled at every following one [us]")
+	var rateLimit = flag.Float64("rateLimit", 0.01, "global rate limit shared by all cores (procType 10) [reqs/us]")
+	var rateBurst = flag.Float64("rateBurst", 1.0, "tokens the global rate limiter can accumulate (procType 10)")
+	var acfLags = flag.Int("acfLags", 0, "report the delay autocorrelation up to this lag, 0 disables the report (topo 0)")
+	var slo = flag.Float64("slo", 0.0, "delay target whose violations are reported, 0 disables the report (topo 0) [us]")
+	var shed = flag.Bool("shed", false, "cores shed the requests that cannot meet their deadline or the slo anymore (topo 0, procType 0, 9, 11)")
+	var slo99 = flag.Float64("slo99", 0.0, "exit with an error if the 99th percentile delay exceeds it, 0 disables the check [us]")
+	var clients = flag.Int("clients", 10, "number of closed loop clients (topo 4, or topo 0 with closedLoop)")
+	var closedLoop = flag.Bool("closedLoop", false, "closed loop clients instead of open loop arrivals (topo 0)")
+	var thinkTime = flag.Float64("thinkTime", 1000.0, "mean exponential think time of the closed loop clients (topo 0) [us]")
+	var percentiles = flag.String("percentiles", "0.5,0.9,0.95,0.99", "comma separated delay percentiles to report")
+	var wallLimit = flag.Float64("wallLimit", 0.0, "abort a simulation after that many seconds of wall clock time, printing its partial statistics and exiting with status 1, 0 disables the limit")
+	var progress = flag.Float64("progress", 0.0, "print a progress line to stderr every that many seconds of wall clock time, 0 disables it")
+	var batches = flag.Int("batches", 0, "number of batches of the batch means confidence intervals of the mean delay and slowdown, 0 disables them")
+	var output = flag.String("output", "", "file the statistics are written to instead of stdout")
+	var format = flag.String("format", "text", "format of the statistics: text, or json for a summary of the main ones")
+	var seed = flag.Int64("seed", 0, "random seed, 0 picks one from the current time")
+	var classes classFlags
+	flag.Var(&classes, "class", "extra request class as lambda:genType:mu, can be repeated (topo 0)")
+	var tracePath = flag.String("trace", "", "CSV file the request events are traced to, empty disables tracing")
+	var chromeTrace = flag.String("chromeTrace", "", "JSON file the schedule of every core is traced to in the Chrome trace event format, empty disables it")
+	var recordArrivals = flag.String("recordArrivals", "", "file the arrival and service time of every request are recorded to, to replay them with genType 9")
+	var replications = flag.Int("replications", 1, "number of independent replications, more than 1 reports 95% confidence intervals")
+	var config = flag.String("config", "", "JSON file describing the simulation, overriding the other flags")
+	var checkpoint = flag.String("checkpoint", "", "file the state of the simulation is saved to once over, to go on with resume (topo 0, procType 0)")
+	var resume = flag.String("resume", "", "checkpoint file the simulation goes on from, with its config up to the duration, and its seed unless one is given")
 
 	flag.Parse()
 
-	var path = GetWorkloadPath(*cdfWorkload)
-	fmt.Printf("Workload path: %v\n", path)
+	if *format != "text" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "unknown format:", *format)
+		os.Exit(1)
+	}
+
+	out, err := OpenOutput(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer out.Close()
+	// the json summary is the only output on stdout, whatever else is printed
+	// along the way, e.g. the parameters and warnings, goes to stderr
+	var info io.Writer = os.Stdout
+	if *format == "json" {
+		info = os.Stderr
+	}
+	// the traces and the arrival records are closed explicitly, before any
+	// exit, at the end time of their simulation
+	finishTrace := func() {}
+	// traceOf makes sim trace its requests to path and chromePath, if tracing
+	traceOf := func(sim *engine.Simulation, path, chromePath string) {
+		var tracers blocks.MultiTracer
+		if *tracePath != "" {
+			t, closeTrace, err := OpenTrace(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			tracers = append(tracers, t)
+			trace := finishTrace
+			finishTrace = func() {
+				trace()
+				if err := closeTrace(); err != nil {
+					fmt.Fprintln(os.Stderr, "cannot write trace:", err)
+				}
+			}
+		}
+		if *chromeTrace != "" {
+			t, closeTrace, err := OpenChromeTrace(chromePath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			tracers = append(tracers, t)
+			trace := finishTrace
+			finishTrace = func() {
+				trace()
+				if err := closeTrace(sim.GetTime()); err != nil {
+					fmt.Fprintln(os.Stderr, "cannot write chrome trace:", err)
+				}
+			}
+		}
+		if len(tracers) > 0 {
+			sim.SetTracer(tracers)
+		}
+	}
+	// recordArrivalsOf makes sim record its arrivals to path, if recording
+	recordArrivalsOf := func(sim *engine.Simulation, path string) {
+		if *recordArrivals == "" {
+			return
+		}
+		r, closeRecord, err := OpenArrivalRecord(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sim.SetArrivalRecorder(r)
+		trace := finishTrace
+		finishTrace = func() {
+			trace()
+			if err := closeRecord(); err != nil {
+				fmt.Fprintln(os.Stderr, "cannot write arrival record:", err)
+			}
+		}
+	}
+	progressInterval := time.Duration(*progress * float64(time.Second))
+	wallClockLimit := time.Duration(*wallLimit * float64(time.Second))
+
+	// a resumed simulation draws from the source of its checkpoint, unless a
+	// seed is given to branch off it
+	if *seed == 0 && *resume == "" {
+		*seed = time.Now().UTC().UnixNano()
+	}
+	if *seed != 0 {
+		fmt.Fprintf(info, "Seed: %v\n", *seed)
+	}
+
+	var path = GetWorkloadPath(info, *cdfWorkload)
+	if *genType == 6 {
+		path = *clusterTrace
+	} else if *genType == 9 {
+		path = *arrivalTrace
+	}
+	mixPaths, mixWeights := ParseCDFMix(info, *cdfMix)
+	phaseProbs, phaseMeans := ParsePhases(*phases)
+
+	// The flags are the defaults of the parameters missing from the config
+	cfg := topologies.Config{
+		Topo: *topo, Lambda: *lambda, Mu: *mu, Duration: *duration, Warmup: *warmup, MaxReqs: *maxReqs,
+		Drain: *drain, EventList: GetEventList(*eventList),
+		GenType: *genType, ProcType: *procType, Quantum: *quantum, Cores: *cores, CtxCost: *ctxCost,
+		NUMANodes: *numaNodes, TransferCost: *transferCost,
+		Path: path, CDFScale: *cdfScale, MixPaths: mixPaths, MixWeights: mixWeights,
+		ParetoAlpha: *paretoAlpha, ParetoRange: *paretoRange, WeibullShape: *weibullShape, GammaShape: *gammaShape,
+		PhaseProbs: phaseProbs, PhaseMeans: phaseMeans,
+		LambdaProfile: ParseRateProfile(*lambdaProfile), LambdaPeriod: *lambdaPeriod, LambdaAmplitude: *lambdaAmplitude,
+		ZipfKeys: *zipfKeys, ZipfExponent: *zipfExponent,
+		ClosedLoop: *closedLoop, Clients: *clients, ThinkTime: *thinkTime,
+		MMPPRates: ParseCoreSpeeds(*mmppRates), MMPPTransitions: ParseMMPPTransitions(*mmppTransitions),
+		PropDelay: *propDelay, ReturnDelay: *returnDelay, SpeedSchedule: ParseSpeedSchedule(*speedSchedule),
+		Alpha: *alpha, DeadlineSlack: *deadlineSlack, Timeout: *timeout,
+		ScaleTime: *scaleTime, ScaleCores: *scaleCores, ValueCorr: *valueCorr,
+		ShedThreshold: *shedThreshold, ShedPolicy: GetShedPolicy(*shedPolicy),
+		BusyPower: *busyPower, IdlePower: *idlePower, SleepPower: *sleepPower, SleepAfter: *sleepAfter, WakeupCost: *wakeupCost,
+		MaxRetries: *maxRetries, RetryBackoff: *retryBackoff, RateLimit: *rateLimit, RateBurst: *rateBurst,
+		AcfLags: *acfLags, CoreSpeeds: ParseCoreSpeeds(*coreSpeeds), HighPrio: *highPrio, Aging: *aging,
+		SharedQueue: *sharedQueue, Steal: *steal, Dispatch: GetDispatchPolicy(*dispatch), Machines: *machines,
+		TimeSeries: *timeSeries, SamplePeriod: *samplePeriod, Classes: classes,
+		MaxBatch: *maxBatch, MaxWait: *maxWait, BatchOverhead: *batchOverhead, BatchService: GetBatchService(*batchService),
+		BulkDist: GetBulkDist(*bulkDist), BulkSize: *bulkSize,
+		GangWidth: *gangWidth, GangRatio: *gangRatio, SLO: *slo, Shed: *shed,
+		BufferSize: *bufferSize, QueueCap: *queueCap, ClassStats: *classStats, DAG: ParseDAG(*dag),
+		Percentiles: ParsePercentiles(*percentiles), Batches: *batches, Replications: *replications,
+	}
+	if *config != "" {
+		if err := topologies.LoadConfig(*config, &cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	var state *engine.Checkpoint
+	if *resume != "" {
+		resumed, s, err := topologies.LoadCheckpoint(*resume)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *duration <= s.Time {
+			fmt.Fprintf(os.Stderr, "duration should be past the checkpoint time %v, got %v\n", s.Time, *duration)
+			os.Exit(1)
+		}
+		resumed.Duration, resumed.Drain = *duration, *drain
+		cfg, state = resumed, s
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid parameters:", err)
+		os.Exit(1)
+	}
+	if *checkpoint != "" {
+		if err := cfg.CanCheckpoint(); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid parameters:", err)
+			os.Exit(1)
+		}
+	}
+	fmt.Fprintf(info, "Workload path: %v\n", cfg.Path)
+	fmt.Fprintf(info, "Selected topology: %v\n", cfg.Topo)
+
+	// the json summary replaces the text statistics
+	var statsOutput io.Writer = out
+	if *format == "json" {
+		statsOutput = io.Discard
+	}
 
-	fmt.Printf("Selected topology: %v\n", *topo)
+	if cfg.Replications > 1 {
+		if *slo99 > 0 {
+			fmt.Fprintln(os.Stderr, "slo99 is not supported with replications")
+			os.Exit(1)
+		}
+		if *checkpoint != "" || *resume != "" {
+			fmt.Fprintln(os.Stderr, "checkpoint and resume are not supported with replications")
+			os.Exit(1)
+		}
+		agg, aborted := topologies.RunReplications(cfg, *seed, func(i int, sim *engine.Simulation) {
+			// only the aggregate is printed
+			sim.SetStatsOutput(io.Discard)
+			sim.SetInfoOutput(info)
+			sim.SetProgress(progressInterval)
+			sim.SetWallLimit(wallClockLimit)
+			traceOf(sim, ReplicationPath(*tracePath, i), ReplicationPath(*chromeTrace, i))
+			recordArrivalsOf(sim, ReplicationPath(*recordArrivals, i))
+		})
+		finishTrace()
+		// the confidence intervals need at least two replications
+		if agg.Replications() < 2 {
+			exitIfAborted(aborted)
+		}
+		if *format == "json" {
+			if err := WriteSummary(out, agg, *seed); err != nil {
+				fmt.Fprintln(os.Stderr, "cannot write summary:", err)
+				os.Exit(1)
+			}
+			exitIfAborted(aborted)
+			return
+		}
+		agg.SetOutput(out)
+		agg.PrintStats()
+		exitIfAborted(aborted)
+		return
+	}
+
+	sim := engine.NewSimulation()
+	if state != nil {
+		sim.Resume(state)
+	}
+	if state == nil || *seed != 0 {
+		sim.SetSeed(*seed)
+	}
+	sim.SetStatsOutput(statsOutput)
+	sim.SetInfoOutput(info)
+	sim.SetProgress(progressInterval)
+	sim.SetWallLimit(wallClockLimit)
+	traceOf(sim, *tracePath, *chromeTrace)
+	recordArrivalsOf(sim, *recordArrivals)
+	stats := topologies.Run(cfg, sim)
+	finishTrace()
+	if *checkpoint != "" {
+		if err := topologies.SaveCheckpoint(*checkpoint, cfg, sim); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if *format == "json" {
+		if err := WriteSummary(out, stats, *seed); err != nil {
+			fmt.Fprintln(os.Stderr, "cannot write summary:", err)
+			os.Exit(1)
+		}
+	}
 
-	if *topo == 0 {
-		topologies.SingleQueue(*lambda, *mu, *duration, *genType, *procType, *quantum, *cores, *ctxCost, path)
-	} else if *topo == 1 {
-		topologies.MultiQueue(*lambda, *mu, *duration, *genType, *procType, *quantum, *cores, *ctxCost)
-	} else if *topo == 2 {
-		topologies.BoundedQueue(*lambda, *mu, *duration, *bufferSize, *cores)
-	} else {
-		panic("Unknown topology")
+	exitIfAborted(sim.Aborted())
+	if *slo99 > 0 && !CheckSLO(info, stats, *slo99) {
+		os.Exit(1)
 	}
 }
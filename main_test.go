@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestMain runs main instead of the tests when runSchedsim asks for it, with
+// the arguments in SCHEDSIM_ARGS
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("SCHEDSIM_ARGS"); ok {
+		os.Args = append([]string{"schedsim"}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runSchedsim runs the simulator with args in a new process and returns what
+// it printed on stdout and stderr
+func runSchedsim(t *testing.T, args ...string) (stdout, stderr []byte) {
+	t.Helper()
+	stdout, stderr, err := execSchedsim(args...)
+	if err != nil {
+		t.Fatalf("schedsim %v: %v\n%s", args, err, stderr)
+	}
+	return stdout, stderr
+}
+
+// execSchedsim runs the simulator with args in a new process and returns what
+// it printed on stdout and stderr, and its error if it failed
+func execSchedsim(args ...string) (stdout, stderr []byte, err error) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "SCHEDSIM_ARGS="+strings.Join(args, " "))
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err = cmd.Run()
+	return out.Bytes(), errOut.Bytes(), err
+}
+
+// With the json format, stdout holds only the summary, even when parameters,
+// constructors and warnings are printed along the way
+func TestJSONOutput(t *testing.T) {
+	for _, args := range [][]string{
+		{"-seed", "1", "-duration", "1e5", "-format", "json"},
+		{"-seed", "1", "-duration", "1e5", "-format", "json", "-genType", "4", "-maxReqs", "1000000"},
+		{"-seed", "1", "-duration", "1e5", "-format", "json", "-replications", "3"},
+		{"-seed", "1", "-duration", "1e5", "-format", "json", "-topo", "4"},
+	} {
+		stdout, stderr := runSchedsim(t, args...)
+		var summary map[string]interface{}
+		if err := json.Unmarshal(stdout, &summary); err != nil {
+			t.Errorf("%v: stdout is not a JSON summary: %v\n%s", args, err, stdout)
+			continue
+		}
+		if _, ok := summary["mean"]; !ok {
+			t.Errorf("%v: no mean in the summary %v", args, summary)
+		}
+		if !bytes.Contains(stderr, []byte("Seed: 1\n")) || !bytes.Contains(stderr, []byte("Generator called with")) {
+			t.Errorf("%v: the parameters are not on stderr:\n%s", args, stderr)
+		}
+	}
+}
+
+// The JSON summary of a small run holds its count and every reported
+// percentile, of the delays and of the slowdowns
+func TestJSONSummaryKeys(t *testing.T) {
+	stdout, _ := runSchedsim(t, "-seed", "1", "-duration", "1e9", "-maxReqs", "300", "-format", "json")
+	var summary map[string]interface{}
+	if err := json.Unmarshal(stdout, &summary); err != nil {
+		t.Fatalf("stdout is not a JSON summary: %v\n%s", err, stdout)
+	}
+	if count := summary["count"]; count != 300.0 {
+		t.Errorf("count %v, want 300", count)
+	}
+	slowdown, _ := summary["slowdown"].(map[string]interface{})
+	for _, key := range []string{"mean", "stddev", "throughput", "p50", "p90", "p95", "p99"} {
+		if v, ok := summary[key].(float64); !ok || v <= 0 {
+			t.Errorf("%v: %v in the summary %v", key, summary[key], summary)
+		}
+		if key == "throughput" {
+			continue
+		}
+		if v, ok := slowdown[key].(float64); !ok || v < 0 {
+			t.Errorf("slowdown %v: %v in the summary %v", key, slowdown[key], slowdown)
+		}
+	}
+}
+
+// The same seed gives the same output, byte for byte, including the results
+// and seeds of the replications
+func TestSameSeedSameOutput(t *testing.T) {
+	for _, args := range [][]string{
+		{"-seed", "5", "-duration", "1e5"},
+		{"-seed", "5", "-duration", "1e5", "-replications", "4"},
+		{"-seed", "5", "-duration", "1e5", "-replications", "4", "-format", "json"},
+	} {
+		first, _ := runSchedsim(t, args...)
+		second, _ := runSchedsim(t, args...)
+		if !bytes.Equal(first, second) {
+			t.Errorf("%v: outputs differ:\n%s\n%s", args, first, second)
+		}
+	}
+}
+
+// The JSON summary reports the seed of the run and of every replication
+func TestJSONSummarySeeds(t *testing.T) {
+	stdout, _ := runSchedsim(t, "-seed", "5", "-duration", "1e5", "-replications", "3", "-format", "json")
+	var summary struct {
+		Seed    int64
+		Results []struct {
+			Seed int64
+			Mean float64
+		}
+	}
+	if err := json.Unmarshal(stdout, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Seed != 5 || len(summary.Results) != 3 {
+		t.Fatalf("seed %v and %v results, want 5 and 3", summary.Seed, len(summary.Results))
+	}
+	for _, r := range summary.Results {
+		stdout, _ := runSchedsim(t, "-seed", strconv.FormatInt(r.Seed, 10), "-duration", "1e5", "-format", "json")
+		var single struct{ Mean float64 }
+		if err := json.Unmarshal(stdout, &single); err != nil {
+			t.Fatal(err)
+		}
+		if single.Mean != r.Mean {
+			t.Errorf("seed %v: mean %v alone, %v as a replication", r.Seed, single.Mean, r.Mean)
+		}
+	}
+}
+
+// The run fails when the 99th percentile delay exceeds slo99, about 9.2 for
+// this M/M/1 queue
+func TestSLO99ExitStatus(t *testing.T) {
+	args := []string{"-seed", "1", "-duration", "1e5", "-lambda", "0.5", "-mu", "1"}
+	stdout, _, err := execSchedsim(append(args, "-slo99", "5")...)
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 1 {
+		t.Errorf("exit status %v over the SLO, want 1", err)
+	}
+	if !bytes.Contains(stdout, []byte("SLO violation: 99th percentile")) {
+		t.Errorf("no SLO violation printed:\n%s", stdout)
+	}
+	if _, _, err := execSchedsim(append(args, "-slo99", "50")...); err != nil {
+		t.Errorf("exit status %v within the SLO, want 0", err)
+	}
+}
+
+// Every replication records its arrivals to its own file, the same as the
+// single run with its seed
+func TestRecordArrivalsPerReplication(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "arrivals.txt")
+	stdout, _ := runSchedsim(t, "-seed", "5", "-duration", "1e3", "-replications", "2", "-format", "json", "-recordArrivals", path)
+	var summary struct {
+		Results []struct{ Seed int64 }
+	}
+	if err := json.Unmarshal(stdout, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Results) != 2 {
+		t.Fatalf("%v results, want 2", len(summary.Results))
+	}
+	for i, r := range summary.Results {
+		single := filepath.Join(dir, "single.txt")
+		runSchedsim(t, "-seed", strconv.FormatInt(r.Seed, 10), "-duration", "1e3", "-recordArrivals", single)
+		want, err := os.ReadFile(single)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(ReplicationPath(path, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want) == 0 || !bytes.Equal(got, want) {
+			t.Errorf("replication %v recorded:\n%s\nwant:\n%s", i, got, want)
+		}
+	}
+}
+
+// Every replication traces its requests to its own files, the same as the
+// single run with its seed but for the IDs of the queues, unique over the
+// replications
+func TestTracePerReplication(t *testing.T) {
+	dir := t.TempDir()
+	trace, chrome := filepath.Join(dir, "trace.csv"), filepath.Join(dir, "trace.json")
+	stdout, _ := runSchedsim(t, "-seed", "5", "-duration", "1e3", "-replications", "2", "-format", "json", "-trace", trace, "-chromeTrace", chrome)
+	var summary struct {
+		Results []struct{ Seed int64 }
+	}
+	if err := json.Unmarshal(stdout, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Results) != 2 {
+		t.Fatalf("%v results, want 2", len(summary.Results))
+	}
+	for i, r := range summary.Results {
+		singleTrace, singleChrome := filepath.Join(dir, "single.csv"), filepath.Join(dir, "single.json")
+		runSchedsim(t, "-seed", strconv.FormatInt(r.Seed, 10), "-duration", "1e3", "-trace", singleTrace, "-chromeTrace", singleChrome)
+		for single, path := range map[string]string{singleTrace: trace, singleChrome: chrome} {
+			want, err := os.ReadFile(single)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(ReplicationPath(path, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			queues := regexp.MustCompile(`queue\d+`)
+			got, want = queues.ReplaceAll(got, []byte("queue")), queues.ReplaceAll(want, []byte("queue"))
+			if len(want) == 0 || !bytes.Equal(got, want) {
+				t.Errorf("replication %v traced to %v:\n%s\nwant:\n%s", i, ReplicationPath(path, i), got, want)
+			}
+		}
+	}
+}
+
+func TestReplicationPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"arrivals.txt":     "arrivals.3.txt",
+		"dir/trace.csv":    "dir/trace.3.csv",
+		"arrivals":         "arrivals.3",
+		"dir.d/trace.json": "dir.d/trace.3.json",
+	} {
+		if got := ReplicationPath(path, 3); got != want {
+			t.Errorf("%v: %v, want %v", path, got, want)
+		}
+	}
+}
@@ -7,27 +7,65 @@ import (
 	"github.com/epfl-dcsl/schedsim/engine"
 )
 
-func BoundedQueue(lambda, mu, duration float64, bufferSize int, cores int) {
+// BoundedQueue describes a two stage topology where the first processor drops
+// requests when the buffer of the second one is full. With classStats the
+// main statistics are also broken down per request color. With a positive
+// queueCap, arrivals to the first stage are dropped once queueCap requests wait.
+// Service times are fixed at 1/mu unless genType selects a CDF workload.
+// The statistics report the delay percentiles, nil for the default ones, and
+// batch means intervals over batches batches, 0 for none.
+// It returns the main statistics once the simulation is over
+func BoundedQueue(sim *engine.Simulation, lambda, mu, duration, warmup float64, maxReqs, genType int, path string, cdfScale float64,
+	bufferSize int, cores int, classStats bool, queueCap int, percentiles []float64, batches int) *blocks.AllKeeper {
 
-	engine.InitSim()
-
-	//Init the statistics
-	stats := &blocks.AllKeeper{}
-	stats.SetName("Main Stats")
-	engine.InitStats(stats)
+	//Init the statistics, optionally per request color
+	var stats *blocks.AllKeeper
+	var mainDrain blocks.RequestDrain
+	if classStats {
+		classKeeper := blocks.NewClassKeeper()
+		classKeeper.SetName("Main Stats")
+		classKeeper.SetWarmup(warmup)
+		classKeeper.SetPercentiles(percentiles)
+		classKeeper.SetBatches(batches)
+		sim.InitStats(classKeeper)
+		stats, mainDrain = classKeeper.All(), classKeeper
+	} else {
+		stats = &blocks.AllKeeper{}
+		stats.SetName("Main Stats")
+		stats.SetWarmup(warmup)
+		stats.SetPercentiles(percentiles)
+		stats.SetBatches(batches)
+		sim.InitStats(stats)
+		mainDrain = stats
+	}
+	stats.StopAfter(maxReqs)
 
 	droppedStats := &blocks.AllKeeper{}
 	droppedStats.SetName("Dropped Stats")
-	engine.InitStats(droppedStats)
+	droppedStats.SetPercentiles(percentiles)
+	droppedStats.SetBatches(batches)
+	sim.InitStats(droppedStats)
 
-	// Add generator
+	// Add generator, with fixed service times unless drawn from a CDF workload
 	var g blocks.Generator
-	g = blocks.NewMDRandGenerator(lambda, 1/mu)
+	if genType == 5 {
+		g = blocks.NewCDFGeneratorScaled(lambda, path, cdfScale)
+	} else {
+		g = blocks.NewMDRandGenerator(lambda, 1/mu)
+	}
 
-	g.SetCreator(&blocks.ColoredReqCreator{})
+	g.SetCreator(blocks.NewColoredReqCreator())
 
-	// Create queues
-	q1 := blocks.NewQueue()
+	// Create queues, the first one dropping arrivals once it holds queueCap
+	var q1 engine.QueueInterface
+	if queueCap > 0 {
+		bq := blocks.NewBoundedFIFOQueue(queueCap)
+		bq.SetDropDrain(droppedStats)
+		sim.InitStats(bq)
+		q1 = bq
+	} else {
+		q1 = blocks.NewQueue()
+	}
 	q2 := blocks.NewQueue()
 
 	// Create processors
@@ -38,15 +76,16 @@ func BoundedQueue(lambda, mu, duration float64, bufferSize int, cores int) {
 	p1.AddInQueue(q1)
 	p1.AddOutQueue(q2)
 	p1.SetReqDrain(droppedStats)
-	engine.RegisterActor(p1)
+	sim.RegisterActor(p1)
 
 	p2.AddInQueue(q2)
-	p2.SetReqDrain(stats)
-	engine.RegisterActor(p2)
+	p2.SetReqDrain(mainDrain)
+	sim.RegisterActor(p2)
 
 	// Register the generator
-	engine.RegisterActor(g)
+	sim.RegisterActor(g)
 
-	fmt.Printf("Cores:%v\tservice_rate:%v\tinterarrival_rate:%v\n", cores, mu, lambda)
-	engine.Run(duration)
+	fmt.Fprintf(sim.InfoOutput(), "Cores:%v\tservice_rate:%v\tinterarrival_rate:%v\n", cores, mu, lambda)
+	sim.Run(duration)
+	return stats
 }
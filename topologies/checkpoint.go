@@ -0,0 +1,80 @@
+package topologies
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// checkpointFile is the content of a checkpoint file: the config of the
+// simulation and its state
+type checkpointFile struct {
+	Config Config             `json:"config"`
+	State  *engine.Checkpoint `json:"state"`
+}
+
+// CanCheckpoint returns an error if the state of the simulation described by
+// c cannot be saved in a checkpoint. Only the single queue topology with run
+// to completion cores, open loop arrivals of a single class and the main
+// statistics can be, since the other blocks do not save their state
+func (c *Config) CanCheckpoint() error {
+	if c.Topo != 0 || c.ProcType != 0 {
+		return fmt.Errorf("only topo 0 with procType 0 can be checkpointed")
+	}
+	switch c.GenType {
+	case 0, 1, 2, 3, 4, 5, 7, 8, 11, 12, 13, 14:
+	default:
+		return fmt.Errorf("genType %v cannot be checkpointed", c.GenType)
+	}
+	if len(c.Classes) > 0 || c.ClosedLoop || c.BulkSize > 1 {
+		return fmt.Errorf("classes, closed loops and bulk arrivals cannot be checkpointed")
+	}
+	if c.Drain {
+		return fmt.Errorf("a drained simulation cannot be checkpointed, its arrivals are over")
+	}
+	if c.PropDelay > 0 || c.ReturnDelay > 0 || c.MaxRetries > 0 || c.ShedThreshold > 0 || c.SLO > 0 {
+		return fmt.Errorf("propagation delays, retries, shedding and slos cannot be checkpointed")
+	}
+	if c.TimeSeries > 0 || c.SamplePeriod > 0 || c.BusyPower > 0 || c.SleepAfter > 0 || c.NUMANodes > 1 {
+		return fmt.Errorf("time series, samples, energy, sleep and NUMA nodes cannot be checkpointed")
+	}
+	return nil
+}
+
+// SaveCheckpoint writes the state of sim, whose run described by c is over,
+// along with c to the checkpoint file at path, to resume it with
+// LoadCheckpoint
+func SaveCheckpoint(path string, c Config, sim *engine.Simulation) error {
+	state, err := sim.Checkpoint()
+	if err != nil {
+		return fmt.Errorf("cannot checkpoint: %v", err)
+	}
+	b, err := json.Marshal(checkpointFile{Config: c, State: state})
+	if err != nil {
+		return fmt.Errorf("cannot checkpoint: %v", err)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("cannot write checkpoint: %v", err)
+	}
+	return nil
+}
+
+// LoadCheckpoint reads the checkpoint file at path and returns the config of
+// the simulation and its state, to pass to engine.Simulation.Resume before
+// running the config again, e.g. with a longer duration
+func LoadCheckpoint(path string) (Config, *engine.Checkpoint, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, nil, fmt.Errorf("cannot read checkpoint: %v", err)
+	}
+	var f checkpointFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return Config{}, nil, fmt.Errorf("invalid checkpoint %s: %v", path, err)
+	}
+	if f.State == nil {
+		return Config{}, nil, fmt.Errorf("invalid checkpoint %s: no state", path)
+	}
+	return f.Config, f.State, nil
+}
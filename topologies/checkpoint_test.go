@@ -0,0 +1,127 @@
+package topologies
+
+import (
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/blocks"
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// checkpointRun runs c, resumed from state if not nil, and returns the main
+// statistics and the simulation once over
+func checkpointRun(t *testing.T, c Config, state *engine.Checkpoint, seed int64) (*blocks.AllKeeper, *engine.Simulation) {
+	t.Helper()
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sim := engine.NewSimulation()
+	if state != nil {
+		sim.Resume(state)
+	} else {
+		sim.SetSeed(seed)
+	}
+	sim.SetStatsOutput(io.Discard)
+	return Run(c, sim), sim
+}
+
+// saveAndLoad checkpoints sim, run with c, to a file and reads it back
+func saveAndLoad(t *testing.T, c Config, sim *engine.Simulation) (Config, *engine.Checkpoint) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	if err := SaveCheckpoint(path, c, sim); err != nil {
+		t.Fatal(err)
+	}
+	loaded, state, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded, state
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	c := mm1Config(1.8, 1, 1e3)
+	c.Cores = 2
+	_, sim := checkpointRun(t, c, nil, 3)
+	loaded, state := saveAndLoad(t, c, sim)
+	if !reflect.DeepEqual(loaded, c) {
+		t.Errorf("got config %+v, want %+v", loaded, c)
+	}
+	if state.Time != sim.GetTime() {
+		t.Errorf("got time %v, want %v", state.Time, sim.GetTime())
+	}
+}
+
+// Once drained, a resumed simulation recorded every request issued, before
+// and after the checkpoint, the ones in service at the checkpoint included
+func TestResumeConservesRequests(t *testing.T) {
+	c := mm1Config(1.8, 1, 1e3)
+	c.Cores = 2
+	stats, sim := checkpointRun(t, c, nil, 5)
+	before := stats.Count()
+	c, state := saveAndLoad(t, c, sim)
+
+	c.Duration, c.Drain = 2e3, true
+	stats, sim = checkpointRun(t, c, state, 0)
+	final, err := sim.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Count() <= before {
+		t.Errorf("%v requests recorded once resumed, %v before", stats.Count(), before)
+	}
+	if stats.Count() != final.IDs {
+		t.Errorf("%v requests recorded, %v issued", stats.Count(), final.IDs)
+	}
+}
+
+// Resuming a checkpoint reproduces the same run, and a seed branches off it
+func TestResumeDeterministic(t *testing.T) {
+	c := mm1Config(0.8, 1, 1e3)
+	_, sim := checkpointRun(t, c, nil, 7)
+	c, state := saveAndLoad(t, c, sim)
+	c.Duration = 3e3
+
+	first, _ := checkpointRun(t, c, state, 0)
+	second, _ := checkpointRun(t, c, state, 0)
+	if first.Count() != second.Count() || first.MeanDelay() != second.MeanDelay() {
+		t.Errorf("resumed twice: %v requests with mean delay %v, then %v with %v",
+			first.Count(), first.MeanDelay(), second.Count(), second.MeanDelay())
+	}
+
+	sim = engine.NewSimulation()
+	sim.Resume(state)
+	sim.SetSeed(8)
+	sim.SetStatsOutput(io.Discard)
+	if branch := Run(c, sim); branch.MeanDelay() == first.MeanDelay() {
+		t.Errorf("branched off with another seed, got the same mean delay %v", branch.MeanDelay())
+	}
+}
+
+func TestCanCheckpoint(t *testing.T) {
+	c := mm1Config(0.8, 1, 1e3)
+	if err := c.CanCheckpoint(); err != nil {
+		t.Errorf("M/M/1 cannot be checkpointed: %v", err)
+	}
+	for name, change := range map[string]func(c *Config){
+		"multi queue": func(c *Config) { c.Topo = 1 },
+		"ps":          func(c *Config) { c.ProcType = 1 },
+		"mmpp":        func(c *Config) { c.GenType = 10 },
+		"trace":       func(c *Config) { c.GenType = 9 },
+		"classes":     func(c *Config) { c.Classes = []ClassSpec{{Lambda: 0.1, Mu: 1}} },
+		"closed loop": func(c *Config) { c.ClosedLoop = true },
+		"drain":       func(c *Config) { c.Drain = true },
+		"retries":     func(c *Config) { c.MaxRetries = 1 },
+		"slo":         func(c *Config) { c.SLO = 10 },
+		"samples":     func(c *Config) { c.SamplePeriod = 10 },
+		"numa":        func(c *Config) { c.NUMANodes = 2 },
+	} {
+		c := mm1Config(0.8, 1, 1e3)
+		change(&c)
+		if err := c.CanCheckpoint(); err == nil {
+			t.Errorf("%v can be checkpointed", name)
+		}
+	}
+}
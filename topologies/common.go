@@ -1 +1,35 @@
 package topologies
+
+import "fmt"
+
+// coreSpeed returns the speed of core i given the speeds of the first cores.
+// Cores beyond the list run at the reference speed
+func coreSpeed(speeds []float64, i int) float64 {
+	if i < len(speeds) {
+		return speeds[i]
+	}
+	return 1.0
+}
+
+// coreNode returns the NUMA node of core i when the cores are split evenly
+// in consecutive groups over nodes nodes
+func coreNode(i, cores, nodes int) int {
+	if nodes <= 1 {
+		return 0
+	}
+	return i * nodes / cores
+}
+
+// checkCoreSpeeds panics if more core speeds than cores are given or if the
+// processors cannot honor them
+func checkCoreSpeeds(speeds []float64, cores int, supported bool) {
+	if len(speeds) == 0 {
+		return
+	}
+	if len(speeds) > cores {
+		panic(fmt.Sprintf("%v core speeds given for %v cores", len(speeds), cores))
+	}
+	if !supported {
+		panic("Core speeds are only supported with run to completion processors")
+	}
+}
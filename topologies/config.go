@@ -0,0 +1,530 @@
+package topologies
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/epfl-dcsl/schedsim/blocks"
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// Config describes a simulation: the topology, its generator, queues and
+// processors, and the duration. The JSON names match the command line flags,
+// except that workloads are given as file paths
+type Config struct {
+	Topo     int     `json:"topo"`
+	Lambda   float64 `json:"lambda"`
+	Mu       float64 `json:"mu"`
+	Duration float64 `json:"duration"`
+	Warmup   float64 `json:"warmup"`
+	MaxReqs  int     `json:"maxReqs"`
+	Drain    bool    `json:"drain"`
+	GenType  int     `json:"genType"`
+	ProcType int     `json:"procType"`
+	Quantum  float64 `json:"quantum"`
+	Cores    int     `json:"cores"`
+	CtxCost  float64 `json:"ctxCost"`
+
+	// Future event set of the engine
+	EventList engine.EventList `json:"eventList"`
+
+	// NUMA placement, single and multi queue topologies
+	NUMANodes    int     `json:"numaNodes"`
+	TransferCost float64 `json:"transferCost"`
+
+	// Workloads
+	Path        string    `json:"path"` // CDF workload or trace (genType 5, 6, 9)
+	CDFScale    float64   `json:"cdfScale"`
+	MixPaths    []string  `json:"mixPaths"`
+	MixWeights  []float64 `json:"mixWeights"`
+	ParetoAlpha float64   `json:"paretoAlpha"`
+	ParetoRange float64   `json:"paretoRange"`
+	ClosedLoop  bool      `json:"closedLoop"`
+	Clients     int       `json:"clients"`
+	ThinkTime   float64   `json:"thinkTime"`
+
+	// Weibull (genType 12) and gamma (genType 13) service times
+	WeibullShape float64 `json:"weibullShape"`
+	GammaShape   float64 `json:"gammaShape"`
+
+	// Hyperexponential service times (genType 14)
+	PhaseProbs []float64 `json:"phaseProbs"`
+	PhaseMeans []float64 `json:"phaseMeans"`
+
+	// Time varying arrivals (genType 15)
+	LambdaProfile   []blocks.RatePoint `json:"lambdaProfile"`
+	LambdaPeriod    float64            `json:"lambdaPeriod"`
+	LambdaAmplitude float64            `json:"lambdaAmplitude"`
+
+	// Zipf distributed keys (genType 16)
+	ZipfKeys     int     `json:"zipfKeys"`
+	ZipfExponent float64 `json:"zipfExponent"`
+
+	// Markov modulated arrivals (genType 10)
+	MMPPRates       []float64   `json:"mmppRates"`
+	MMPPTransitions [][]float64 `json:"mmppTransitions"`
+
+	// Single queue topology
+	PropDelay     float64               `json:"propDelay"`
+	ReturnDelay   float64               `json:"returnDelay"`
+	SpeedSchedule []blocks.SpeedSegment `json:"speedSchedule"`
+	Alpha         float64               `json:"alpha"`
+	DeadlineSlack float64               `json:"deadlineSlack"`
+	Timeout       float64               `json:"timeout"`
+	ScaleTime     float64               `json:"scaleTime"`
+	ScaleCores    int                   `json:"scaleCores"`
+	ValueCorr     float64               `json:"valueCorr"`
+	ShedThreshold int                   `json:"shedThreshold"`
+	ShedPolicy    blocks.ShedPolicy     `json:"shedPolicy"`
+	BusyPower     float64               `json:"busyPower"`
+	IdlePower     float64               `json:"idlePower"`
+	SleepPower    float64               `json:"sleepPower"`
+	SleepAfter    float64               `json:"sleepAfter"`
+	WakeupCost    float64               `json:"wakeupCost"`
+	MaxRetries    int                   `json:"maxRetries"`
+	RetryBackoff  float64               `json:"retryBackoff"`
+	RateLimit     float64               `json:"rateLimit"`
+	RateBurst     float64               `json:"rateBurst"`
+	AcfLags       int                   `json:"acfLags"`
+	CoreSpeeds    []float64             `json:"coreSpeeds"`
+	HighPrio      float64               `json:"highPrio"`
+	Aging         float64               `json:"aging"`
+	TimeSeries    float64               `json:"timeSeries"`
+	SamplePeriod  float64               `json:"samplePeriod"`
+	MaxBatch      int                   `json:"maxBatch"`
+	MaxWait       float64               `json:"maxWait"`
+	BatchOverhead float64               `json:"batchOverhead"`
+	BatchService  blocks.BatchService   `json:"batchService"`
+	GangWidth     int                   `json:"gangWidth"`
+	GangRatio     float64               `json:"gangRatio"`
+	BulkDist      blocks.BulkDist       `json:"bulkDist"`
+	BulkSize      float64               `json:"bulkSize"`
+	SLO           float64               `json:"slo"`
+	Shed          bool                  `json:"shed"`
+	Classes       []ClassSpec           `json:"classes"`
+
+	// Multi queue topology
+	SharedQueue bool                  `json:"sharedQueue"`
+	Steal       bool                  `json:"steal"`
+	Dispatch    blocks.DispatchPolicy `json:"dispatch"`
+
+	// Hierarchical topology, cores are per machine
+	Machines int `json:"machines"`
+
+	// Bounded queue topology
+	BufferSize int  `json:"buffersize"`
+	QueueCap   int  `json:"queueCap"`
+	ClassStats bool `json:"classStats"`
+
+	// DAG topology
+	DAG blocks.DAGTemplate `json:"dag"`
+
+	// Delay percentiles reported by the statistics, nil for the default ones
+	Percentiles []float64 `json:"percentiles"`
+	// Batches of the batch means confidence intervals reported by the
+	// statistics, 0 for none
+	Batches int `json:"batches"`
+
+	// Independent replications of the simulation, see RunReplications
+	Replications int `json:"replications"`
+}
+
+// LoadConfig reads the JSON config file at path into c. Parameters missing
+// from the file keep their value in c, e.g. the defaults, while unknown ones
+// are reported as errors
+func LoadConfig(path string, c *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open config: %v", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate returns an error describing the first invalid or missing parameter
+// of the config
+func (c *Config) Validate() error {
+	if c.Duration <= 0 {
+		return fmt.Errorf("duration should be positive, got %v", c.Duration)
+	}
+	if c.Warmup < 0 || c.Warmup >= c.Duration {
+		return fmt.Errorf("warmup should be in [0, duration), got %v", c.Warmup)
+	}
+	if c.MaxReqs < 0 {
+		return fmt.Errorf("maxReqs should not be negative, got %v", c.MaxReqs)
+	}
+	if c.Percentiles != nil && len(c.Percentiles) == 0 {
+		return fmt.Errorf("percentiles should not be empty")
+	}
+	for _, p := range c.Percentiles {
+		if p <= 0 || p >= 1 {
+			return fmt.Errorf("percentiles should be in (0, 1), got %v", p)
+		}
+	}
+	if c.Batches < 0 || c.Batches == 1 {
+		return fmt.Errorf("batches should be 0 or at least 2, got %v", c.Batches)
+	}
+	if c.Replications < 1 {
+		return fmt.Errorf("replications should be at least 1, got %v", c.Replications)
+	}
+	if c.Cores < 1 {
+		return fmt.Errorf("cores should be at least 1, got %v", c.Cores)
+	}
+	if c.Mu <= 0 {
+		return fmt.Errorf("mu should be positive, got %v", c.Mu)
+	}
+	replay := c.GenType == 6 || c.GenType == 9
+	if c.Lambda <= 0 && !replay && c.GenType != 10 && !(c.Topo == 0 && c.ClosedLoop) {
+		return fmt.Errorf("lambda should be positive, got %v", c.Lambda)
+	}
+
+	var genTypes, procTypes int
+	switch c.Topo {
+	case 0:
+		genTypes, procTypes = 17, 16
+	case 1:
+		genTypes, procTypes = 6, 3
+		if c.GenType == 4 {
+			return fmt.Errorf("genType 4 is not supported by the multi queue topology")
+		}
+	case 5:
+		genTypes, procTypes = 6, 4
+		if c.GenType == 4 {
+			return fmt.Errorf("genType 4 is not supported by the hierarchical topology")
+		}
+		if c.Machines < 1 {
+			return fmt.Errorf("machines should be at least 1, got %v", c.Machines)
+		}
+	case 2, 3, 4:
+		// the generator and processors are fixed, except for CDF workloads
+		genTypes, procTypes = 10, 1
+	default:
+		return fmt.Errorf("unknown topo %v", c.Topo)
+	}
+	// the multi queue topologies also replay traces of arrivals
+	dispatchReplay := c.GenType == 9 && (c.Topo == 1 || c.Topo == 5)
+	if (c.GenType < 0 || c.GenType >= genTypes) && !dispatchReplay {
+		return fmt.Errorf("unknown genType %v for topo %v", c.GenType, c.Topo)
+	}
+	if (c.Topo == 0 || c.Topo == 1 || c.Topo == 5) && (c.ProcType < 0 || c.ProcType >= procTypes) {
+		return fmt.Errorf("unknown procType %v for topo %v", c.ProcType, c.Topo)
+	}
+
+	if c.Path == "" && ((c.GenType == 5 && (c.Topo <= 2 || c.Topo == 5)) || (replay && c.Topo == 0) || dispatchReplay) {
+		return fmt.Errorf("genType %v needs a workload path", c.GenType)
+	}
+	if c.GenType == 7 && c.Topo == 0 {
+		if len(c.MixPaths) == 0 {
+			return fmt.Errorf("genType 7 needs mixPaths")
+		}
+		if len(c.MixPaths) != len(c.MixWeights) {
+			return fmt.Errorf("%v mixPaths but %v mixWeights", len(c.MixPaths), len(c.MixWeights))
+		}
+		var sum float64
+		for i, w := range c.MixWeights {
+			if w < 0 {
+				return fmt.Errorf("mixWeight %v of %v should not be negative, got %v", i, c.MixPaths[i], w)
+			}
+			sum += w
+		}
+		if math.Abs(sum-1) > 1e-6 {
+			return fmt.Errorf("mixWeights should sum to 1, got %v", sum)
+		}
+	}
+	if c.GenType == 10 && c.Topo == 0 {
+		if len(c.MMPPRates) == 0 || len(c.MMPPTransitions) != len(c.MMPPRates) {
+			return fmt.Errorf("genType 10 needs mmppRates and a row of mmppTransitions per rate")
+		}
+		for i, row := range c.MMPPTransitions {
+			if len(row) != len(c.MMPPRates) {
+				return fmt.Errorf("mmppTransitions row %v has %v rates instead of %v", i, len(row), len(c.MMPPRates))
+			}
+			if c.MMPPRates[i] < 0 {
+				return fmt.Errorf("mmppRate %v should not be negative, got %v", i, c.MMPPRates[i])
+			}
+			for j, r := range row {
+				if r < 0 {
+					return fmt.Errorf("mmppTransition from %v to %v should not be negative, got %v", i, j, r)
+				}
+			}
+		}
+		if i := mmppSilentState(c.MMPPRates, c.MMPPTransitions); i >= 0 {
+			return fmt.Errorf("mmpp state %v never leads to an arrival", i)
+		}
+	}
+	if c.Topo == 0 && c.GenType == 11 && c.ParetoAlpha <= 1 {
+		return fmt.Errorf("genType 11 needs a paretoAlpha above 1 for a finite mean, got %v", c.ParetoAlpha)
+	}
+	if c.Topo == 0 && (c.WeibullShape <= 0 || c.GammaShape <= 0) {
+		return fmt.Errorf("weibullShape and gammaShape should be positive, got %v and %v", c.WeibullShape, c.GammaShape)
+	}
+	if c.Topo == 0 && c.GenType == 16 && (c.ZipfKeys < 1 || c.ZipfExponent < 0) {
+		return fmt.Errorf("genType 16 needs at least a zipfKey and a non negative zipfExponent, got %v and %v", c.ZipfKeys, c.ZipfExponent)
+	}
+	if c.Topo == 0 && c.GenType == 15 {
+		if len(c.LambdaProfile) == 0 && c.LambdaPeriod <= 0 {
+			return fmt.Errorf("genType 15 needs a lambdaProfile or a positive lambdaPeriod")
+		}
+		if c.LambdaAmplitude < 0 || c.LambdaAmplitude > 1 {
+			return fmt.Errorf("lambdaAmplitude should be in [0, 1], got %v", c.LambdaAmplitude)
+		}
+		positive := false
+		for i, p := range c.LambdaProfile {
+			if p.Rate < 0 {
+				return fmt.Errorf("lambdaProfile point %v has a negative rate %v", i, p.Rate)
+			}
+			if i > 0 && p.Time <= c.LambdaProfile[i-1].Time {
+				return fmt.Errorf("lambdaProfile points should be sorted by time, %v comes after %v", p.Time, c.LambdaProfile[i-1].Time)
+			}
+			positive = positive || p.Rate > 0
+		}
+		if len(c.LambdaProfile) > 0 && !positive {
+			return fmt.Errorf("lambdaProfile has no positive rate")
+		}
+	}
+	if c.Topo == 0 && (c.GenType == 14 || c.hasClassGenType(14)) {
+		if len(c.PhaseProbs) == 0 || len(c.PhaseProbs) != len(c.PhaseMeans) {
+			return fmt.Errorf("genType 14 needs phases, with as many phaseProbs as phaseMeans")
+		}
+		var sum float64
+		for i, p := range c.PhaseProbs {
+			if p < 0 || c.PhaseMeans[i] <= 0 {
+				return fmt.Errorf("phase %v should have a non negative probability and a positive mean, got %v:%v", i, p, c.PhaseMeans[i])
+			}
+			sum += p
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			return fmt.Errorf("phase probabilities should sum to 1, got %v", sum)
+		}
+	}
+	for i, cl := range c.Classes {
+		if c.Topo != 0 {
+			return fmt.Errorf("classes are only supported by the single queue topology")
+		}
+		if cl.Lambda <= 0 || cl.Mu <= 0 {
+			return fmt.Errorf("class %v should have a positive lambda and mu, got %v and %v", i+1, cl.Lambda, cl.Mu)
+		}
+		if cl.GenType < 0 || cl.GenType > 14 || cl.GenType == 6 || cl.GenType == 7 || cl.GenType == 9 || cl.GenType == 10 {
+			return fmt.Errorf("class %v has an unsupported genType %v", i+1, cl.GenType)
+		}
+		if cl.GenType == 5 && c.Path == "" {
+			return fmt.Errorf("class %v needs a workload path", i+1)
+		}
+		if cl.GenType == 11 && c.ParetoAlpha <= 1 {
+			return fmt.Errorf("class %v needs a paretoAlpha above 1 for a finite mean, got %v", i+1, c.ParetoAlpha)
+		}
+	}
+	if c.SleepAfter < 0 || c.WakeupCost < 0 {
+		return fmt.Errorf("sleepAfter and wakeupCost should not be negative")
+	}
+	if c.SleepAfter > 0 && (c.Topo != 0 || c.ProcType == 1 || c.ProcType == 4 || c.ProcType == 15) {
+		return fmt.Errorf("sleep is not supported by procType %v of topo %v", c.ProcType, c.Topo)
+	}
+	if c.NUMANodes < 1 || c.NUMANodes > c.Cores {
+		return fmt.Errorf("numaNodes should be in [1, cores], got %v", c.NUMANodes)
+	}
+	if c.TransferCost < 0 {
+		return fmt.Errorf("transferCost should not be negative, got %v", c.TransferCost)
+	}
+	if c.NUMANodes > 1 && (c.Topo > 1 || c.ProcType == 1 || (c.Topo == 0 && (c.ProcType == 4 || c.ProcType == 13 || c.ProcType == 15))) {
+		return fmt.Errorf("numaNodes is not supported by procType %v of topo %v", c.ProcType, c.Topo)
+	}
+	if c.Topo == 0 && c.ProcType == 13 && c.Cores != 1 {
+		return fmt.Errorf("procType 13 only supports a single core, got %v", c.Cores)
+	}
+	if c.Topo == 0 && c.ProcType == 14 && (c.MaxBatch < 1 || c.MaxWait < 0 || c.BatchOverhead < 0) {
+		return fmt.Errorf("batching needs a positive maxBatch and non negative maxWait and batchOverhead")
+	}
+	if c.Topo == 0 && c.ProcType == 15 && (c.GangWidth < 1 || c.GangWidth > c.Cores || c.GangRatio < 0 || c.GangRatio > 1) {
+		return fmt.Errorf("gang scheduling needs gangWidth in [1, cores] and gangRatio in [0, 1]")
+	}
+	if c.SLO < 0 || (c.SLO > 0 && c.Topo != 0) {
+		return fmt.Errorf("slo should not be negative and is only supported by topo 0, got %v", c.SLO)
+	}
+	if c.Shed && (c.SLO <= 0 || c.Topo != 0 || (c.ProcType != 0 && c.ProcType != 9 && c.ProcType != 11)) {
+		return fmt.Errorf("shed needs an slo and procType 0, 9 or 11 of topo 0")
+	}
+	if c.Quantum <= 0 && (c.ProcType == 2 || c.ProcType == 3 || c.ProcType == 6 || c.ProcType == 12) {
+		return fmt.Errorf("quantum should be positive, got %v", c.Quantum)
+	}
+	if (c.ClosedLoop || c.Topo == 4) && c.Clients < 1 {
+		return fmt.Errorf("clients should be at least 1, got %v", c.Clients)
+	}
+	if c.BulkSize != 0 && c.BulkSize < 1 {
+		return fmt.Errorf("bulkSize should be at least 1, got %v", c.BulkSize)
+	}
+	if c.BulkSize > 1 && (c.Topo != 0 || c.ClosedLoop || c.GenType == 6 || c.GenType == 7 || c.GenType == 9 || c.GenType == 10) {
+		return fmt.Errorf("bulk arrivals are only supported by the open loop generators of topo 0, except genTypes 6, 7, 9 and 10")
+	}
+	if c.BulkDist == blocks.BulkFixed && c.BulkSize != math.Trunc(c.BulkSize) {
+		return fmt.Errorf("a fixed bulkSize should be a whole number, got %v", c.BulkSize)
+	}
+	if c.ClosedLoop && c.ThinkTime < 0 {
+		return fmt.Errorf("thinkTime should not be negative, got %v", c.ThinkTime)
+	}
+	if c.Topo == 3 && len(c.DAG.ServiceTimes) == 0 {
+		return fmt.Errorf("topo 3 needs a dag")
+	}
+	if c.Topo == 3 && len(c.DAG.Deps) != len(c.DAG.ServiceTimes) {
+		return fmt.Errorf("dag has %v tasks but %v dependency lists", len(c.DAG.ServiceTimes), len(c.DAG.Deps))
+	}
+	return nil
+}
+
+// mmppSilentState returns a state of the MMPP from which no state with a
+// positive arrival rate can be reached, or -1 if there is none. The generator
+// would wait forever for its next arrival once in such a state
+func mmppSilentState(rates []float64, transitions [][]float64) int {
+	// the states leading to an arrival, found backwards from the ones with
+	// a positive rate
+	active := make([]bool, len(rates))
+	for changed := true; changed; {
+		changed = false
+		for i := range rates {
+			if active[i] {
+				continue
+			}
+			for j, r := range transitions[i] {
+				if j != i && r > 0 && active[j] {
+					active[i] = true
+				}
+			}
+			if rates[i] > 0 {
+				active[i] = true
+			}
+			changed = changed || active[i]
+		}
+	}
+	for i, a := range active {
+		if !a {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasClassGenType returns whether an extra class has the given genType
+func (c *Config) hasClassGenType(genType int) bool {
+	for _, cl := range c.Classes {
+		if cl.GenType == genType {
+			return true
+		}
+	}
+	return false
+}
+
+// Run builds the topology described by c on sim, a new simulation, runs it and
+// returns the main statistics. The config should be valid. It warns if the
+// duration ended the simulation before MaxReqs requests were recorded, since
+// the sample is then smaller than asked for
+func Run(c Config, sim *engine.Simulation) *blocks.AllKeeper {
+	stats := runSimulation(c, sim)
+	warnIfShort(c, sim, stats)
+	return stats
+}
+
+// runSimulation sets sim up as described by c, then builds and runs the
+// topology on it and returns the main statistics
+func runSimulation(c Config, sim *engine.Simulation) *blocks.AllKeeper {
+	sim.SetDrain(c.Drain)
+	sim.SetEventList(c.EventList)
+	return runTopology(c, sim)
+}
+
+// warnIfShort warns on the information output of sim if fewer than the
+// MaxReqs requests of c were recorded in stats
+func warnIfShort(c Config, sim *engine.Simulation, stats *blocks.AllKeeper) {
+	if c.MaxReqs > 0 && stats.Count() < c.MaxReqs {
+		fmt.Fprintf(sim.InfoOutput(), "WARNING: only %v of the %v requests were recorded by the end of the duration\n", stats.Count(), c.MaxReqs)
+	}
+}
+
+// runTopology builds the topology described by c, runs the simulation and
+// returns the main statistics
+func runTopology(c Config, sim *engine.Simulation) *blocks.AllKeeper {
+	switch c.Topo {
+	case 0:
+		return SingleQueue(sim, c)
+	case 1:
+		return MultiQueue(sim, c.Lambda, c.Mu, c.Duration, c.Warmup, c.MaxReqs, c.GenType, c.ProcType, c.Quantum, c.Cores, c.CtxCost,
+			c.Path, c.CDFScale, c.SharedQueue, c.Steal, c.Dispatch, c.CoreSpeeds,
+			c.NUMANodes, c.TransferCost, c.Percentiles, c.Batches)
+	case 2:
+		return BoundedQueue(sim, c.Lambda, c.Mu, c.Duration, c.Warmup, c.MaxReqs, c.GenType, c.Path, c.CDFScale, c.BufferSize, c.Cores,
+			c.ClassStats, c.QueueCap, c.Percentiles, c.Batches)
+	case 3:
+		return DAGQueue(sim, c.Lambda, c.Duration, c.Warmup, c.MaxReqs, c.Cores, c.CtxCost, c.DAG, c.Percentiles, c.Batches)
+	case 4:
+		return OpenClosedLoop(sim, c.Lambda, c.Mu, c.Duration, c.Warmup, c.MaxReqs, c.Cores, c.CtxCost, c.Clients, c.Percentiles, c.Batches)
+	case 5:
+		return HierarchicalQueue(sim, c.Lambda, c.Mu, c.Duration, c.Warmup, c.MaxReqs, c.GenType, c.ProcType, c.Quantum,
+			c.Machines, c.Cores, c.CtxCost, c.Path, c.CDFScale, c.Dispatch, c.Percentiles, c.Batches)
+	default:
+		panic("Unknown topology")
+	}
+}
+
+// RunReplications runs c.Replications independent replications of the
+// simulation described by c and returns the aggregate of their main
+// statistics, and whether a replication was aborted by the wall clock limit.
+// Every replication runs on its own simulation, seeded with a seed derived
+// from seed, and up to GOMAXPROCS of them run concurrently. setup, if not nil,
+// is called with the index and the simulation of every replication before the
+// run, e.g. to set its outputs. Their seeds and warnings are printed in order
+// to the information output of their simulation once they are all over, and
+// only the ones up to the first aborted are aggregated, so that the result
+// only depends on seed. The config should be valid
+func RunReplications(c Config, seed int64, setup func(i int, sim *engine.Simulation)) (*blocks.ReplicationAggregator, bool) {
+	source := rand.New(rand.NewSource(seed))
+	seeds := make([]int64, c.Replications)
+	sims := make([]*engine.Simulation, c.Replications)
+	for i := range sims {
+		seeds[i] = source.Int63()
+		sims[i] = engine.NewSimulation()
+		sims[i].SetRand(rand.New(rand.NewSource(seeds[i])))
+		if setup != nil {
+			setup(i, sims[i])
+		}
+	}
+
+	stats := make([]*blocks.AllKeeper, len(sims))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0) && w < len(sims); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				stats[i] = runSimulation(c, sims[i])
+			}
+		}()
+	}
+	for i := range sims {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	agg := blocks.NewReplicationAggregator(c.Percentiles)
+	for i, sim := range sims {
+		fmt.Fprintf(sim.InfoOutput(), "Replication %v seed: %v\n", i, seeds[i])
+		warnIfShort(c, sim, stats[i])
+		agg.Add(seeds[i], stats[i])
+		if sim.Aborted() {
+			fmt.Fprintf(sim.InfoOutput(), "WARNING: stopping after replication %v\n", i)
+			return agg, true
+		}
+	}
+	return agg, false
+}
@@ -0,0 +1,378 @@
+package topologies
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// mm1Config returns the config of an M/M/1 FIFO queue at load lambda/mu
+func mm1Config(lambda, mu, duration float64) Config {
+	return Config{
+		Topo: 0, Lambda: lambda, Mu: mu, Duration: duration, Cores: 1,
+		GenType: 0, ProcType: 0, Replications: 1, NUMANodes: 1,
+		WeibullShape: 0.5, GammaShape: 2,
+	}
+}
+
+// discardStats sets up the replications to only return their results
+func discardStats(i int, sim *engine.Simulation) {
+	sim.SetStatsOutput(io.Discard)
+}
+
+func TestRunReplicationsMM1(t *testing.T) {
+	c := mm1Config(0.5, 1, 2e4)
+	c.Replications = 20
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	agg, aborted := RunReplications(c, 1, discardStats)
+	if aborted {
+		t.Fatal("aborted without a wall clock limit")
+	}
+	if agg.Replications() != c.Replications {
+		t.Fatalf("%v replications aggregated, want %v", agg.Replications(), c.Replications)
+	}
+	// the mean sojourn time of M/M/1 is 1/(mu-lambda)
+	want := 1 / (c.Mu - c.Lambda)
+	mean, hw := agg.Interval(0)
+	if math.Abs(mean-want) > hw {
+		t.Errorf("mean delay %v +- %v, want %v in the interval", mean, hw, want)
+	}
+	if hw <= 0 || hw > 0.05*want {
+		t.Errorf("half width %v, want in (0, %v]", hw, 0.05*want)
+	}
+}
+
+// Replications of a deterministic workload all give the same results, so the
+// confidence intervals have no width
+func TestRunReplicationsDeterministic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace")
+	if err := os.WriteFile(path, []byte("0 1\n0.5 2\n1 1\n4 3\n5 0.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := mm1Config(0.5, 1, 100)
+	c.GenType, c.Path, c.Replications = 9, path, 5
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	agg, _ := RunReplications(c, 1, discardStats)
+	metrics := 0
+	for key, v := range agg.Summary() {
+		interval, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		metrics++
+		if hw := interval["half_width"]; hw != 0.0 {
+			t.Errorf("%v: half width %v, want 0", key, hw)
+		}
+	}
+	if metrics == 0 {
+		t.Error("no metric summarized")
+	}
+	// the mean delay of 1, 2.5, 3, 3 and 2.5
+	if mean, _ := agg.Interval(0); !(math.Abs(mean-2.4) < 1e-9) {
+		t.Errorf("mean delay %v, want 2.4", mean)
+	}
+}
+
+// The replications should not depend on how many of them run concurrently
+func TestRunReplicationsReproducible(t *testing.T) {
+	c := mm1Config(0.8, 1, 5e3)
+	c.Replications = 4
+	alone := func() float64 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+		agg, _ := RunReplications(c, 7, discardStats)
+		mean, _ := agg.Interval(0)
+		return mean
+	}()
+	agg, _ := RunReplications(c, 7, discardStats)
+	if mean, _ := agg.Interval(0); mean != alone {
+		t.Errorf("mean delay %v, %v with a single replication at a time", mean, alone)
+	}
+}
+
+// The actors of the replications should be shut down once they are over
+func TestRunReplicationsShutsActorsDown(t *testing.T) {
+	before := runtime.NumGoroutine()
+	c := mm1Config(0.8, 1, 1e3)
+	c.Replications = 8
+	RunReplications(c, 3, discardStats)
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%v goroutines left running, %v before the replications", n, before)
+	}
+}
+
+// Rerunning a single simulation with the seed of a replication reproduces
+// its results exactly
+func TestReplicationSeedReproduces(t *testing.T) {
+	c := mm1Config(0.8, 1, 5e3)
+	c.Replications = 3
+	agg, _ := RunReplications(c, 11, discardStats)
+	for r := 0; r < agg.Replications(); r++ {
+		sim := engine.NewSimulation()
+		sim.SetRand(rand.New(rand.NewSource(agg.Seed(r))))
+		sim.SetStatsOutput(io.Discard)
+		stats := Run(c, sim)
+		if got, want := stats.MeanDelay(), agg.Result(r, 0); got != want {
+			t.Errorf("replication %v with seed %v: mean delay %v, %v when replicated", r, agg.Seed(r), got, want)
+		}
+	}
+}
+
+// Every genType accepted by Validate has a generator, and the others are
+// rejected before the simulation is built. Without a path, the genTypes
+// reading workloads are rejected too
+// Every run reports its own percentiles, and the replications aggregate them
+func TestPercentilesOfConfig(t *testing.T) {
+	c := mm1Config(0.5, 1, 1e3)
+	c.Replications = 2
+	c.Percentiles = []float64{0.999, 0.5}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	agg, _ := RunReplications(c, 1, discardStats)
+	summary := runConfig(t, mm1Config(0.5, 1, 1e3), 1).Summary()
+	for key, want := range map[string]bool{"p50": true, "p99.9": true, "p99": false} {
+		if _, ok := agg.Summary()[key]; ok != want {
+			t.Errorf("%v in the replication summary: %v, want %v", key, ok, want)
+		}
+	}
+	if _, ok := summary["p99"]; !ok {
+		t.Error("no p99 in the summary of a run with the default percentiles")
+	}
+
+	for _, ps := range [][]float64{{}, {0}, {0.5, 1}} {
+		c.Percentiles = ps
+		if err := c.Validate(); err == nil {
+			t.Errorf("percentiles %v: no error", ps)
+		}
+	}
+}
+
+// The batch means intervals are only reported by the runs asking for them
+func TestBatchesOfConfig(t *testing.T) {
+	c := mm1Config(0.5, 1, 1e4)
+	c.Batches = 10
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	batched, ok := runConfig(t, c, 1).Summary()["batch_means"].(map[string]interface{})
+	if !ok || batched["batches"] != 10 {
+		t.Errorf("batch means %v, want 10 batches", batched)
+	}
+	if _, ok := runConfig(t, mm1Config(0.5, 1, 1e4), 1).Summary()["batch_means"]; ok {
+		t.Error("batch means reported by default")
+	}
+
+	for _, n := range []int{-1, 1} {
+		c.Batches = n
+		if err := c.Validate(); err == nil {
+			t.Errorf("batches %v: no error", n)
+		}
+	}
+}
+
+func TestValidateGenTypes(t *testing.T) {
+	for _, topo := range []int{0, 1, 5} {
+		for genType := -1; genType <= 20; genType++ {
+			c := mm1Config(0.5, 1, 1e3)
+			c.Topo, c.GenType, c.Machines = topo, genType, 1
+			c.ParetoAlpha, c.ParetoRange = 1.5, 100
+			c.MMPPRates, c.MMPPTransitions = []float64{0.1, 0.5}, [][]float64{{0, 0.01}, {0.01, 0}}
+			c.PhaseProbs, c.PhaseMeans = []float64{0.5, 0.5}, []float64{1, 3}
+			c.LambdaPeriod, c.LambdaAmplitude = 100, 0.5
+			c.ZipfKeys, c.ZipfExponent = 10, 0.99
+			err := c.Validate()
+			supported := genType >= 0 && genType <= 3
+			if topo == 0 {
+				supported = genType >= 0 && genType <= 16 && genType != 5 && genType != 6 && genType != 7 && genType != 9
+			}
+			if (err == nil) != supported {
+				t.Errorf("topo %v: genType %v: %v", topo, genType, err)
+			}
+			if err != nil {
+				continue
+			}
+			if topo == 0 && newSingleQueueGenerator(engine.NewSimulation(), c.GenType, c.Lambda, c.Mu, c) == nil ||
+				topo != 0 && newDispatchGenerator(c.GenType, c.Lambda, c.Mu, c.Path, c.CDFScale) == nil {
+				t.Errorf("topo %v: no generator for genType %v", topo, genType)
+			}
+		}
+	}
+}
+
+// The extra classes only accept the genTypes of an open loop generator with
+// the rates of the class
+func TestValidateClassGenTypes(t *testing.T) {
+	for genType := -1; genType <= 20; genType++ {
+		c := mm1Config(0.5, 1, 1e3)
+		c.ParetoAlpha, c.ParetoRange = 1.5, 100
+		c.PhaseProbs, c.PhaseMeans = []float64{0.5, 0.5}, []float64{1, 3}
+		c.Classes = []ClassSpec{{Lambda: 0.1, GenType: genType, Mu: 1}}
+		err := c.Validate()
+		supported := genType >= 0 && genType <= 14 && genType != 6 && genType != 7 && genType != 9 && genType != 10
+		if genType == 5 {
+			// needs a workload path
+			supported = false
+		}
+		if (err == nil) != supported {
+			t.Errorf("class genType %v: %v", genType, err)
+		}
+		if err == nil && newSingleQueueGenerator(engine.NewSimulation(), genType, 0.1, 1, c) == nil {
+			t.Errorf("no generator for class genType %v", genType)
+		}
+	}
+}
+
+// The mixture weights are checked before the CDFs are loaded
+func TestValidateMixWeights(t *testing.T) {
+	for _, tc := range []struct {
+		weights []float64
+		valid   bool
+	}{
+		{[]float64{0.3, 0.7}, true},
+		{[]float64{0.5, 0.5000001}, true},
+		{[]float64{0.3, 0.6}, false},
+		{[]float64{1.5, -0.5}, false},
+		{[]float64{0.5}, false},
+	} {
+		c := mm1Config(0.5, 1, 1e3)
+		c.GenType, c.MixPaths, c.MixWeights = 7, []string{"w3", "w4"}, tc.weights
+		if err := c.Validate(); (err == nil) != tc.valid {
+			t.Errorf("weights %v: %v", tc.weights, err)
+		}
+	}
+}
+
+// The MMPP states should not be negative and should all lead to arrivals,
+// otherwise the generator waits forever
+func TestValidateMMPP(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		rates       []float64
+		transitions [][]float64
+		valid       bool
+	}{
+		{"bursty", []float64{0.1, 0.5}, [][]float64{{0, 0.01}, {0.01, 0}}, true},
+		{"off state", []float64{0, 0.5}, [][]float64{{0, 0.01}, {0.01, 0}}, true},
+		{"absorbing", []float64{0.5}, [][]float64{{0}}, true},
+		{"silent absorbing", []float64{0.5, 0}, [][]float64{{0, 0.01}, {0, 0}}, false},
+		{"silent cycle", []float64{0, 0, 0.5}, [][]float64{{0, 1, 0}, {1, 0, 0}, {0.1, 0, 0}}, false},
+		{"negative rate", []float64{-0.1, 0.5}, [][]float64{{0, 0.01}, {0.01, 0}}, false},
+		{"negative transition", []float64{0.1, 0.5}, [][]float64{{0, -0.01}, {0.01, 0}}, false},
+	} {
+		c := mm1Config(0.5, 1, 1e3)
+		c.GenType, c.MMPPRates, c.MMPPTransitions = 10, tc.rates, tc.transitions
+		if err := c.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%v: %v", tc.name, err)
+		}
+	}
+}
+
+func TestLoadConfigRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mm1.json")
+	sample := `{"topo": 0, "lambda": 0.5, "mu": 1, "duration": 20000, "cores": 1, "genType": 0, "procType": 0}`
+	if err := os.WriteFile(path, []byte(sample), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the parameters missing from the file keep their defaults
+	c := mm1Config(0.9, 2, 1e3)
+	c.Replications = 3
+	if err := LoadConfig(path, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Lambda != 0.5 || c.Mu != 1 || c.Duration != 2e4 || c.Replications != 3 {
+		t.Errorf("lambda %v, mu %v, duration %v and %v replications, want 0.5, 1, 20000 and 3",
+			c.Lambda, c.Mu, c.Duration, c.Replications)
+	}
+
+	// writing the config back and reading it gives the same config
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip := filepath.Join(dir, "round_trip.json")
+	if err := os.WriteFile(roundTrip, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var loaded Config
+	if err := LoadConfig(roundTrip, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, c) {
+		t.Errorf("config %+v read back as %+v", c, loaded)
+	}
+
+	// and it builds a runnable M/M/1 queue, of mean sojourn time 1/(mu-lambda)
+	loaded.Replications = 1
+	if mean := runConfig(t, loaded, 1).MeanDelay(); math.Abs(mean-2) > 0.2 {
+		t.Errorf("mean delay %v, want about 2", mean)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"unknown.json":   `{"lambda": 0.5, "lamda": 0.5}`,
+		"malformed.json": `{"lambda": 0.5`,
+		"type.json":      `{"cores": "four"}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c := mm1Config(0.5, 1, 1e3)
+		if err := LoadConfig(path, &c); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+	if err := LoadConfig(filepath.Join(dir, "missing.json"), &Config{}); err == nil {
+		t.Error("missing config: no error")
+	}
+
+	// the required parameters are checked before the simulation is built
+	c := mm1Config(0.5, 1, 1e3)
+	c.Cores = 0
+	if err := c.Validate(); err == nil {
+		t.Error("no error without cores")
+	}
+	c = mm1Config(0.5, 1, 1e3)
+	c.ProcType, c.Cores = 13, 2
+	if err := c.Validate(); err == nil {
+		t.Error("no error with preemptive priorities on two cores")
+	}
+}
+
+// The run stops after maxReqs completions or the duration, whichever comes
+// first
+func TestMaxReqsStopsRun(t *testing.T) {
+	for _, topo := range []int{0, 1} {
+		c := mm1Config(0.8, 1, 1e9)
+		c.Topo, c.Cores, c.Lambda, c.MaxReqs = topo, 4, 3.2, 1000
+		if n := runConfig(t, c, 1).Count(); n != 1000 {
+			t.Errorf("topo %v: %v requests completed, want 1000", topo, n)
+		}
+
+		// about lambda*duration requests complete before the duration
+		c.Duration, c.MaxReqs = 100, 1e6
+		if n := runConfig(t, c, 1).Count(); n < 250 || n > 400 {
+			t.Errorf("topo %v: %v requests completed in 100, want about 320", topo, n)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package topologies
+
+import (
+	"fmt"
+
+	"github.com/epfl-dcsl/schedsim/blocks"
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// DAGQueue describes a topology where jobs made of a DAG of tasks arrive to a
+// single queue served by run to completion cores. The tasks of a job are
+// enqueued as their predecessors complete. The statistics report the delay
+// percentiles, nil for the default ones, and batch means intervals over
+// batches batches, 0 for none.
+// It returns the job statistics once the simulation is over
+func DAGQueue(sim *engine.Simulation, lambda, duration, warmup float64, maxReqs, cores int, ctxCost float64, template blocks.DAGTemplate,
+	percentiles []float64, batches int) *blocks.AllKeeper {
+
+	//Init the statistics
+	stats := &blocks.AllKeeper{}
+	stats.SetName("Job Stats")
+	stats.SetWarmup(warmup)
+	stats.SetPercentiles(percentiles)
+	stats.SetBatches(batches)
+	stats.StopAfter(maxReqs)
+	sim.InitStats(stats)
+
+	// Add generator, that is also the drain of the tasks
+	g := blocks.NewDAGGenerator(lambda, template)
+	g.SetJobDrain(stats)
+
+	// Create queues
+	q := blocks.NewQueue()
+	g.AddOutQueue(q)
+
+	// Create processors
+	for i := 0; i < cores; i++ {
+		p := blocks.NewRTCProcessor(ctxCost)
+		p.SetID(i)
+		p.AddInQueue(q)
+		p.SetReqDrain(g)
+		sim.RegisterActor(p)
+	}
+
+	// Register the generator
+	sim.RegisterActor(g)
+
+	fmt.Fprintf(sim.InfoOutput(), "Cores:%v\ttasks:%v\tinterarrival_rate:%v\n", cores, len(template.ServiceTimes), lambda)
+	sim.Run(duration)
+	return stats
+}
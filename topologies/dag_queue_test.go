@@ -0,0 +1,48 @@
+package topologies
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/blocks"
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+func TestDAGQueueDiamond(t *testing.T) {
+	// a fans out to b and c, which both precede d: the critical path a-c-d
+	// takes 5 and the four tasks 7
+	diamond := blocks.DAGTemplate{
+		ServiceTimes: []float64{1, 2, 3, 1},
+		Deps:         [][]int{{}, {0}, {0}, {1, 2}},
+	}
+	for _, tc := range []struct {
+		cores int
+		want  float64
+	}{
+		// b and c run in parallel, but d waits for both
+		{2, 5},
+		{3, 5},
+		// all the tasks run one after the other
+		{1, 7},
+	} {
+		sim := engine.NewSimulation()
+		sim.SetRand(rand.New(rand.NewSource(1)))
+		// rare jobs, which mostly run alone
+		stats := DAGQueue(sim, 1e-3, 1e6, 0, 0, tc.cores, 0, diamond, nil, 0)
+		if stats.Count() < 500 {
+			t.Fatalf("%v cores: only %v jobs completed", tc.cores, stats.Count())
+		}
+		alone := 0
+		for _, d := range stats.Delays() {
+			if d < tc.want-1e-9 {
+				t.Fatalf("%v cores: job delay %v, below %v", tc.cores, d, tc.want)
+			}
+			if d < tc.want+1e-9 {
+				alone++
+			}
+		}
+		if alone < stats.Count()*9/10 {
+			t.Errorf("%v cores: only %v of %v jobs took %v", tc.cores, alone, stats.Count(), tc.want)
+		}
+	}
+}
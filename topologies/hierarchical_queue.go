@@ -0,0 +1,99 @@
+package topologies
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/epfl-dcsl/schedsim/blocks"
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// HierarchicalQueue describes a cluster of machines, each with its own queue
+// shared by its cores. A cluster level dispatcher, the generator, feeds every
+// request to a machine selected by dispatch, based on the requests in each
+// machine for jsq and po2. Every machine serves its queue with cores cores of
+// procType: FIFO (0), processor sharing (1), time sharing (2) or SRPT time
+// sharing (3). The statistics of every machine are printed along with the
+// cluster wide ones, reporting the delay percentiles, nil for the default ones,
+// and batch means intervals over batches batches, 0 for none.
+// It returns the main statistics once the simulation is over
+func HierarchicalQueue(sim *engine.Simulation, lambda, mu, duration, warmup float64, maxReqs int, genType, procType int, quantum float64,
+	machines, cores int, ctxCost float64, path string, cdfScale float64, dispatch blocks.DispatchPolicy,
+	percentiles []float64, batches int) *blocks.AllKeeper {
+
+	//Init the cluster wide statistics
+	stats := &blocks.AllKeeper{}
+	stats.SetName("Main Stats")
+	stats.SetWarmup(warmup)
+	stats.SetPercentiles(percentiles)
+	stats.SetBatches(batches)
+	stats.StopAfter(maxReqs)
+	sim.InitStats(stats)
+
+	capacity := blocks.NewCapacityKeeper(mu, lambda, machines*cores)
+	sim.InitStats(capacity)
+
+	// Add the cluster dispatcher
+	g := newDispatchGenerator(genType, lambda, mu, path, cdfScale)
+	g.SetDispatch(dispatch)
+
+	// Track the requests in the system
+	occupancy := blocks.NewOccupancyKeeper(&blocks.SimpleReqCreator{}, stats)
+	sim.InitStats(occupancy)
+	g.SetCreator(occupancy)
+
+	for m := 0; m < machines; m++ {
+		var q engine.QueueInterface = blocks.NewQueue()
+		if procType == 3 {
+			q = blocks.NewPQueue()
+		}
+		machine := blocks.NewMachineQueue(q, occupancy)
+		machine.SetName(fmt.Sprintf("Machine %v", m))
+		machine.SetWarmup(warmup)
+		machine.SetPercentiles(percentiles)
+		machine.SetBatches(batches)
+		sim.InitStats(machine)
+		g.AddOutQueue(machine)
+
+		// Processors are numbered across the cluster
+		if procType == 1 {
+			p := blocks.NewPSProcessorCtx(ctxCost)
+			p.SetWorkerCount(cores)
+			p.SetID(m * cores)
+			p.AddInQueue(machine)
+			p.SetReqDrain(machine)
+			capacity.AddProcessor(p)
+			sim.RegisterActor(p)
+			continue
+		}
+		for i := 0; i < cores; i++ {
+			var p blocks.Processor
+			if procType == 0 {
+				p = blocks.NewRTCProcessor(ctxCost)
+			} else if procType == 2 {
+				p = blocks.NewTSProcessor(quantum, ctxCost)
+			} else if procType == 3 {
+				p = blocks.NewSrptTSProcessor(quantum, ctxCost)
+			}
+			p.SetID(m*cores + i)
+			p.AddInQueue(machine)
+			p.SetReqDrain(machine)
+			capacity.AddProcessor(p)
+			sim.RegisterActor(p)
+		}
+	}
+
+	// Register the generator
+	sim.RegisterActor(g)
+
+	policy, _ := dispatch.MarshalText()
+	// a single write, whole even if replications run concurrently
+	var desc strings.Builder
+	fmt.Fprintf(&desc, "Machines:%v\tCores:%v\tservice_rate:%v\tinterarrival_rate:%v\tdispatch:%s", machines, cores, mu, lambda, policy)
+	if procType == 2 || procType == 3 {
+		fmt.Fprintf(&desc, "\tquantum:%v", quantum)
+	}
+	fmt.Fprintln(sim.InfoOutput(), desc.String())
+	sim.Run(duration)
+	return stats
+}
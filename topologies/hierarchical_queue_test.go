@@ -0,0 +1,48 @@
+package topologies
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/blocks"
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+func TestHierarchicalQueueMachines(t *testing.T) {
+	// 2 machines of 2 FIFO cores at a load of 0.8
+	c := mm1Config(3.2, 1, 2e4)
+	c.Topo, c.Machines, c.Cores, c.Dispatch = 5, 2, 2, blocks.DispatchJSQ
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	sim := engine.NewSimulation()
+	sim.SetRand(rand.New(rand.NewSource(1)))
+	sim.SetStatsOutput(&out)
+	stats := Run(c, sim)
+
+	// the cores are numbered across the cluster, machine by machine
+	total := 0
+	for m := 0; m < c.Machines; m++ {
+		machine := stats.Filter(func(d blocks.RequestData) bool { return d.ServedBy/c.Cores == m })
+		// JSQ balances the machines
+		if want := float64(stats.Count()) / 2; math.Abs(float64(machine.Count())-want) > 0.05*want {
+			t.Errorf("machine %v: %v of the %v requests", m, machine.Count(), stats.Count())
+		}
+		total += machine.Count()
+		if name := fmt.Sprintf("Stats collector: Machine %v\n", m); !strings.Contains(out.String(), name) {
+			t.Errorf("no statistics for machine %v", m)
+		}
+	}
+	if total != stats.Count() {
+		t.Errorf("%v requests served by the machines, %v in total", total, stats.Count())
+	}
+	// all the arrivals but the few still in the system complete
+	if want := c.Lambda * c.Duration; math.Abs(float64(stats.Count())-want) > 0.02*want {
+		t.Errorf("%v requests completed, want about %v", stats.Count(), want)
+	}
+}
@@ -2,41 +2,63 @@ package topologies
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/epfl-dcsl/schedsim/blocks"
 	"github.com/epfl-dcsl/schedsim/engine"
 )
 
 // MultiQueue describes a single-generator-multi-processor topology where every
-// processor has its own incoming queue
-func MultiQueue(lambda, mu, duration float64, genType, procType int, quantum float64, cores int, ctxCost float64) {
+// processor has its own incoming queue. With sharedQueue, the generator also
+// feeds a shared overflow queue that the run to completion processors serve
+// when their own queue is empty. With steal, idle run to completion processors
+// steal requests from a random non-empty sibling queue instead. dispatch
+// selects the queue the generator feeds every request to. The statistics
+// report the delay percentiles, nil for the default ones, and batch means
+// intervals over batches batches, 0 for none.
+// It returns the main statistics once the simulation is over
+func MultiQueue(sim *engine.Simulation, lambda, mu, duration, warmup float64, maxReqs int, genType, procType int, quantum float64, cores int, ctxCost float64,
+	path string, cdfScale float64, sharedQueue, steal bool, dispatch blocks.DispatchPolicy, coreSpeeds []float64,
+	numaNodes int, transferCost float64, percentiles []float64, batches int) *blocks.AllKeeper {
 
-	engine.InitSim()
+	checkCoreSpeeds(coreSpeeds, cores, procType == 0 && !sharedQueue && !steal)
+
+	if steal && (procType != 0 || sharedQueue) {
+		panic("Work stealing is only supported with run to completion processors and no shared queue")
+	}
 
 	//Init the statistics
 	//stats := blocks.NewBookKeeper()
 	stats := &blocks.AllKeeper{}
 	stats.SetName("Main Stats")
-	engine.InitStats(stats)
+	stats.SetWarmup(warmup)
+	stats.SetPercentiles(percentiles)
+	stats.SetBatches(batches)
+	stats.StopAfter(maxReqs)
+	sim.InitStats(stats)
+
+	capacity := blocks.NewCapacityKeeper(mu, lambda, cores)
+	sim.InitStats(capacity)
 
 	// Add generator
-	var g blocks.Generator
-	if genType == 0 {
-		g = blocks.NewMMRandGenerator(lambda, mu)
-	} else if genType == 1 {
-		g = blocks.NewMDRandGenerator(lambda, 1/mu)
-	} else if genType == 2 {
-		g = blocks.NewMBRandGenerator(lambda, 1, 10*(1/mu-0.9), 0.9)
-	} else if genType == 3 {
-		g = blocks.NewMBRandGenerator(lambda, 1, 1000*(1/mu-0.999), 0.999)
-	}
+	g := newDispatchGenerator(genType, lambda, mu, path, cdfScale)
+	g.SetDispatch(dispatch)
 
-	g.SetCreator(&blocks.SimpleReqCreator{})
+	// Track the requests in the system
+	var creator blocks.ReqCreator = &blocks.SimpleReqCreator{}
+	if steal {
+		creator = &blocks.StealableReqCreator{}
+	}
+	occupancy := blocks.NewOccupancyKeeper(creator, stats)
+	sim.InitStats(occupancy)
+	g.SetCreator(occupancy)
 
-	// Create queues
+	// Create queues, each on the node of its core
 	fastQueues := make([]engine.QueueInterface, cores)
 	for i := range fastQueues {
-		fastQueues[i] = blocks.NewQueue()
+		q := blocks.NewQueue()
+		q.SetNode(coreNode(i, cores, numaNodes))
+		fastQueues[i] = q
 	}
 
 	// Create processors
@@ -44,10 +66,14 @@ func MultiQueue(lambda, mu, duration float64, genType, procType int, quantum flo
 
 	// first the slow cores
 	for i := 0; i < cores; i++ {
-		if procType == 0 {
-			processors[i] = blocks.NewRTCProcessor(ctxCost)
+		if procType == 0 && steal {
+			processors[i] = blocks.NewWorkStealingProcessor(ctxCost)
+		} else if procType == 0 && sharedQueue {
+			processors[i] = blocks.NewQueuePrioRTCProcessor(ctxCost)
+		} else if procType == 0 {
+			processors[i] = blocks.NewRTCProcessorScaled(ctxCost, coreSpeed(coreSpeeds, i))
 		} else if procType == 1 {
-			processors[i] = blocks.NewPSProcessor()
+			processors[i] = blocks.NewPSProcessorCtx(ctxCost)
 		} else if procType == 2 {
 			processors[i] = blocks.NewTSProcessor(quantum, ctxCost)
 		}
@@ -59,19 +85,74 @@ func MultiQueue(lambda, mu, duration float64, genType, procType int, quantum flo
 		processors[i].AddInQueue(q)
 	}
 
+	// Every processor can steal from all the other queues
+	if steal {
+		for i, p := range processors {
+			for j, q := range fastQueues {
+				if j != i {
+					p.(*blocks.WorkStealingProcessor).AddStealQueue(q, processors[j].(*blocks.WorkStealingProcessor))
+				}
+			}
+		}
+	}
+
+	// The shared queue has lower priority than the processor own queue
+	if sharedQueue {
+		if procType != 0 {
+			panic("Shared queue is only supported with run to completion processors")
+		}
+		shared := blocks.NewQueue()
+		g.AddOutQueue(shared)
+		for _, p := range processors {
+			p.AddInQueue(shared)
+		}
+	}
+
 	// Add the stats and register processors
-	for _, p := range processors {
-		p.SetReqDrain(stats)
-		engine.RegisterActor(p)
+	for i, p := range processors {
+		p.SetID(i)
+		p.SetNode(coreNode(i, cores, numaNodes), transferCost)
+		p.SetReqDrain(occupancy)
+		capacity.AddProcessor(p)
+		sim.RegisterActor(p)
 	}
 
 	// Register the generator
-	engine.RegisterActor(g)
+	sim.RegisterActor(g)
 
-	fmt.Printf("Cores:%v\tservice_rate:%v\tinterarrival_rate:%v", cores, mu, lambda)
+	// a single write, whole even if replications run concurrently
+	var desc strings.Builder
+	fmt.Fprintf(&desc, "Cores:%v\tservice_rate:%v\tinterarrival_rate:%v", cores, mu, lambda)
 	if procType == 2 {
-		fmt.Printf("\tquantum:%v", quantum)
+		fmt.Fprintf(&desc, "\tquantum:%v", quantum)
+	}
+	if numaNodes > 1 {
+		fmt.Fprintf(&desc, "\tnuma_nodes:%v\ttransfer_cost:%v", numaNodes, transferCost)
+	}
+	fmt.Fprintln(sim.InfoOutput(), desc.String())
+	sim.Run(duration)
+	return stats
+}
+
+// newDispatchGenerator returns the generator of the given genType feeding
+// multiple queues. It panics on an unknown genType, which Config.Validate
+// rejects
+func newDispatchGenerator(genType int, lambda, mu float64, path string, cdfScale float64) blocks.DispatchGenerator {
+	var g blocks.DispatchGenerator
+	if genType == 0 {
+		g = blocks.NewMMRandGenerator(lambda, mu)
+	} else if genType == 1 {
+		g = blocks.NewMDRandGenerator(lambda, 1/mu)
+	} else if genType == 2 {
+		g = blocks.NewMBRandGenerator(lambda, 1, 10*(1/mu-0.9), 0.9)
+	} else if genType == 3 {
+		g = blocks.NewMBRandGenerator(lambda, 1, 1000*(1/mu-0.999), 0.999)
+	} else if genType == 5 {
+		g = blocks.NewCDFGeneratorScaled(lambda, path, cdfScale)
+	} else if genType == 9 {
+		g = blocks.NewTraceGenerator(path)
+	} else {
+		panic(fmt.Sprintf("Unknown genType %v", genType))
 	}
-	fmt.Println()
-	engine.Run(duration)
+	return g
 }
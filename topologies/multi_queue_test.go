@@ -0,0 +1,99 @@
+package topologies
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/blocks"
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// runConfig validates c and runs it in a simulation seeded with seed
+func runConfig(t *testing.T, c Config, seed int64) *blocks.AllKeeper {
+	t.Helper()
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sim := engine.NewSimulation()
+	sim.SetRand(rand.New(rand.NewSource(seed)))
+	sim.SetStatsOutput(io.Discard)
+	return Run(c, sim)
+}
+
+// multiQueueConfig returns the config of cores M/M/1 queues fed at a total
+// rate lambda
+func multiQueueConfig(lambda float64, cores int) Config {
+	c := mm1Config(lambda, 1, 5e4)
+	c.Topo, c.Cores = 1, cores
+	return c
+}
+
+func TestMultiQueueWorkStealing(t *testing.T) {
+	c := multiQueueConfig(3.2, 4)
+	alone := runConfig(t, c, 1)
+	c.Steal = true
+	stealing := runConfig(t, c, 1)
+
+	if n := alone.Summary()["stolen"].(int); n != 0 {
+		t.Errorf("%v requests stolen without stealing", n)
+	}
+	if n := stealing.Summary()["stolen"].(int); n < stealing.Count()/20 {
+		t.Errorf("only %v of %v requests stolen", n, stealing.Count())
+	}
+	// idle cores help the busy ones instead of waiting
+	if s, a := stealing.Percentile(0.99), alone.Percentile(0.99); !(s < a/2) {
+		t.Errorf("99th percentile %v with stealing, %v without", s, a)
+	}
+}
+
+func TestMultiQueueDispatchPolicies(t *testing.T) {
+	// bimodal service times of mean 1, 10% of them 10 times longer
+	c := multiQueueConfig(3.2, 4)
+	c.GenType = 2
+	p99 := map[blocks.DispatchPolicy]float64{}
+	for _, d := range []blocks.DispatchPolicy{blocks.DispatchRandom, blocks.DispatchPowerOfTwo, blocks.DispatchJSQ} {
+		c.Dispatch = d
+		p99[d] = runConfig(t, c, 1).Percentile(0.99)
+	}
+	// looking at the queue lengths avoids the queues stuck behind long
+	// requests, and looking at all of them more so than at two
+	if !(p99[blocks.DispatchJSQ] < p99[blocks.DispatchPowerOfTwo] && p99[blocks.DispatchPowerOfTwo] < p99[blocks.DispatchRandom]/2) {
+		t.Errorf("99th percentile %v with JSQ, %v with the power of two choices, %v at random",
+			p99[blocks.DispatchJSQ], p99[blocks.DispatchPowerOfTwo], p99[blocks.DispatchRandom])
+	}
+}
+
+func TestCDFWorkloadTopologies(t *testing.T) {
+	// every request has a size of 2000, i.e. a service time of 2
+	path := filepath.Join(t.TempDir(), "sizes.cdf")
+	if err := os.WriteFile(path, []byte("2000\n2000 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		topo     int
+		minDelay float64
+	}{
+		{1, 2},
+		{2, 6}, // the two stages take three times the service time together
+	} {
+		c := multiQueueConfig(0.5, 2)
+		c.Topo, c.GenType, c.Path, c.CDFScale, c.BufferSize = tc.topo, 5, path, 1000, 10
+		stats := runConfig(t, c, 1)
+		if stats.Count() < 1000 {
+			t.Errorf("topo %v: %v requests completed", tc.topo, stats.Count())
+			continue
+		}
+		// the requests that do not wait only take their service time
+		min := math.Inf(1)
+		for _, d := range stats.Delays() {
+			min = math.Min(min, d)
+		}
+		if math.Abs(min-tc.minDelay) > 1e-9 {
+			t.Errorf("topo %v: minimum delay %v, want %v", tc.topo, min, tc.minDelay)
+		}
+	}
+}
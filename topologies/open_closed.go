@@ -0,0 +1,79 @@
+package topologies
+
+import (
+	"fmt"
+
+	"github.com/epfl-dcsl/schedsim/blocks"
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+// OpenClosedLoop runs the same single queue of run to completion cores twice,
+// once with open loop Poisson arrivals of rate lambda and once with a closed
+// loop of clients. The think time of the clients is clients/lambda - 1/mu,
+// so that both runs offer the same mean load when queueing is negligible.
+// The open loop runs on sim and the closed loop on a sibling of it. The
+// statistics report the delay percentiles, nil for the default ones, and
+// batch means intervals over batches batches, 0 for none.
+// It returns the open loop statistics once both simulations are over
+func OpenClosedLoop(sim *engine.Simulation, lambda, mu, duration, warmup float64, maxReqs, cores int, ctxCost float64, clients int,
+	percentiles []float64, batches int) *blocks.AllKeeper {
+	thinkTime := float64(clients)/lambda - 1/mu
+	if thinkTime < 0 {
+		panic(fmt.Sprintf("Too few clients to match the load: %v", clients))
+	}
+
+	fmt.Fprintf(sim.InfoOutput(), "Cores:%v\tservice_rate:%v\tinterarrival_rate:%v\tclients:%v\tthink_time:%v\n", cores, mu, lambda, clients, thinkTime)
+	open := runLoop(sim, lambda, mu, duration, warmup, maxReqs, cores, ctxCost, 0, 0, percentiles, batches)
+	closed := runLoop(sim.Sibling(), lambda, mu, duration, warmup, maxReqs, cores, ctxCost, clients, thinkTime, percentiles, batches)
+
+	fmt.Fprintf(sim.InfoOutput(), "open_loop_avg:%v\tclosed_loop_avg:%v\topen_loop_99th:%v\tclosed_loop_99th:%v\n",
+		open.MeanDelay(), closed.MeanDelay(), open.Percentile(0.99), closed.Percentile(0.99))
+	return open
+}
+
+// runLoop runs a single simulation of OpenClosedLoop. clients = 0 selects the
+// open loop arrivals
+func runLoop(sim *engine.Simulation, lambda, mu, duration, warmup float64, maxReqs, cores int, ctxCost float64, clients int, thinkTime float64,
+	percentiles []float64, batches int) *blocks.AllKeeper {
+	//Init the statistics
+	stats := &blocks.AllKeeper{}
+	stats.SetWarmup(warmup)
+	stats.SetPercentiles(percentiles)
+	stats.SetBatches(batches)
+	stats.StopAfter(maxReqs)
+	sim.InitStats(stats)
+
+	// Add generator
+	var g blocks.Generator
+	g = blocks.NewMMRandGenerator(lambda, mu)
+	g.SetCreator(&blocks.SimpleReqCreator{})
+	var drain blocks.RequestDrain = stats
+	if clients > 0 {
+		stats.SetName("Closed Loop Stats")
+		cl := blocks.NewClosedLoopGenerator(clients, thinkTime, &blocks.SimpleReqCreator{})
+		cl.SetServiceTimeOf(g)
+		cl.SetReqDrain(stats)
+		g, drain = cl, cl
+	} else {
+		stats.SetName("Open Loop Stats")
+	}
+
+	// Create queues
+	q := blocks.NewQueue()
+	g.AddOutQueue(q)
+
+	// Create processors
+	for i := 0; i < cores; i++ {
+		p := blocks.NewRTCProcessor(ctxCost)
+		p.SetID(i)
+		p.AddInQueue(q)
+		p.SetReqDrain(drain)
+		sim.RegisterActor(p)
+	}
+
+	// Register the generator
+	sim.RegisterActor(g)
+
+	sim.Run(duration)
+	return stats
+}
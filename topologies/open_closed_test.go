@@ -0,0 +1,36 @@
+package topologies
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/engine"
+)
+
+func TestOpenClosedLoop(t *testing.T) {
+	const lambda, mu, duration, clients = 0.8, 1.0, 1e5, 4
+	sim := engine.NewSimulation()
+	sim.SetRand(rand.New(rand.NewSource(1)))
+	open := runLoop(sim, lambda, mu, duration, 0, 0, 1, 0, 0, 0, nil, 0)
+	closed := runLoop(sim.Sibling(), lambda, mu, duration, 0, 0, 1, 0, clients, clients/lambda-1/mu, nil, 0)
+
+	// both runs complete about lambda*duration requests, a little less for
+	// the closed loop, whose clients wait for their requests
+	for _, r := range []struct {
+		name  string
+		count int
+	}{{"open", open.Count()}, {"closed", closed.Count()}} {
+		if r.count < lambda*duration*0.8 || r.count > lambda*duration*1.05 {
+			t.Errorf("%v loop: %v requests completed, want about %v", r.name, r.count, lambda*duration)
+		}
+	}
+	// the open loop is an M/M/1 queue, while the clients of the closed loop
+	// can never queue more than clients requests
+	if want := 1 / (mu - lambda); math.Abs(open.MeanDelay()-want) > 0.1*want {
+		t.Errorf("open loop mean delay %v, want about %v", open.MeanDelay(), want)
+	}
+	if !(closed.MeanDelay() < open.MeanDelay()/2) {
+		t.Errorf("closed loop mean delay %v, open loop %v", closed.MeanDelay(), open.MeanDelay())
+	}
+}
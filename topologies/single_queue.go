@@ -2,25 +2,458 @@ package topologies
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/epfl-dcsl/schedsim/blocks"
 	"github.com/epfl-dcsl/schedsim/engine"
 )
 
 // SingleQueue implement a single-generator-multiprocessor topology with a single
-// queue. Each processor just dequeues from this queue
-func SingleQueue(lambda, mu, duration float64,
-	genType, procType int, quantum float64, cores int,
-	ctxCost float64, path string) {
+// queue. Each processor just dequeues from this queue.
+// With c.ClosedLoop the arrivals are closed loop: every one of the c.Clients
+// clients submits a request with the service times of c.GenType and the next
+// one c.ThinkTime after its completion, and c.Lambda is ignored.
+// It returns the main statistics once the simulation is over
+func SingleQueue(sim *engine.Simulation, c Config) *blocks.AllKeeper {
+	clients := 0
+	if c.ClosedLoop {
+		clients = c.Clients
+	}
+
+	checkCoreSpeeds(c.CoreSpeeds, c.Cores, c.ProcType == 0 || c.ProcType == 9 || c.ProcType == 11)
+
+	//Init the statistics, per priority level with preemptive priorities or
+	// per class with multiple classes
+	var stats *blocks.AllKeeper
+	var mainDrain blocks.RequestDrain
+	if c.ProcType == 13 || len(c.Classes) > 0 {
+		classKeeper := blocks.NewClassKeeper()
+		classKeeper.SetName("Main Stats")
+		classKeeper.SetWarmup(c.Warmup)
+		classKeeper.SetPercentiles(c.Percentiles)
+		classKeeper.SetBatches(c.Batches)
+		sim.InitStats(classKeeper)
+		stats, mainDrain = classKeeper.All(), classKeeper
+	} else {
+		stats = &blocks.AllKeeper{}
+		stats.SetName("Main Stats")
+		stats.SetWarmup(c.Warmup)
+		stats.SetPercentiles(c.Percentiles)
+		stats.SetBatches(c.Batches)
+		sim.InitStats(stats)
+		mainDrain = stats
+	}
+	stats.StopAfter(c.MaxReqs)
 
-	engine.InitSim()
+	// Follow the completions over time along with the main statistics
+	if c.TimeSeries > 0 {
+		series := blocks.NewTimeSeriesKeeper(c.TimeSeries)
+		series.SetName("Time Series")
+		sim.InitStats(series)
+		mainDrain = blocks.NewMuxDrain(mainDrain, series)
+	}
 
-	//Init the statistics
-	stats := &blocks.AllKeeper{}
-	stats.SetName("Main Stats")
-	engine.InitStats(stats)
+	// every arrival of a bulk generator issues bulkSize requests on average
+	reqRate := c.Lambda
+	if c.BulkSize > 1 {
+		reqRate *= c.BulkSize
+	}
+	capacity := blocks.NewCapacityKeeper(c.Mu, reqRate, c.Cores)
+	sim.InitStats(capacity)
+
+	if c.AcfLags > 0 {
+		sim.InitStats(blocks.NewAutocorrKeeper(stats, c.AcfLags))
+	}
+
+	energy := blocks.NewEnergyKeeper(c.BusyPower, c.IdlePower, c.SleepPower, stats)
+	if c.BusyPower > 0 || c.SleepAfter > 0 {
+		sim.InitStats(energy)
+	}
 
 	// Add generator
+	g := newSingleQueueGenerator(sim, c.GenType, c.Lambda, c.Mu, c)
+	if mmpp, ok := g.(*blocks.MMPPGenerator); ok {
+		sim.InitStats(mmpp)
+	}
+	if c.BulkSize > 1 {
+		g.(blocks.BulkGenerator).SetBulk(c.BulkDist, c.BulkSize)
+	}
+
+	var creator blocks.ReqCreator = &blocks.SimpleReqCreator{}
+	if c.ProcType == 6 || c.ProcType == 11 {
+		creator = &blocks.DeadlineReqCreator{Slack: c.DeadlineSlack}
+	} else if c.ProcType == 9 {
+		creator = blocks.NewValueReqCreator(1.0, c.ValueCorr)
+	} else if c.ProcType == 13 {
+		creator = blocks.NewPriorityReqCreator(c.HighPrio)
+	} else if c.ProcType == 15 {
+		creator = blocks.NewGangReqCreator(c.GangWidth, c.GangRatio)
+	}
+
+	// Count the deadline misses of the completed requests
+	final := mainDrain
+	if c.ProcType == 6 || c.ProcType == 11 {
+		deadlines := blocks.NewDeadlineKeeper(mainDrain)
+		deadlines.SetName("Deadline Stats")
+		deadlines.SetWarmup(c.Warmup)
+		sim.InitStats(deadlines)
+		final = deadlines
+	}
+
+	// Count the SLO violations of the completed and shed requests
+	var slos *blocks.SLOKeeper
+	if c.SLO > 0 {
+		slos = blocks.NewSLOKeeper(c.SLO, final)
+		slos.SetName("SLO Stats")
+		slos.SetWarmup(c.Warmup)
+		sim.InitStats(slos)
+		final = slos
+	}
+
+	// Track the requests in the system
+	occupancy := blocks.NewOccupancyKeeper(creator, final)
+	sim.InitStats(occupancy)
+
+	// Cores shed the requests that cannot meet the SLO anymore
+	var shedDrain blocks.RequestDrain
+	if c.Shed {
+		shedStats := &blocks.AllKeeper{}
+		shedStats.SetName("Shed Stats")
+		shedStats.SetPercentiles(c.Percentiles)
+		shedStats.SetBatches(c.Batches)
+		sim.InitStats(shedStats)
+		shedDrain = slos.ShedDrain(occupancy.DrainTo(shedStats))
+	}
+
+	// Completed requests go to the stats, through the clients if closed loop
+	var completed blocks.RequestDrain = occupancy
+	if clients > 0 {
+		// the clients draw the service times of the selected generator
+		cl := blocks.NewClosedLoopGenerator(clients, c.ThinkTime, occupancy)
+		cl.SetServiceTimeOf(g)
+		cl.SetReqDrain(occupancy)
+		sim.InitStats(cl)
+		g, completed = cl, cl
+	}
+	g.SetCreator(occupancy)
+
+	// Processors terminate requests to the stats or to the return path
+	drain := completed
+	if c.ReturnDelay > 0 {
+		back := blocks.NewQueue()
+		drain = blocks.NewQueueDrain(back)
+		d := blocks.NewPropagationDelay(c.ReturnDelay)
+		d.AddInQueue(back)
+		d.SetReqDrain(completed)
+		sim.RegisterActor(d)
+	}
+
+	// Failed requests are terminated to their stats or retried first
+	failDrain := func(rd blocks.RequestDrain) blocks.RequestDrain {
+		return occupancy.DrainTo(rd)
+	}
+	var retry *blocks.RetryCoordinator
+	if c.MaxRetries > 0 {
+		retry = blocks.NewRetryCoordinator(c.MaxRetries, c.RetryBackoff)
+		sim.InitStats(retry)
+		sim.RegisterActor(retry)
+		failDrain = func(rd blocks.RequestDrain) blocks.RequestDrain {
+			retry.SetReqDrain(occupancy.DrainTo(rd))
+			return retry
+		}
+	}
+
+	// Create queues
+	var q engine.QueueInterface
+	if (c.ProcType == 3 || c.ProcType == 11) && c.Aging > 0 {
+		q = blocks.NewAgingPQueue(c.Aging)
+	} else if c.ProcType == 3 || c.ProcType == 11 { // SRPT or EDF, ordered by GetCmpVal
+		q = blocks.NewPQueue()
+	} else if c.ProcType == 6 {
+		q = blocks.NewBlendedPQueue(c.Alpha)
+	} else if c.ProcType == 9 {
+		q = blocks.NewValuePQueue()
+	} else if c.ProcType == 12 {
+		q = blocks.NewLASPQueue()
+	} else if c.ShedThreshold > 0 {
+		droppedStats := &blocks.AllKeeper{}
+		droppedStats.SetName("Dropped Stats")
+		droppedStats.SetPercentiles(c.Percentiles)
+		droppedStats.SetBatches(c.Batches)
+		sim.InitStats(droppedStats)
+
+		sq := blocks.NewSheddingQueue(c.ShedThreshold, c.ShedPolicy)
+		sq.SetDropDrain(failDrain(droppedStats))
+		sim.InitStats(sq)
+		q = sq
+	} else {
+		q = blocks.NewQueue()
+	}
+
+	// Sample the queue length and the requests in the system over time
+	if c.SamplePeriod > 0 {
+		sampler := blocks.NewSampler(c.SamplePeriod)
+		sampler.SetName("Samples")
+		sampler.AddQueue("queue_len", q)
+		sampler.AddProbe("in_system", func() float64 { return float64(occupancy.InSystem()) })
+		sim.InitStats(sampler)
+		sim.RegisterActor(sampler)
+	}
+
+	// Create processors
+	cores := &coreWiring{sim: sim, c: c, q: q, drain: drain, capacity: capacity, energy: energy}
+	if c.ProcType == 0 || c.ProcType == 9 || c.ProcType == 11 { // FIFO, highest value or earliest deadline first
+		for i := 0; i < c.Cores; i++ {
+			p := blocks.NewRTCProcessorScaled(c.CtxCost, coreSpeed(c.CoreSpeeds, i))
+			p.SetAdmission(c.SLO, shedDrain)
+			cores.add(p, i)
+		}
+	} else if c.ProcType == 1 {
+		p := blocks.NewPSProcessorCtx(c.CtxCost)
+		p.SetWorkerCount(c.Cores)
+		p.AddInQueue(q)
+		p.SetReqDrain(drain)
+		capacity.AddProcessor(p)
+		energy.AddProcessor(p)
+		sim.RegisterActor(p)
+	} else if c.ProcType == 2 {
+		for i := 0; i < c.Cores; i++ {
+			cores.add(blocks.NewTSProcessor(c.Quantum, c.CtxCost), i)
+		}
+	} else if c.ProcType == 3 || c.ProcType == 6 { // SRPT or blended SRPT+EDF
+		for i := 0; i < c.Cores; i++ {
+			cores.add(blocks.NewSrptTSProcessor(c.Quantum, c.CtxCost), i)
+		}
+	} else if c.ProcType == 12 { // LAS
+		for i := 0; i < c.Cores; i++ {
+			cores.add(blocks.NewLASProcessor(c.Quantum, c.CtxCost), i)
+		}
+	} else if c.ProcType == 14 { // batching
+		for i := 0; i < c.Cores; i++ {
+			p := blocks.NewBatchProcessor(c.MaxBatch, c.MaxWait, c.BatchOverhead)
+			p.SetBatchService(c.BatchService)
+			sim.InitStats(p)
+			cores.add(p, i)
+		}
+	} else if c.ProcType == 15 { // gang scheduling
+		s := blocks.NewGangScheduler()
+		s.AddInQueue(q)
+		sim.InitStats(s)
+		for i := 0; i < c.Cores; i++ {
+			p := blocks.NewGangProcessor(c.CtxCost)
+			p.SetID(i)
+			p.SetReqDrain(drain)
+			s.AddProcessor(p)
+			capacity.AddProcessor(p)
+			energy.AddProcessor(p)
+			sim.RegisterActor(p)
+		}
+		sim.RegisterActor(s)
+	} else if c.ProcType == 5 { // RTC with scheduled speed
+		for i := 0; i < c.Cores; i++ {
+			cores.add(blocks.NewScheduledSpeedProcessor(c.SpeedSchedule, c.CtxCost), i)
+		}
+	} else if c.ProcType == 7 { // RTC with client timeout
+		timeoutStats := &blocks.TimeoutKeeper{}
+		timeoutStats.SetName("Timeout Stats")
+		timeoutStats.SetWarmup(c.Warmup)
+		sim.InitStats(timeoutStats)
+		timeoutDrain := failDrain(timeoutStats)
+		for i := 0; i < c.Cores; i++ {
+			p := blocks.NewTimeoutRTCProcessor(c.Timeout, c.CtxCost)
+			p.SetTimeoutDrain(timeoutDrain)
+			cores.add(p, i)
+		}
+	} else if c.ProcType == 8 { // RTC with a scale event adding or removing cores
+		total := c.Cores
+		if c.ScaleCores > 0 {
+			total += c.ScaleCores
+		}
+		cores.total = total
+		var added []engine.ActorInterface
+		for i := 0; i < total; i++ {
+			stop := -1.0
+			if c.ScaleCores < 0 && i >= c.Cores+c.ScaleCores {
+				// removed at the scale event
+				stop = c.ScaleTime
+			}
+			p := blocks.NewScalableRTCProcessor(c.CtxCost, 0, stop)
+			if i >= c.Cores {
+				// added at the scale event
+				cores.connect(p, i)
+				added = append(added, p)
+			} else {
+				cores.add(p, i)
+			}
+		}
+		if len(added) > 0 {
+			sim.RegisterActor(blocks.NewCoreLauncher(c.ScaleTime, added...))
+		}
+	} else if c.ProcType == 10 { // RTC with a global rate limiter
+		limiter := blocks.NewTokenBucket(c.RateLimit, c.RateBurst)
+		sim.InitStats(limiter)
+		for i := 0; i < c.Cores; i++ {
+			cores.add(blocks.NewRateLimitedRTCProcessor(limiter, c.CtxCost), i)
+		}
+	} else if c.ProcType == 13 { // preemptive priority RTC
+		if c.Cores != 1 {
+			panic("Preemptive priority processors only support a single core")
+		}
+		p := blocks.NewPreemptiveRTCProcessor(c.CtxCost)
+		sim.InitStats(p)
+		cores.add(p, 0)
+	} else if c.ProcType == 4 { // infinite server, cores are ignored
+		p := blocks.NewInfiniteServerProcessor()
+		p.AddInQueue(q)
+		p.SetReqDrain(drain)
+		sim.RegisterActor(p)
+	}
+
+	if retry != nil {
+		retry.AddOutQueue(q)
+	}
+
+	entry := q
+	if c.PropDelay > 0 {
+		in := blocks.NewQueue()
+		d := blocks.NewPropagationDelay(c.PropDelay)
+		d.AddInQueue(in)
+		d.AddOutQueue(q)
+		sim.RegisterActor(d)
+		entry = in
+	}
+	g.AddOutQueue(entry)
+
+	// Register the generator
+	sim.RegisterActor(g)
+
+	// Every extra class has its own open loop generator, tagging its requests
+	for i, cl := range c.Classes {
+		cg := newSingleQueueGenerator(sim, cl.GenType, cl.Lambda, cl.Mu, c)
+		cg.SetCreator(blocks.TaggingReqCreator{Creator: occupancy, Key: blocks.ClassTag, Value: strconv.Itoa(i + 1)})
+		cg.AddOutQueue(entry)
+		sim.RegisterActor(cg)
+		capacity.AddClass(cl.Lambda, cl.Mu)
+	}
+
+	// Compare GI/G/1 FIFO against the Kingman approximation
+	if c.ProcType == 0 && c.Cores == 1 && len(c.Classes) == 0 {
+		sim.InitStats(blocks.NewKingmanKeeper(g, stats))
+	}
+	// and M/M/k or M/G/1 FIFO against their exact results
+	if c.ProcType == 0 && len(c.Classes) == 0 && len(c.CoreSpeeds) == 0 {
+		sim.InitStats(blocks.NewQueueingModelKeeper(g, stats, c.Cores))
+	}
+
+	// a single write, whole even if replications run concurrently
+	var desc strings.Builder
+	fmt.Fprintf(&desc, "Cores:%v\tservice_rate:%v\tinterarrival_rate:%v", c.Cores, c.Mu, c.Lambda)
+	if clients > 0 {
+		fmt.Fprintf(&desc, "\tclients:%v\tthink_time:%v", clients, c.ThinkTime)
+	}
+	if c.BulkSize > 1 {
+		fmt.Fprintf(&desc, "\tbulk_size:%v\trequest_rate:%v", c.BulkSize, reqRate)
+	}
+	if c.ProcType == 2 || c.ProcType == 3 || c.ProcType == 6 || c.ProcType == 12 {
+		fmt.Fprintf(&desc, "\tquantum:%v", c.Quantum)
+	}
+	if (c.ProcType == 3 || c.ProcType == 11) && c.Aging > 0 {
+		fmt.Fprintf(&desc, "\taging:%v", c.Aging)
+	}
+	if c.ProcType == 8 {
+		fmt.Fprintf(&desc, "\tscale_time:%v\tscale_cores:%v", c.ScaleTime, c.ScaleCores)
+	}
+	if c.ProcType == 10 {
+		fmt.Fprintf(&desc, "\trate_limit:%v\trate_burst:%v", c.RateLimit, c.RateBurst)
+	}
+	if c.ProcType == 6 {
+		fmt.Fprintf(&desc, "\talpha:%v\tdeadline_slack:%v", c.Alpha, c.DeadlineSlack)
+	}
+	if c.ProcType == 11 {
+		fmt.Fprintf(&desc, "\tdeadline_slack:%v", c.DeadlineSlack)
+	}
+	if c.ProcType == 13 {
+		fmt.Fprintf(&desc, "\thigh_prio:%v", c.HighPrio)
+	}
+	if c.ProcType == 14 {
+		service, _ := c.BatchService.MarshalText()
+		fmt.Fprintf(&desc, "\tmax_batch:%v\tmax_wait:%v\tbatch_overhead:%v\tbatch_service:%s", c.MaxBatch, c.MaxWait, c.BatchOverhead, service)
+	}
+	if c.ProcType == 15 {
+		fmt.Fprintf(&desc, "\tgang_width:%v\tgang_ratio:%v", c.GangWidth, c.GangRatio)
+	}
+	if c.SLO > 0 {
+		fmt.Fprintf(&desc, "\tslo:%v\tshed:%v", c.SLO, c.Shed)
+	}
+	if c.SleepAfter > 0 {
+		fmt.Fprintf(&desc, "\tsleep_after:%v\twakeup_cost:%v", c.SleepAfter, c.WakeupCost)
+	}
+	if c.NUMANodes > 1 {
+		fmt.Fprintf(&desc, "\tnuma_nodes:%v\ttransfer_cost:%v", c.NUMANodes, c.TransferCost)
+	}
+	for i, cl := range c.Classes {
+		fmt.Fprintf(&desc, "\tclass%v:%v:%v:%v", i+1, cl.Lambda, cl.GenType, cl.Mu)
+	}
+	fmt.Fprintln(sim.InfoOutput(), desc.String())
+	sim.Run(c.Duration)
+	return stats
+}
+
+// queueProcessor is a processor of the single queue topology, fed by its
+// queue and able to sleep when idle
+type queueProcessor interface {
+	blocks.Processor
+	AddInQueue(q engine.QueueInterface)
+	SetSleep(sleepAfter, wakeupCost float64)
+}
+
+// coreWiring connects the cores of the single queue topology to the queue,
+// the drain of the completed requests and the keepers of their capacity and
+// energy
+type coreWiring struct {
+	sim      *engine.Simulation
+	c        Config
+	q        engine.QueueInterface
+	drain    blocks.RequestDrain
+	capacity *blocks.CapacityKeeper
+	energy   *blocks.EnergyKeeper
+	total    int // cores spread over the NUMA nodes, c.Cores if 0
+}
+
+// connect wires p as core i, without registering it
+func (w *coreWiring) connect(p queueProcessor, i int) {
+	total := w.total
+	if total == 0 {
+		total = w.c.Cores
+	}
+	p.SetID(i)
+	p.AddInQueue(w.q)
+	p.SetReqDrain(w.drain)
+	p.SetSleep(w.c.SleepAfter, w.c.WakeupCost)
+	p.SetNode(coreNode(i, total, w.c.NUMANodes), w.c.TransferCost)
+	w.capacity.AddProcessor(p)
+	w.energy.AddProcessor(p)
+}
+
+// add wires p as core i and registers it to the simulation
+func (w *coreWiring) add(p queueProcessor, i int) {
+	w.connect(p, i)
+	w.sim.RegisterActor(p)
+}
+
+// ClassSpec describes an extra class of requests of the single queue
+// topology, arriving with its own rate and service times
+type ClassSpec struct {
+	Lambda  float64 `json:"lambda"`
+	GenType int     `json:"genType"`
+	Mu      float64 `json:"mu"`
+}
+
+// newSingleQueueGenerator returns the generator of the given genType for sim,
+// with the rates lambda and mu and the workload parameters of c. It panics on
+// an unknown genType, which c.Validate rejects
+func newSingleQueueGenerator(sim *engine.Simulation, genType int, lambda, mu float64, c Config) blocks.Generator {
 	var g blocks.Generator
 	if genType == 0 {
 		g = blocks.NewMMRandGenerator(lambda, mu)
@@ -40,62 +473,43 @@ func SingleQueue(lambda, mu, duration float64,
 		peak1 := meanServiceTime / 10.0
 		// peak2 is derived from: mean = ratio * peak1 + (1-ratio) * peak2
 		peak2 := (meanServiceTime - ratio*peak1) / (1.0 - ratio)
-		fmt.Printf("Peak1: %v, Peak2: %v, Ratio: %v", peak1, peak2, ratio)
+		fmt.Fprintf(sim.InfoOutput(), "Peak1: %v, Peak2: %v, Ratio: %v\n", peak1, peak2, ratio)
 		g = blocks.NewMBRandGenerator(lambda, peak1, peak2, ratio)
 	} else if genType == 5 {
-		g = blocks.NewCDFGenerator(lambda, path)
-	}
-
-	g.SetCreator(&blocks.SimpleReqCreator{})
-
-	// Create queues
-	var q engine.QueueInterface
-	if procType == 3 {
-		q = blocks.NewPQueue()
-	} else {
-		q = blocks.NewQueue()
-	}
-
-	// Create processors
-
-	if procType == 0 {
-		for i := 0; i < cores; i++ {
-			p := blocks.NewRTCProcessor(ctxCost)
-			p.AddInQueue(q)
-			p.SetReqDrain(stats)
-			engine.RegisterActor(p)
-		}
-	} else if procType == 1 {
-		p := blocks.NewPSProcessor()
-		p.SetWorkerCount(cores)
-		p.AddInQueue(q)
-		p.SetReqDrain(stats)
-		engine.RegisterActor(p)
-	} else if procType == 2 {
-		for i := 0; i < cores; i++ {
-			p := blocks.NewTSProcessor(quantum, ctxCost)
-			p.AddInQueue(q)
-			p.SetReqDrain(stats)
-			engine.RegisterActor(p)
+		g = blocks.NewCDFGeneratorScaled(lambda, c.Path, c.CDFScale)
+	} else if genType == 6 {
+		g = blocks.NewClusterTraceGenerator(c.Path)
+	} else if genType == 7 {
+		g = blocks.NewMixtureCDFGeneratorScaled(lambda, c.MixPaths, c.MixWeights, c.CDFScale)
+	} else if genType == 9 {
+		g = blocks.NewTraceGenerator(c.Path)
+	} else if genType == 8 {
+		// Bounded Pareto spanning c.ParetoRange around the mean service time
+		low := blocks.BoundedParetoLow(c.ParetoAlpha, c.ParetoRange, 1/mu)
+		g = blocks.NewBoundedParetoGenerator(lambda, c.ParetoAlpha, low, low*c.ParetoRange)
+	} else if genType == 10 {
+		g = blocks.NewMMPPGenerator(c.MMPPRates, c.MMPPTransitions, mu)
+	} else if genType == 11 {
+		// Pareto with the mean service time, without an upper bound
+		g = blocks.NewParetoGenerator(lambda, c.ParetoAlpha, blocks.ParetoLow(c.ParetoAlpha, 1/mu))
+	} else if genType == 12 {
+		g = blocks.NewWeibullGenerator(lambda, c.WeibullShape, 1/mu)
+	} else if genType == 13 {
+		g = blocks.NewGammaGenerator(lambda, c.GammaShape, 1/mu)
+	} else if genType == 14 {
+		// the phases give the shape, scaled to the mean service time
+		g = blocks.NewHyperExpGenerator(lambda, c.PhaseProbs, blocks.ScaledPhaseMeans(c.PhaseProbs, c.PhaseMeans, 1/mu))
+	} else if genType == 15 {
+		// a rate profile, or else a sinusoid around lambda
+		if len(c.LambdaProfile) > 0 {
+			g = blocks.NewProfileGenerator(c.LambdaProfile, mu)
+		} else {
+			g = blocks.NewSinusoidGenerator(lambda, c.LambdaAmplitude, c.LambdaPeriod, mu)
 		}
-	} else if procType == 3 { // SRPT
-		for i := 0; i < cores; i++ {
-			p := blocks.NewSrptTSProcessor(quantum, ctxCost)
-			p.AddInQueue(q)
-			p.SetReqDrain(stats)
-			engine.RegisterActor(p)
-		}
-	}
-
-	g.AddOutQueue(q)
-
-	// Register the generator
-	engine.RegisterActor(g)
-
-	fmt.Printf("Cores:%v\tservice_rate:%v\tinterarrival_rate:%v", cores, mu, lambda)
-	if procType == 2 || procType == 3 {
-		fmt.Printf("\tquantum:%v", quantum)
+	} else if genType == 16 {
+		g = blocks.NewZipfGenerator(lambda, c.ZipfKeys, c.ZipfExponent, 1/mu)
+	} else {
+		panic(fmt.Sprintf("Unknown genType %v", genType))
 	}
-	fmt.Println()
-	engine.Run(duration)
+	return g
 }
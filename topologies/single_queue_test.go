@@ -0,0 +1,39 @@
+package topologies
+
+import (
+	"math"
+	"testing"
+
+	"github.com/epfl-dcsl/schedsim/blocks"
+)
+
+func TestSingleQueueClasses(t *testing.T) {
+	// small requests at rate 0.4 and requests twice as long at rate 0.1
+	c := mm1Config(0.4, 1, 1e5)
+	c.Classes = []ClassSpec{{Lambda: 0.1, GenType: 0, Mu: 0.5}}
+	stats := runConfig(t, c, 1)
+	big := stats.Filter(blocks.TagEquals(blocks.ClassTag, "1"))
+	small := stats.Filter(func(d blocks.RequestData) bool { return d.Tags[blocks.ClassTag] == "" })
+
+	// both classes wait the M/G/1 mean wait lambda E[S^2] / (2 (1-rho)),
+	// 0.5 * 3.2 / 0.8 = 2, on top of their own mean service time
+	for _, tc := range []struct {
+		name  string
+		stats *blocks.AllKeeper
+		rate  float64
+		delay float64
+	}{
+		{"small", small, 0.4, 3},
+		{"big", big, 0.1, 4},
+	} {
+		if want := tc.rate * c.Duration; math.Abs(float64(tc.stats.Count())-want) > 0.05*want {
+			t.Errorf("%v class: %v requests completed, want about %v", tc.name, tc.stats.Count(), want)
+		}
+		if d := tc.stats.MeanDelay(); math.Abs(d-tc.delay) > 0.1*tc.delay {
+			t.Errorf("%v class: mean delay %v, want about %v", tc.name, d, tc.delay)
+		}
+	}
+	if small.Count()+big.Count() != stats.Count() {
+		t.Errorf("%v small and %v big requests out of %v", small.Count(), big.Count(), stats.Count())
+	}
+}